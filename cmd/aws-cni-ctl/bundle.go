@@ -0,0 +1,284 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// bundleDir is the directory of the files in the bundle
+	bundleDir = "aws-cni-support"
+
+	// manifestName is the file of the bundle that lists the other ones
+	manifestName = "manifest.json"
+
+	// The kinds of data the bundle can redact
+	redactIPs  = "ips"
+	redactPods = "pods"
+)
+
+// manifestFile is a file of the bundle. A source that failed is listed with its error, and its file holds the output
+// it produced before failing, if any.
+type manifestFile struct {
+	Name   string
+	Source string
+	Size   int64
+	SHA256 string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// manifest describes the content of a support bundle
+type manifest struct {
+	Version    string
+	CreatedAt  time.Time
+	Hostname   string
+	Redactions []string `json:",omitempty"`
+	Files      []manifestFile
+}
+
+// bundleSource is a file of the bundle and how to collect it
+type bundleSource struct {
+	name    string
+	source  string
+	collect func() ([]byte, error)
+}
+
+// commandSource collects the output of a command
+func commandSource(name string, command string, args ...string) bundleSource {
+	return bundleSource{
+		name:   name,
+		source: strings.Join(append([]string{command}, args...), " "),
+		collect: func() ([]byte, error) {
+			return exec.Command(command, args...).CombinedOutput()
+		},
+	}
+}
+
+// fileSources collects the files matching a pattern into a directory of the bundle
+func fileSources(dir string, pattern string, exclude func(string) bool) []bundleSource {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return []bundleSource{{name: dir, source: pattern, collect: func() ([]byte, error) { return nil, err }}}
+	}
+	var sources []bundleSource
+	for _, match := range matches {
+		if exclude != nil && exclude(match) {
+			continue
+		}
+		file := match
+		sources = append(sources, bundleSource{
+			name:    path.Join(dir, filepath.Base(file)),
+			source:  file,
+			collect: func() ([]byte, error) { return ioutil.ReadFile(file) },
+		})
+	}
+	return sources
+}
+
+// sysctlSource collects the sysctls that matter to the pod traffic
+func sysctlSource() bundleSource {
+	const pattern = "/proc/sys/net/ipv4/conf/*/rp_filter"
+	return bundleSource{
+		name:   "sysctls.txt",
+		source: pattern,
+		collect: func() ([]byte, error) {
+			files, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, err
+			}
+			var out bytes.Buffer
+			for _, file := range files {
+				value, err := ioutil.ReadFile(file)
+				if err != nil {
+					fmt.Fprintf(&out, "%s: %v\n", file, err)
+					continue
+				}
+				fmt.Fprintf(&out, "%s = %s\n", file, strings.TrimSpace(string(value)))
+			}
+			return out.Bytes(), nil
+		},
+	}
+}
+
+// writeBundle collects the sources into a gzipped tarball, redacted by the redactor, followed by its manifest. The
+// sources that fail are recorded in the manifest and don't fail the bundle.
+func writeBundle(w io.Writer, sources []bundleSource, r *redactor, m manifest) (manifest, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	m.Redactions = r.kinds
+	m.Hostname = r.redact(m.Hostname)
+	for _, source := range sources {
+		data, err := source.collect()
+		file := manifestFile{Name: source.name, Source: r.redact(source.source)}
+		if err != nil {
+			file.Error = r.redact(err.Error())
+		}
+		if len(data) > 0 || err == nil {
+			data = []byte(r.redact(string(data)))
+			sum := sha256.Sum256(data)
+			file.Size = int64(len(data))
+			file.SHA256 = hex.EncodeToString(sum[:])
+			if err := addToTar(tw, source.name, data, m.CreatedAt); err != nil {
+				return m, err
+			}
+		}
+		m.Files = append(m.Files, file)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return m, errors.Wrap(err, "failed to marshal the manifest")
+	}
+	if err := addToTar(tw, manifestName, data, m.CreatedAt); err != nil {
+		return m, err
+	}
+	if err := tw.Close(); err != nil {
+		return m, errors.Wrap(err, "failed to write the bundle")
+	}
+	return m, errors.Wrap(gz.Close(), "failed to write the bundle")
+}
+
+func addToTar(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    path.Join(bundleDir, name),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return errors.Wrapf(err, "failed to add %s to the bundle", name)
+	}
+	_, err := tw.Write(data)
+	return errors.Wrapf(err, "failed to add %s to the bundle", name)
+}
+
+var (
+	ipPattern       = regexp.MustCompile(`\b(\d{1,3})\.(\d{1,3})\.(\d{1,3})\.(\d{1,3})\b`)
+	ipHostnameRegex = regexp.MustCompile(`\bip-(\d{1,3})-(\d{1,3})-(\d{1,3})-(\d{1,3})\b`)
+)
+
+// redactor replaces the IP addresses and the pod names of the bundle with pseudonyms. The same value gets the same
+// pseudonym in all the files, so that they can still be correlated.
+type redactor struct {
+	kinds []string
+	ips   map[string]string
+	// names replaces the pod names and namespaces
+	names *strings.Replacer
+}
+
+// newRedactor returns a redactor for the kinds of data, and the pods whose names and namespaces to redact. The
+// default and kube-* namespaces are not redacted.
+func newRedactor(kinds []string, podNames []string, namespaces []string) (*redactor, error) {
+	r := &redactor{kinds: kinds}
+	for _, kind := range kinds {
+		switch kind {
+		case redactIPs:
+			r.ips = make(map[string]string)
+		case redactPods:
+			r.names = namesReplacer(podNames, namespaces)
+		default:
+			return nil, errors.Errorf("unknown redaction %q, must be %s or %s", kind, redactIPs, redactPods)
+		}
+	}
+	return r, nil
+}
+
+// namesReplacer replaces the pod names and namespaces, the longest ones first so that a name that contains another
+// one is replaced whole
+func namesReplacer(podNames []string, namespaces []string) *strings.Replacer {
+	pseudonyms := make(map[string]string)
+	add := func(names []string, prefix string) {
+		sorted := append([]string(nil), names...)
+		sort.Strings(sorted)
+		for _, name := range sorted {
+			if _, ok := pseudonyms[name]; !ok && name != "" {
+				pseudonyms[name] = prefix + strconv.Itoa(len(pseudonyms)+1)
+			}
+		}
+	}
+	var redacted []string
+	for _, namespace := range namespaces {
+		if namespace != "default" && !strings.HasPrefix(namespace, "kube-") {
+			redacted = append(redacted, namespace)
+		}
+	}
+	add(podNames, "pod-")
+	add(redacted, "namespace-")
+
+	names := make([]string, 0, len(pseudonyms))
+	for name := range pseudonyms {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	pairs := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		pairs = append(pairs, name, pseudonyms[name])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// redact returns s with the pseudonyms of the redacted data
+func (r *redactor) redact(s string) string {
+	if r.names != nil {
+		s = r.names.Replace(s)
+	}
+	if r.ips != nil {
+		s = ipPattern.ReplaceAllStringFunc(s, r.redactIP)
+		s = ipHostnameRegex.ReplaceAllStringFunc(s, func(hostname string) string {
+			ip := strings.Replace(strings.TrimPrefix(hostname, "ip-"), "-", ".", -1)
+			return "ip-" + strings.Replace(r.redactIP(ip), ".", "-", -1)
+		})
+	}
+	return s
+}
+
+// redactIP maps an IP address to an address of 198.18.0.0/15, the range reserved for benchmarks. The unspecified,
+// loopback, link-local and broadcast addresses and the netmasks don't identify anything, and are kept.
+func (r *redactor) redactIP(ip string) string {
+	for _, kept := range []string{"0.", "127.", "169.254.", "255."} {
+		if strings.HasPrefix(ip, kept) {
+			return ip
+		}
+	}
+	if pseudonym, ok := r.ips[ip]; ok {
+		return pseudonym
+	}
+	n := len(r.ips) + 1
+	pseudonym := fmt.Sprintf("198.%d.%d.%d", 18+n>>16&1, n>>8&0xff, n&0xff)
+	r.ips[ip] = pseudonym
+	return pseudonym
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	r, err := newRedactor([]string{redactIPs, redactPods}, []string{"web", "web-1"}, []string{"shop", "default", "kube-system"})
+	assert.NoError(t, err)
+	assert.Equal(t,
+		"pod-2 in namespace-3 has 198.18.0.1, pod-1 has 198.18.0.2 via 198.18.0.1/32 on ip-198-18-0-2",
+		r.redact("web-1 in shop has 10.0.0.1, web has 10.0.0.2 via 10.0.0.1/32 on ip-10-0-0-2"))
+	assert.Equal(t, "default kube-system 127.0.0.1 0.0.0.0/0 255.255.255.0 169.254.169.254",
+		r.redact("default kube-system 127.0.0.1 0.0.0.0/0 255.255.255.0 169.254.169.254"))
+
+	none, err := newRedactor(nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "web 10.0.0.1", none.redact("web 10.0.0.1"))
+
+	_, err = newRedactor([]string{"secrets"}, nil, nil)
+	assert.Error(t, err)
+}
+
+// readBundle returns the files of a bundle
+func readBundle(t *testing.T, data []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	tr := tar.NewReader(gz)
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		assert.NoError(t, err)
+		content, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err)
+		files[header.Name] = string(content)
+	}
+}
+
+func TestWriteBundle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/pods" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"web_shop_s1": {"Name": "web", "Namespace": "shop", "IP": "10.0.0.1"}}`))
+	}))
+	defer server.Close()
+	c := &ctl{introspection: newIntrospectionClient(server.URL, time.Second)}
+
+	r, err := newRedactor([]string{redactIPs}, nil, nil)
+	assert.NoError(t, err)
+	sources := []bundleSource{
+		c.introspectionSource("ipamd/pods.json", "/v1/pods"),
+		c.introspectionSource("ipamd/enis.json", "/v1/enis"),
+		{name: "commands/ip-rule.txt", source: "ip rule show", collect: func() ([]byte, error) {
+			return []byte("partial output of 10.0.0.1"), errors.New("exit status 1")
+		}},
+	}
+	var out bytes.Buffer
+	created := time.Date(2019, 10, 21, 0, 0, 0, 0, time.UTC)
+	m, err := writeBundle(&out, sources, r, manifest{Version: "v1.6.0", CreatedAt: created, Hostname: "ip-10-0-0-2"})
+	assert.NoError(t, err)
+
+	files := readBundle(t, out.Bytes())
+	assert.Len(t, files, 3)
+	assert.Contains(t, files[path.Join(bundleDir, "ipamd/pods.json")], `"IP": "198.18.0.2"`)
+	assert.Equal(t, "partial output of 198.18.0.2", files[path.Join(bundleDir, "commands/ip-rule.txt")])
+
+	var written manifest
+	assert.NoError(t, json.Unmarshal([]byte(files[path.Join(bundleDir, manifestName)]), &written))
+	assert.Equal(t, m, written)
+	assert.Equal(t, "ip-198-18-0-1", written.Hostname)
+	assert.Equal(t, []string{redactIPs}, written.Redactions)
+	assert.Len(t, written.Files, 3)
+	assert.Empty(t, written.Files[0].Error)
+	assert.NotEmpty(t, written.Files[0].SHA256)
+	// The ENIs failed without output, so they are only in the manifest
+	assert.Contains(t, written.Files[1].Error, "404")
+	assert.Equal(t, int64(0), written.Files[1].Size)
+	assert.Equal(t, "exit status 1", written.Files[2].Error)
+	assert.Equal(t, int64(len("partial output of 198.18.0.2")), written.Files[2].Size)
+}
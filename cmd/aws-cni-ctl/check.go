@@ -0,0 +1,143 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ec2metadata"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/consistency"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/netlinkwrapper"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+const (
+	// The settings of /v1/networkutils-env-settings that make up the routing layout
+	envRouteTableOffset = "AWS_VPC_K8S_CNI_ROUTE_TABLE_OFFSET"
+	envRulePriorityBase = "AWS_VPC_K8S_CNI_RULE_PRIORITY_BASE"
+
+	imdsMACsPath = "network/interfaces/macs/"
+
+	// The views of the checks, in the Skipped errors of the report, as ipamd names them
+	viewRules  = "rules"
+	viewRoutes = "routes"
+	viewIMDS   = "imds"
+	viewEC2    = "ec2"
+)
+
+// routingLayout returns the routing layout from the settings of /v1/networkutils-env-settings. Older versions of
+// ipamd don't report it, and use the default layout.
+func routingLayout(settings map[string]interface{}) networkutils.RoutingLayout {
+	layout := networkutils.DefaultRoutingLayout
+	if offset, ok := settings[envRouteTableOffset].(float64); ok {
+		layout.TableOffset = int(offset)
+	}
+	if base, ok := settings[envRulePriorityBase].(float64); ok {
+		layout.PriorityBase = int(base)
+	}
+	return layout
+}
+
+// readIMDSENIs returns the secondary IP addresses of the ENIs attached to the instance, by ENI ID. IMDS lists the
+// primary IP address of an ENI first, and ipamd doesn't keep it in its pool.
+func readIMDSENIs(md ec2metadata.EC2Metadata) (map[string][]string, error) {
+	macs, err := md.GetMetadata(imdsMACsPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the ENIs in IMDS")
+	}
+	enis := make(map[string][]string)
+	for _, mac := range strings.Fields(macs) {
+		mac = strings.TrimSuffix(mac, "/")
+		eniID, err := md.GetMetadata(imdsMACsPath + mac + "/interface-id")
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get the ENI of MAC %s from IMDS", mac)
+		}
+		ips, err := md.GetMetadata(imdsMACsPath + mac + "/local-ipv4s")
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get the IP addresses of ENI %s from IMDS", eniID)
+		}
+		addresses := strings.Fields(ips)
+		if len(addresses) > 0 {
+			addresses = addresses[1:]
+		}
+		enis[strings.TrimSpace(eniID)] = addresses
+	}
+	return enis, nil
+}
+
+// podList returns the pods of /v1/pods as a list
+func podList(pods map[string]datastore.PodIPv4Address) []datastore.PodIPv4Address {
+	list := make([]datastore.PodIPv4Address, 0, len(pods))
+	for _, pod := range pods {
+		list = append(list, pod)
+	}
+	return list
+}
+
+// localViews read the views of the node that the check command collects itself
+type localViews struct {
+	rules  func() ([]netlink.Rule, error)
+	routes func() (map[string]string, error)
+	imds   func() (map[string][]string, error)
+}
+
+func newLocalViews() localViews {
+	netLink := netlinkwrapper.NewNetLink()
+	return localViews{
+		rules:  func() ([]netlink.Rule, error) { return netLink.RuleList(unix.AF_INET) },
+		routes: func() (map[string]string, error) { return networkutils.PodRoutes(netLink) },
+		imds:   func() (map[string][]string, error) { return readIMDSENIs(ec2metadata.New()) },
+	}
+}
+
+// checkNow runs the consistency checks on ipamd's datastore as it is now, with the IP rules and routes of the host
+// and IMDS read by the command. The views that can't be read are skipped, like when the command doesn't run on the
+// node. EC2 is left to ipamd's own checks, so the ENIs tagged node.k8s.amazonaws.com/no_manage show up as
+// ENINotInDatastore warnings.
+func (c *ctl) checkNow(local localViews) (ipamd.ConsistencyReport, error) {
+	report := ipamd.ConsistencyReport{CheckedAt: time.Now(), Skipped: map[string]string{
+		viewEC2: "only checked by ipamd, see check -last",
+	}}
+	enis, err := c.introspection.getENIs(nil)
+	if err != nil {
+		return report, err
+	}
+	pods, err := c.introspection.getPods(nil)
+	if err != nil {
+		return report, err
+	}
+	settings, err := c.introspection.getNetworkEnv()
+	if err != nil {
+		return report, err
+	}
+	view := consistency.View{ENIs: enis, Pods: podList(pods), Layout: routingLayout(settings)}
+	if view.Rules, err = local.rules(); err != nil {
+		report.Skipped[viewRules] = err.Error()
+	}
+	if view.HostRoutes, err = local.routes(); err != nil {
+		report.Skipped[viewRoutes] = err.Error()
+	}
+	if view.IMDS, err = local.imds(); err != nil {
+		report.Skipped[viewIMDS] = err.Error()
+	}
+	report.Findings = consistency.Check(view)
+	return report, nil
+}
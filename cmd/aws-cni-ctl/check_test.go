@@ -0,0 +1,118 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/consistency"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+func TestRoutingLayout(t *testing.T) {
+	layout := networkutils.RoutingLayout{TableOffset: 100, PriorityBase: 1000}
+	assert.Equal(t, layout, routingLayout(map[string]interface{}{envRouteTableOffset: 100.0, envRulePriorityBase: 1000.0}))
+	assert.Equal(t, networkutils.DefaultRoutingLayout, routingLayout(nil))
+}
+
+type fakeMetadata map[string]string
+
+func (m fakeMetadata) GetMetadata(path string) (string, error) {
+	value, ok := m[path]
+	if !ok {
+		return "", errors.Errorf("no metadata at %s", path)
+	}
+	return value, nil
+}
+
+func (m fakeMetadata) Region() (string, error) {
+	return "us-west-2", nil
+}
+
+func TestReadIMDSENIs(t *testing.T) {
+	md := fakeMetadata{
+		imdsMACsPath: "0a:00:00:00:00:01/\n0a:00:00:00:00:02/\n0a:00:00:00:00:03/",
+		imdsMACsPath + "0a:00:00:00:00:01/interface-id": "eni-1",
+		imdsMACsPath + "0a:00:00:00:00:01/local-ipv4s":  "10.0.0.10\n10.0.0.11\n10.0.0.12",
+		imdsMACsPath + "0a:00:00:00:00:02/interface-id": "eni-2",
+		imdsMACsPath + "0a:00:00:00:00:02/local-ipv4s":  "10.0.0.20\n10.0.0.22",
+		imdsMACsPath + "0a:00:00:00:00:03/interface-id": "eni-3",
+		imdsMACsPath + "0a:00:00:00:00:03/local-ipv4s":  "10.0.0.30",
+	}
+	imdsENIs, err := readIMDSENIs(md)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"eni-1": {"10.0.0.11", "10.0.0.12"},
+		"eni-2": {"10.0.0.22"},
+		"eni-3": {},
+	}, imdsENIs)
+
+	delete(md, imdsMACsPath+"0a:00:00:00:00:03/local-ipv4s")
+	_, err = readIMDSENIs(md)
+	assert.Error(t, err)
+}
+
+func TestCheckNow(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/enis":
+			_, _ = w.Write([]byte(`{"ENIIPPools": {"eni-1": {"ID": "eni-1", "IsPrimary": true, "IPv4Addresses": {
+				"10.0.0.11": {"Address": "10.0.0.11", "Assigned": true, "PodNamespace": "ns", "PodName": "pod-1"},
+				"10.0.0.12": {"Address": "10.0.0.12", "Assigned": true, "PodNamespace": "ns", "PodName": "pod-2"}}}}}`))
+		case "/v1/pods":
+			_, _ = w.Write([]byte(`{"pod-1_ns_s1": {"Name": "pod-1", "Namespace": "ns", "IP": "10.0.0.11", "ENI": "eni-1"}}`))
+		case "/v1/networkutils-env-settings":
+			_, _ = w.Write([]byte(`{"AWS_VPC_K8S_CNI_RULE_PRIORITY_BASE": 1000}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	c := &ctl{introspection: newIntrospectionClient(server.URL, time.Second)}
+
+	layout := networkutils.RoutingLayout{PriorityBase: 1000}
+	local := localViews{
+		rules: func() ([]netlink.Rule, error) {
+			return []netlink.Rule{{Priority: layout.ToPodRulePriority(), Table: unix.RT_TABLE_MAIN,
+				Dst: &net.IPNet{IP: net.ParseIP("10.0.0.11"), Mask: net.CIDRMask(32, 32)}}}, nil
+		},
+		routes: func() (map[string]string, error) { return nil, errors.New("no netlink") },
+		imds: func() (map[string][]string, error) {
+			return map[string][]string{"eni-1": {"10.0.0.11", "10.0.0.12"}}, nil
+		},
+	}
+	report, err := c.checkNow(local)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{viewEC2: "only checked by ipamd, see check -last", viewRoutes: "no netlink"},
+		report.Skipped)
+	// pod-2 is on the ENI, but not in the pods of the datastore, and has no rules
+	assert.Len(t, report.Findings, 2)
+	assert.Equal(t, consistency.DatastoreMismatch, report.Findings[0].Category)
+	assert.Equal(t, "10.0.0.12", report.Findings[0].IP)
+	assert.Equal(t, consistency.MissingRule, report.Findings[1].Category)
+	assert.Equal(t, "ns/pod-2", report.Findings[1].Pod)
+
+	server.Close()
+	_, err = c.checkNow(local)
+	assert.Error(t, err)
+}
@@ -0,0 +1,171 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+// healthServiceName is the gRPC health service of ipamd
+const healthServiceName = "grpc.health.v1.aws-node"
+
+// introspectionClient reads the introspection and metrics endpoints of ipamd
+type introspectionClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// newIntrospectionClient returns a client for an HTTP URL, or for a unix socket given as unix:<path> like
+// INTROSPECTION_BIND_ADDRESS
+func newIntrospectionClient(addr string, timeout time.Duration) *introspectionClient {
+	if strings.HasPrefix(addr, "unix:") {
+		socket := strings.TrimPrefix(addr, "unix:")
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &introspectionClient{baseURL: "http://unix", client: &http.Client{Transport: transport, Timeout: timeout}}
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &introspectionClient{baseURL: strings.TrimSuffix(addr, "/"), client: &http.Client{Timeout: timeout}}
+}
+
+// getRaw returns the body of an endpoint
+func (c *introspectionClient) getRaw(path string, query url.Values) ([]byte, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	resp, err := c.client.Get(u)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s", path)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return body, errors.Errorf("%s returned %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// get decodes the JSON body of an endpoint into v
+func (c *introspectionClient) get(path string, query url.Values, v interface{}) error {
+	body, err := c.getRaw(path, query)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return errors.Wrapf(err, "failed to decode %s", path)
+	}
+	return nil
+}
+
+func (c *introspectionClient) getENIs(query url.Values) (datastore.ENIRecords, error) {
+	var enis datastore.ENIRecords
+	err := c.get("/v1/enis", query, &enis)
+	return enis, err
+}
+
+func (c *introspectionClient) getPods(query url.Values) (map[string]datastore.PodIPv4Address, error) {
+	var pods map[string]datastore.PodIPv4Address
+	err := c.get("/v1/pods", query, &pods)
+	return pods, err
+}
+
+func (c *introspectionClient) getPoolPolicy() (ipamd.PoolPolicyState, error) {
+	var state ipamd.PoolPolicyState
+	err := c.get("/v1/pool-policy", nil, &state)
+	return state, err
+}
+
+func (c *introspectionClient) getPoolBackoff() (ipamd.PoolBackoffState, error) {
+	var state ipamd.PoolBackoffState
+	err := c.get("/v1/pool-backoff", nil, &state)
+	return state, err
+}
+
+// getHealth returns the health checks of ipamd. /healthz answers 503 with the checks when ipamd is unhealthy.
+func (c *introspectionClient) getHealth() (ipamd.HealthStatus, error) {
+	var status ipamd.HealthStatus
+	body, err := c.getRaw("/healthz", nil)
+	if err != nil && len(body) == 0 {
+		return status, err
+	}
+	if jsonErr := json.Unmarshal(body, &status); jsonErr != nil {
+		if err != nil {
+			return status, err
+		}
+		return status, errors.Wrap(jsonErr, "failed to decode /healthz")
+	}
+	return status, nil
+}
+
+// getNetworkEnv returns the settings of the host network, as reported by ipamd
+func (c *introspectionClient) getNetworkEnv() (map[string]interface{}, error) {
+	var settings map[string]interface{}
+	err := c.get("/v1/networkutils-env-settings", nil, &settings)
+	return settings, err
+}
+
+// getConsistency returns the report of the last consistency check of ipamd
+func (c *introspectionClient) getConsistency() (ipamd.ConsistencyReport, error) {
+	var report ipamd.ConsistencyReport
+	err := c.get("/v1/consistency", nil, &report)
+	return report, err
+}
+
+// getPodPath returns how the node routes the traffic of a pod to a destination
+func (c *introspectionClient) getPodPath(query url.Values) (networkutils.PodPath, error) {
+	var path networkutils.PodPath
+	err := c.get("/v1/explain", query, &path)
+	return path, err
+}
+
+// checkGRPCHealth returns the serving status of ipamd's gRPC health service
+func checkGRPCHealth(addr string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to connect to ipamd at %s", addr)
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: healthServiceName})
+	if err != nil {
+		return "", errors.Wrap(err, "health check failed")
+	}
+	return resp.GetStatus().String(), nil
+}
@@ -0,0 +1,368 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// aws-cni-ctl shows the state of ipamd on a node, its consistency checks and the path of pod traffic, and collects
+// support bundles
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/consistency"
+)
+
+const (
+	defaultIntrospectionAddress = "http://127.0.0.1:61679"
+	defaultMetricsAddress       = "http://127.0.0.1:61678"
+	defaultGRPCAddress          = "127.0.0.1:50051"
+	defaultBundlePath           = "/var/log/aws-routed-eni/aws-cni-support.tar.gz"
+
+	// The exit codes
+	statusOK            = 0
+	statusError         = 1
+	statusUsage         = 2
+	statusInconsistency = 3
+)
+
+// version is set by the Makefile
+var version string
+
+// errInconsistent is returned by the check and explain commands when they find problems
+var errInconsistent = errors.New("inconsistencies found")
+
+// ctl holds the global options of the commands
+type ctl struct {
+	introspection *introspectionClient
+	metrics       *introspectionClient
+	grpcAddress   string
+	output        string
+	timeout       time.Duration
+	out           io.Writer
+}
+
+// command is a subcommand of aws-cni-ctl
+type command struct {
+	usage string
+	run   func(c *ctl, args []string) error
+}
+
+var commands = map[string]command{
+	"enis":    {"list the ENIs, or their IP addresses with -ips", runENIs},
+	"pods":    {"list the pods and their IP addresses", runPods},
+	"pool":    {"show the IP pool policy and backoff", runPool},
+	"health":  {"show the gRPC health and the health checks of ipamd", runHealth},
+	"check":   {"check ipamd's datastore against the IP rules, routes and IMDS, or show its last check", runCheck},
+	"explain": {"explain how the node routes the traffic of a pod to a destination", runExplain},
+	"bundle":  {"collect a support bundle for offline troubleshooting", runBundle},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] <command> [command flags]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-8s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	introspection := flag.String("introspection", defaultIntrospectionAddress,
+		"address of the ipamd introspection endpoints, unix:<path> for a unix socket")
+	metrics := flag.String("metrics", defaultMetricsAddress, "address of the ipamd metrics endpoint")
+	grpcAddress := flag.String("grpc", defaultGRPCAddress, "address of the ipamd gRPC server")
+	output := flag.String("o", outputTable, "output format: table or json")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of the requests to ipamd")
+	flag.Usage = usage
+	flag.Parse()
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok || (*output != outputTable && *output != outputJSON) {
+		usage()
+		os.Exit(statusUsage)
+	}
+	c := &ctl{
+		introspection: newIntrospectionClient(*introspection, *timeout),
+		metrics:       newIntrospectionClient(*metrics, *timeout),
+		grpcAddress:   *grpcAddress,
+		output:        *output,
+		timeout:       *timeout,
+		out:           os.Stdout,
+	}
+	err := cmd.run(c, flag.Args()[1:])
+	switch {
+	case err == errInconsistent:
+		os.Exit(statusInconsistency)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(statusError)
+	}
+	os.Exit(statusOK)
+}
+
+// addressQuery adds the flags of the /v1/enis and /v1/pods filters to a command
+func addressQuery(flags *flag.FlagSet) func() url.Values {
+	params := []string{"namespace", "pod", "eni", "ip"}
+	values := make(map[string]*string, len(params))
+	for _, param := range params {
+		values[param] = flags.String(param, "", "only show the IP addresses of this "+param)
+	}
+	return func() url.Values {
+		query := url.Values{}
+		for param, value := range values {
+			if *value != "" {
+				query.Set(param, *value)
+			}
+		}
+		return query
+	}
+}
+
+func runENIs(c *ctl, args []string) error {
+	flags := flag.NewFlagSet("enis", flag.ExitOnError)
+	query := addressQuery(flags)
+	ips := flags.Bool("ips", false, "list the IP addresses of the ENIs")
+	_ = flags.Parse(args)
+	enis, err := c.introspection.getENIs(query())
+	if err != nil {
+		return err
+	}
+	if *ips {
+		return render(c.out, c.output, enis, addressTable(enis, time.Now()))
+	}
+	return render(c.out, c.output, enis, eniTable(enis))
+}
+
+func runPods(c *ctl, args []string) error {
+	flags := flag.NewFlagSet("pods", flag.ExitOnError)
+	query := addressQuery(flags)
+	_ = flags.Parse(args)
+	pods, err := c.introspection.getPods(query())
+	if err != nil {
+		return err
+	}
+	return render(c.out, c.output, pods, podTable(pods, time.Now()))
+}
+
+func runPool(c *ctl, args []string) error {
+	_ = flag.NewFlagSet("pool", flag.ExitOnError).Parse(args)
+	var state poolState
+	var err error
+	if state.Policy, err = c.introspection.getPoolPolicy(); err != nil {
+		return err
+	}
+	if state.Backoff, err = c.introspection.getPoolBackoff(); err != nil {
+		return err
+	}
+	return render(c.out, c.output, state, poolTable(state, time.Now()))
+}
+
+func runHealth(c *ctl, args []string) error {
+	_ = flag.NewFlagSet("health", flag.ExitOnError).Parse(args)
+	var state healthState
+	var err error
+	if state.GRPCStatus, err = checkGRPCHealth(c.grpcAddress, c.timeout); err != nil {
+		state.GRPCError = err.Error()
+	}
+	if state.Checks, err = c.introspection.getHealth(); err != nil {
+		return err
+	}
+	return render(c.out, c.output, state, healthTable(state))
+}
+
+func runCheck(c *ctl, args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	last := flags.Bool("last", false, "show ipamd's last check, which also compares the ENIs with EC2")
+	_ = flags.Parse(args)
+	var report ipamd.ConsistencyReport
+	var err error
+	if *last {
+		report, err = c.introspection.getConsistency()
+	} else {
+		report, err = c.checkNow(newLocalViews())
+	}
+	if err != nil {
+		return err
+	}
+	if err := render(c.out, c.output, report, consistencyTable(report, time.Now())); err != nil {
+		return err
+	}
+	if consistency.HasErrors(report.Findings) {
+		return errInconsistent
+	}
+	return nil
+}
+
+func runExplain(c *ctl, args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	namespace := flags.String("namespace", "", "namespace of the pod")
+	pod := flags.String("pod", "", "name of the pod")
+	ip := flags.String("ip", "", "IP address of the pod, instead of its name")
+	dst := flags.String("dst", "", "destination IP address")
+	_ = flags.Parse(args)
+	if (*pod == "" && *ip == "") || *dst == "" {
+		return errors.New("explain needs -pod or -ip, and -dst")
+	}
+	query := url.Values{"dst": {*dst}}
+	for param, value := range map[string]string{"namespace": *namespace, "pod": *pod, "ip": *ip} {
+		if value != "" {
+			query.Set(param, value)
+		}
+	}
+	path, err := c.introspection.getPodPath(query)
+	if err != nil {
+		return err
+	}
+	if err := render(c.out, c.output, path, podPathTable(path)); err != nil {
+		return err
+	}
+	if path.HasProblems() {
+		return errInconsistent
+	}
+	return nil
+}
+
+// introspectionSource collects an introspection endpoint of ipamd
+func (c *ctl) introspectionSource(name string, path string) bundleSource {
+	return bundleSource{
+		name:    name,
+		source:  c.introspection.baseURL + path,
+		collect: func() ([]byte, error) { return c.introspection.getRaw(path, url.Values{"pretty": {""}}) },
+	}
+}
+
+// bundleSources are the files of the support bundle
+func (c *ctl) bundleSources() []bundleSource {
+	sources := []bundleSource{
+		c.introspectionSource("ipamd/enis.json", "/v1/enis"),
+		c.introspectionSource("ipamd/pods.json", "/v1/pods"),
+		c.introspectionSource("ipamd/eni-configs.json", "/v1/eni-configs"),
+		c.introspectionSource("ipamd/egress-ips.json", "/v1/egress-ips"),
+		c.introspectionSource("ipamd/pod-eips.json", "/v1/pod-eips"),
+		c.introspectionSource("ipamd/namespace-ips.json", "/v1/namespace-ips"),
+		c.introspectionSource("ipamd/pool-policy.json", "/v1/pool-policy"),
+		c.introspectionSource("ipamd/pool-backoff.json", "/v1/pool-backoff"),
+		c.introspectionSource("ipamd/warm-pool-schedule.json", "/v1/warm-pool-schedule"),
+		c.introspectionSource("ipamd/networkutils-env.json", "/v1/networkutils-env-settings"),
+		c.introspectionSource("ipamd/ipamd-env.json", "/v1/ipamd-env-settings"),
+		c.introspectionSource("ipamd/healthz.json", "/healthz"),
+		c.introspectionSource("ipamd/consistency.json", "/v1/consistency"),
+		{
+			name:   "checks.json",
+			source: "aws-cni-ctl check",
+			collect: func() ([]byte, error) {
+				report, err := c.checkNow(newLocalViews())
+				if err != nil {
+					return nil, err
+				}
+				var out strings.Builder
+				err = render(&out, outputJSON, report, nil)
+				return []byte(out.String()), err
+			},
+		},
+		{
+			name:    "ipamd/metrics.txt",
+			source:  c.metrics.baseURL + "/metrics",
+			collect: func() ([]byte, error) { return c.metrics.getRaw("/metrics", nil) },
+		},
+		{
+			name:   "ipamd/grpc-health.txt",
+			source: c.grpcAddress,
+			collect: func() ([]byte, error) {
+				status, err := checkGRPCHealth(c.grpcAddress, c.timeout)
+				return []byte(status), err
+			},
+		},
+		commandSource("commands/ip-rule.txt", "ip", "rule", "show"),
+		commandSource("commands/ip-route.txt", "ip", "route", "show", "table", "all"),
+		commandSource("commands/ip-addr.txt", "ip", "addr", "show"),
+		commandSource("commands/iptables-save.txt", "iptables-save"),
+		commandSource("commands/iptables.txt", "iptables", "-w1", "-nvL"),
+		commandSource("commands/iptables-nat.txt", "iptables", "-w1", "-nvL", "-t", "nat"),
+		commandSource("commands/iptables-mangle.txt", "iptables", "-w1", "-nvL", "-t", "mangle"),
+		sysctlSource(),
+	}
+	sources = append(sources, fileSources("cni", "/etc/cni/net.d/*", nil)...)
+	sources = append(sources, fileSources("logs", "/var/log/aws-routed-eni/*", func(file string) bool {
+		info, err := os.Stat(file)
+		return err != nil || info.IsDir() || strings.HasSuffix(file, ".tar.gz")
+	})...)
+	return append(sources, fileSources("logs", "/var/log/messages", nil)...)
+}
+
+func runBundle(c *ctl, args []string) error {
+	flags := flag.NewFlagSet("bundle", flag.ExitOnError)
+	out := flags.String("out", defaultBundlePath, "path of the bundle")
+	redact := flags.String("redact", "", "comma separated data to redact: ips, pods")
+	_ = flags.Parse(args)
+
+	var kinds []string
+	if *redact != "" {
+		kinds = strings.Split(*redact, ",")
+	}
+	var podNames, namespaces []string
+	if strings.Contains(*redact, redactPods) {
+		pods, err := c.introspection.getPods(nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to get the pods to redact")
+		}
+		for _, pod := range pods {
+			podNames = append(podNames, pod.Name)
+			namespaces = append(namespaces, pod.Namespace)
+		}
+	}
+	r, err := newRedactor(kinds, podNames, namespaces)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*out), 0755); err != nil {
+		return errors.Wrap(err, "failed to create the bundle directory")
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the bundle")
+	}
+	hostname, _ := os.Hostname()
+	m, err := writeBundle(file, c.bundleSources(), r, manifest{Version: version, CreatedAt: time.Now(), Hostname: hostname})
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = errors.Wrap(closeErr, "failed to write the bundle")
+	}
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, f := range m.Files {
+		if f.Error != "" {
+			failed++
+		}
+	}
+	fmt.Fprintf(c.out, "Wrote %s: %d files, %d sources failed (see %s)\n", *out, len(m.Files), failed, manifestName)
+	return nil
+}
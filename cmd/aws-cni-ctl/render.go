@@ -0,0 +1,226 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// render writes v as indented JSON, or as the table written by table
+func render(w io.Writer, output string, v interface{}, table func(w io.Writer)) error {
+	if output == outputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// row writes a tab separated row of a table
+func row(w io.Writer, columns ...interface{}) {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = fmt.Sprint(column)
+	}
+	fmt.Fprintln(w, strings.Join(values, "\t"))
+}
+
+// age is the time since t, rounded for a table. The zero time has no age.
+func age(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return now.Sub(t).Round(time.Second).String()
+}
+
+func sortedENIs(enis datastore.ENIRecords) []datastore.ENIRecord {
+	records := make([]datastore.ENIRecord, 0, len(enis.ENIIPPools))
+	for _, eni := range enis.ENIIPPools {
+		records = append(records, eni)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].DeviceNumber < records[j].DeviceNumber })
+	return records
+}
+
+func eniTable(enis datastore.ENIRecords) func(w io.Writer) {
+	return func(w io.Writer) {
+		row(w, "ENI", "DEVICE", "PRIMARY", "DRAINING", "IPS", "ASSIGNED")
+		for _, eni := range sortedENIs(enis) {
+			row(w, eni.ID, eni.DeviceNumber, eni.IsPrimary, eni.Draining, len(eni.IPv4Addresses),
+				eni.AssignedIPv4Addresses)
+		}
+		row(w, "TOTAL", "", "", "", enis.TotalIPs, enis.AssignedIPs)
+	}
+}
+
+func addressTable(enis datastore.ENIRecords, now time.Time) func(w io.Writer) {
+	return func(w io.Writer) {
+		row(w, "ENI", "IP", "ASSIGNED", "POD", "AGE", "COOLING")
+		for _, eni := range sortedENIs(enis) {
+			ips := make([]string, 0, len(eni.IPv4Addresses))
+			for ip := range eni.IPv4Addresses {
+				ips = append(ips, ip)
+			}
+			sort.Strings(ips)
+			for _, ip := range ips {
+				addr := eni.IPv4Addresses[ip]
+				pod, since := "-", addr.UnassignedTime
+				if addr.Assigned {
+					pod, since = addr.PodNamespace+"/"+addr.PodName, addr.AssignedTime
+				}
+				row(w, eni.ID, ip, addr.Assigned, pod, age(since, now), addr.InCoolingPeriod)
+			}
+		}
+	}
+}
+
+func podTable(pods map[string]datastore.PodIPv4Address, now time.Time) func(w io.Writer) {
+	return func(w io.Writer) {
+		keys := make([]string, 0, len(pods))
+		for key := range pods {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		row(w, "NAMESPACE", "POD", "IP", "ENI", "DEVICE", "AGE")
+		for _, key := range keys {
+			pod := pods[key]
+			namespace := pod.Namespace
+			if namespace == "" {
+				namespace = "-"
+			}
+			row(w, namespace, pod.Name, pod.IP, pod.ENI, pod.DeviceNumber, age(pod.AssignedTime, now))
+		}
+	}
+}
+
+// poolState is the output of the pool command
+type poolState struct {
+	Policy  ipamd.PoolPolicyState
+	Backoff ipamd.PoolBackoffState
+}
+
+func poolTable(state poolState, now time.Time) func(w io.Writer) {
+	return func(w io.Writer) {
+		policy, backoff := state.Policy, state.Backoff
+		row(w, "Policy:", policy.Policy)
+		row(w, "Decided:", age(policy.DecidedAt, now)+" ago")
+		row(w, "ENIs:", fmt.Sprintf("%d/%d", policy.Stats.ENIs, policy.Limits.MaxENIs))
+		row(w, "IPs:", fmt.Sprintf("%d assigned, %d total, %d per ENI", policy.Stats.AssignedIPs,
+			policy.Stats.TotalIPs, policy.Limits.MaxIPsPerENI))
+		row(w, "Targets:", fmt.Sprintf("WARM_ENI_TARGET=%d WARM_IP_TARGET=%d MINIMUM_IP_TARGET=%d",
+			policy.Limits.WarmENITarget, policy.Limits.WarmIPTarget, policy.Limits.MinimumIPTarget))
+		actions := policy.Actions
+		row(w, "Actions:", fmt.Sprintf("add %d IPs (new ENI: %t), free %d IPs (free ENI: %t)", actions.AddIPs,
+			actions.AddENI, actions.FreeIPs, actions.FreeENI))
+		row(w, "Circuit:", fmt.Sprintf("%s, %d consecutive failures", backoff.CircuitState,
+			backoff.ConsecutiveFailures))
+		if backoff.LastError != "" {
+			row(w, "Last error:", fmt.Sprintf("%s: %s", backoff.LastFailureClass, backoff.LastError))
+		}
+		if !backoff.RetryAt.IsZero() && backoff.RetryAt.After(now) {
+			row(w, "Retry in:", backoff.RetryAt.Sub(now).Round(time.Second))
+		}
+	}
+}
+
+// healthState is the output of the health command
+type healthState struct {
+	// GRPCStatus is the status of the gRPC health service, that the liveness probe reads
+	GRPCStatus string
+	GRPCError  string `json:",omitempty"`
+	Checks     ipamd.HealthStatus
+}
+
+func healthTable(state healthState) func(w io.Writer) {
+	return func(w io.Writer) {
+		if state.GRPCError != "" {
+			row(w, "gRPC:", state.GRPCError)
+		} else {
+			row(w, "gRPC:", state.GRPCStatus)
+		}
+		row(w, "Ready:", state.Checks.Ready)
+		row(w, "")
+		row(w, "CHECK", "HEALTHY", "LIVENESS", "MESSAGE")
+		for _, check := range state.Checks.Checks {
+			row(w, check.Name, check.Healthy, check.Liveness, check.Message)
+		}
+	}
+}
+
+func consistencyTable(report ipamd.ConsistencyReport, now time.Time) func(w io.Writer) {
+	return func(w io.Writer) {
+		row(w, "Checked:", age(report.CheckedAt, now)+" ago")
+		views := make([]string, 0, len(report.Skipped))
+		for view := range report.Skipped {
+			views = append(views, view)
+		}
+		sort.Strings(views)
+		for _, view := range views {
+			row(w, "Skipped "+view+":", report.Skipped[view])
+		}
+		row(w, "")
+		if len(report.Findings) == 0 {
+			row(w, "No inconsistencies found")
+			return
+		}
+		row(w, "SEVERITY", "CATEGORY", "ENI", "IP", "POD", "MESSAGE", "FIXED")
+		for _, f := range report.Findings {
+			fixed := "-"
+			switch {
+			case f.FixError != "":
+				fixed = "failed: " + f.FixError
+			case f.Fixed:
+				fixed = "yes"
+			}
+			row(w, f.Severity, f.Category, dash(f.ENI), dash(f.IP), dash(f.Pod), f.Message, fixed)
+		}
+	}
+}
+
+func podPathTable(path networkutils.PodPath) func(w io.Writer) {
+	return func(w io.Writer) {
+		row(w, "Pod:", path.PodIP)
+		row(w, "Destination:", path.Destination)
+		row(w, "")
+		row(w, "STEP", "DETAIL", "PROBLEM")
+		for _, step := range path.Steps {
+			row(w, step.Name, dash(step.Detail), dash(step.Problem))
+		}
+	}
+}
+
+// dash replaces an empty column
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
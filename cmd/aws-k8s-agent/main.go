@@ -21,11 +21,14 @@ import (
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/tracing"
 )
 
 const (
-	defaultLogFilePath = "/host/var/log/aws-routed-eni/ipamd.log"
+	defaultLogFilePath   = "/host/var/log/aws-routed-eni/ipamd.log"
+	defaultTraceFilePath = "/host/var/log/aws-routed-eni/ipamd-traces.json"
 )
 
 var (
@@ -38,10 +41,16 @@ func main() {
 
 func _main() int {
 	defer log.Flush()
-	logger.SetupLogger(logger.GetLogFileLocation(defaultLogFilePath))
+	logger.SetupLogger(logger.GetLogFileLocation(defaultLogFilePath), "aws-k8s-agent")
 
 	log.Infof("Starting L-IPAMD %s  ...", version)
 
+	shutdownTracing, err := tracing.Init("aws-k8s-agent", tracing.ConfigFromEnv(defaultTraceFilePath))
+	if err != nil {
+		log.Errorf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing()
+
 	kubeClient, err := k8sapi.CreateKubeClient()
 	if err != nil {
 		log.Errorf("Failed to create client: %v", err)
@@ -52,7 +61,13 @@ func _main() int {
 	go discoverController.DiscoverLocalK8SPods()
 
 	eniConfigController := eniconfig.NewENIConfigController()
-	if ipamd.UseCustomNetworkCfg() {
+	if networkutils.UseEgressIP() {
+		eniConfigController.WatchEgressIPConfigs()
+	}
+	if ipamd.UseIPQuotas() {
+		eniConfigController.WatchIPQuotas()
+	}
+	if ipamd.UseCustomNetworkCfg() || networkutils.UseEgressIP() || ipamd.UseIPQuotas() {
 		go eniConfigController.Start()
 	}
 
@@ -66,6 +81,14 @@ func _main() int {
 	// Pool manager
 	go ipamContext.StartNodeIPPoolManager()
 
+	// Consistency checks of the datastore against the kernel, IMDS and EC2
+	go ipamContext.StartConsistencyChecker()
+
+	// Elastic IPs of pods
+	if ipamd.UsePodEIP() {
+		go ipamContext.StartPodEIPManager()
+	}
+
 	// Prometheus metrics
 	go ipamContext.ServeMetrics()
 
@@ -22,6 +22,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"github.com/containernetworking/cni/pkg/skel"
@@ -31,6 +32,8 @@ import (
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/aws/amazon-vpc-cni-k8s/cmd/routed-eni-cni-plugin/driver"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/grpcwrapper"
@@ -39,12 +42,18 @@ import (
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/rpcwrapper"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/typeswrapper"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/tracing"
 	pb "github.com/aws/amazon-vpc-cni-k8s/rpc"
 )
 
 const (
-	ipamDAddress       = "localhost:50051"
-	defaultLogFilePath = "/var/log/aws-routed-eni/plugin.log"
+	ipamDAddress         = "localhost:50051"
+	defaultLogFilePath   = "/var/log/aws-routed-eni/plugin.log"
+	defaultTraceFilePath = "/var/log/aws-routed-eni/plugin-traces.json"
+
+	// reportNetworkSetupTimeout is how long the plugin waits for ipamd to take the report of the network setup of a pod.
+	// The report is only sent once the result is written, and only feeds a metric, so it must not hold up the runtime.
+	reportNetworkSetupTimeout = 100 * time.Millisecond
 )
 
 var (
@@ -69,6 +78,12 @@ type NetConf struct {
 
 	// MTU for eth0
 	MTU string `json:"mtu"`
+
+	// Tracing is where the plugin exports its spans, it doesn't by default
+	Tracing tracing.Config `json:"tracing"`
+
+	// LogFormat is the format of the plugin log, "text" or "json". Default is "text".
+	LogFormat string `json:"logFormat"`
 }
 
 // K8sArgs is the valid CNI_ARGS used for Kubernetes
@@ -99,10 +114,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 }
 
 func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrapper.GRPC,
-	rpcClient rpcwrapper.RPC, driverClient driver.NetworkAPIs) error {
-	log.Infof("Received CNI add request: ContainerID(%s) Netns(%s) IfName(%s) Args(%s) Path(%s) argsStdinData(%s)",
-		args.ContainerID, args.Netns, args.IfName, args.Args, args.Path, args.StdinData)
-
+	rpcClient rpcwrapper.RPC, driverClient driver.NetworkAPIs) (err error) {
 	conf := NetConf{}
 	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
 		log.Errorf("Error loading config from args: %v", err)
@@ -114,6 +126,11 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		log.Errorf("Failed to load k8s config from arg: %v", err)
 		return errors.Wrap(err, "add cmd: failed to load k8s config from arg")
 	}
+	ctx, plog := logCommand(conf, k8sArgs)
+	plog.Infof("Received CNI add request: ContainerID(%s) Netns(%s) IfName(%s) Args(%s) Path(%s) argsStdinData(%s)",
+		args.ContainerID, args.Netns, args.IfName, args.Args, args.Path, args.StdinData)
+	ctx, endTrace := traceCommand(ctx, conf, "cni.add", args, k8sArgs)
+	defer func() { endTrace(err) }()
 
 	// Default the host-side veth prefix to 'eni'.
 	if conf.VethPrefix == "" {
@@ -125,7 +142,7 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 
 	// MTU
 	if conf.MTU == "" {
-		log.Debug("MTU not set, defaulting to 9001")
+		plog.Debugf("MTU not set, defaulting to 9001")
 		conf.MTU = "9001"
 	}
 	mtu := networkutils.GetEthernetMTU(conf.MTU)
@@ -133,20 +150,17 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 	cniVersion := conf.CNIVersion
 
 	// Set up a connection to the ipamD server.
-	conn, err := grpcClient.Dial(ipamDAddress, grpc.WithInsecure())
+	conn, err := grpcClient.Dial(ipamDAddress, grpc.WithInsecure(),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor(), logger.UnaryClientInterceptor()))
 	if err != nil {
-		log.Errorf("Failed to connect to backend server for pod %s namespace %s sandbox %s: %v",
-			string(k8sArgs.K8S_POD_NAME),
-			string(k8sArgs.K8S_POD_NAMESPACE),
-			string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID),
-			err)
+		plog.Errorf("Failed to connect to backend server: %v", err)
 		return errors.Wrap(err, "add cmd: failed to connect to backend server")
 	}
 	defer conn.Close()
 
 	c := rpcClient.NewCNIBackendClient(conn)
 
-	r, err := c.AddNetwork(context.Background(),
+	r, err := c.AddNetwork(ctx,
 		&pb.AddNetworkRequest{
 			Netns:                      args.Netns,
 			K8S_POD_NAME:               string(k8sArgs.K8S_POD_NAME),
@@ -155,25 +169,21 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 			IfName:                     args.IfName})
 
 	if err != nil {
-		log.Errorf("Error received from AddNetwork grpc call for pod %s namespace %s sandbox %s: %v",
-			string(k8sArgs.K8S_POD_NAME),
-			string(k8sArgs.K8S_POD_NAMESPACE),
-			string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID),
-			err)
+		plog.Errorf("Error received from AddNetwork grpc call: %v", err)
 		return err
 	}
 
 	if !r.Success {
-		log.Errorf("Failed to assign an IP address to pod %s, namespace %s sandbox %s",
-			string(k8sArgs.K8S_POD_NAME),
-			string(k8sArgs.K8S_POD_NAMESPACE),
-			string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID))
+		plog.Errorf("Failed to assign an IP address: %s %s", r.ErrorCode, r.ErrorMessage)
+		if r.ErrorCode != "" {
+			return fmt.Errorf("add cmd: failed to assign an IP address to container: %s: %s", r.ErrorCode, r.ErrorMessage)
+		}
 		return fmt.Errorf("add cmd: failed to assign an IP address to container")
 	}
 
-	log.Infof("Received add network response for pod %s namespace %s sandbox %s: %s, table %d, external-SNAT: %v, vpcCIDR: %v",
-		string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID),
-		r.IPv4Addr, r.DeviceNumber, r.UseExternalSNAT, r.VPCcidrs)
+	plog = plog.With(logger.Fields{IP: r.IPv4Addr})
+	plog.Infof("Received add network response: %s, table %d, external-SNAT: %v, per-ENI-SNAT: %v, vpcCIDR: %v",
+		r.IPv4Addr, r.DeviceNumber, r.UseExternalSNAT, r.UsePerENISNAT, r.VPCcidrs)
 
 	addr := &net.IPNet{
 		IP:   net.ParseIP(r.IPv4Addr),
@@ -184,14 +194,23 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 	// Note: the maximum length for linux interface name is 15
 	hostVethName := generateHostVethName(conf.VethPrefix, string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
 
-	err = driverClient.SetupNS(hostVethName, args.IfName, args.Netns, addr, int(r.DeviceNumber), r.VPCcidrs, r.UseExternalSNAT, mtu)
+	// With per-ENI SNAT, pods on secondary ENIs send all of their traffic through their own ENI, which is the same
+	// routing that is used when SNAT is done outside of the node.
+	routeAllTrafficViaENI := r.UseExternalSNAT || r.UsePerENISNAT
+	layout := routingLayout(r.RouteTableOffset, r.RulePriorityBase)
+	setupStart := time.Now()
+	_, setupSpan := tracing.Start(ctx, "driver.SetupNS", tracing.KindInternal)
+	setupSpan.SetAttribute("net.interface.host", hostVethName)
+	setupSpan.SetAttribute("cni.device_number", r.DeviceNumber)
+	err = driverClient.SetupNS(hostVethName, args.IfName, args.Netns, addr, int(r.DeviceNumber), layout, r.VPCcidrs, routeAllTrafficViaENI, mtu)
+	setupSpan.End(err)
+	setupElapsed := time.Since(setupStart)
 
 	if err != nil {
-		log.Errorf("Failed SetupPodNetwork for pod %s namespace %s sandbox %s: %v",
-			string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID), err)
+		plog.Errorf("Failed SetupPodNetwork: %v", err)
 
 		// return allocated IP back to IP pool
-		r, delErr := c.DelNetwork(context.Background(),
+		r, delErr := c.DelNetwork(ctx,
 			&pb.DelNetworkRequest{
 				K8S_POD_NAME:               string(k8sArgs.K8S_POD_NAME),
 				K8S_POD_NAMESPACE:          string(k8sArgs.K8S_POD_NAMESPACE),
@@ -200,14 +219,13 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 				Reason:                     "SetupNSFailed"})
 
 		if delErr != nil {
-			log.Errorf("Error received from DelNetwork grpc call for pod %s namespace %s sandbox %s: %v",
-				string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID), delErr)
+			plog.Errorf("Error received from DelNetwork grpc call: %v", delErr)
 		}
 
 		if !r.Success {
-			log.Errorf("Failed to release IP of pod %s namespace %s sandbox %s: %v",
-				string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID), delErr)
+			plog.Errorf("Failed to release IP: %v", delErr)
 		}
+		reportNetworkSetup(ctx, plog, c, k8sArgs, addr.IP.String(), false, setupElapsed)
 		return errors.Wrap(err, "add command: failed to setup network")
 	}
 
@@ -222,7 +240,76 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		IPs: ips,
 	}
 
-	return cniTypes.PrintResult(result, cniVersion)
+	if err = cniTypes.PrintResult(result, cniVersion); err != nil {
+		return err
+	}
+	reportNetworkSetup(ctx, plog, c, k8sArgs, r.IPv4Addr, true, setupElapsed)
+	return nil
+}
+
+// reportNetworkSetup tells ipamd how long setting up the network of the pod took, for its metrics. It doesn't fail the
+// add command, versions of ipamd from before the report was added don't know about it, and it gives up after
+// reportNetworkSetupTimeout.
+func reportNetworkSetup(ctx context.Context, plog logger.Entry, c pb.CNIBackendClient, k8sArgs K8sArgs, ipv4Addr string,
+	success bool, elapsed time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, reportNetworkSetupTimeout)
+	defer cancel()
+	_, err := c.ReportNetworkSetup(ctx, &pb.ReportNetworkSetupRequest{
+		K8S_POD_NAME:               string(k8sArgs.K8S_POD_NAME),
+		K8S_POD_NAMESPACE:          string(k8sArgs.K8S_POD_NAMESPACE),
+		K8S_POD_INFRA_CONTAINER_ID: string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID),
+		IPv4Addr:                   ipv4Addr,
+		Success:                    success,
+		SetupSeconds:               elapsed.Seconds(),
+	})
+	if err != nil && status.Code(err) != codes.Unimplemented {
+		plog.Warnf("Failed to report the network setup: %v", err)
+	}
+}
+
+// logCommand applies the log format of the config, and returns the logger of the CNI command with a new request ID.
+// The returned context sends the request ID to ipamd with the gRPC requests, so that their logs can be joined.
+func logCommand(conf NetConf, k8sArgs K8sArgs) (context.Context, logger.Entry) {
+	if conf.LogFormat != "" {
+		if err := logger.SetLogFormat(conf.LogFormat); err != nil {
+			log.Warnf("Failed to set the log format: %v", err)
+		}
+	}
+	requestID := logger.NewRequestID()
+	return logger.ContextWithRequestID(context.Background(), requestID), logger.With(logger.Fields{
+		RequestID: requestID,
+		Pod:       string(k8sArgs.K8S_POD_NAME),
+		Namespace: string(k8sArgs.K8S_POD_NAMESPACE),
+		Sandbox:   string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID),
+	})
+}
+
+// traceCommand sets up the tracing of the plugin and starts the span of the CNI command. The returned function ends the
+// span with the error of the command, and exports the spans. Tracing problems don't fail the command.
+func traceCommand(ctx context.Context, conf NetConf, name string, args *skel.CmdArgs, k8sArgs K8sArgs) (context.Context, func(error)) {
+	shutdown, err := tracing.InitCommand("aws-cni", conf.Tracing.WithDefaults(defaultTraceFilePath))
+	if err != nil {
+		log.Warnf("Failed to set up tracing: %v", err)
+	}
+	ctx, span := tracing.Start(ctx, name, tracing.KindInternal)
+	span.SetAttribute("cni.request_id", logger.RequestIDFromContext(ctx))
+	span.SetAttribute("k8s.pod.name", string(k8sArgs.K8S_POD_NAME))
+	span.SetAttribute("k8s.namespace.name", string(k8sArgs.K8S_POD_NAMESPACE))
+	span.SetAttribute("cni.container_id", args.ContainerID)
+	span.SetAttribute("cni.netns", args.Netns)
+	return ctx, func(err error) {
+		span.End(err)
+		shutdown()
+	}
+}
+
+// routingLayout returns the route table numbering and rule priorities sent by ipamd. Versions of ipamd from before
+// they were configurable don't send them and use the default ones.
+func routingLayout(tableOffset int32, priorityBase int32) networkutils.RoutingLayout {
+	if priorityBase == 0 {
+		return networkutils.DefaultRoutingLayout
+	}
+	return networkutils.RoutingLayout{TableOffset: int(tableOffset), PriorityBase: int(priorityBase)}
 }
 
 // generateHostVethName returns a name to be used on the host-side veth device.
@@ -237,11 +324,7 @@ func cmdDel(args *skel.CmdArgs) error {
 }
 
 func del(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrapper.GRPC, rpcClient rpcwrapper.RPC,
-	driverClient driver.NetworkAPIs) error {
-
-	log.Infof("Received CNI del request: ContainerID(%s) Netns(%s) IfName(%s) Args(%s) Path(%s) argsStdinData(%s)",
-		args.ContainerID, args.Netns, args.IfName, args.Args, args.Path, args.StdinData)
-
+	driverClient driver.NetworkAPIs) (err error) {
 	conf := NetConf{}
 	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
 		log.Errorf("Failed to load netconf from args %v", err)
@@ -253,24 +336,25 @@ func del(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		log.Errorf("Failed to load k8s config from args: %v", err)
 		return errors.Wrap(err, "del cmd: failed to load k8s config from args")
 	}
+	ctx, plog := logCommand(conf, k8sArgs)
+	plog.Infof("Received CNI del request: ContainerID(%s) Netns(%s) IfName(%s) Args(%s) Path(%s) argsStdinData(%s)",
+		args.ContainerID, args.Netns, args.IfName, args.Args, args.Path, args.StdinData)
+	ctx, endTrace := traceCommand(ctx, conf, "cni.del", args, k8sArgs)
+	defer func() { endTrace(err) }()
 
 	// notify local IP address manager to free secondary IP
 	// Set up a connection to the server.
-	conn, err := grpcClient.Dial(ipamDAddress, grpc.WithInsecure())
+	conn, err := grpcClient.Dial(ipamDAddress, grpc.WithInsecure(),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor(), logger.UnaryClientInterceptor()))
 	if err != nil {
-		log.Errorf("Failed to connect to backend server for pod %s namespace %s sandbox %s: %v",
-			string(k8sArgs.K8S_POD_NAME),
-			string(k8sArgs.K8S_POD_NAMESPACE),
-			string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID),
-			err)
-
+		plog.Errorf("Failed to connect to backend server: %v", err)
 		return errors.Wrap(err, "del cmd: failed to connect to backend server")
 	}
 	defer conn.Close()
 
 	c := rpcClient.NewCNIBackendClient(conn)
 
-	r, err := c.DelNetwork(context.Background(),
+	r, err := c.DelNetwork(ctx,
 		&pb.DelNetworkRequest{
 			K8S_POD_NAME:               string(k8sArgs.K8S_POD_NAME),
 			K8S_POD_NAMESPACE:          string(k8sArgs.K8S_POD_NAMESPACE),
@@ -283,42 +367,41 @@ func del(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 			// Plugins should generally complete a DEL action without error even if some resources are missing. For example,
 			// an IPAM plugin should generally release an IP allocation and return success even if the container network
 			// namespace no longer exists, unless that network namespace is critical for IPAM management
-			log.Infof("Pod %s in namespace %s not found", string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE))
+			plog.Infof("Pod not found")
 			return nil
 		} else {
-			log.Errorf("Error received from DelNetwork grpc call for pod %s namespace %s sandbox %s: %v",
-				string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID), err)
+			plog.Errorf("Error received from DelNetwork grpc call: %v", err)
 			return err
 		}
 	}
 
 	if !r.Success {
-		log.Errorf("Failed to process delete request for pod %s namespace %s sandbox %s: Success == false",
-			string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID))
+		plog.Errorf("Failed to process delete request: Success == false")
 		return errors.New("del cmd: failed to process delete request")
 	}
 
+	plog = plog.With(logger.Fields{IP: r.IPv4Addr})
 	deletedPodIp := net.ParseIP(r.IPv4Addr)
 	if deletedPodIp != nil {
 		addr := &net.IPNet{
 			IP:   deletedPodIp,
 			Mask: net.IPv4Mask(255, 255, 255, 255),
 		}
-		err = driverClient.TeardownNS(addr, int(r.DeviceNumber))
+		_, teardownSpan := tracing.Start(ctx, "driver.TeardownNS", tracing.KindInternal)
+		err = driverClient.TeardownNS(addr, int(r.DeviceNumber), routingLayout(r.RouteTableOffset, r.RulePriorityBase))
+		teardownSpan.End(err)
 		if err != nil {
-			log.Errorf("Failed on TeardownPodNetwork for pod %s namespace %s sandbox %s: %v",
-				string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID), err)
+			plog.Errorf("Failed on TeardownPodNetwork: %v", err)
 			return err
 		}
 	} else {
-		log.Warnf("Pod %s in namespace %s did not have a valid IP %s", string(k8sArgs.K8S_POD_NAME),
-			string(k8sArgs.K8S_POD_NAMESPACE), r.IPv4Addr)
+		plog.Warnf("Pod did not have a valid IP %s", r.IPv4Addr)
 	}
 	return nil
 }
 
 func main() {
-	logger.SetupLogger(logger.GetLogFileLocation(defaultLogFilePath))
+	logger.SetupLogger(logger.GetLogFileLocation(defaultLogFilePath), "aws-cni")
 
 	log.Infof("Starting CNI Plugin %s ...", version)
 
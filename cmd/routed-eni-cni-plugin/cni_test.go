@@ -18,14 +18,19 @@ import (
 	"errors"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	mock_driver "github.com/aws/amazon-vpc-cni-k8s/cmd/routed-eni-cni-plugin/driver/mocks"
 	mock_grpcwrapper "github.com/aws/amazon-vpc-cni-k8s/pkg/grpcwrapper/mocks"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
 	mock_rpcwrapper "github.com/aws/amazon-vpc-cni-k8s/pkg/rpcwrapper/mocks"
 	mock_typeswrapper "github.com/aws/amazon-vpc-cni-k8s/pkg/typeswrapper/mocks"
 	"github.com/aws/amazon-vpc-cni-k8s/rpc"
@@ -93,9 +98,19 @@ func TestCmdAdd(t *testing.T) {
 	}
 
 	mocksNetwork.EXPECT().SetupNS(gomock.Any(), cmdArgs.IfName, cmdArgs.Netns,
-		addr, int(addNetworkReply.DeviceNumber), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
-
-	mocksTypes.EXPECT().PrintResult(gomock.Any(), gomock.Any()).Return(nil)
+		addr, int(addNetworkReply.DeviceNumber), networkutils.DefaultRoutingLayout, gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	// The setup is only reported once the result is written, so that it doesn't hold up the runtime
+	gomock.InOrder(
+		mocksTypes.EXPECT().PrintResult(gomock.Any(), gomock.Any()).Return(nil),
+		mockC.EXPECT().ReportNetworkSetup(gomock.Any(), gomock.Any()).Do(func(ctx context.Context, in *rpc.ReportNetworkSetupRequest) {
+			assert.Equal(t, ipAddr, in.IPv4Addr)
+			assert.True(t, in.Success)
+			assert.True(t, in.SetupSeconds >= 0)
+			deadline, ok := ctx.Deadline()
+			assert.True(t, ok)
+			assert.True(t, time.Until(deadline) <= reportNetworkSetupTimeout)
+		}).Return(&rpc.ReportNetworkSetupReply{}, nil),
+	)
 
 	err := add(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
 	assert.Nil(t, err)
@@ -131,6 +146,37 @@ func TestCmdAddNetworkErr(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestCmdAddQuotaExceeded(t *testing.T) {
+	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
+	defer ctrl.Finish()
+
+	netconf := &NetConf{CNIVersion: cniVersion,
+		Name: cniName,
+		Type: cniType}
+	stdinData, _ := json.Marshal(netconf)
+
+	cmdArgs := &skel.CmdArgs{ContainerID: containerID,
+		Netns:     netNS,
+		IfName:    ifName,
+		StdinData: stdinData}
+
+	mocksTypes.EXPECT().LoadArgs(gomock.Any(), gomock.Any()).Return(nil)
+
+	conn, _ := grpc.Dial(ipamDAddress, grpc.WithInsecure())
+
+	mocksGRPC.EXPECT().Dial(gomock.Any(), gomock.Any()).Return(conn, nil)
+	mockC := mock_rpc.NewMockCNIBackendClient(ctrl)
+	mocksRPC.EXPECT().NewCNIBackendClient(conn).Return(mockC)
+
+	addNetworkReply := &rpc.AddNetworkReply{Success: false, ErrorCode: "QuotaExceeded",
+		ErrorMessage: "namespace ns is using all of its 5 IP addresses on this node"}
+	mockC.EXPECT().AddNetwork(gomock.Any(), gomock.Any()).Return(addNetworkReply, nil)
+
+	err := add(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
+	assert.EqualError(t, err, "add cmd: failed to assign an IP address to container: QuotaExceeded: "+
+		"namespace ns is using all of its 5 IP addresses on this node")
+}
+
 func TestCmdAddErrSetupPodNetwork(t *testing.T) {
 	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
 	defer ctrl.Finish()
@@ -162,7 +208,11 @@ func TestCmdAddErrSetupPodNetwork(t *testing.T) {
 	}
 
 	mocksNetwork.EXPECT().SetupNS(gomock.Any(), cmdArgs.IfName, cmdArgs.Netns,
-		addr, int(addNetworkReply.DeviceNumber), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("error on SetupPodNetwork"))
+		addr, int(addNetworkReply.DeviceNumber), networkutils.DefaultRoutingLayout, gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("error on SetupPodNetwork"))
+	// An ipamd that doesn't know the report doesn't fail the add command with another error
+	mockC.EXPECT().ReportNetworkSetup(gomock.Any(), gomock.Any()).Do(func(_ context.Context, in *rpc.ReportNetworkSetupRequest) {
+		assert.False(t, in.Success)
+	}).Return(nil, status.Error(codes.Unimplemented, "unknown method ReportNetworkSetup"))
 
 	// when SetupPodNetwork fails, expect to return IP back to datastore
 	delNetworkReply := &rpc.DelNetworkReply{Success: true, IPv4Addr: ipAddr, DeviceNumber: devNum}
@@ -171,6 +221,7 @@ func TestCmdAddErrSetupPodNetwork(t *testing.T) {
 	err := add(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
 
 	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "error on SetupPodNetwork")
 }
 
 func TestCmdDel(t *testing.T) {
@@ -204,7 +255,7 @@ func TestCmdDel(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 
-	mocksNetwork.EXPECT().TeardownNS(addr, int(delNetworkReply.DeviceNumber)).Return(nil)
+	mocksNetwork.EXPECT().TeardownNS(addr, int(delNetworkReply.DeviceNumber), networkutils.DefaultRoutingLayout).Return(nil)
 
 	err := del(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
 	assert.Nil(t, err)
@@ -271,8 +322,14 @@ func TestCmdDelErrTeardown(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 
-	mocksNetwork.EXPECT().TeardownNS(addr, int(delNetworkReply.DeviceNumber)).Return(errors.New("error on teardown"))
+	mocksNetwork.EXPECT().TeardownNS(addr, int(delNetworkReply.DeviceNumber), networkutils.DefaultRoutingLayout).Return(errors.New("error on teardown"))
 
 	err := del(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
 	assert.Error(t, err)
 }
+
+func TestRoutingLayout(t *testing.T) {
+	assert.Equal(t, networkutils.RoutingLayout{TableOffset: 100, PriorityBase: 2048}, routingLayout(100, 2048))
+	// ipamd versions that don't send the layout use the default one
+	assert.Equal(t, networkutils.DefaultRoutingLayout, routingLayout(0, 0))
+}
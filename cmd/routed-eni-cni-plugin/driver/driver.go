@@ -32,18 +32,15 @@ import (
 )
 
 const (
-	// IP rules priority, leaving a 512 gap for the future
-	toContainerRulePriority = 512
-	// 1024 is reserved for (IP rule not to <VPC's subnet> table main)
-	fromContainerRulePriority = 1536
 	// Main routing table number
 	mainRouteTable = unix.RT_TABLE_MAIN
 )
 
 // NetworkAPIs defines network API calls
 type NetworkAPIs interface {
-	SetupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, table int, vpcCIDRs []string, useExternalSNAT bool, mtu int) error
-	TeardownNS(addr *net.IPNet, table int) error
+	SetupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, deviceNumber int,
+		layout networkutils.RoutingLayout, vpcCIDRs []string, useExternalSNAT bool, mtu int) error
+	TeardownNS(addr *net.IPNet, deviceNumber int, layout networkutils.RoutingLayout) error
 }
 
 type linuxNetwork struct {
@@ -164,13 +161,15 @@ func (createVethContext *createVethPairContext) run(hostNS ns.NetNS) error {
 }
 
 // SetupNS wires up linux networking for a pod's network
-func (os *linuxNetwork) SetupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, table int, vpcCIDRs []string, useExternalSNAT bool, mtu int) error {
-	log.Debugf("SetupNS: hostVethName=%s, contVethName=%s, netnsPath=%s, table=%d, mtu=%d", hostVethName, contVethName, netnsPath, table, mtu)
-	return setupNS(hostVethName, contVethName, netnsPath, addr, table, vpcCIDRs, useExternalSNAT, os.netLink, os.ns, mtu)
+func (os *linuxNetwork) SetupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, deviceNumber int,
+	layout networkutils.RoutingLayout, vpcCIDRs []string, useExternalSNAT bool, mtu int) error {
+	log.Debugf("SetupNS: hostVethName=%s, contVethName=%s, netnsPath=%s, deviceNumber=%d, layout=%s, mtu=%d",
+		hostVethName, contVethName, netnsPath, deviceNumber, layout, mtu)
+	return setupNS(hostVethName, contVethName, netnsPath, addr, deviceNumber, layout, vpcCIDRs, useExternalSNAT, os.netLink, os.ns, mtu)
 }
 
-func setupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, table int, vpcCIDRs []string, useExternalSNAT bool,
-	netLink netlinkwrapper.NetLink, ns nswrapper.NS, mtu int) error {
+func setupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, deviceNumber int,
+	layout networkutils.RoutingLayout, vpcCIDRs []string, useExternalSNAT bool, netLink netlinkwrapper.NetLink, ns nswrapper.NS, mtu int) error {
 	// Clean up if hostVeth exists.
 	if oldHostVeth, err := netLink.LinkByName(hostVethName); err == nil {
 		if err = netLink.LinkDel(oldHostVeth); err != nil {
@@ -213,7 +212,7 @@ func setupNS(hostVethName string, contVethName string, netnsPath string, addr *n
 	}
 	log.Debugf("Successfully set host route to be %s/0", route.Dst.IP.String())
 
-	err = addContainerRule(netLink, true, addr, mainRouteTable)
+	err = addContainerRule(netLink, true, addr, mainRouteTable, layout.ToPodRulePriority())
 
 	if err != nil {
 		log.Errorf("Failed to add toContainer rule for %s err=%v, ", addr.String(), err)
@@ -223,15 +222,16 @@ func setupNS(hostVethName string, contVethName string, netnsPath string, addr *n
 	log.Infof("Added toContainer rule for %s", addr.String())
 
 	// add from-pod rule, only need it when it is not primary ENI
-	if table > 0 {
+	if deviceNumber > 0 {
+		table := layout.ENIRouteTable(deviceNumber)
 		if useExternalSNAT {
 			// add rule: 1536: from <podIP> use table <table>
-			err = addContainerRule(netLink, false, addr, table)
+			err = addContainerRule(netLink, false, addr, table, layout.FromPodRulePriority())
 			if err != nil {
 				log.Errorf("Failed to add fromContainer rule for %s err: %v", addr.String(), err)
 				return errors.Wrap(err, "add NS network: failed to add fromContainer rule")
 			}
-			log.Infof("Added rule priority %d from %s table %d", fromContainerRulePriority, addr.String(), table)
+			log.Infof("Added rule priority %d from %s table %d", layout.FromPodRulePriority(), addr.String(), table)
 		} else {
 			// add rule: 1536: list of from <podIP> to <vpcCIDR> use table <table>
 			for _, cidr := range vpcCIDRs {
@@ -239,7 +239,7 @@ func setupNS(hostVethName string, contVethName string, netnsPath string, addr *n
 				_, podRule.Dst, _ = net.ParseCIDR(cidr)
 				podRule.Src = addr
 				podRule.Table = table
-				podRule.Priority = fromContainerRulePriority
+				podRule.Priority = layout.FromPodRulePriority()
 
 				err = netLink.RuleAdd(podRule)
 				if isRuleExistsError(err) {
@@ -262,7 +262,7 @@ func setupNS(hostVethName string, contVethName string, netnsPath string, addr *n
 	return nil
 }
 
-func addContainerRule(netLink netlinkwrapper.NetLink, isToContainer bool, addr *net.IPNet, table int) error {
+func addContainerRule(netLink netlinkwrapper.NetLink, isToContainer bool, addr *net.IPNet, table int, priority int) error {
 	if addr == nil {
 		return errors.New("can't add container rules without an IP address")
 	}
@@ -270,13 +270,12 @@ func addContainerRule(netLink netlinkwrapper.NetLink, isToContainer bool, addr *
 	if isToContainer {
 		// Example: 512:	from all to 10.200.202.222 lookup main
 		containerRule.Dst = addr
-		containerRule.Priority = toContainerRulePriority
 	} else {
 		// Example: 1536:	from 10.200.202.222 to 10.200.0.0/16 lookup 2
 		containerRule.Src = addr
-		containerRule.Priority = fromContainerRulePriority
 	}
 	containerRule.Table = table
+	containerRule.Priority = priority
 
 	err := netLink.RuleDel(containerRule)
 	if err != nil && !containsNoSuchRule(err) {
@@ -291,19 +290,19 @@ func addContainerRule(netLink netlinkwrapper.NetLink, isToContainer bool, addr *
 }
 
 // TeardownPodNetwork cleanup ip rules
-func (os *linuxNetwork) TeardownNS(addr *net.IPNet, table int) error {
-	log.Debugf("TeardownNS: addr %s, table %d", addr.String(), table)
-	return tearDownNS(addr, table, os.netLink)
+func (os *linuxNetwork) TeardownNS(addr *net.IPNet, deviceNumber int, layout networkutils.RoutingLayout) error {
+	log.Debugf("TeardownNS: addr %s, deviceNumber %d, layout %s", addr.String(), deviceNumber, layout)
+	return tearDownNS(addr, deviceNumber, layout, os.netLink)
 }
 
-func tearDownNS(addr *net.IPNet, table int, netLink netlinkwrapper.NetLink) error {
+func tearDownNS(addr *net.IPNet, deviceNumber int, layout networkutils.RoutingLayout, netLink netlinkwrapper.NetLink) error {
 	if addr == nil {
 		return errors.New("can't tear down network namespace with no IP address")
 	}
 	// Remove to-pod rule
 	toContainerRule := netLink.NewRule()
 	toContainerRule.Dst = addr
-	toContainerRule.Priority = toContainerRulePriority
+	toContainerRule.Priority = layout.ToPodRulePriority()
 	err := netLink.RuleDel(toContainerRule)
 
 	if err != nil {
@@ -312,14 +311,14 @@ func tearDownNS(addr *net.IPNet, table int, netLink netlinkwrapper.NetLink) erro
 		log.Infof("Delete toContainer rule for %s ", addr.String())
 	}
 
-	if table > 0 {
+	if deviceNumber > 0 {
 		// remove from-pod rule only for non main table
 		err := deleteRuleListBySrc(*addr)
 		if err != nil {
 			log.Errorf("Failed to delete fromContainer for %s %v", addr.String(), err)
 			return errors.Wrapf(err, "delete NS network: failed to delete fromContainer rule for %s", addr.String())
 		}
-		log.Infof("Delete fromContainer rule for %s in table %d", addr.String(), table)
+		log.Infof("Delete fromContainer rule for %s in table %d", addr.String(), layout.ENIRouteTable(deviceNumber))
 	}
 
 	addrHostAddr := &net.IPNet{
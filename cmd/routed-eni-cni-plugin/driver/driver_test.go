@@ -27,6 +27,7 @@ import (
 	mocks_ip "github.com/aws/amazon-vpc-cni-k8s/pkg/ipwrapper/mocks"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/netlinkwrapper/mock_netlink"
 	mock_netlinkwrapper "github.com/aws/amazon-vpc-cni-k8s/pkg/netlinkwrapper/mocks"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
 	mock_nswrapper "github.com/aws/amazon-vpc-cni-k8s/pkg/nswrapper/mocks"
 )
 
@@ -39,7 +40,7 @@ const (
 	testHostVethName = "aws-eth0"
 	testFD           = 10
 	testnetnsPath    = "/proc/1234/netns"
-	testTable        = 10
+	testDeviceNumber = 10
 	testeniIP        = "10.10.10.20"
 	testeniMAC       = "01:23:45:67:89:ab"
 	testeniSubnet    = "10.10.0.0/16"
@@ -530,10 +531,46 @@ func TestSetupPodNetwork(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 	var cidrs []string
-	err = setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, true, mockNetLink, mockNS, mtu)
+	err = setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testDeviceNumber, networkutils.DefaultRoutingLayout, cidrs, true, mockNetLink, mockNS, mtu)
 	assert.NoError(t, err)
 }
 
+func TestSetupPodNetworkRoutingLayout(t *testing.T) {
+	ctrl, mockNetLink, _, mockNS := setup(t)
+	defer ctrl.Finish()
+
+	mockHostVeth := mock_netlink.NewMockLink(ctrl)
+
+	mockNetLink.EXPECT().LinkByName(testHostVethName).Return(mockHostVeth, errors.New("hostVeth already exists"))
+	mockNS.EXPECT().WithNetNSPath(testnetnsPath, gomock.Any()).Return(nil)
+	mockNetLink.EXPECT().LinkByName(testHostVethName).Return(mockHostVeth, nil)
+	mockNetLink.EXPECT().LinkSetUp(mockHostVeth).Return(nil)
+	mockHostVeth.EXPECT().Attrs().Return(&netlink.LinkAttrs{}).Times(2)
+	mockNetLink.EXPECT().RouteReplace(gomock.Any()).Return(nil)
+
+	var addedRules []netlink.Rule
+	mockNetLink.EXPECT().NewRule().DoAndReturn(netlink.NewRule).Times(2)
+	mockNetLink.EXPECT().RuleDel(gomock.Any()).Return(nil)
+	mockNetLink.EXPECT().RuleAdd(gomock.Any()).Do(func(rule *netlink.Rule) {
+		addedRules = append(addedRules, *rule)
+	}).Return(nil).Times(2)
+
+	addr := &net.IPNet{
+		IP:   net.ParseIP(testIP),
+		Mask: net.IPv4Mask(255, 255, 255, 255),
+	}
+	layout := networkutils.RoutingLayout{TableOffset: 100, PriorityBase: 2048}
+	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testDeviceNumber, layout, []string{"10.0.0.0/16"}, false, mockNetLink, mockNS, mtu)
+	assert.NoError(t, err)
+
+	// The to-pod rule looks up the main table, the from-pod rule the ENI's table
+	assert.Len(t, addedRules, 2)
+	assert.Equal(t, 2048, addedRules[0].Priority)
+	assert.Equal(t, mainRouteTable, addedRules[0].Table)
+	assert.Equal(t, 3072, addedRules[1].Priority)
+	assert.Equal(t, 110, addedRules[1].Table)
+}
+
 func TestSetupPodNetworkErrLinkByName(t *testing.T) {
 	ctrl, mockNetLink, _, mockNS := setup(t)
 	defer ctrl.Finish()
@@ -549,7 +586,7 @@ func TestSetupPodNetworkErrLinkByName(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 	var cidrs []string
-	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, mockNetLink, mockNS, mtu)
+	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testDeviceNumber, networkutils.DefaultRoutingLayout, cidrs, false, mockNetLink, mockNS, mtu)
 
 	assert.Error(t, err)
 }
@@ -571,7 +608,7 @@ func TestSetupPodNetworkErrLinkSetup(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 	var cidrs []string
-	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, mockNetLink, mockNS, mtu)
+	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testDeviceNumber, networkutils.DefaultRoutingLayout, cidrs, false, mockNetLink, mockNS, mtu)
 
 	assert.Error(t, err)
 }
@@ -604,7 +641,7 @@ func TestSetupPodNetworkErrRouteReplace(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 	var cidrs []string
-	err = setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, mockNetLink, mockNS, mtu)
+	err = setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testDeviceNumber, networkutils.DefaultRoutingLayout, cidrs, false, mockNetLink, mockNS, mtu)
 
 	assert.Error(t, err)
 }
@@ -652,7 +689,7 @@ func TestSetupPodNetworkPrimaryIntf(t *testing.T) {
 	}
 
 	var cidrs []string
-	err = setupNS(testHostVethName, testContVethName, testnetnsPath, addr, 0, cidrs, false, mockNetLink, mockNS, mtu)
+	err = setupNS(testHostVethName, testContVethName, testnetnsPath, addr, 0, networkutils.DefaultRoutingLayout, cidrs, false, mockNetLink, mockNS, mtu)
 
 	assert.NoError(t, err)
 }
@@ -683,7 +720,7 @@ func TestTearDownPodNetwork(t *testing.T) {
 		IP:   net.ParseIP(testIP),
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
-	err := tearDownNS(addr, 0, mockNetLink)
+	err := tearDownNS(addr, 0, networkutils.DefaultRoutingLayout, mockNetLink)
 	assert.NoError(t, err)
 }
 
@@ -712,6 +749,6 @@ func TestTearDownPodNetworkMain(t *testing.T) {
 		IP:   net.ParseIP(testIP),
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
-	err := tearDownNS(addr, 0, mockNetLink)
+	err := tearDownNS(addr, 0, networkutils.DefaultRoutingLayout, mockNetLink)
 	assert.NoError(t, err)
 }
@@ -29,6 +29,10 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&ENIConfig{},
 		&ENIConfigList{},
+		&EgressIPConfig{},
+		&EgressIPConfigList{},
+		&IPQuota{},
+		&IPQuotaList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -28,3 +28,57 @@ type ENIConfigSpec struct {
 type ENIConfigStatus struct {
 	// Fill me
 }
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type EgressIPConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []EgressIPConfig `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type EgressIPConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              EgressIPConfigSpec   `json:"spec"`
+	Status            EgressIPConfigStatus `json:"status,omitempty"`
+}
+
+// EgressIPConfigSpec selects the pods whose traffic leaving the VPC is SNATed to a dedicated IP address on each node
+// instead of the node's primary IP. A pod is selected if it is in one of the namespaces or if it has all the labels
+// of the pod selector.
+type EgressIPConfigSpec struct {
+	Namespaces  []string          `json:"namespaces,omitempty"`
+	PodSelector map[string]string `json:"podSelector,omitempty"`
+}
+
+// EgressIPConfigStatus shows the egress IP address reserved on each node
+type EgressIPConfigStatus struct {
+	// EgressIPs maps a node name to the egress IP address reserved on that node
+	EgressIPs map[string]string `json:"egressIPs,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type IPQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []IPQuota `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type IPQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              IPQuotaSpec `json:"spec"`
+}
+
+// IPQuotaSpec limits the number of IP addresses the pods of each of the namespaces may be assigned on a node. When
+// several IPQuotas name a namespace, the lowest limit applies.
+type IPQuotaSpec struct {
+	Namespaces    []string `json:"namespaces"`
+	MaxIPsPerNode int      `json:"maxIPsPerNode"`
+}
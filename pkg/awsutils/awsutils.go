@@ -19,18 +19,22 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 
 	log "github.com/cihub/seelog"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ec2metadata"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ec2wrapper"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/retry"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/tracing"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -66,6 +70,9 @@ const (
 	eniClusterTagKey        = "cluster.k8s.amazonaws.com/name"
 	additionalEniTagsEnvVar = "ADDITIONAL_ENI_TAGS"
 	reservedTagKeyPrefix    = "k8s.amazonaws.com"
+	// ElasticIPPoolTagKey is the tag key that puts an Elastic IP in a pool. Pods ask for an Elastic IP of a pool with
+	// the pool name.
+	ElasticIPPoolTagKey = "k8s.amazonaws.com/eip-pool"
 	// UnknownInstanceType indicates that the instance type is not yet supported
 	UnknownInstanceType = "vpc ip resource(eni ip limit): unknown instance type"
 
@@ -76,6 +83,10 @@ const (
 // ErrENINotFound is an error when ENI is not found.
 var ErrENINotFound = errors.New("ENI is not found")
 
+// ErrElasticIPAssociated is returned by AssociateElasticIP, without reassociation, for an Elastic IP that is associated
+// with something else
+var ErrElasticIPAssociated = errors.New("Elastic IP is associated with something else")
+
 var (
 	awsAPILatency = prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
@@ -104,7 +115,7 @@ var (
 // APIs defines interfaces calls for adding/getting/deleting ENIs/secondary IPs. The APIs are not thread-safe.
 type APIs interface {
 	// AllocENI creates an ENI and attaches it to the instance
-	AllocENI(useCustomCfg bool, sg []*string, subnet string) (eni string, err error)
+	AllocENI(useCustomCfg bool, sg []*string, subnet string) (eni string, mac string, err error)
 
 	// FreeENI detaches ENI interface and deletes it
 	FreeENI(eniName string) error
@@ -124,12 +135,18 @@ type APIs interface {
 	// DeallocIPAddresses deallocates the list of IP addresses from a ENI
 	DeallocIPAddresses(eniID string, ips []string) error
 
+	// GetIMDSIPv4Addresses returns the IPv4 addresses of an ENI according to IMDS, the primary one first
+	GetIMDSIPv4Addresses(eniMAC string) ([]string, error)
+
 	// GetVPCIPv4CIDR returns VPC's 1st CIDR
 	GetVPCIPv4CIDR() string
 
 	// GetVPCIPv4CIDRs returns VPC's CIDRs
 	GetVPCIPv4CIDRs() []*string
 
+	// RefreshVPCIPv4CIDRs retrieves the VPC's CIDRs again and returns whether they changed
+	RefreshVPCIPv4CIDRs() (bool, error)
+
 	// GetLocalIPv4 returns the primary IP address on the primary ENI interface
 	GetLocalIPv4() string
 
@@ -144,6 +161,21 @@ type APIs interface {
 
 	// GetPrimaryENImac returns the mac address of the primary ENI
 	GetPrimaryENImac() string
+
+	// AssociateElasticIP associates an Elastic IP with a private IP address of an ENI and returns the association ID
+	AssociateElasticIP(allocationID string, eniID string, privateIP string, allowReassociation bool) (string, error)
+
+	// DisassociateElasticIP removes an Elastic IP association
+	DisassociateElasticIP(associationID string) error
+
+	// CanReassociateElasticIP returns whether an Elastic IP may be taken from what it is associated with
+	CanReassociateElasticIP(allocationID string) (bool, error)
+
+	// GetFreeElasticIPs returns the allocation IDs of the unassociated Elastic IPs of a pool
+	GetFreeElasticIPs(pool string) ([]string, error)
+
+	// GetElasticIPAssociations returns the Elastic IPs this instance associated that are still associated with it
+	GetElasticIPAssociations() ([]ElasticIP, error)
 }
 
 // EC2InstanceMetadataCache caches instance metadata
@@ -157,6 +189,8 @@ type EC2InstanceMetadataCache struct {
 	instanceType     string
 	vpcIPv4CIDR      string
 	vpcIPv4CIDRs     []*string
+	// vpcIPv4CIDRsLock guards vpcIPv4CIDRs, which RefreshVPCIPv4CIDRs replaces
+	vpcIPv4CIDRsLock sync.RWMutex
 	primaryENI       string
 	primaryENImac    string
 	availabilityZone string
@@ -170,6 +204,27 @@ type EC2InstanceMetadataCache struct {
 	ec2SVC      ec2wrapper.EC2
 }
 
+// ElasticIP contains information about an Elastic IP association
+type ElasticIP struct {
+	// AllocationID is the allocation ID of the Elastic IP
+	AllocationID string
+
+	// AssociationID is the ID of the association
+	AssociationID string
+
+	// ENIID is the ENI the Elastic IP is associated with
+	ENIID string
+
+	// PrivateIP is the private IP address the Elastic IP is associated with
+	PrivateIP string
+
+	// PublicIP is the Elastic IP address
+	PublicIP string
+
+	// Pool is the value of the Elastic IP's pool tag
+	Pool string
+}
+
 // ENIMetadata contains information about an ENI
 type ENIMetadata struct {
 	// ENIID is the id of network interface
@@ -205,6 +260,76 @@ func msSince(start time.Time) float64 {
 	return float64(time.Since(start) / time.Millisecond)
 }
 
+// APILatency returns the average latency of the successful calls to an AWS API since ipamd started, as observed by
+// the awscni_aws_api_latency_ms metric. It returns false if there was no successful call yet.
+func APILatency(api string) (time.Duration, bool) {
+	var metric dto.Metric
+	if err := awsAPILatency.WithLabelValues(api, "false").Write(&metric); err != nil {
+		return 0, false
+	}
+	summary := metric.GetSummary()
+	if summary.GetSampleCount() == 0 {
+		return 0, false
+	}
+	return time.Duration(summary.GetSampleSum() / float64(summary.GetSampleCount()) * float64(time.Millisecond)), true
+}
+
+// Endpoint is an AWS service ipamd calls
+type Endpoint string
+
+const (
+	// EndpointIMDS is the instance metadata service
+	EndpointIMDS Endpoint = "imds"
+	// EndpointEC2 is the EC2 API
+	EndpointEC2 Endpoint = "ec2"
+)
+
+// EndpointHealth is when an AWS endpoint last answered a call, and when a call to it last got no answer
+type EndpointHealth struct {
+	LastResponse time.Time
+	LastFailure  time.Time
+	LastError    string `json:",omitempty"`
+}
+
+var (
+	endpointHealth     = map[Endpoint]*EndpointHealth{EndpointIMDS: {}, EndpointEC2: {}}
+	endpointHealthLock sync.Mutex
+)
+
+// GetEndpointHealth returns when the endpoint last answered and last failed to answer a call
+func GetEndpointHealth(endpoint Endpoint) EndpointHealth {
+	endpointHealthLock.Lock()
+	defer endpointHealthLock.Unlock()
+	return *endpointHealth[endpoint]
+}
+
+// observeAPICall records the latency of an AWS API call, and whether its endpoint answered. An error response still
+// is an answer, only errors without a response, like timeouts, count as failures. The EC2 calls are traced, the IMDS
+// ones are local and too frequent to be worth it.
+func observeAPICall(api string, start time.Time, err error) {
+	awsAPILatency.WithLabelValues(api, fmt.Sprint(err != nil)).Observe(msSince(start))
+
+	endpoint := EndpointEC2
+	if api == "GetMetadata" {
+		endpoint = EndpointIMDS
+	} else {
+		_, span := tracing.StartAt(context.Background(), "ec2."+api, tracing.KindClient, start)
+		span.SetAttribute("rpc.system", "aws-api")
+		span.SetAttribute("rpc.service", "EC2")
+		span.SetAttribute("rpc.method", api)
+		span.End(err)
+	}
+	endpointHealthLock.Lock()
+	defer endpointHealthLock.Unlock()
+	health := endpointHealth[endpoint]
+	if _, isResponse := err.(awserr.RequestFailure); err == nil || isResponse {
+		health.LastResponse = time.Now()
+		return
+	}
+	health.LastFailure = time.Now()
+	health.LastError = err.Error()
+}
+
 func prometheusRegister() {
 	if !prometheusRegistered {
 		prometheus.MustRegister(awsAPILatency)
@@ -338,20 +463,44 @@ func (cache *EC2InstanceMetadataCache) initWithEC2Metadata() error {
 	log.Debugf("Found vpc-ipv4-cidr-block: %s ", cache.vpcIPv4CIDR)
 
 	// retrieve vpc-ipv4-cidr-blocks
-	metadataVPCIPv4CIDRs, err := cache.ec2Metadata.GetMetadata(metadataMACPath + mac + metadataVPCcidrs)
+	cache.vpcIPv4CIDRs, err = cache.getVPCIPv4CIDRs()
+	return err
+}
+
+// getVPCIPv4CIDRs retrieves the VPC CIDRs from the instance metadata service
+func (cache *EC2InstanceMetadataCache) getVPCIPv4CIDRs() ([]*string, error) {
+	metadataVPCIPv4CIDRs, err := cache.ec2Metadata.GetMetadata(metadataMACPath + cache.primaryENImac + metadataVPCcidrs)
 	if err != nil {
 		awsAPIErrInc("GetMetadata", err)
 		log.Errorf("Failed to retrieve vpc-ipv4-cidr-blocks from instance metadata service")
-		return errors.Wrap(err, "get instance metadata: failed to retrieve vpc-ipv4-cidr-block data")
+		return nil, errors.Wrap(err, "get instance metadata: failed to retrieve vpc-ipv4-cidr-block data")
 	}
 
-	vpcIPv4CIDRs := strings.Fields(metadataVPCIPv4CIDRs)
-
-	for _, vpcCIDR := range vpcIPv4CIDRs {
+	var vpcIPv4CIDRs []*string
+	for _, vpcCIDR := range strings.Fields(metadataVPCIPv4CIDRs) {
 		log.Debugf("Found VPC CIDR: %s", vpcCIDR)
-		cache.vpcIPv4CIDRs = append(cache.vpcIPv4CIDRs, aws.String(vpcCIDR))
+		vpcIPv4CIDRs = append(vpcIPv4CIDRs, aws.String(vpcCIDR))
 	}
-	return nil
+	return vpcIPv4CIDRs, nil
+}
+
+// RefreshVPCIPv4CIDRs retrieves the VPC CIDRs from the instance metadata service again, and returns whether they
+// changed
+func (cache *EC2InstanceMetadataCache) RefreshVPCIPv4CIDRs() (bool, error) {
+	vpcIPv4CIDRs, err := cache.getVPCIPv4CIDRs()
+	if err != nil {
+		return false, err
+	}
+
+	cache.vpcIPv4CIDRsLock.Lock()
+	defer cache.vpcIPv4CIDRsLock.Unlock()
+	if reflect.DeepEqual(aws.StringValueSlice(vpcIPv4CIDRs), aws.StringValueSlice(cache.vpcIPv4CIDRs)) {
+		return false, nil
+	}
+	log.Infof("VPC CIDRs changed from %v to %v",
+		aws.StringValueSlice(cache.vpcIPv4CIDRs), aws.StringValueSlice(vpcIPv4CIDRs))
+	cache.vpcIPv4CIDRs = vpcIPv4CIDRs
+	return true, nil
 }
 
 func (cache *EC2InstanceMetadataCache) setPrimaryENI() error {
@@ -494,7 +643,7 @@ func (cache *EC2InstanceMetadataCache) getENIMetadata(macStr string) (ENIMetadat
 func (cache *EC2InstanceMetadataCache) getIPsAndCIDR(eniMAC string) ([]string, string, error) {
 	start := time.Now()
 	cidr, err := cache.ec2Metadata.GetMetadata(metadataMACPath + eniMAC + metadataSubnetCIDR)
-	awsAPILatency.WithLabelValues("GetMetadata", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("GetMetadata", start, err)
 
 	if err != nil {
 		awsAPIErrInc("GetMetadata", err)
@@ -505,7 +654,7 @@ func (cache *EC2InstanceMetadataCache) getIPsAndCIDR(eniMAC string) ([]string, s
 
 	start = time.Now()
 	ipv4s, err := cache.ec2Metadata.GetMetadata(metadataMACPath + eniMAC + metadataIPv4s)
-	awsAPILatency.WithLabelValues("GetMetadata", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("GetMetadata", start, err)
 	if err != nil {
 		awsAPIErrInc("GetMetadata", err)
 		log.Errorf("Failed to retrieve ENI %s local-ipv4s from instance metadata service, %v", eniMAC, err)
@@ -517,12 +666,25 @@ func (cache *EC2InstanceMetadataCache) getIPsAndCIDR(eniMAC string) ([]string, s
 	return ipv4Strs, cidr, nil
 }
 
+// GetIMDSIPv4Addresses returns the IPv4 addresses of an ENI according to IMDS, the primary one first. IMDS can take a
+// few seconds to see the addresses assigned to or unassigned from the ENI.
+func (cache *EC2InstanceMetadataCache) GetIMDSIPv4Addresses(eniMAC string) ([]string, error) {
+	start := time.Now()
+	ipv4s, err := cache.ec2Metadata.GetMetadata(metadataMACPath + eniMAC + metadataIPv4s)
+	observeAPICall("GetMetadata", start, err)
+	if err != nil {
+		awsAPIErrInc("GetMetadata", err)
+		return nil, errors.Wrapf(err, "failed to retrieve ENI %s local-ipv4s", eniMAC)
+	}
+	return strings.Fields(ipv4s), nil
+}
+
 // getENIDeviceNumber returns ENI ID, device number, error
 func (cache *EC2InstanceMetadataCache) getENIDeviceNumber(eniMAC string) (string, int, error) {
 	// get device-number
 	start := time.Now()
 	device, err := cache.ec2Metadata.GetMetadata(metadataMACPath + eniMAC + metadataDeviceNum)
-	awsAPILatency.WithLabelValues("GetMetadata", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("GetMetadata", start, err)
 	if err != nil {
 		awsAPIErrInc("GetMetadata", err)
 		log.Errorf("Failed to retrieve the device-number of ENI %s, %v", eniMAC, err)
@@ -537,7 +699,7 @@ func (cache *EC2InstanceMetadataCache) getENIDeviceNumber(eniMAC string) (string
 
 	start = time.Now()
 	eni, err := cache.ec2Metadata.GetMetadata(metadataMACPath + eniMAC + metadataInterface)
-	awsAPILatency.WithLabelValues("GetMetadata", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("GetMetadata", start, err)
 	if err != nil {
 		awsAPIErrInc("GetMetadata", err)
 		log.Errorf("Failed to retrieve the interface-id data from instance metadata service, %v", err)
@@ -559,7 +721,7 @@ func (cache *EC2InstanceMetadataCache) awsGetFreeDeviceNumber() (int, error) {
 
 	start := time.Now()
 	result, err := cache.ec2SVC.DescribeInstances(input)
-	awsAPILatency.WithLabelValues("DescribeInstances", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("DescribeInstances", start, err)
 	if err != nil {
 		awsAPIErrInc("DescribeInstances", err)
 		log.Errorf("awsGetFreeDeviceNumber: Unable to retrieve instance data from EC2 control plane %v", err)
@@ -594,17 +756,17 @@ func (cache *EC2InstanceMetadataCache) awsGetFreeDeviceNumber() (int, error) {
 }
 
 // AllocENI creates an ENI and attaches it to the instance
-// returns: newly created ENI ID
-func (cache *EC2InstanceMetadataCache) AllocENI(useCustomCfg bool, sg []*string, subnet string) (string, error) {
-	eniID, err := cache.createENI(useCustomCfg, sg, subnet)
+// returns: newly created ENI ID and its MAC address
+func (cache *EC2InstanceMetadataCache) AllocENI(useCustomCfg bool, sg []*string, subnet string) (string, string, error) {
+	eniID, eniMAC, err := cache.createENI(useCustomCfg, sg, subnet)
 	if err != nil {
-		return "", errors.Wrap(err, "AllocENI: failed to create ENI")
+		return "", "", errors.Wrap(err, "AllocENI: failed to create ENI")
 	}
 
 	attachmentID, err := cache.attachENI(eniID)
 	if err != nil {
 		_ = cache.deleteENI(eniID, maxENIBackoffDelay)
-		return "", errors.Wrap(err, "AllocENI: error attaching ENI")
+		return "", "", errors.Wrap(err, "AllocENI: error attaching ENI")
 	}
 
 	// Once the ENI is attached, tag it.
@@ -621,18 +783,18 @@ func (cache *EC2InstanceMetadataCache) AllocENI(useCustomCfg bool, sg []*string,
 
 	start := time.Now()
 	_, err = cache.ec2SVC.ModifyNetworkInterfaceAttribute(attributeInput)
-	awsAPILatency.WithLabelValues("ModifyNetworkInterfaceAttribute", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("ModifyNetworkInterfaceAttribute", start, err)
 	if err != nil {
 		awsAPIErrInc("ModifyNetworkInterfaceAttribute", err)
 		err := cache.FreeENI(eniID)
 		if err != nil {
 			awsUtilsErrInc("ENICleanupUponModifyNetworkErr", err)
 		}
-		return "", errors.Wrap(err, "AllocENI: unable to change the ENI's attribute")
+		return "", "", errors.Wrap(err, "AllocENI: unable to change the ENI's attribute")
 	}
 
 	log.Infof("Successfully created and attached a new ENI %s to instance", eniID)
-	return eniID, nil
+	return eniID, eniMAC, nil
 }
 
 // return attachment id, error
@@ -650,7 +812,7 @@ func (cache *EC2InstanceMetadataCache) attachENI(eniID string) (string, error) {
 	}
 	start := time.Now()
 	attachOutput, err := cache.ec2SVC.AttachNetworkInterface(attachInput)
-	awsAPILatency.WithLabelValues("AttachNetworkInterface", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("AttachNetworkInterface", start, err)
 	if err != nil {
 		awsAPIErrInc("AttachNetworkInterface", err)
 		log.Errorf("Failed to attach ENI %s: %v", eniID, err)
@@ -659,8 +821,8 @@ func (cache *EC2InstanceMetadataCache) attachENI(eniID string) (string, error) {
 	return aws.StringValue(attachOutput.AttachmentId), err
 }
 
-// return ENI id, error
-func (cache *EC2InstanceMetadataCache) createENI(useCustomCfg bool, sg []*string, subnet string) (string, error) {
+// return ENI id, MAC address, error
+func (cache *EC2InstanceMetadataCache) createENI(useCustomCfg bool, sg []*string, subnet string) (string, string, error) {
 	eniDescription := eniDescriptionPrefix + cache.instanceID
 	input := &ec2.CreateNetworkInterfaceInput{
 		Description: aws.String(eniDescription),
@@ -682,14 +844,14 @@ func (cache *EC2InstanceMetadataCache) createENI(useCustomCfg bool, sg []*string
 	log.Infof("Creating ENI with security groups: %v in subnet: %s", sgs, *input.SubnetId)
 	start := time.Now()
 	result, err := cache.ec2SVC.CreateNetworkInterface(input)
-	awsAPILatency.WithLabelValues("CreateNetworkInterface", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("CreateNetworkInterface", start, err)
 	if err != nil {
 		awsAPIErrInc("CreateNetworkInterface", err)
 		log.Errorf("Failed to CreateNetworkInterface %v", err)
-		return "", errors.Wrap(err, "failed to create network interface")
+		return "", "", errors.Wrap(err, "failed to create network interface")
 	}
 	log.Infof("Created a new ENI: %s", aws.StringValue(result.NetworkInterface.NetworkInterfaceId))
-	return aws.StringValue(result.NetworkInterface.NetworkInterfaceId), nil
+	return aws.StringValue(result.NetworkInterface.NetworkInterfaceId), aws.StringValue(result.NetworkInterface.MacAddress), nil
 }
 
 func (cache *EC2InstanceMetadataCache) tagENI(eniID string, maxBackoffDelay time.Duration) {
@@ -735,7 +897,7 @@ func (cache *EC2InstanceMetadataCache) tagENI(eniID string, maxBackoffDelay time
 	_ = retry.RetryNWithBackoff(retry.NewSimpleBackoff(500*time.Millisecond, maxBackoffDelay, 0.3, 2), 5, func() error {
 		start := time.Now()
 		_, err := cache.ec2SVC.CreateTags(input)
-		awsAPILatency.WithLabelValues("CreateTags", fmt.Sprint(err != nil)).Observe(msSince(start))
+		observeAPICall("CreateTags", start, err)
 		if err != nil {
 			awsAPIErrInc("CreateTags", err)
 			return log.Warnf("Failed to tag the newly created ENI %s: %v", eniID, err)
@@ -825,7 +987,7 @@ func (cache *EC2InstanceMetadataCache) freeENI(eniName string, sleepDelayAfterDe
 	err = retry.RetryNWithBackoff(retry.NewSimpleBackoff(time.Millisecond*200, maxBackoffDelay, 0.15, 2.0), maxENIDeleteRetries, func() error {
 		start := time.Now()
 		_, ec2Err := cache.ec2SVC.DetachNetworkInterface(detachInput)
-		awsAPILatency.WithLabelValues("DetachNetworkInterface", fmt.Sprint(ec2Err != nil)).Observe(msSince(start))
+		observeAPICall("DetachNetworkInterface", start, ec2Err)
 		if ec2Err != nil {
 			awsAPIErrInc("DetachNetworkInterface", ec2Err)
 			log.Errorf("Failed to detach ENI %s %v", eniName, ec2Err)
@@ -860,7 +1022,7 @@ func (cache *EC2InstanceMetadataCache) deleteENI(eniName string, maxBackoffDelay
 	err := retry.RetryNWithBackoff(retry.NewSimpleBackoff(time.Millisecond*500, maxBackoffDelay, 0.15, 2.0), maxENIDeleteRetries, func() error {
 		start := time.Now()
 		_, ec2Err := cache.ec2SVC.DeleteNetworkInterface(deleteInput)
-		awsAPILatency.WithLabelValues("DeleteNetworkInterface", fmt.Sprint(ec2Err != nil)).Observe(msSince(start))
+		observeAPICall("DeleteNetworkInterface", start, ec2Err)
 		if ec2Err != nil {
 			if aerr, ok := ec2Err.(awserr.Error); ok {
 				// If already deleted, we are good
@@ -888,7 +1050,7 @@ func (cache *EC2InstanceMetadataCache) DescribeENI(eniID string) ([]*ec2.Network
 
 	start := time.Now()
 	result, err := cache.ec2SVC.DescribeNetworkInterfaces(input)
-	awsAPILatency.WithLabelValues("DescribeNetworkInterfaces", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("DescribeNetworkInterfaces", start, err)
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			if aerr.Code() == "InvalidNetworkInterfaceID.NotFound" {
@@ -908,7 +1070,11 @@ func (cache *EC2InstanceMetadataCache) DescribeENI(eniID string) ([]*ec2.Network
 		tags[*tag.Key] = *tag.Value
 	}
 
-	return result.NetworkInterfaces[0].PrivateIpAddresses, tags, result.NetworkInterfaces[0].Attachment.AttachmentId, nil
+	var attachmentID *string
+	if attachment := result.NetworkInterfaces[0].Attachment; attachment != nil {
+		attachmentID = attachment.AttachmentId
+	}
+	return result.NetworkInterfaces[0].PrivateIpAddresses, tags, attachmentID, nil
 }
 
 // AllocIPAddress allocates an IP address for an ENI
@@ -922,7 +1088,7 @@ func (cache *EC2InstanceMetadataCache) AllocIPAddress(eniID string) error {
 
 	start := time.Now()
 	output, err := cache.ec2SVC.AssignPrivateIpAddresses(input)
-	awsAPILatency.WithLabelValues("AssignPrivateIpAddresses", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("AssignPrivateIpAddresses", start, err)
 	if err != nil {
 		awsAPIErrInc("AssignPrivateIpAddresses", err)
 		log.Errorf("Failed to allocate a private IP address  %v", err)
@@ -996,7 +1162,7 @@ func (cache *EC2InstanceMetadataCache) AllocIPAddresses(eniID string, numIPs int
 
 	start := time.Now()
 	_, err = cache.ec2SVC.AssignPrivateIpAddresses(input)
-	awsAPILatency.WithLabelValues("AssignPrivateIpAddresses", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("AssignPrivateIpAddresses", start, err)
 	if err != nil {
 		awsAPIErrInc("AssignPrivateIpAddresses", err)
 		if containsPrivateIPAddressLimitExceededError(err) {
@@ -1024,7 +1190,7 @@ func (cache *EC2InstanceMetadataCache) DeallocIPAddresses(eniID string, ips []st
 
 	start := time.Now()
 	_, err := cache.ec2SVC.UnassignPrivateIpAddressesWithContext(ctx, input)
-	awsAPILatency.WithLabelValues("UnassignPrivateIpAddressesWithContext", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPICall("UnassignPrivateIpAddressesWithContext", start, err)
 	if err != nil {
 		awsAPIErrInc("UnassignPrivateIpAddressesWithContext", err)
 		log.Errorf("Failed to deallocate a private IP address %v", err)
@@ -1105,6 +1271,8 @@ func (cache *EC2InstanceMetadataCache) GetVPCIPv4CIDR() string {
 
 // GetVPCIPv4CIDRs returns VPC CIDRs
 func (cache *EC2InstanceMetadataCache) GetVPCIPv4CIDRs() []*string {
+	cache.vpcIPv4CIDRsLock.RLock()
+	defer cache.vpcIPv4CIDRsLock.RUnlock()
 	return cache.vpcIPv4CIDRs
 }
 
@@ -1122,3 +1290,183 @@ func (cache *EC2InstanceMetadataCache) GetPrimaryENI() string {
 func (cache *EC2InstanceMetadataCache) GetPrimaryENImac() string {
 	return cache.primaryENImac
 }
+
+// AssociateElasticIP associates an Elastic IP with a private IP address of an ENI. The Elastic IP is tagged with
+// "node.k8s.amazonaws.com/instance_id=<instance_id>" so that GetElasticIPAssociations can find it after a restart.
+func (cache *EC2InstanceMetadataCache) AssociateElasticIP(allocationID string, eniID string, privateIP string, allowReassociation bool) (string, error) {
+	input := &ec2.AssociateAddressInput{
+		AllocationId:       aws.String(allocationID),
+		NetworkInterfaceId: aws.String(eniID),
+		PrivateIpAddress:   aws.String(privateIP),
+		AllowReassociation: aws.Bool(allowReassociation),
+	}
+
+	start := time.Now()
+	output, err := cache.ec2SVC.AssociateAddress(input)
+	observeAPICall("AssociateAddress", start, err)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "Resource.AlreadyAssociated" {
+		log.Infof("Elastic IP %s is associated with something else, not associating it with %s", allocationID, privateIP)
+		return "", ErrElasticIPAssociated
+	}
+	if err != nil {
+		awsAPIErrInc("AssociateAddress", err)
+		log.Errorf("Failed to associate Elastic IP %s with %s on ENI %s: %v", allocationID, privateIP, eniID, err)
+		return "", errors.Wrapf(err, "associate elastic IP: failed to associate %s with %s", allocationID, privateIP)
+	}
+	associationID := aws.StringValue(output.AssociationId)
+	log.Infof("Associated Elastic IP %s with %s on ENI %s (%s)", allocationID, privateIP, eniID, associationID)
+
+	tagInput := &ec2.CreateTagsInput{
+		Resources: []*string{aws.String(allocationID)},
+		Tags: []*ec2.Tag{
+			{
+				Key:   aws.String(eniNodeTagKey),
+				Value: aws.String(cache.instanceID),
+			},
+		},
+	}
+	start = time.Now()
+	_, err = cache.ec2SVC.CreateTags(tagInput)
+	observeAPICall("CreateTags", start, err)
+	if err != nil {
+		// The association works without the tag, it only can't be cleaned up after a restart
+		awsAPIErrInc("CreateTags", err)
+		log.Warnf("Failed to tag Elastic IP %s: %v", allocationID, err)
+	}
+	return associationID, nil
+}
+
+// DisassociateElasticIP removes an Elastic IP association
+func (cache *EC2InstanceMetadataCache) DisassociateElasticIP(associationID string) error {
+	input := &ec2.DisassociateAddressInput{
+		AssociationId: aws.String(associationID),
+	}
+
+	start := time.Now()
+	_, err := cache.ec2SVC.DisassociateAddress(input)
+	observeAPICall("DisassociateAddress", start, err)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidAssociationID.NotFound" {
+			log.Infof("Elastic IP association %s is already gone", associationID)
+			return nil
+		}
+		awsAPIErrInc("DisassociateAddress", err)
+		log.Errorf("Failed to disassociate Elastic IP association %s: %v", associationID, err)
+		return errors.Wrapf(err, "disassociate elastic IP: failed to disassociate %s", associationID)
+	}
+	log.Infof("Removed Elastic IP association %s", associationID)
+	return nil
+}
+
+// CanReassociateElasticIP returns whether an Elastic IP may be taken from what it is associated with: it must be tagged
+// with this instance's "node.k8s.amazonaws.com/instance_id", or carry no other instance's and be tagged with this
+// cluster's "cluster.k8s.amazonaws.com/name" or with a pool. Any other Elastic IP may belong to something outside the
+// cluster, like a NAT instance, or to a pod of another node that would take it back.
+func (cache *EC2InstanceMetadataCache) CanReassociateElasticIP(allocationID string) (bool, error) {
+	addresses, err := cache.describeAddresses(&ec2.DescribeAddressesInput{AllocationIds: []*string{aws.String(allocationID)}})
+	if err != nil {
+		return false, errors.Wrapf(err, "can reassociate elastic IP: failed to describe %s", allocationID)
+	}
+	if len(addresses) == 0 {
+		return false, errors.Errorf("can reassociate elastic IP: %s not found", allocationID)
+	}
+	tags := make(map[string]string)
+	for _, tag := range addresses[0].Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	if node, ok := tags[eniNodeTagKey]; ok {
+		return node == cache.instanceID, nil
+	}
+	clusterName := os.Getenv(clusterNameEnvVar)
+	if clusterName != "" && tags[eniClusterTagKey] == clusterName {
+		return true, nil
+	}
+	_, inPool := tags[ElasticIPPoolTagKey]
+	return inPool, nil
+}
+
+// GetFreeElasticIPs returns the allocation IDs of the Elastic IPs tagged with "k8s.amazonaws.com/eip-pool=<pool>"
+// that are not associated with anything
+func (cache *EC2InstanceMetadataCache) GetFreeElasticIPs(pool string) ([]string, error) {
+	input := &ec2.DescribeAddressesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:" + ElasticIPPoolTagKey),
+				Values: []*string{aws.String(pool)},
+			},
+			{
+				Name:   aws.String("domain"),
+				Values: []*string{aws.String("vpc")},
+			},
+		},
+	}
+
+	addresses, err := cache.describeAddresses(input)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get free elastic IPs: failed to describe the elastic IPs of pool %s", pool)
+	}
+
+	var allocationIDs []string
+	for _, address := range addresses {
+		if address.AssociationId != nil {
+			continue
+		}
+		allocationIDs = append(allocationIDs, aws.StringValue(address.AllocationId))
+	}
+	return allocationIDs, nil
+}
+
+// GetElasticIPAssociations returns the Elastic IPs tagged with "node.k8s.amazonaws.com/instance_id=<instance_id>"
+// that are still associated with one of the ENIs of this instance
+func (cache *EC2InstanceMetadataCache) GetElasticIPAssociations() ([]ElasticIP, error) {
+	input := &ec2.DescribeAddressesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:" + eniNodeTagKey),
+				Values: []*string{aws.String(cache.instanceID)},
+			},
+			{
+				Name:   aws.String("instance-id"),
+				Values: []*string{aws.String(cache.instanceID)},
+			},
+		},
+	}
+
+	addresses, err := cache.describeAddresses(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "get elastic IP associations: failed to describe the elastic IPs of this instance")
+	}
+
+	var elasticIPs []ElasticIP
+	for _, address := range addresses {
+		if address.AssociationId == nil || address.NetworkInterfaceId == nil {
+			continue
+		}
+		elasticIP := ElasticIP{
+			AllocationID:  aws.StringValue(address.AllocationId),
+			AssociationID: aws.StringValue(address.AssociationId),
+			ENIID:         aws.StringValue(address.NetworkInterfaceId),
+			PrivateIP:     aws.StringValue(address.PrivateIpAddress),
+			PublicIP:      aws.StringValue(address.PublicIp),
+		}
+		for _, tag := range address.Tags {
+			if aws.StringValue(tag.Key) == ElasticIPPoolTagKey {
+				elasticIP.Pool = aws.StringValue(tag.Value)
+			}
+		}
+		elasticIPs = append(elasticIPs, elasticIP)
+	}
+	return elasticIPs, nil
+}
+
+func (cache *EC2InstanceMetadataCache) describeAddresses(input *ec2.DescribeAddressesInput) ([]*ec2.Address, error) {
+	start := time.Now()
+	output, err := cache.ec2SVC.DescribeAddresses(input)
+	observeAPICall("DescribeAddresses", start, err)
+	if err != nil {
+		awsAPIErrInc("DescribeAddresses", err)
+		log.Errorf("Failed to describe Elastic IPs: %v", err)
+		return nil, err
+	}
+	return output.Addresses, nil
+}
@@ -226,6 +226,30 @@ func TestInitWithEC2metadataAZErr(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestRefreshVPCIPv4CIDRs(t *testing.T) {
+	ctrl, mockMetadata, _ := setup(t)
+	defer ctrl.Finish()
+
+	ins := &EC2InstanceMetadataCache{ec2Metadata: mockMetadata, primaryENImac: primaryMAC,
+		vpcIPv4CIDRs: []*string{aws.String(vpcCIDR)}}
+
+	mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataVPCcidrs).Return(vpcCIDR, nil)
+	changed, err := ins.RefreshVPCIPv4CIDRs()
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataVPCcidrs).Return(vpcCIDR+" 100.64.0.0/16", nil)
+	changed, err = ins.RefreshVPCIPv4CIDRs()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{vpcCIDR, "100.64.0.0/16"}, aws.StringValueSlice(ins.GetVPCIPv4CIDRs()))
+
+	mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataVPCcidrs).Return("", errors.New("Error on metadata"))
+	_, err = ins.RefreshVPCIPv4CIDRs()
+	assert.Error(t, err)
+	assert.Equal(t, []string{vpcCIDR, "100.64.0.0/16"}, aws.StringValueSlice(ins.GetVPCIPv4CIDRs()))
+}
+
 func TestSetPrimaryENs(t *testing.T) {
 	ctrl, mockMetadata, _ := setup(t)
 	defer ctrl.Finish()
@@ -462,7 +486,8 @@ func TestAllocENI(t *testing.T) {
 	defer ctrl.Finish()
 
 	cureniID := eniID
-	eni := ec2.CreateNetworkInterfaceOutput{NetworkInterface: &ec2.NetworkInterface{NetworkInterfaceId: &cureniID}}
+	eni := ec2.CreateNetworkInterfaceOutput{NetworkInterface: &ec2.NetworkInterface{NetworkInterfaceId: &cureniID,
+		MacAddress: aws.String(primaryMAC)}}
 	mockEC2.EXPECT().CreateNetworkInterface(gomock.Any()).Return(&eni, nil)
 
 	// 2 ENIs, uses device number 0 3, expect to find free at 1
@@ -491,8 +516,9 @@ func TestAllocENI(t *testing.T) {
 	mockEC2.EXPECT().ModifyNetworkInterfaceAttribute(gomock.Any()).Return(nil, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	_, err := ins.AllocENI(false, nil, "")
+	_, mac, err := ins.AllocENI(false, nil, "")
 	assert.NoError(t, err)
+	assert.Equal(t, primaryMAC, mac)
 }
 
 func TestAllocENINoFreeDevice(t *testing.T) {
@@ -521,7 +547,7 @@ func TestAllocENINoFreeDevice(t *testing.T) {
 	mockEC2.EXPECT().DeleteNetworkInterface(gomock.Any()).Return(nil, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	_, err := ins.AllocENI(false, nil, "")
+	_, _, err := ins.AllocENI(false, nil, "")
 	assert.Error(t, err)
 }
 
@@ -555,7 +581,7 @@ func TestAllocENIMaxReached(t *testing.T) {
 	mockEC2.EXPECT().DeleteNetworkInterface(gomock.Any()).Return(nil, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	_, err := ins.AllocENI(false, nil, "")
+	_, _, err := ins.AllocENI(false, nil, "")
 	assert.Error(t, err)
 }
 
@@ -746,3 +772,168 @@ func TestEC2InstanceMetadataCache_getFilteredListOfNetworkInterfaces_Error(t *te
 	assert.Nil(t, got)
 	assert.Error(t, err)
 }
+
+func TestAssociateElasticIP(t *testing.T) {
+	ctrl, _, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	mockEC2.EXPECT().AssociateAddress(&ec2.AssociateAddressInput{
+		AllocationId:       aws.String("eipalloc-1"),
+		NetworkInterfaceId: aws.String("eni-id"),
+		PrivateIpAddress:   aws.String("10.0.0.10"),
+		AllowReassociation: aws.Bool(false),
+	}).Return(&ec2.AssociateAddressOutput{AssociationId: aws.String("eipassoc-1")}, nil)
+	mockEC2.EXPECT().CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String("eipalloc-1")},
+		Tags:      []*ec2.Tag{{Key: aws.String(eniNodeTagKey), Value: aws.String(instanceID)}},
+	}).Return(nil, errors.New("tagging is best effort"))
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceID: instanceID}
+	associationID, err := ins.AssociateElasticIP("eipalloc-1", "eni-id", "10.0.0.10", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "eipassoc-1", associationID)
+
+	mockEC2.EXPECT().AssociateAddress(gomock.Any()).Return(nil, awserr.New("Resource.AlreadyAssociated", "", nil))
+	_, err = ins.AssociateElasticIP("eipalloc-1", "eni-id", "10.0.0.10", false)
+	assert.Equal(t, ErrElasticIPAssociated, err)
+
+	mockEC2.EXPECT().AssociateAddress(gomock.Any()).Return(nil, errors.New("Error on AssociateAddress"))
+	_, err = ins.AssociateElasticIP("eipalloc-1", "eni-id", "10.0.0.10", false)
+	assert.Error(t, err)
+}
+
+func TestCanReassociateElasticIP(t *testing.T) {
+	ctrl, _, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	_ = os.Setenv(clusterNameEnvVar, "prod")
+	defer os.Unsetenv(clusterNameEnvVar)
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceID: instanceID}
+	for _, tc := range []struct {
+		name string
+		tags map[string]string
+		want bool
+	}{
+		{"this node", map[string]string{eniNodeTagKey: instanceID}, true},
+		{"another node", map[string]string{eniNodeTagKey: "i-other", ElasticIPPoolTagKey: "web"}, false},
+		{"this cluster", map[string]string{eniClusterTagKey: "prod"}, true},
+		{"another cluster", map[string]string{eniClusterTagKey: "test"}, false},
+		{"a pool", map[string]string{ElasticIPPoolTagKey: "web"}, true},
+		{"untagged", nil, false},
+	} {
+		var tags []*ec2.Tag
+		for key, value := range tc.tags {
+			tags = append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+		mockEC2.EXPECT().DescribeAddresses(&ec2.DescribeAddressesInput{AllocationIds: []*string{aws.String("eipalloc-1")}}).
+			Return(&ec2.DescribeAddressesOutput{Addresses: []*ec2.Address{{Tags: tags}}}, nil)
+		reassociate, err := ins.CanReassociateElasticIP("eipalloc-1")
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.want, reassociate, tc.name)
+	}
+
+	mockEC2.EXPECT().DescribeAddresses(gomock.Any()).Return(&ec2.DescribeAddressesOutput{}, nil)
+	_, err := ins.CanReassociateElasticIP("eipalloc-1")
+	assert.Error(t, err)
+}
+
+func TestDisassociateElasticIP(t *testing.T) {
+	ctrl, _, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
+	mockEC2.EXPECT().DisassociateAddress(&ec2.DisassociateAddressInput{AssociationId: aws.String("eipassoc-1")}).Return(nil, nil)
+	assert.NoError(t, ins.DisassociateElasticIP("eipassoc-1"))
+
+	// An association that is already gone isn't an error
+	mockEC2.EXPECT().DisassociateAddress(gomock.Any()).Return(nil, awserr.New("InvalidAssociationID.NotFound", "", nil))
+	assert.NoError(t, ins.DisassociateElasticIP("eipassoc-1"))
+
+	mockEC2.EXPECT().DisassociateAddress(gomock.Any()).Return(nil, awserr.New("UnauthorizedOperation", "", nil))
+	assert.Error(t, ins.DisassociateElasticIP("eipassoc-1"))
+}
+
+func TestGetFreeElasticIPs(t *testing.T) {
+	ctrl, _, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	mockEC2.EXPECT().DescribeAddresses(gomock.Any()).Return(&ec2.DescribeAddressesOutput{
+		Addresses: []*ec2.Address{
+			{AllocationId: aws.String("eipalloc-1"), AssociationId: aws.String("eipassoc-1")},
+			{AllocationId: aws.String("eipalloc-2")},
+		},
+	}, nil)
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
+	allocationIDs, err := ins.GetFreeElasticIPs("web")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eipalloc-2"}, allocationIDs)
+}
+
+func TestGetElasticIPAssociations(t *testing.T) {
+	ctrl, _, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	mockEC2.EXPECT().DescribeAddresses(gomock.Any()).Return(&ec2.DescribeAddressesOutput{
+		Addresses: []*ec2.Address{
+			{
+				AllocationId:       aws.String("eipalloc-1"),
+				AssociationId:      aws.String("eipassoc-1"),
+				NetworkInterfaceId: aws.String("eni-id"),
+				PrivateIpAddress:   aws.String("10.0.0.10"),
+				PublicIp:           aws.String("3.3.3.3"),
+				Tags:               []*ec2.Tag{{Key: aws.String(ElasticIPPoolTagKey), Value: aws.String("web")}},
+			},
+			// Associated with the instance itself, not with a pod
+			{AllocationId: aws.String("eipalloc-2"), AssociationId: aws.String("eipassoc-2")},
+		},
+	}, nil)
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceID: instanceID}
+	elasticIPs, err := ins.GetElasticIPAssociations()
+	assert.NoError(t, err)
+	assert.Equal(t, []ElasticIP{{
+		AllocationID:  "eipalloc-1",
+		AssociationID: "eipassoc-1",
+		ENIID:         "eni-id",
+		PrivateIP:     "10.0.0.10",
+		PublicIP:      "3.3.3.3",
+		Pool:          "web",
+	}}, elasticIPs)
+
+	mockEC2.EXPECT().DescribeAddresses(gomock.Any()).Return(nil, errors.New("Error on DescribeAddresses"))
+	_, err = ins.GetElasticIPAssociations()
+	assert.Error(t, err)
+}
+
+func TestAPILatency(t *testing.T) {
+	_, ok := APILatency("TestAPILatency")
+	assert.False(t, ok)
+
+	awsAPILatency.WithLabelValues("TestAPILatency", "false").Observe(100)
+	awsAPILatency.WithLabelValues("TestAPILatency", "false").Observe(300)
+	awsAPILatency.WithLabelValues("TestAPILatency", "true").Observe(5000)
+	latency, ok := APILatency("TestAPILatency")
+	assert.True(t, ok)
+	assert.Equal(t, 200*time.Millisecond, latency)
+}
+
+func TestObserveAPICall(t *testing.T) {
+	before := GetEndpointHealth(EndpointEC2)
+
+	// An error response is an answer
+	observeAPICall("TestObserveAPICall", time.Now(), awserr.NewRequestFailure(awserr.New("UnauthorizedOperation", "", nil), 403, ""))
+	health := GetEndpointHealth(EndpointEC2)
+	assert.True(t, health.LastResponse.After(before.LastResponse))
+	assert.Equal(t, before.LastFailure, health.LastFailure)
+
+	observeAPICall("TestObserveAPICall", time.Now(), awserr.New("RequestError", "send request failed", nil))
+	health = GetEndpointHealth(EndpointEC2)
+	assert.True(t, health.LastFailure.After(before.LastFailure))
+	assert.Equal(t, "RequestError: send request failed", health.LastError)
+
+	// IMDS calls don't change the health of EC2
+	observeAPICall("GetMetadata", time.Now(), nil)
+	assert.Equal(t, health, GetEndpointHealth(EndpointEC2))
+	assert.False(t, GetEndpointHealth(EndpointIMDS).LastResponse.IsZero())
+}
@@ -49,11 +49,12 @@ func (m *MockAPIs) EXPECT() *MockAPIsMockRecorder {
 }
 
 // AllocENI mocks base method
-func (m *MockAPIs) AllocENI(arg0 bool, arg1 []*string, arg2 string) (string, error) {
+func (m *MockAPIs) AllocENI(arg0 bool, arg1 []*string, arg2 string) (string, string, error) {
 	ret := m.ctrl.Call(m, "AllocENI", arg0, arg1, arg2)
 	ret0, _ := ret[0].(string)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
 // AllocENI indicates an expected call of AllocENI
@@ -85,6 +86,32 @@ func (mr *MockAPIsMockRecorder) AllocIPAddresses(arg0, arg1 interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllocIPAddresses", reflect.TypeOf((*MockAPIs)(nil).AllocIPAddresses), arg0, arg1)
 }
 
+// AssociateElasticIP mocks base method
+func (m *MockAPIs) AssociateElasticIP(arg0, arg1, arg2 string, arg3 bool) (string, error) {
+	ret := m.ctrl.Call(m, "AssociateElasticIP", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AssociateElasticIP indicates an expected call of AssociateElasticIP
+func (mr *MockAPIsMockRecorder) AssociateElasticIP(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AssociateElasticIP", reflect.TypeOf((*MockAPIs)(nil).AssociateElasticIP), arg0, arg1, arg2, arg3)
+}
+
+// CanReassociateElasticIP mocks base method
+func (m *MockAPIs) CanReassociateElasticIP(arg0 string) (bool, error) {
+	ret := m.ctrl.Call(m, "CanReassociateElasticIP", arg0)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CanReassociateElasticIP indicates an expected call of CanReassociateElasticIP
+func (mr *MockAPIsMockRecorder) CanReassociateElasticIP(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CanReassociateElasticIP", reflect.TypeOf((*MockAPIs)(nil).CanReassociateElasticIP), arg0)
+}
+
 // DeallocIPAddresses mocks base method
 func (m *MockAPIs) DeallocIPAddresses(arg0 string, arg1 []string) error {
 	ret := m.ctrl.Call(m, "DeallocIPAddresses", arg0, arg1)
@@ -112,6 +139,18 @@ func (mr *MockAPIsMockRecorder) DescribeENI(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DescribeENI", reflect.TypeOf((*MockAPIs)(nil).DescribeENI), arg0)
 }
 
+// DisassociateElasticIP mocks base method
+func (m *MockAPIs) DisassociateElasticIP(arg0 string) error {
+	ret := m.ctrl.Call(m, "DisassociateElasticIP", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DisassociateElasticIP indicates an expected call of DisassociateElasticIP
+func (mr *MockAPIsMockRecorder) DisassociateElasticIP(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisassociateElasticIP", reflect.TypeOf((*MockAPIs)(nil).DisassociateElasticIP), arg0)
+}
+
 // FreeENI mocks base method
 func (m *MockAPIs) FreeENI(arg0 string) error {
 	ret := m.ctrl.Call(m, "FreeENI", arg0)
@@ -163,6 +202,45 @@ func (mr *MockAPIsMockRecorder) GetENIipLimit() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetENIipLimit", reflect.TypeOf((*MockAPIs)(nil).GetENIipLimit))
 }
 
+// GetElasticIPAssociations mocks base method
+func (m *MockAPIs) GetElasticIPAssociations() ([]awsutils.ElasticIP, error) {
+	ret := m.ctrl.Call(m, "GetElasticIPAssociations")
+	ret0, _ := ret[0].([]awsutils.ElasticIP)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetElasticIPAssociations indicates an expected call of GetElasticIPAssociations
+func (mr *MockAPIsMockRecorder) GetElasticIPAssociations() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetElasticIPAssociations", reflect.TypeOf((*MockAPIs)(nil).GetElasticIPAssociations))
+}
+
+// GetFreeElasticIPs mocks base method
+func (m *MockAPIs) GetFreeElasticIPs(arg0 string) ([]string, error) {
+	ret := m.ctrl.Call(m, "GetFreeElasticIPs", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFreeElasticIPs indicates an expected call of GetFreeElasticIPs
+func (mr *MockAPIsMockRecorder) GetFreeElasticIPs(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFreeElasticIPs", reflect.TypeOf((*MockAPIs)(nil).GetFreeElasticIPs), arg0)
+}
+
+// GetIMDSIPv4Addresses mocks base method
+func (m *MockAPIs) GetIMDSIPv4Addresses(arg0 string) ([]string, error) {
+	ret := m.ctrl.Call(m, "GetIMDSIPv4Addresses", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIMDSIPv4Addresses indicates an expected call of GetIMDSIPv4Addresses
+func (mr *MockAPIsMockRecorder) GetIMDSIPv4Addresses(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIMDSIPv4Addresses", reflect.TypeOf((*MockAPIs)(nil).GetIMDSIPv4Addresses), arg0)
+}
+
 // GetLocalIPv4 mocks base method
 func (m *MockAPIs) GetLocalIPv4() string {
 	ret := m.ctrl.Call(m, "GetLocalIPv4")
@@ -222,3 +300,16 @@ func (m *MockAPIs) GetVPCIPv4CIDRs() []*string {
 func (mr *MockAPIsMockRecorder) GetVPCIPv4CIDRs() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVPCIPv4CIDRs", reflect.TypeOf((*MockAPIs)(nil).GetVPCIPv4CIDRs))
 }
+
+// RefreshVPCIPv4CIDRs mocks base method
+func (m *MockAPIs) RefreshVPCIPv4CIDRs() (bool, error) {
+	ret := m.ctrl.Call(m, "RefreshVPCIPv4CIDRs")
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshVPCIPv4CIDRs indicates an expected call of RefreshVPCIPv4CIDRs
+func (mr *MockAPIsMockRecorder) RefreshVPCIPv4CIDRs() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshVPCIPv4CIDRs", reflect.TypeOf((*MockAPIs)(nil).RefreshVPCIPv4CIDRs))
+}
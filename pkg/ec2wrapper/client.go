@@ -32,6 +32,9 @@ type EC2 interface {
 	DescribeNetworkInterfaces(input *ec2svc.DescribeNetworkInterfacesInput) (*ec2svc.DescribeNetworkInterfacesOutput, error)
 	ModifyNetworkInterfaceAttribute(input *ec2svc.ModifyNetworkInterfaceAttributeInput) (*ec2svc.ModifyNetworkInterfaceAttributeOutput, error)
 	CreateTags(input *ec2svc.CreateTagsInput) (*ec2svc.CreateTagsOutput, error)
+	AssociateAddress(input *ec2svc.AssociateAddressInput) (*ec2svc.AssociateAddressOutput, error)
+	DisassociateAddress(input *ec2svc.DisassociateAddressInput) (*ec2svc.DisassociateAddressOutput, error)
+	DescribeAddresses(input *ec2svc.DescribeAddressesInput) (*ec2svc.DescribeAddressesOutput, error)
 }
 
 func New(sess *session.Session) EC2 {
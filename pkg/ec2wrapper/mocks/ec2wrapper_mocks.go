@@ -62,6 +62,19 @@ func (mr *MockEC2MockRecorder) AssignPrivateIpAddresses(arg0 interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AssignPrivateIpAddresses", reflect.TypeOf((*MockEC2)(nil).AssignPrivateIpAddresses), arg0)
 }
 
+// AssociateAddress mocks base method
+func (m *MockEC2) AssociateAddress(arg0 *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+	ret := m.ctrl.Call(m, "AssociateAddress", arg0)
+	ret0, _ := ret[0].(*ec2.AssociateAddressOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AssociateAddress indicates an expected call of AssociateAddress
+func (mr *MockEC2MockRecorder) AssociateAddress(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AssociateAddress", reflect.TypeOf((*MockEC2)(nil).AssociateAddress), arg0)
+}
+
 // AttachNetworkInterface mocks base method
 func (m *MockEC2) AttachNetworkInterface(arg0 *ec2.AttachNetworkInterfaceInput) (*ec2.AttachNetworkInterfaceOutput, error) {
 	ret := m.ctrl.Call(m, "AttachNetworkInterface", arg0)
@@ -114,17 +127,17 @@ func (mr *MockEC2MockRecorder) DeleteNetworkInterface(arg0 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteNetworkInterface", reflect.TypeOf((*MockEC2)(nil).DeleteNetworkInterface), arg0)
 }
 
-// DescribeInstances mocks base method
-func (m *MockEC2) DescribeInstances(arg0 *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
-	ret := m.ctrl.Call(m, "DescribeInstances", arg0)
-	ret0, _ := ret[0].(*ec2.DescribeInstancesOutput)
+// DescribeAddresses mocks base method
+func (m *MockEC2) DescribeAddresses(arg0 *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	ret := m.ctrl.Call(m, "DescribeAddresses", arg0)
+	ret0, _ := ret[0].(*ec2.DescribeAddressesOutput)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// DescribeInstances indicates an expected call of DescribeInstances
-func (mr *MockEC2MockRecorder) DescribeInstances(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DescribeInstances", reflect.TypeOf((*MockEC2)(nil).DescribeInstances), arg0)
+// DescribeAddresses indicates an expected call of DescribeAddresses
+func (mr *MockEC2MockRecorder) DescribeAddresses(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DescribeAddresses", reflect.TypeOf((*MockEC2)(nil).DescribeAddresses), arg0)
 }
 
 // DescribeInstanceTypes mocks base method
@@ -140,6 +153,19 @@ func (mr *MockEC2MockRecorder) DescribeInstanceTypes(arg0 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DescribeInstanceTypes", reflect.TypeOf((*MockEC2)(nil).DescribeInstanceTypes), arg0)
 }
 
+// DescribeInstances mocks base method
+func (m *MockEC2) DescribeInstances(arg0 *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	ret := m.ctrl.Call(m, "DescribeInstances", arg0)
+	ret0, _ := ret[0].(*ec2.DescribeInstancesOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DescribeInstances indicates an expected call of DescribeInstances
+func (mr *MockEC2MockRecorder) DescribeInstances(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DescribeInstances", reflect.TypeOf((*MockEC2)(nil).DescribeInstances), arg0)
+}
+
 // DescribeNetworkInterfaces mocks base method
 func (m *MockEC2) DescribeNetworkInterfaces(arg0 *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
 	ret := m.ctrl.Call(m, "DescribeNetworkInterfaces", arg0)
@@ -166,6 +192,19 @@ func (mr *MockEC2MockRecorder) DetachNetworkInterface(arg0 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DetachNetworkInterface", reflect.TypeOf((*MockEC2)(nil).DetachNetworkInterface), arg0)
 }
 
+// DisassociateAddress mocks base method
+func (m *MockEC2) DisassociateAddress(arg0 *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error) {
+	ret := m.ctrl.Call(m, "DisassociateAddress", arg0)
+	ret0, _ := ret[0].(*ec2.DisassociateAddressOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DisassociateAddress indicates an expected call of DisassociateAddress
+func (mr *MockEC2MockRecorder) DisassociateAddress(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisassociateAddress", reflect.TypeOf((*MockEC2)(nil).DisassociateAddress), arg0)
+}
+
 // ModifyNetworkInterfaceAttribute mocks base method
 func (m *MockEC2) ModifyNetworkInterfaceAttribute(arg0 *ec2.ModifyNetworkInterfaceAttributeInput) (*ec2.ModifyNetworkInterfaceAttributeOutput, error) {
 	ret := m.ctrl.Call(m, "ModifyNetworkInterfaceAttribute", arg0)
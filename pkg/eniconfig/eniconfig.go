@@ -18,6 +18,7 @@ import (
 	"context"
 	"os"
 	"runtime"
+	"sort"
 	"sync"
 	"time"
 
@@ -29,6 +30,7 @@ import (
 	log "github.com/cihub/seelog"
 	sdkVersion "github.com/operator-framework/operator-sdk/version"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
@@ -50,10 +52,17 @@ const (
 type ENIConfig interface {
 	MyENIConfig() (*v1alpha1.ENIConfigSpec, error)
 	Getter() *ENIConfigInfo
+	EgressIPConfigs() []v1alpha1.EgressIPConfig
+	ListEgressIPConfigs() ([]v1alpha1.EgressIPConfig, error)
+	SetEgressIPStatus(name string, ip string) error
+	IPQuotas() []v1alpha1.IPQuota
 }
 
 var ErrNoENIConfig = errors.New("eniconfig: eniconfig is not available")
 
+// ErrNoEgressIPConfig is returned when updating the status of an EgressIPConfig that is not in the cache
+var ErrNoEgressIPConfig = errors.New("eniconfig: egressipconfig is not available")
+
 // ENIConfigController defines global context for ENIConfig controller
 type ENIConfigController struct {
 	eni                    map[string]*v1alpha1.ENIConfigSpec
@@ -62,6 +71,12 @@ type ENIConfigController struct {
 	myNodeName             string
 	eniConfigAnnotationDef string
 	eniConfigLabelDef      string
+
+	watchEgressIPConfigs bool
+	egressIPConfigs      map[string]*v1alpha1.EgressIPConfig
+
+	watchIPQuotas bool
+	ipQuotas      map[string]*v1alpha1.IPQuota
 }
 
 // ENIConfigInfo returns locally cached ENIConfigs
@@ -77,6 +92,8 @@ func NewENIConfigController() *ENIConfigController {
 	return &ENIConfigController{
 		myNodeName:             os.Getenv("MY_NODE_NAME"),
 		eni:                    make(map[string]*v1alpha1.ENIConfigSpec),
+		egressIPConfigs:        make(map[string]*v1alpha1.EgressIPConfig),
+		ipQuotas:               make(map[string]*v1alpha1.IPQuota),
 		myENI:                  eniConfigDefault,
 		eniConfigAnnotationDef: getEniConfigAnnotationDef(),
 		eniConfigLabelDef:      getEniConfigLabelDef(),
@@ -114,6 +131,44 @@ func (h *Handler) Handle(ctx context.Context, event sdk.Event) error {
 		defer h.controller.eniLock.Unlock()
 		h.controller.eni[eniConfigName] = &curENIConfig.Spec
 
+	case *v1alpha1.EgressIPConfig:
+		egressIPConfigName := o.GetName()
+		if event.Deleted {
+			log.Debugf("Deleting EgressIPConfig: %s", egressIPConfigName)
+			h.controller.eniLock.Lock()
+			defer h.controller.eniLock.Unlock()
+			delete(h.controller.egressIPConfigs, egressIPConfigName)
+			return nil
+		}
+
+		curEgressIPConfig := o.DeepCopy()
+
+		log.Debugf("Handle EgressIPConfig Add/Update: %s, %v, %v", egressIPConfigName,
+			curEgressIPConfig.Spec.Namespaces, curEgressIPConfig.Spec.PodSelector)
+
+		h.controller.eniLock.Lock()
+		defer h.controller.eniLock.Unlock()
+		h.controller.egressIPConfigs[egressIPConfigName] = curEgressIPConfig
+
+	case *v1alpha1.IPQuota:
+		ipQuotaName := o.GetName()
+		if event.Deleted {
+			log.Debugf("Deleting IPQuota: %s", ipQuotaName)
+			h.controller.eniLock.Lock()
+			defer h.controller.eniLock.Unlock()
+			delete(h.controller.ipQuotas, ipQuotaName)
+			return nil
+		}
+
+		curIPQuota := o.DeepCopy()
+
+		log.Debugf("Handle IPQuota Add/Update: %s, %v, %d", ipQuotaName,
+			curIPQuota.Spec.Namespaces, curIPQuota.Spec.MaxIPsPerNode)
+
+		h.controller.eniLock.Lock()
+		defer h.controller.eniLock.Unlock()
+		h.controller.ipQuotas[ipQuotaName] = curIPQuota
+
 	case *corev1.Node:
 		log.Debugf("Handle corev1.Node: %s, %v, %v", o.GetName(), o.GetAnnotations(), o.GetLabels())
 		// Get annotations if not found get labels if not found fallback use default
@@ -143,6 +198,16 @@ func printVersion() {
 	log.Infof("operator-sdk Version: %v", sdkVersion.Version)
 }
 
+// WatchEgressIPConfigs makes Start watch EgressIPConfigs as well. It must be called before Start.
+func (eniCfg *ENIConfigController) WatchEgressIPConfigs() {
+	eniCfg.watchEgressIPConfigs = true
+}
+
+// WatchIPQuotas makes Start watch IPQuotas as well. It must be called before Start.
+func (eniCfg *ENIConfigController) WatchIPQuotas() {
+	eniCfg.watchIPQuotas = true
+}
+
 // Start kicks off ENIConfig controller
 func (eniCfg *ENIConfigController) Start() {
 	printVersion()
@@ -154,6 +219,14 @@ func (eniCfg *ENIConfigController) Start() {
 	resyncPeriod := time.Second * 5
 	log.Infof("Watching %s, %s, every %v s", resource, kind, resyncPeriod.Seconds())
 	sdk.Watch(resource, kind, "", resyncPeriod)
+	if eniCfg.watchEgressIPConfigs {
+		log.Infof("Watching %s, %s, every %v s", resource, "EgressIPConfig", resyncPeriod.Seconds())
+		sdk.Watch(resource, "EgressIPConfig", "", resyncPeriod)
+	}
+	if eniCfg.watchIPQuotas {
+		log.Infof("Watching %s, %s, every %v s", resource, "IPQuota", resyncPeriod.Seconds())
+		sdk.Watch(resource, "IPQuota", "", resyncPeriod)
+	}
 	sdk.Watch("/v1", "Node", corev1.NamespaceAll, resyncPeriod)
 	sdk.Handle(NewHandler(eniCfg))
 	sdk.Run(context.TODO())
@@ -192,6 +265,78 @@ func (eniCfg *ENIConfigController) MyENIConfig() (*v1alpha1.ENIConfigSpec, error
 	return nil, ErrNoENIConfig
 }
 
+// EgressIPConfigs returns a copy of the cached EgressIPConfigs, sorted by name
+func (eniCfg *ENIConfigController) EgressIPConfigs() []v1alpha1.EgressIPConfig {
+	eniCfg.eniLock.RLock()
+	defer eniCfg.eniLock.RUnlock()
+
+	output := make([]v1alpha1.EgressIPConfig, 0, len(eniCfg.egressIPConfigs))
+	for _, val := range eniCfg.egressIPConfigs {
+		output = append(output, *val.DeepCopy())
+	}
+	sort.Slice(output, func(i, j int) bool { return output[i].Name < output[j].Name })
+	return output
+}
+
+// ListEgressIPConfigs returns the EgressIPConfigs of the API server, sorted by name. It is for the callers that can't
+// wait for Start to fill the cache.
+func (eniCfg *ENIConfigController) ListEgressIPConfigs() ([]v1alpha1.EgressIPConfig, error) {
+	list := &v1alpha1.EgressIPConfigList{
+		TypeMeta: metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "EgressIPConfig"},
+	}
+	if err := sdk.List("", list); err != nil {
+		return nil, errors.Wrap(err, "eniconfig: failed to list EgressIPConfigs")
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+	return list.Items, nil
+}
+
+// IPQuotas returns a copy of the cached IPQuotas, sorted by name
+func (eniCfg *ENIConfigController) IPQuotas() []v1alpha1.IPQuota {
+	eniCfg.eniLock.RLock()
+	defer eniCfg.eniLock.RUnlock()
+
+	output := make([]v1alpha1.IPQuota, 0, len(eniCfg.ipQuotas))
+	for _, val := range eniCfg.ipQuotas {
+		output = append(output, *val.DeepCopy())
+	}
+	sort.Slice(output, func(i, j int) bool { return output[i].Name < output[j].Name })
+	return output
+}
+
+// SetEgressIPStatus records the egress IP reserved on this node in the status of an EgressIPConfig. The API server is
+// only called when the cached status differs; a conflicting update fails and is retried by the next caller.
+func (eniCfg *ENIConfigController) SetEgressIPStatus(name string, ip string) error {
+	eniCfg.eniLock.RLock()
+	cached, ok := eniCfg.egressIPConfigs[name]
+	if !ok {
+		eniCfg.eniLock.RUnlock()
+		return ErrNoEgressIPConfig
+	}
+	if cached.Status.EgressIPs[eniCfg.myNodeName] == ip {
+		eniCfg.eniLock.RUnlock()
+		return nil
+	}
+	egressIPConfig := cached.DeepCopy()
+	eniCfg.eniLock.RUnlock()
+
+	if egressIPConfig.Status.EgressIPs == nil {
+		egressIPConfig.Status.EgressIPs = make(map[string]string)
+	}
+	egressIPConfig.Status.EgressIPs[eniCfg.myNodeName] = ip
+	log.Infof("Updating EgressIPConfig %s status: node %s uses egress IP %s", name, eniCfg.myNodeName, ip)
+	if err := sdk.Update(egressIPConfig); err != nil {
+		return errors.Wrapf(err, "eniconfig: failed to update status of EgressIPConfig %s", name)
+	}
+
+	eniCfg.eniLock.Lock()
+	defer eniCfg.eniLock.Unlock()
+	if _, ok := eniCfg.egressIPConfigs[name]; ok {
+		eniCfg.egressIPConfigs[name] = egressIPConfig
+	}
+	return nil
+}
+
 // getEniConfigAnnotationDef returns eniConfigAnnotation
 func getEniConfigAnnotationDef() string {
 	inputStr, found := os.LookupEnv(envEniConfigAnnotationDef)
@@ -238,3 +238,82 @@ func TestGetEniConfigLabelDefCustom(t *testing.T) {
 	eniConfigLabelDef := getEniConfigLabelDef()
 	assert.Equal(t, eniConfigLabelDef, "k8s.amazonaws.com/eniConfigCustom")
 }
+
+func updateEgressIPConfig(hdlr sdk.Handler, name string, spec v1alpha1.EgressIPConfigSpec, toDelete bool) {
+	event := sdk.Event{
+		Object: &v1alpha1.EgressIPConfig{
+			TypeMeta: metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String()},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Spec: spec},
+		Deleted: toDelete,
+	}
+
+	hdlr.Handle(nil, event)
+}
+
+func TestEgressIPConfig(t *testing.T) {
+	testENIConfigController := NewENIConfigController()
+	testHandler := NewHandler(testENIConfigController)
+
+	assert.Empty(t, testENIConfigController.EgressIPConfigs())
+	assert.Equal(t, ErrNoEgressIPConfig, testENIConfigController.SetEgressIPStatus("payments", "10.0.0.1"))
+
+	paymentsCfg := v1alpha1.EgressIPConfigSpec{Namespaces: []string{"payments"}}
+	billingCfg := v1alpha1.EgressIPConfigSpec{PodSelector: map[string]string{"app": "billing"}}
+	updateEgressIPConfig(testHandler, "payments", paymentsCfg, false)
+	updateEgressIPConfig(testHandler, "billing", billingCfg, false)
+
+	// Sorted by name
+	output := testENIConfigController.EgressIPConfigs()
+	assert.Equal(t, 2, len(output))
+	assert.Equal(t, "billing", output[0].Name)
+	assert.Equal(t, billingCfg, output[0].Spec)
+	assert.Equal(t, "payments", output[1].Name)
+	assert.Equal(t, paymentsCfg, output[1].Spec)
+
+	updateEgressIPConfig(testHandler, "billing", billingCfg, true)
+	output = testENIConfigController.EgressIPConfigs()
+	assert.Equal(t, 1, len(output))
+	assert.Equal(t, "payments", output[0].Name)
+}
+
+func updateIPQuota(hdlr sdk.Handler, name string, spec v1alpha1.IPQuotaSpec, toDelete bool) {
+	event := sdk.Event{
+		Object: &v1alpha1.IPQuota{
+			TypeMeta: metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String()},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Spec: spec},
+		Deleted: toDelete,
+	}
+
+	hdlr.Handle(nil, event)
+}
+
+func TestIPQuota(t *testing.T) {
+	testENIConfigController := NewENIConfigController()
+	testHandler := NewHandler(testENIConfigController)
+
+	assert.Empty(t, testENIConfigController.IPQuotas())
+
+	teamsQuota := v1alpha1.IPQuotaSpec{Namespaces: []string{"team-a", "team-b"}, MaxIPsPerNode: 20}
+	batchQuota := v1alpha1.IPQuotaSpec{Namespaces: []string{"batch"}, MaxIPsPerNode: 5}
+	updateIPQuota(testHandler, "teams", teamsQuota, false)
+	updateIPQuota(testHandler, "batch", batchQuota, false)
+
+	// Sorted by name
+	output := testENIConfigController.IPQuotas()
+	assert.Equal(t, 2, len(output))
+	assert.Equal(t, "batch", output[0].Name)
+	assert.Equal(t, batchQuota, output[0].Spec)
+	assert.Equal(t, "teams", output[1].Name)
+	assert.Equal(t, teamsQuota, output[1].Spec)
+
+	updateIPQuota(testHandler, "batch", batchQuota, true)
+	output = testENIConfigController.IPQuotas()
+	assert.Equal(t, 1, len(output))
+	assert.Equal(t, "teams", output[0].Name)
+}
@@ -48,6 +48,18 @@ func (m *MockENIConfig) EXPECT() *MockENIConfigMockRecorder {
 	return m.recorder
 }
 
+// EgressIPConfigs mocks base method
+func (m *MockENIConfig) EgressIPConfigs() []v1alpha1.EgressIPConfig {
+	ret := m.ctrl.Call(m, "EgressIPConfigs")
+	ret0, _ := ret[0].([]v1alpha1.EgressIPConfig)
+	return ret0
+}
+
+// EgressIPConfigs indicates an expected call of EgressIPConfigs
+func (mr *MockENIConfigMockRecorder) EgressIPConfigs() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EgressIPConfigs", reflect.TypeOf((*MockENIConfig)(nil).EgressIPConfigs))
+}
+
 // Getter mocks base method
 func (m *MockENIConfig) Getter() *eniconfig.ENIConfigInfo {
 	ret := m.ctrl.Call(m, "Getter")
@@ -60,6 +72,31 @@ func (mr *MockENIConfigMockRecorder) Getter() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Getter", reflect.TypeOf((*MockENIConfig)(nil).Getter))
 }
 
+// IPQuotas mocks base method
+func (m *MockENIConfig) IPQuotas() []v1alpha1.IPQuota {
+	ret := m.ctrl.Call(m, "IPQuotas")
+	ret0, _ := ret[0].([]v1alpha1.IPQuota)
+	return ret0
+}
+
+// IPQuotas indicates an expected call of IPQuotas
+func (mr *MockENIConfigMockRecorder) IPQuotas() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IPQuotas", reflect.TypeOf((*MockENIConfig)(nil).IPQuotas))
+}
+
+// ListEgressIPConfigs mocks base method
+func (m *MockENIConfig) ListEgressIPConfigs() ([]v1alpha1.EgressIPConfig, error) {
+	ret := m.ctrl.Call(m, "ListEgressIPConfigs")
+	ret0, _ := ret[0].([]v1alpha1.EgressIPConfig)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListEgressIPConfigs indicates an expected call of ListEgressIPConfigs
+func (mr *MockENIConfigMockRecorder) ListEgressIPConfigs() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListEgressIPConfigs", reflect.TypeOf((*MockENIConfig)(nil).ListEgressIPConfigs))
+}
+
 // MyENIConfig mocks base method
 func (m *MockENIConfig) MyENIConfig() (*v1alpha1.ENIConfigSpec, error) {
 	ret := m.ctrl.Call(m, "MyENIConfig")
@@ -72,3 +109,15 @@ func (m *MockENIConfig) MyENIConfig() (*v1alpha1.ENIConfigSpec, error) {
 func (mr *MockENIConfigMockRecorder) MyENIConfig() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MyENIConfig", reflect.TypeOf((*MockENIConfig)(nil).MyENIConfig))
 }
+
+// SetEgressIPStatus mocks base method
+func (m *MockENIConfig) SetEgressIPStatus(arg0, arg1 string) error {
+	ret := m.ctrl.Call(m, "SetEgressIPStatus", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEgressIPStatus indicates an expected call of SetEgressIPStatus
+func (mr *MockENIConfigMockRecorder) SetEgressIPStatus(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEgressIPStatus", reflect.TypeOf((*MockENIConfig)(nil).SetEgressIPStatus), arg0, arg1)
+}
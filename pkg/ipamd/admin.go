@@ -0,0 +1,265 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+)
+
+const (
+	// This environment variable is used to specify a file with the token of the admin API, which the requests send as
+	// "Authorization: Bearer <token>". The file is read on every request, so the token can be rotated. Requests over
+	// a Unix socket INTROSPECTION_BIND_ADDRESS from root or from ipamd's user don't need the token. Default is unset,
+	// which only allows the admin API over a Unix socket.
+	envAdminTokenFile = "AWS_VPC_K8S_CNI_ADMIN_TOKEN_FILE"
+)
+
+var adminRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "awscni_admin_requests",
+		Help: "The number of admin API requests, by action and result",
+	},
+	[]string{"action", "result"},
+)
+
+// AdminResult is the response of an admin endpoint
+type AdminResult struct {
+	Action  string
+	Message string
+	// Pod is the pod whose IP address was released
+	Pod *datastore.PodIPv4Address `json:",omitempty"`
+}
+
+// adminRequestError is the error of an admin request with a missing or wrong parameter
+type adminRequestError struct {
+	error
+}
+
+// peerCredentialsKey is the request context key of the credentials of the process on the other end of a Unix socket
+type peerCredentialsKey struct{}
+
+// peerCredentials adds the credentials of the process on the other end of a Unix socket to the context of its
+// requests. It is the ConnContext of the introspection server.
+func peerCredentials(ctx context.Context, conn net.Conn) context.Context {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		log.Warnf("Failed to get the peer credentials of the introspection connection: %v", err)
+		return ctx
+	}
+	var cred *unix.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		log.Warnf("Failed to get the peer credentials of the introspection connection: %v", err)
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredentialsKey{}, cred)
+}
+
+// authenticateAdmin returns who sent an admin request, or an error if the request isn't allowed
+func authenticateAdmin(r *http.Request) (string, error) {
+	if cred, ok := r.Context().Value(peerCredentialsKey{}).(*unix.Ucred); ok {
+		if cred.Uid == 0 || int(cred.Uid) == os.Geteuid() {
+			return fmt.Sprintf("uid %d pid %d over the Unix socket", cred.Uid, cred.Pid), nil
+		}
+		log.Debugf("Admin API: uid %d pid %d is not root or ipamd's user, checking the token", cred.Uid, cred.Pid)
+	}
+	tokenFile := os.Getenv(envAdminTokenFile)
+	if tokenFile == "" {
+		return "", errors.Errorf("%s is not set and the request did not come from root over a Unix socket", envAdminTokenFile)
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read the admin token")
+	}
+	token = []byte(strings.TrimSpace(string(token)))
+	if len(token) == 0 {
+		return "", errors.Errorf("the admin token file %s is empty", tokenFile)
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", errors.New("no bearer token")
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), token) != 1 {
+		return "", errors.New("wrong bearer token")
+	}
+	return "token holder at " + r.RemoteAddr, nil
+}
+
+// adminHandler runs an admin action for the POST requests of authenticated callers, and audits the requests in the
+// logs
+func adminHandler(action string, run func(r *http.Request) (AdminResult, error)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		caller, err := authenticateAdmin(r)
+		if err != nil {
+			log.Warnf("Admin API: denied %s from %s: %v", action, r.RemoteAddr, err)
+			adminRequests.WithLabelValues(action, "denied").Inc()
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		log.Infof("Admin API: %s %q requested by %s", action, r.URL.RawQuery, caller)
+		result, err := run(r)
+		if err != nil {
+			log.Warnf("Admin API: %s %q by %s failed: %v", action, r.URL.RawQuery, caller, err)
+			adminRequests.WithLabelValues(action, "failed").Inc()
+			status := http.StatusInternalServerError
+			if _, ok := err.(adminRequestError); ok {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		result.Action = action
+		log.Infof("Admin API: %s %q by %s succeeded: %s", action, r.URL.RawQuery, caller, result.Message)
+		adminRequests.WithLabelValues(action, "succeeded").Inc()
+		responseJSON, err := json.Marshal(result)
+		if err != nil {
+			log.Errorf("Failed to marshal admin result: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		logErr(w.Write(responseJSON))
+	}
+}
+
+// requiredParam returns a query parameter of an admin request
+func requiredParam(r *http.Request, name string) (string, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return "", adminRequestError{errors.Errorf("missing %s parameter", name)}
+	}
+	return value, nil
+}
+
+// adminReconcile reconciles the ENIs and IP addresses with EC2 right away
+func (c *IPAMContext) adminReconcile(r *http.Request) (AdminResult, error) {
+	c.waitENISetup()
+	c.poolManagerLock.Lock()
+	defer c.poolManagerLock.Unlock()
+	c.nodeIPPoolReconcile(0)
+	return AdminResult{Message: "reconciled the ENIs and IP addresses"}, nil
+}
+
+// adminReleaseIP releases the IP address of a pod that is gone without its IP being released
+func (c *IPAMContext) adminReleaseIP(r *http.Request) (AdminResult, error) {
+	ip, err := requiredParam(r, "ip")
+	if err != nil {
+		return AdminResult{}, err
+	}
+	pod, err := c.dataStore.ReleasePodIP(ip)
+	if err != nil {
+		return AdminResult{}, adminRequestError{err}
+	}
+	c.observePodDeleted(pod.Sandbox)
+	c.triggerPodEIPSync()
+
+	// The CNI plugin never deleted the pod, so its rules and host route are still there
+	podIP := net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(32, 32)}
+	if err = c.networkClient.DeletePodRules(podIP); err == nil {
+		err = c.networkClient.DeletePodRoute(podIP)
+	}
+	if err != nil {
+		ipamdErrInc("adminReleaseIPDeleteRoutingFailed")
+		return AdminResult{}, errors.Wrapf(err, "released IP %s of pod %s/%s, but failed to delete its routing",
+			ip, pod.Namespace, pod.Name)
+	}
+	return AdminResult{
+		Message: fmt.Sprintf("released IP %s of pod %s/%s", ip, pod.Namespace, pod.Name),
+		Pod:     &pod,
+	}, nil
+}
+
+// adminDrainENI stops assigning the IPs of an ENI to new pods, and frees it once its pods are gone
+func (c *IPAMContext) adminDrainENI(r *http.Request) (AdminResult, error) {
+	eni, err := requiredParam(r, "eni")
+	if err != nil {
+		return AdminResult{}, err
+	}
+	if err = c.dataStore.SetENIDraining(eni); err != nil {
+		return AdminResult{}, adminRequestError{err}
+	}
+	c.waitENISetup()
+	c.poolManagerLock.Lock()
+	defer c.poolManagerLock.Unlock()
+	for _, freed := range c.freeDrainedENIs() {
+		if freed == eni {
+			return AdminResult{Message: fmt.Sprintf("freed ENI %s", eni)}, nil
+		}
+	}
+	return AdminResult{Message: fmt.Sprintf("draining ENI %s, it is freed once its pods are gone", eni)}, nil
+}
+
+// adminLogLevel changes the log level until ipamd restarts
+func (c *IPAMContext) adminLogLevel(r *http.Request) (AdminResult, error) {
+	level, err := requiredParam(r, "level")
+	if err != nil {
+		return AdminResult{}, err
+	}
+	if err = logger.SetLogLevel(level); err != nil {
+		return AdminResult{}, adminRequestError{err}
+	}
+	return AdminResult{Message: fmt.Sprintf("set the log level to %s until ipamd restarts", level)}, nil
+}
+
+// freeDrainedENIs frees the draining ENIs whose pods are gone, and returns them
+func (c *IPAMContext) freeDrainedENIs() []string {
+	var freed []string
+	for {
+		eni := c.dataStore.RemoveDrainedENIFromStore()
+		if eni == "" {
+			return freed
+		}
+		log.Infof("Freeing drained ENI %s", eni)
+		c.teardownENINetwork(eni)
+		err := c.awsClient.FreeENI(eni)
+		c.publishPoolAction(poolActionFreeENI, eni, err, "drained")
+		if err != nil {
+			ipamdErrInc("freeDrainedENIFailed")
+			log.Errorf("Failed to free drained ENI %s: %v", eni, err)
+			continue
+		}
+		freed = append(freed, eni)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+// setAdminToken writes a token file and points AWS_VPC_K8S_CNI_ADMIN_TOKEN_FILE to it
+func setAdminToken(t *testing.T, token string) func() {
+	dir, err := ioutil.TempDir("", "admin-token")
+	assert.NoError(t, err)
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600))
+	_ = os.Setenv(envAdminTokenFile, tokenFile)
+	return func() {
+		_ = os.Unsetenv(envAdminTokenFile)
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func adminRequest(handler func(http.ResponseWriter, *http.Request), method, target, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler(recorder, r)
+	return recorder
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	handler := adminHandler("test", func(r *http.Request) (AdminResult, error) {
+		return AdminResult{Message: "done"}, nil
+	})
+
+	// Without a token file, only the Unix socket is allowed
+	assert.Equal(t, http.StatusForbidden, adminRequest(handler, http.MethodPost, "/v1/admin/test", "secret").Code)
+
+	defer setAdminToken(t, "secret")()
+	assert.Equal(t, http.StatusMethodNotAllowed, adminRequest(handler, http.MethodGet, "/v1/admin/test", "secret").Code)
+	assert.Equal(t, http.StatusForbidden, adminRequest(handler, http.MethodPost, "/v1/admin/test", "").Code)
+	assert.Equal(t, http.StatusForbidden, adminRequest(handler, http.MethodPost, "/v1/admin/test", "wrong").Code)
+
+	recorder := adminRequest(handler, http.MethodPost, "/v1/admin/test", "secret")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var result AdminResult
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.Equal(t, AdminResult{Action: "test", Message: "done"}, result)
+}
+
+func TestAdminUnixSocketPeerCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin-socket")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "ipamd.sock")
+	ln, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(adminHandler("test", func(r *http.Request) (AdminResult, error) {
+			return AdminResult{Message: "done"}, nil
+		})),
+		ConnContext: peerCredentials,
+	}
+	go func() { _ = server.Serve(ln) }()
+	defer server.Close()
+
+	// This process is ipamd's user, so it needs no token
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Post("http://ipamd/v1/admin/test", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_ = resp.Body.Close()
+}
+
+func TestAdminReleaseIP(t *testing.T) {
+	ctrl, _, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+	defer setAdminToken(t, "secret")()
+	c := &IPAMContext{dataStore: datastore.NewDataStore(), networkClient: mockNetwork}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "gone", Namespace: "default", Sandbox: "s1"})
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "gone-too", Namespace: "default", Sandbox: "s2"})
+	handler := adminHandler("release-ip", c.adminReleaseIP)
+
+	assert.Equal(t, http.StatusBadRequest, adminRequest(handler, http.MethodPost, "/v1/admin/release-ip", "secret").Code)
+
+	// The rules and the host route of the pod are deleted with its IP
+	podIP := net.IPNet{IP: net.ParseIP(ipaddr01), Mask: net.CIDRMask(32, 32)}
+	mockNetwork.EXPECT().DeletePodRules(podIP).Return(nil)
+	mockNetwork.EXPECT().DeletePodRoute(podIP).Return(nil)
+	recorder := adminRequest(handler, http.MethodPost, "/v1/admin/release-ip?ip="+ipaddr01, "secret")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var result AdminResult
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.Equal(t, "gone", result.Pod.Name)
+	_, assigned := c.dataStore.GetStats()
+	assert.Equal(t, 1, assigned)
+
+	assert.Equal(t, http.StatusBadRequest, adminRequest(handler, http.MethodPost, "/v1/admin/release-ip?ip="+ipaddr01, "secret").Code)
+
+	mockNetwork.EXPECT().DeletePodRules(net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.CIDRMask(32, 32)}).Return(
+		errors.New("netlink failure"))
+	recorder = adminRequest(handler, http.MethodPost, "/v1/admin/release-ip?ip="+ipaddr02, "secret")
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "failed to delete its routing")
+}
+
+func TestAdminDrainENI(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+	defer setAdminToken(t, "secret")()
+	c := &IPAMContext{awsClient: mockAWS, dataStore: datastore.NewDataStore()}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddENI(secENIid, secDevice, false)
+	_ = c.dataStore.AddIPv4AddressToStore(secENIid, ipaddr02)
+	handler := adminHandler("drain-eni", c.adminDrainENI)
+
+	assert.Equal(t, http.StatusBadRequest, adminRequest(handler, http.MethodPost, "/v1/admin/drain-eni?eni="+primaryENIid, "secret").Code)
+
+	// An ENI without pods is freed right away
+	mockAWS.EXPECT().FreeENI(secENIid).Return(nil)
+	recorder := adminRequest(handler, http.MethodPost, "/v1/admin/drain-eni?eni="+secENIid, "secret")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "freed ENI "+secENIid)
+	assert.Equal(t, 1, c.dataStore.GetENIs())
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	defer setAdminToken(t, "secret")()
+	handler := adminHandler("log-level", (&IPAMContext{}).adminLogLevel)
+	assert.Equal(t, http.StatusBadRequest, adminRequest(handler, http.MethodPost, "/v1/admin/log-level?level=loud", "secret").Code)
+}
@@ -0,0 +1,311 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/cihub/seelog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/consistency"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+const (
+	// envConsistencyCheckInterval is how often the datastore is compared with the kernel, IMDS and EC2, as a
+	// duration. Defaults to 5 minutes, 0 disables the checks.
+	envConsistencyCheckInterval     = "AWS_VPC_K8S_CNI_CONSISTENCY_CHECK_INTERVAL"
+	defaultConsistencyCheckInterval = 5 * time.Minute
+
+	// envConsistencyAutoFix fixes the findings of the categories that are safe to fix, once two checks in a row found
+	// them. Defaults to false.
+	envConsistencyAutoFix = "AWS_VPC_K8S_CNI_CONSISTENCY_AUTOFIX"
+
+	// The views of the consistency checks, in the Skipped errors of the report
+	consistencyViewRules  = "rules"
+	consistencyViewRoutes = "routes"
+	consistencyViewIMDS   = "imds"
+	consistencyViewEC2    = "ec2"
+)
+
+var (
+	consistencyMismatches = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_consistency_mismatches",
+			Help: "The number of mismatches found by the last consistency check, by category",
+		},
+		[]string{"category"},
+	)
+	consistencyFixes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "awscni_consistency_fixes",
+			Help: "The number of mismatches the consistency checks tried to fix, by category and result",
+		},
+		[]string{"category", "result"},
+	)
+)
+
+// ConsistencyReport is the result of a consistency check
+type ConsistencyReport struct {
+	CheckedAt time.Time
+	// Skipped are the views that couldn't be collected, with their error. Their checks are left out.
+	Skipped  map[string]string `json:",omitempty"`
+	Findings []consistency.Finding
+}
+
+// consistencyState is the last consistency check
+type consistencyState struct {
+	lock   sync.Mutex
+	report *ConsistencyReport
+}
+
+func (s *consistencyState) get() *ConsistencyReport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.report
+}
+
+func (s *consistencyState) set(report *ConsistencyReport) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.report = report
+}
+
+func getConsistencyCheckInterval() time.Duration {
+	inputStr, found := os.LookupEnv(envConsistencyCheckInterval)
+	if !found {
+		return defaultConsistencyCheckInterval
+	}
+	interval, err := time.ParseDuration(inputStr)
+	if err != nil || interval < 0 {
+		log.Errorf("Failed to parse %s %q; using default: %v", envConsistencyCheckInterval, inputStr,
+			defaultConsistencyCheckInterval)
+		return defaultConsistencyCheckInterval
+	}
+	return interval
+}
+
+func useConsistencyAutoFix() bool {
+	if strValue := os.Getenv(envConsistencyAutoFix); strValue != "" {
+		parsedValue, err := strconv.ParseBool(strValue)
+		if err == nil {
+			return parsedValue
+		}
+		log.Error("Failed to parse "+envConsistencyAutoFix+"; using default: false", err.Error())
+	}
+	return false
+}
+
+// StartConsistencyChecker compares the datastore with the kernel, IMDS and EC2 every
+// AWS_VPC_K8S_CNI_CONSISTENCY_CHECK_INTERVAL
+func (c *IPAMContext) StartConsistencyChecker() {
+	interval := getConsistencyCheckInterval()
+	if interval == 0 {
+		log.Info("Consistency checks are disabled")
+		return
+	}
+	autoFix := useConsistencyAutoFix()
+	c.waitENISetup()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.checkConsistency(autoFix)
+		<-ticker.C
+	}
+}
+
+// collectConsistencyView collects the views of the datastore, the kernel, IMDS and EC2. The views that fail are
+// returned with their error instead.
+func (c *IPAMContext) collectConsistencyView() (consistency.View, map[string]string) {
+	skipped := make(map[string]string)
+	view := consistency.View{
+		ENIs:   c.dataStore.GetENIRecords(datastore.AddressFilter{}),
+		Pods:   c.dataStore.GetPodIPv4Addresses(),
+		Layout: c.networkClient.GetRoutingLayout(),
+	}
+	rules, err := c.networkClient.GetRuleList()
+	if err != nil {
+		skipped[consistencyViewRules] = err.Error()
+	} else {
+		view.Rules = rules
+	}
+	if view.HostRoutes, err = c.networkClient.GetPodRoutes(); err != nil {
+		skipped[consistencyViewRoutes] = err.Error()
+	}
+
+	// GetAttachedENIs lists the ENIs of IMDS, with their IP addresses from EC2
+	attached, err := c.awsClient.GetAttachedENIs()
+	if err != nil {
+		skipped[consistencyViewIMDS] = err.Error()
+		skipped[consistencyViewEC2] = err.Error()
+		return view, skipped
+	}
+	view.IMDS = make(map[string][]string, len(attached))
+	view.EC2 = make(map[string][]string, len(attached))
+	view.Unmanaged = make(map[string]bool)
+	for _, eni := range attached {
+		if eni.Tags[eniNoManageTagKey] == "true" {
+			view.Unmanaged[eni.ENIID] = true
+		}
+		view.EC2[eni.ENIID] = secondaryIPv4Addresses(eni.IPv4Addresses)
+		ips, err := c.awsClient.GetIMDSIPv4Addresses(eni.MAC)
+		if err != nil {
+			skipped[consistencyViewIMDS] = err.Error()
+			view.IMDS = nil
+			continue
+		}
+		if view.IMDS != nil && len(ips) > 0 {
+			// The primary IP address comes first, and isn't in the datastore
+			view.IMDS[eni.ENIID] = ips[1:]
+		}
+	}
+
+	// The ENIs of the datastore that IMDS doesn't list are looked up in EC2
+	for eniID := range view.ENIs.ENIIPPools {
+		if _, ok := view.EC2[eniID]; ok {
+			continue
+		}
+		addrs, _, attachmentID, err := c.awsClient.DescribeENI(eniID)
+		if err == awsutils.ErrENINotFound || (err == nil && attachmentID == nil) {
+			continue
+		}
+		if err != nil {
+			skipped[consistencyViewEC2] = err.Error()
+			view.EC2 = nil
+			break
+		}
+		view.EC2[eniID] = secondaryIPv4Addresses(addrs)
+	}
+	return view, skipped
+}
+
+// secondaryIPv4Addresses returns the IP addresses of an ENI that ipamd keeps in its datastore
+func secondaryIPv4Addresses(addrs []*ec2.NetworkInterfacePrivateIpAddress) []string {
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if !aws.BoolValue(addr.Primary) {
+			ips = append(ips, aws.StringValue(addr.PrivateIpAddress))
+		}
+	}
+	return ips
+}
+
+// checkConsistency compares the views, records the report and the metrics, and fixes the findings that can be
+// fixed if autoFix is set
+func (c *IPAMContext) checkConsistency(autoFix bool) *ConsistencyReport {
+	view, skipped := c.collectConsistencyView()
+	report := &ConsistencyReport{CheckedAt: time.Now(), Findings: consistency.Check(view)}
+	if len(skipped) > 0 {
+		report.Skipped = skipped
+		log.Warnf("Consistency check: skipped views %v", skipped)
+	}
+
+	counts := make(map[consistency.Category]int)
+	for _, f := range report.Findings {
+		counts[f.Category]++
+	}
+	for _, category := range consistency.Categories {
+		consistencyMismatches.WithLabelValues(string(category)).Set(float64(counts[category]))
+	}
+
+	if autoFix {
+		c.fixConsistency(report, c.consistency.get())
+	}
+	for _, f := range report.Findings {
+		if f.Severity == consistency.SeverityError {
+			log.Errorf("Consistency check: %s ENI %s IP %s pod %s: %s", f.Category, f.ENI, f.IP, f.Pod, f.Message)
+		} else {
+			log.Infof("Consistency check: %s ENI %s IP %s: %s", f.Category, f.ENI, f.IP, f.Message)
+		}
+	}
+	c.consistency.set(report)
+	return report
+}
+
+// fixConsistency fixes the fixable findings that the previous check found too, so that the changes that were in
+// progress during a check are left alone. The stale rules are deleted, and the IP pool is reconciled for the other
+// categories.
+func (c *IPAMContext) fixConsistency(report *ConsistencyReport, previous *ConsistencyReport) {
+	if previous == nil {
+		return
+	}
+	found := make(map[string]bool, len(previous.Findings))
+	for _, f := range previous.Findings {
+		found[f.Key()] = true
+	}
+	var reconcile []*consistency.Finding
+	for i := range report.Findings {
+		f := &report.Findings[i]
+		if !f.Fixable || !found[f.Key()] {
+			continue
+		}
+		if f.Category == consistency.RuleWithoutPod {
+			c.fixRuleWithoutPod(f, previous.CheckedAt)
+		} else {
+			reconcile = append(reconcile, f)
+		}
+	}
+	if len(reconcile) == 0 {
+		return
+	}
+	c.poolManagerLock.Lock()
+	c.nodeIPPoolReconcile(0)
+	c.poolManagerLock.Unlock()
+	for _, f := range reconcile {
+		f.Fixed = true
+		consistencyFixes.WithLabelValues(string(f.Category), "reconciled").Inc()
+	}
+}
+
+// fixRuleWithoutPod deletes the rules of an IP address, unless it was assigned to a pod or released since the
+// previous check. The rules are deleted with the datastore locked, so that AddNetwork can't assign the IP address
+// meanwhile.
+func (c *IPAMContext) fixRuleWithoutPod(f *consistency.Finding, previousCheck time.Time) {
+	deleted, err := c.dataStore.WithUnusedIPv4Address(f.IP, previousCheck, func() error {
+		log.Infof("Consistency check: deleting the rules of IP %s, which is not assigned to a pod", f.IP)
+		return c.networkClient.DeletePodRules(net.IPNet{IP: net.ParseIP(f.IP), Mask: net.CIDRMask(32, 32)})
+	})
+	if err != nil {
+		log.Warnf("Consistency check: failed to delete the rules of IP %s: %v", f.IP, err)
+		f.FixError = err.Error()
+		consistencyFixes.WithLabelValues(string(f.Category), "failed").Inc()
+		return
+	}
+	if !deleted {
+		return
+	}
+	f.Fixed = true
+	consistencyFixes.WithLabelValues(string(f.Category), "fixed").Inc()
+}
+
+// consistencyV1RequestHandler returns the report of the last consistency check
+func consistencyV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := ipam.consistency.get()
+		if report == nil {
+			http.Error(w, "no consistency check has run yet", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, r, report, "consistency report")
+	}
+}
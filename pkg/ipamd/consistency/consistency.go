@@ -0,0 +1,469 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package consistency compares the pods and IP addresses of ipamd's datastore with each other, with the IP rules and
+// routes of the kernel, and with the ENIs and IP addresses that IMDS and EC2 report
+package consistency
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+// Category is a kind of mismatch between the views
+type Category string
+
+const (
+	// MissingRule is a pod without its to-pod rule, or a pod of a secondary ENI without its from-pod rules
+	MissingRule Category = "MissingRule"
+	// WrongRule is a rule of a pod that looks up the wrong route table
+	WrongRule Category = "WrongRule"
+	// RuleWithoutPod is a to-pod or from-pod rule of an IP address that isn't assigned to a pod
+	RuleWithoutPod Category = "RuleWithoutPod"
+	// MissingHostRoute is a pod without the route to its veth
+	MissingHostRoute Category = "MissingHostRoute"
+	// HostRouteWithoutPod is a route to a veth for an IP address that isn't assigned to a pod
+	HostRouteWithoutPod Category = "HostRouteWithoutPod"
+	// IPNotInDatastore is an IP address of an ENI that is missing from the datastore
+	IPNotInDatastore Category = "IPNotInDatastore"
+	// IPNotOnENI is an unassigned IP address of the datastore that is no longer on its ENI
+	IPNotOnENI Category = "IPNotOnENI"
+	// PodIPNotOnENI is the IP address of a pod that is no longer on its ENI
+	PodIPNotOnENI Category = "PodIPNotOnENI"
+	// ENINotAttached is an ENI of the datastore that is no longer attached
+	ENINotAttached Category = "ENINotAttached"
+	// ENINotInDatastore is an attached ENI that ipamd should manage but is missing from the datastore
+	ENINotInDatastore Category = "ENINotInDatastore"
+	// DatastoreMismatch is a pod of the datastore whose IP address isn't assigned to it on its ENI, or an IP address
+	// assigned to no pod
+	DatastoreMismatch Category = "DatastoreMismatch"
+	// IMDSOutOfDate is an ENI or IP address that IMDS and EC2 disagree on. IMDS can take a few seconds to see a
+	// change.
+	IMDSOutOfDate Category = "IMDSOutOfDate"
+)
+
+// Categories are all the categories, for the metrics
+var Categories = []Category{MissingRule, WrongRule, RuleWithoutPod, MissingHostRoute, HostRouteWithoutPod,
+	IPNotInDatastore, IPNotOnENI, PodIPNotOnENI, ENINotAttached, ENINotInDatastore, DatastoreMismatch, IMDSOutOfDate}
+
+// fixable are the categories that are safe to fix without the CNI plugin: a stale rule can be deleted, and the
+// reconcile of the IP pool adds and removes the unassigned IP addresses and the ENIs the way it does when IMDS reports
+// them
+var fixable = map[Category]bool{
+	RuleWithoutPod:    true,
+	IPNotInDatastore:  true,
+	IPNotOnENI:        true,
+	ENINotInDatastore: true,
+}
+
+// Severity is how much a finding matters. Only errors break pods, the other findings can be expected for a while
+// when ipamd is changing the pool.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+)
+
+var severities = map[Category]Severity{
+	MissingRule:         SeverityError,
+	WrongRule:           SeverityError,
+	RuleWithoutPod:      SeverityWarning,
+	MissingHostRoute:    SeverityError,
+	HostRouteWithoutPod: SeverityWarning,
+	IPNotInDatastore:    SeverityWarning,
+	IPNotOnENI:          SeverityWarning,
+	PodIPNotOnENI:       SeverityError,
+	ENINotAttached:      SeverityError,
+	ENINotInDatastore:   SeverityWarning,
+	DatastoreMismatch:   SeverityError,
+	IMDSOutOfDate:       SeverityInfo,
+}
+
+// Finding is a mismatch between the views
+type Finding struct {
+	Category Category
+	Severity Severity
+	ENI      string `json:",omitempty"`
+	IP       string `json:",omitempty"`
+	// Pod is the namespace and name of the pod the IP address is assigned to
+	Pod     string `json:",omitempty"`
+	Message string
+	// Fixable is set if the category can be fixed automatically
+	Fixable bool `json:",omitempty"`
+	// Fixed and FixError are set by ipamd when it tried to fix the finding
+	Fixed    bool   `json:",omitempty"`
+	FixError string `json:",omitempty"`
+}
+
+// Key identifies a finding across checks
+func (f Finding) Key() string {
+	return fmt.Sprintf("%s/%s/%s", f.Category, f.ENI, f.IP)
+}
+
+func newFinding(category Category, eni string, ip string, pod string, format string, args ...interface{}) Finding {
+	return Finding{
+		Category: category,
+		Severity: severities[category],
+		ENI:      eni,
+		IP:       ip,
+		Pod:      pod,
+		Message:  fmt.Sprintf(format, args...),
+		Fixable:  fixable[category],
+	}
+}
+
+// HasErrors returns whether one of the findings is an error
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// View is what the datastore, the kernel, IMDS and EC2 say about the IP addresses and ENIs of the node. A nil view
+// wasn't collected, and is left out of the checks.
+type View struct {
+	ENIs datastore.ENIRecords
+	// Pods are the pods of the datastore, with the IP address and the ENI they were assigned
+	Pods   []datastore.PodIPv4Address
+	Layout networkutils.RoutingLayout
+	Rules  []netlink.Rule
+	// HostRoutes are the links of the routes to the veths of the pods in the main table, by IP address
+	HostRoutes map[string]string
+	// IMDS and EC2 are the secondary IP addresses of the attached ENIs, by ENI
+	IMDS map[string][]string
+	EC2  map[string][]string
+	// Unmanaged are the ENIs ipamd doesn't manage, tagged with node.k8s.amazonaws.com/no_manage
+	Unmanaged map[string]bool
+}
+
+// address is an IP address of the datastore
+type address struct {
+	eni          string
+	deviceNumber int
+	assigned     bool
+	// pod is empty for the egress IPs, that are reserved without a namespace and have no rules or routes
+	pod string
+}
+
+func (v View) addresses() map[string]address {
+	addresses := make(map[string]address)
+	for eniID, eni := range v.ENIs.ENIIPPools {
+		for ip, addr := range eni.IPv4Addresses {
+			a := address{eni: eniID, deviceNumber: eni.DeviceNumber, assigned: addr.Assigned}
+			if addr.Assigned && addr.PodNamespace != "" {
+				a.pod = addr.PodNamespace + "/" + addr.PodName
+			}
+			addresses[ip] = a
+		}
+	}
+	return addresses
+}
+
+// Check compares the views, and returns the findings sorted by severity, category, ENI and IP address
+func Check(v View) []Finding {
+	addresses := v.addresses()
+	var findings []Finding
+	if v.Pods != nil {
+		findings = append(findings, checkPods(addresses, v.Pods)...)
+	}
+	if v.Rules != nil {
+		findings = append(findings, checkRules(addresses, v.Layout, v.Rules)...)
+	}
+	if v.HostRoutes != nil {
+		findings = append(findings, checkHostRoutes(addresses, v.HostRoutes)...)
+	}
+	if v.IMDS != nil || v.EC2 != nil {
+		findings = append(findings, checkENIs(v)...)
+	}
+	Sort(findings)
+	return findings
+}
+
+// Sort orders the findings by severity, category, ENI and IP address
+func Sort(findings []Finding) {
+	rank := map[Severity]int{SeverityError: 0, SeverityWarning: 1, SeverityInfo: 2}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Severity != b.Severity {
+			return rank[a.Severity] < rank[b.Severity]
+		}
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		if a.ENI != b.ENI {
+			return a.ENI < b.ENI
+		}
+		return a.IP < b.IP
+	})
+}
+
+// hostIP returns the address of a /32 rule selector, or "" if it is not one
+func hostIP(ipNet *net.IPNet) string {
+	if ipNet == nil {
+		return ""
+	}
+	if ones, bits := ipNet.Mask.Size(); ones != 32 || bits != 32 {
+		return ""
+	}
+	return ipNet.IP.String()
+}
+
+// checkPods compares the pods of the datastore with the IP addresses of its ENIs
+func checkPods(addresses map[string]address, pods []datastore.PodIPv4Address) []Finding {
+	var findings []Finding
+	podIPs := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		podIPs[pod.IP] = true
+		name := pod.Namespace + "/" + pod.Name
+		addr, ok := addresses[pod.IP]
+		switch {
+		case !ok:
+			findings = append(findings, newFinding(DatastoreMismatch, pod.ENI, pod.IP, name,
+				"the pod has an IP address that is not on any ENI"))
+		case !addr.assigned:
+			findings = append(findings, newFinding(DatastoreMismatch, addr.eni, pod.IP, name,
+				"the pod has an IP address that is not assigned on its ENI"))
+		case pod.ENI != "" && pod.ENI != addr.eni:
+			findings = append(findings, newFinding(DatastoreMismatch, addr.eni, pod.IP, name,
+				"the pod is recorded on ENI %s, but its IP address is on this ENI", pod.ENI))
+		}
+	}
+	for ip, addr := range addresses {
+		if addr.assigned && !podIPs[ip] {
+			findings = append(findings, newFinding(DatastoreMismatch, addr.eni, ip, "",
+				"IP address is assigned on the ENI, but to no pod"))
+		}
+	}
+	return findings
+}
+
+// checkRules compares the pods with the IP rules the CNI plugin adds for them: every pod needs a
+// "to <pod IP> lookup main" rule, and the pods of secondary ENIs also need "from <pod IP> lookup <ENI table>" rules
+func checkRules(addresses map[string]address, layout networkutils.RoutingLayout, rules []netlink.Rule) []Finding {
+	toPod := make(map[string]int)
+	fromPod := make(map[string][]int)
+	for _, rule := range rules {
+		switch rule.Priority {
+		case layout.ToPodRulePriority():
+			if ip := hostIP(rule.Dst); ip != "" && rule.Src == nil {
+				toPod[ip] = rule.Table
+			}
+		case layout.FromPodRulePriority():
+			if ip := hostIP(rule.Src); ip != "" {
+				fromPod[ip] = append(fromPod[ip], rule.Table)
+			}
+		}
+	}
+
+	var findings []Finding
+	for ip, addr := range addresses {
+		if addr.pod == "" {
+			continue
+		}
+		table, ok := toPod[ip]
+		if !ok {
+			findings = append(findings, newFinding(MissingRule, addr.eni, ip, addr.pod,
+				"no rule to the pod at priority %d", layout.ToPodRulePriority()))
+		} else if table != unix.RT_TABLE_MAIN {
+			findings = append(findings, newFinding(WrongRule, addr.eni, ip, addr.pod,
+				"the rule to the pod looks up table %d instead of main", table))
+		}
+		tables := fromPod[ip]
+		if addr.deviceNumber == 0 {
+			if len(tables) > 0 {
+				findings = append(findings, newFinding(WrongRule, addr.eni, ip, addr.pod,
+					"the pod is on the primary ENI, but has a rule from its IP address to table %d", tables[0]))
+			}
+			continue
+		}
+		want := layout.ENIRouteTable(addr.deviceNumber)
+		if len(tables) == 0 {
+			findings = append(findings, newFinding(MissingRule, addr.eni, ip, addr.pod,
+				"no rule from the pod at priority %d", layout.FromPodRulePriority()))
+		}
+		for _, table := range tables {
+			if table != want {
+				findings = append(findings, newFinding(WrongRule, addr.eni, ip, addr.pod,
+					"a rule from the pod looks up table %d instead of %d", table, want))
+				break
+			}
+		}
+	}
+	for ip := range toPod {
+		if addr, ok := addresses[ip]; !ok || !addr.assigned {
+			findings = append(findings, newFinding(RuleWithoutPod, addr.eni, ip, "",
+				"rule to an IP address that is not assigned to a pod"))
+		}
+	}
+	for ip := range fromPod {
+		if _, ok := toPod[ip]; ok {
+			// Already reported with the to-pod rule
+			continue
+		}
+		if addr, ok := addresses[ip]; !ok || !addr.assigned {
+			findings = append(findings, newFinding(RuleWithoutPod, addr.eni, ip, "",
+				"rule from an IP address that is not assigned to a pod"))
+		}
+	}
+	return findings
+}
+
+// checkHostRoutes compares the pods with the routes to their veths
+func checkHostRoutes(addresses map[string]address, routes map[string]string) []Finding {
+	var findings []Finding
+	for ip, addr := range addresses {
+		if _, ok := routes[ip]; addr.pod != "" && !ok {
+			findings = append(findings, newFinding(MissingHostRoute, addr.eni, ip, addr.pod,
+				"no route to the pod's veth in the main table"))
+		}
+	}
+	for ip, link := range routes {
+		if addr, ok := addresses[ip]; !ok || !addr.assigned {
+			findings = append(findings, newFinding(HostRouteWithoutPod, addr.eni, ip, "",
+				"route to veth %s for an IP address that is not assigned to a pod", link))
+		}
+	}
+	return findings
+}
+
+// sources are the views of IMDS and EC2 that were collected
+type sources struct {
+	names []string
+	views []map[string][]string
+}
+
+func (v View) sources() sources {
+	var s sources
+	for _, source := range []struct {
+		name string
+		view map[string][]string
+	}{{"IMDS", v.IMDS}, {"EC2", v.EC2}} {
+		if source.view != nil {
+			s.names = append(s.names, source.name)
+			s.views = append(s.views, source.view)
+		}
+	}
+	return s
+}
+
+// attached returns in which sources an ENI is attached
+func (s sources) attached(eni string) []string {
+	var in []string
+	for i, view := range s.views {
+		if _, ok := view[eni]; ok {
+			in = append(in, s.names[i])
+		}
+	}
+	return in
+}
+
+// onENI returns in which sources an IP address is on an ENI
+func (s sources) onENI(eni string, ip string) []string {
+	var in []string
+	for i, view := range s.views {
+		for _, addr := range view[eni] {
+			if addr == ip {
+				in = append(in, s.names[i])
+				break
+			}
+		}
+	}
+	return in
+}
+
+// checkENIs compares the ENIs and IP addresses of the datastore with the ones attached according to IMDS and EC2.
+// If they disagree, it's reported as IMDSOutOfDate rather than a mismatch of the datastore.
+func checkENIs(v View) []Finding {
+	s := v.sources()
+	all := len(s.names)
+	var findings []Finding
+	for eniID, eni := range v.ENIs.ENIIPPools {
+		switch attached := s.attached(eniID); len(attached) {
+		case 0:
+			findings = append(findings, newFinding(ENINotAttached, eniID, "", "",
+				"ENI is in the datastore, but not attached"))
+			continue
+		case all:
+		default:
+			findings = append(findings, newFinding(IMDSOutOfDate, eniID, "", "",
+				"ENI is attached according to %s only", attached[0]))
+			continue
+		}
+		for ip, addr := range eni.IPv4Addresses {
+			pod := ""
+			if addr.Assigned {
+				pod = addr.PodNamespace + "/" + addr.PodName
+			}
+			switch in := s.onENI(eniID, ip); len(in) {
+			case 0:
+				if addr.Assigned {
+					findings = append(findings, newFinding(PodIPNotOnENI, eniID, ip, pod,
+						"IP address of a pod is no longer on its ENI"))
+				} else {
+					findings = append(findings, newFinding(IPNotOnENI, eniID, ip, "",
+						"IP address is in the datastore, but no longer on its ENI"))
+				}
+			case all:
+			default:
+				findings = append(findings, newFinding(IMDSOutOfDate, eniID, ip, pod,
+					"IP address is on the ENI according to %s only", in[0]))
+			}
+		}
+		for i, view := range s.views {
+			for _, ip := range view[eniID] {
+				if _, ok := eni.IPv4Addresses[ip]; ok {
+					continue
+				}
+				if in := s.onENI(eniID, ip); len(in) == all {
+					if i == 0 {
+						findings = append(findings, newFinding(IPNotInDatastore, eniID, ip, "",
+							"IP address is on the ENI, but not in the datastore"))
+					}
+				} else {
+					findings = append(findings, newFinding(IMDSOutOfDate, eniID, ip, "",
+						"IP address is on the ENI according to %s only", s.names[i]))
+				}
+			}
+		}
+	}
+
+	reported := make(map[string]bool)
+	for i, view := range s.views {
+		for eniID := range view {
+			if _, ok := v.ENIs.ENIIPPools[eniID]; ok || v.Unmanaged[eniID] || reported[eniID] {
+				continue
+			}
+			reported[eniID] = true
+			if attached := s.attached(eniID); len(attached) == all {
+				findings = append(findings, newFinding(ENINotInDatastore, eniID, "", "",
+					"ENI is attached, but not in the datastore"))
+			} else {
+				findings = append(findings, newFinding(IMDSOutOfDate, eniID, "", "",
+					"ENI is attached according to %s only", s.names[i]))
+			}
+		}
+	}
+	return findings
+}
@@ -0,0 +1,246 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package consistency
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+const (
+	primaryENI   = "eni-00000000"
+	secondaryENI = "eni-00000001"
+	podIP        = "10.0.0.10"
+	freeIP       = "10.0.0.11"
+	secondaryIP  = "10.0.1.10"
+	egressIP     = "10.0.1.11"
+)
+
+var layout = networkutils.DefaultRoutingLayout
+
+func hostNet(ip string) *net.IPNet {
+	return &net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(32, 32)}
+}
+
+func toPodRule(ip string) netlink.Rule {
+	rule := *netlink.NewRule()
+	rule.Dst = hostNet(ip)
+	rule.Table = unix.RT_TABLE_MAIN
+	rule.Priority = layout.ToPodRulePriority()
+	return rule
+}
+
+func fromPodRule(ip string, table int) netlink.Rule {
+	rule := *netlink.NewRule()
+	rule.Src = hostNet(ip)
+	rule.Table = table
+	rule.Priority = layout.FromPodRulePriority()
+	return rule
+}
+
+// consistentView is a node with a pod on each ENI, an unassigned IP address and an egress IP
+func consistentView() View {
+	return View{
+		ENIs: datastore.ENIRecords{ENIIPPools: map[string]datastore.ENIRecord{
+			primaryENI: {ID: primaryENI, IsPrimary: true, IPv4Addresses: map[string]datastore.AddressRecord{
+				podIP:  {Address: podIP, Assigned: true, PodNamespace: "default", PodName: "a"},
+				freeIP: {Address: freeIP},
+			}},
+			secondaryENI: {ID: secondaryENI, DeviceNumber: 1, IPv4Addresses: map[string]datastore.AddressRecord{
+				secondaryIP: {Address: secondaryIP, Assigned: true, PodNamespace: "default", PodName: "b"},
+				egressIP:    {Address: egressIP, Assigned: true, PodName: "egress-ip"},
+			}},
+		}},
+		Pods: []datastore.PodIPv4Address{
+			{Name: "a", Namespace: "default", IP: podIP, ENI: primaryENI},
+			{Name: "b", Namespace: "default", IP: secondaryIP, ENI: secondaryENI, DeviceNumber: 1},
+			{Name: "egress-ip", IP: egressIP, ENI: secondaryENI, DeviceNumber: 1},
+		},
+		Layout: layout,
+		Rules: []netlink.Rule{
+			toPodRule(podIP),
+			toPodRule(secondaryIP),
+			fromPodRule(secondaryIP, layout.ENIRouteTable(1)),
+		},
+		HostRoutes: map[string]string{podIP: "eni1", secondaryIP: "eni2"},
+		IMDS:       map[string][]string{primaryENI: {podIP, freeIP}, secondaryENI: {secondaryIP, egressIP}},
+		EC2:        map[string][]string{primaryENI: {podIP, freeIP}, secondaryENI: {secondaryIP, egressIP}},
+	}
+}
+
+func categories(findings []Finding) []Category {
+	var result []Category
+	for _, f := range findings {
+		result = append(result, f.Category)
+	}
+	return result
+}
+
+func TestCheckConsistent(t *testing.T) {
+	assert.Empty(t, Check(consistentView()))
+}
+
+func TestCheckPods(t *testing.T) {
+	v := consistentView()
+	v.ENIs.ENIIPPools[primaryENI].IPv4Addresses[freeIP] = datastore.AddressRecord{Address: freeIP, Assigned: true}
+	v.Pods = []datastore.PodIPv4Address{
+		{Name: "a", Namespace: "default", IP: podIP, ENI: secondaryENI},
+		{Name: "b", Namespace: "default", IP: "10.0.0.99"},
+		{Name: "egress-ip", IP: egressIP, ENI: secondaryENI, DeviceNumber: 1},
+	}
+	v.Rules, v.HostRoutes, v.IMDS, v.EC2 = nil, nil, nil, nil
+	findings := Check(v)
+	assert.Equal(t, []Category{DatastoreMismatch, DatastoreMismatch, DatastoreMismatch, DatastoreMismatch},
+		categories(findings))
+	assert.Equal(t, "10.0.0.99", findings[0].IP)
+	assert.Equal(t, "default/b", findings[0].Pod)
+	assert.Equal(t, podIP, findings[1].IP)
+	assert.Contains(t, findings[1].Message, "recorded on ENI "+secondaryENI)
+	assert.Equal(t, freeIP, findings[2].IP)
+	assert.Contains(t, findings[2].Message, "to no pod")
+	assert.Equal(t, secondaryIP, findings[3].IP)
+	assert.False(t, findings[3].Fixable)
+	assert.True(t, HasErrors(findings))
+}
+
+func TestCheckRules(t *testing.T) {
+	v := consistentView()
+	v.Rules = []netlink.Rule{
+		toPodRule(podIP),
+		fromPodRule(podIP, 2),
+		toPodRule(freeIP),
+		toPodRule(secondaryIP),
+	}
+	findings := Check(v)
+	assert.Equal(t, []Category{MissingRule, WrongRule, RuleWithoutPod}, categories(findings))
+	assert.Equal(t, secondaryIP, findings[0].IP)
+	assert.Equal(t, "default/b", findings[0].Pod)
+	assert.Equal(t, podIP, findings[1].IP)
+	assert.Equal(t, freeIP, findings[2].IP)
+	assert.True(t, findings[2].Fixable)
+	assert.True(t, HasErrors(findings))
+}
+
+func TestCheckRulesWrongTable(t *testing.T) {
+	v := consistentView()
+	v.Rules = []netlink.Rule{toPodRule(podIP), toPodRule(secondaryIP), fromPodRule(secondaryIP, 5)}
+	findings := Check(v)
+	assert.Equal(t, []Category{WrongRule}, categories(findings))
+	assert.Contains(t, findings[0].Message, "table 5 instead of 1")
+}
+
+func TestCheckRulesWithLayout(t *testing.T) {
+	v := consistentView()
+	v.Layout = networkutils.RoutingLayout{TableOffset: 100, PriorityBase: 2048}
+	layout := v.Layout
+	v.Rules = []netlink.Rule{
+		{Dst: hostNet(podIP), Table: unix.RT_TABLE_MAIN, Priority: layout.ToPodRulePriority()},
+		{Dst: hostNet(secondaryIP), Table: unix.RT_TABLE_MAIN, Priority: layout.ToPodRulePriority()},
+		{Src: hostNet(secondaryIP), Table: layout.ENIRouteTable(1), Priority: layout.FromPodRulePriority()},
+		// The rules of the default layout are not the CNI's
+		toPodRule(freeIP),
+	}
+	assert.Empty(t, Check(v))
+}
+
+func TestCheckHostRoutes(t *testing.T) {
+	v := consistentView()
+	v.HostRoutes = map[string]string{podIP: "eni1", freeIP: "eni3"}
+	findings := Check(v)
+	assert.Equal(t, []Category{MissingHostRoute, HostRouteWithoutPod}, categories(findings))
+	assert.Equal(t, secondaryIP, findings[0].IP)
+	assert.Contains(t, findings[1].Message, "eni3")
+	assert.False(t, findings[1].Fixable)
+}
+
+func TestCheckENIs(t *testing.T) {
+	v := consistentView()
+	v.IMDS = map[string][]string{primaryENI: {podIP, "10.0.0.12"}, "eni-00000002": {}}
+	v.EC2 = map[string][]string{primaryENI: {podIP, "10.0.0.12"}, "eni-00000002": {}}
+	findings := Check(v)
+	assert.Equal(t, []Category{ENINotAttached, ENINotInDatastore, IPNotInDatastore, IPNotOnENI},
+		categories(findings))
+	assert.Equal(t, secondaryENI, findings[0].ENI)
+	assert.Equal(t, "eni-00000002", findings[1].ENI)
+	assert.Equal(t, "10.0.0.12", findings[2].IP)
+	assert.Equal(t, freeIP, findings[3].IP)
+}
+
+func TestCheckPodIPNotOnENI(t *testing.T) {
+	v := consistentView()
+	v.IMDS[primaryENI] = []string{freeIP}
+	v.EC2[primaryENI] = []string{freeIP}
+	findings := Check(v)
+	assert.Equal(t, []Category{PodIPNotOnENI}, categories(findings))
+	assert.Equal(t, "default/a", findings[0].Pod)
+	assert.False(t, findings[0].Fixable)
+}
+
+func TestCheckUnmanagedENI(t *testing.T) {
+	v := consistentView()
+	v.IMDS["eni-00000002"] = []string{"10.0.2.10"}
+	v.EC2["eni-00000002"] = []string{"10.0.2.10"}
+	v.Unmanaged = map[string]bool{"eni-00000002": true}
+	assert.Empty(t, Check(v))
+}
+
+func TestCheckIMDSOutOfDate(t *testing.T) {
+	v := consistentView()
+	// EC2 has a new IP address and a new ENI that IMDS doesn't report yet, and removed an IP address
+	v.EC2[primaryENI] = []string{podIP, freeIP, "10.0.0.12"}
+	v.EC2[secondaryENI] = []string{secondaryIP}
+	v.EC2["eni-00000002"] = nil
+	findings := Check(v)
+	assert.Equal(t, []Category{IMDSOutOfDate, IMDSOutOfDate, IMDSOutOfDate}, categories(findings))
+	assert.False(t, HasErrors(findings))
+	for _, f := range findings {
+		assert.Equal(t, SeverityInfo, f.Severity)
+	}
+	assert.Equal(t, "10.0.0.12", findings[0].IP)
+	assert.Contains(t, findings[0].Message, "EC2 only")
+	assert.Equal(t, egressIP, findings[1].IP)
+	assert.Contains(t, findings[1].Message, "IMDS only")
+	assert.Equal(t, "eni-00000002", findings[2].ENI)
+	assert.Contains(t, findings[2].Message, "EC2 only")
+}
+
+func TestCheckSkippedViews(t *testing.T) {
+	v := consistentView()
+	v.Rules = nil
+	v.HostRoutes = nil
+	v.EC2 = nil
+	v.IMDS[primaryENI] = []string{podIP}
+	findings := Check(v)
+	assert.Equal(t, []Category{IPNotOnENI}, categories(findings))
+}
+
+func TestSort(t *testing.T) {
+	findings := []Finding{
+		newFinding(IMDSOutOfDate, "eni-a", "", "", ""),
+		newFinding(RuleWithoutPod, "", "10.0.0.2", "", ""),
+		newFinding(MissingRule, "eni-b", "10.0.0.1", "", ""),
+		newFinding(MissingRule, "eni-a", "10.0.0.3", "", ""),
+	}
+	Sort(findings)
+	assert.Equal(t, []Category{MissingRule, MissingRule, RuleWithoutPod, IMDSOutOfDate}, categories(findings))
+	assert.Equal(t, "eni-a", findings[0].ENI)
+	assert.Equal(t, "MissingRule/eni-b/10.0.0.1", findings[1].Key())
+}
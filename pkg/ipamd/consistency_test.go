@@ -0,0 +1,172 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	mock_awsutils "github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils/mocks"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/consistency"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+	mock_networkutils "github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils/mocks"
+)
+
+const consistencyPrimaryIP = "10.10.10.10"
+
+// newConsistencyTestContext has a pod on ipaddr01 and a free ipaddr02, and the kernel still has the rule of a pod
+// that used ipaddr02
+func newConsistencyTestContext(t *testing.T, mockAWS *mock_awsutils.MockAPIs,
+	mockNetwork *mock_networkutils.MockNetworkAPIs, checks int) *IPAMContext {
+	c := &IPAMContext{awsClient: mockAWS, networkClient: mockNetwork, dataStore: datastore.NewDataStore()}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_, _, err := c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "a", Namespace: "default", Sandbox: "s1"})
+	assert.NoError(t, err)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+
+	layout := networkutils.DefaultRoutingLayout
+	rules := []netlink.Rule{
+		{Dst: &net.IPNet{IP: net.ParseIP(ipaddr01), Mask: net.CIDRMask(32, 32)}, Table: unix.RT_TABLE_MAIN,
+			Priority: layout.ToPodRulePriority()},
+		{Dst: &net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.CIDRMask(32, 32)}, Table: unix.RT_TABLE_MAIN,
+			Priority: layout.ToPodRulePriority()},
+	}
+	eni := awsutils.ENIMetadata{
+		ENIID: primaryENIid,
+		MAC:   primaryMAC,
+		IPv4Addresses: []*ec2.NetworkInterfacePrivateIpAddress{
+			{PrivateIpAddress: aws.String(consistencyPrimaryIP), Primary: aws.Bool(true)},
+			{PrivateIpAddress: aws.String(ipaddr01), Primary: aws.Bool(false)},
+			{PrivateIpAddress: aws.String(ipaddr02), Primary: aws.Bool(false)},
+		},
+	}
+	mockNetwork.EXPECT().GetRoutingLayout().Return(layout).Times(checks)
+	mockNetwork.EXPECT().GetRuleList().Return(rules, nil).Times(checks)
+	mockNetwork.EXPECT().GetPodRoutes().Return(map[string]string{ipaddr01: "eni1"}, nil).Times(checks)
+	mockAWS.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{eni}, nil).Times(checks)
+	mockAWS.EXPECT().GetIMDSIPv4Addresses(primaryMAC).
+		Return([]string{consistencyPrimaryIP, ipaddr01, ipaddr02}, nil).Times(checks)
+	return c
+}
+
+func TestCheckConsistencyAutoFix(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+	c := newConsistencyTestContext(t, mockAWS, mockNetwork, 3)
+
+	// The first check only reports the stale rule, in case a pod was being deleted
+	report := c.checkConsistency(true)
+	assert.Empty(t, report.Skipped)
+	assert.Len(t, report.Findings, 1)
+	finding := report.Findings[0]
+	assert.Equal(t, consistency.RuleWithoutPod, finding.Category)
+	assert.Equal(t, ipaddr02, finding.IP)
+	assert.False(t, finding.Fixed)
+
+	// The second check deletes it
+	mockNetwork.EXPECT().DeletePodRules(net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.CIDRMask(32, 32)}).Return(nil)
+	report = c.checkConsistency(true)
+	assert.Len(t, report.Findings, 1)
+	assert.True(t, report.Findings[0].Fixed)
+
+	// A failure to delete it is reported
+	mockNetwork.EXPECT().DeletePodRules(gomock.Any()).Return(errors.New("operation not permitted"))
+	report = c.checkConsistency(true)
+	assert.False(t, report.Findings[0].Fixed)
+	assert.Equal(t, "operation not permitted", report.Findings[0].FixError)
+}
+
+func TestCheckConsistencyAutoFixSkipsReusedIP(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+	c := newConsistencyTestContext(t, mockAWS, mockNetwork, 2)
+
+	c.checkConsistency(true)
+	// A pod is assigned ipaddr02 and deleted between the checks, so its rule may be the one of a new pod
+	pod := &k8sapi.K8SPodInfo{Name: "b", Namespace: "default", Sandbox: "s2"}
+	ip, _, err := c.dataStore.AssignPodIPv4Address(pod)
+	assert.NoError(t, err)
+	assert.Equal(t, ipaddr02, ip)
+	_, _, err = c.dataStore.UnassignPodIPv4Address(pod)
+	assert.NoError(t, err)
+
+	report := c.checkConsistency(true)
+	assert.Len(t, report.Findings, 1)
+	assert.Equal(t, consistency.RuleWithoutPod, report.Findings[0].Category)
+	assert.False(t, report.Findings[0].Fixed)
+	assert.Empty(t, report.Findings[0].FixError)
+}
+
+func TestCheckConsistencyWithoutAutoFix(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+	c := newConsistencyTestContext(t, mockAWS, mockNetwork, 2)
+
+	c.checkConsistency(false)
+	report := c.checkConsistency(false)
+	assert.Len(t, report.Findings, 1)
+	assert.False(t, report.Findings[0].Fixed)
+}
+
+func TestCheckConsistencySkippedViews(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+	c := &IPAMContext{awsClient: mockAWS, networkClient: mockNetwork, dataStore: datastore.NewDataStore()}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+
+	mockNetwork.EXPECT().GetRoutingLayout().Return(networkutils.DefaultRoutingLayout)
+	mockNetwork.EXPECT().GetRuleList().Return(nil, errors.New("netlink error"))
+	mockNetwork.EXPECT().GetPodRoutes().Return(map[string]string{}, nil)
+	mockAWS.EXPECT().GetAttachedENIs().Return(nil, errors.New("IMDS error"))
+
+	report := c.checkConsistency(false)
+	assert.Equal(t, map[string]string{
+		consistencyViewRules: "netlink error",
+		consistencyViewIMDS:  "IMDS error",
+		consistencyViewEC2:   "IMDS error",
+	}, report.Skipped)
+	assert.Empty(t, report.Findings)
+}
+
+func TestConsistencyV1RequestHandler(t *testing.T) {
+	c := &IPAMContext{}
+	handler := consistencyV1RequestHandler(c)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/v1/consistency", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	c.consistency.set(&ConsistencyReport{Findings: []consistency.Finding{{Category: consistency.MissingRule}}})
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/v1/consistency", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var report ConsistencyReport
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.Equal(t, consistency.MissingRule, report.Findings[0].Category)
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datastore
+
+import (
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// ReleasePodIP unassigns an IP address from the pod it is assigned to, for a pod that is gone without its IP being
+// released. It returns the pod the IP address was assigned to.
+func (ds *DataStore) ReleasePodIP(ip string) (PodIPv4Address, error) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	eni, ok := ds.eniByIP[ip]
+	if !ok {
+		return PodIPv4Address{}, errors.New(UnknownIPError)
+	}
+	for podKey, info := range ds.podsIP {
+		if info.IP != ip {
+			continue
+		}
+		if addr := eni.IPv4Addresses[ip]; addr.Assigned {
+			decrementAssignedCount(ds, eni, addr)
+		}
+		log.Infof("ReleasePodIP: released IP %s of pod (name %s, namespace %s, sandbox %s)",
+			ip, podKey.name, podKey.namespace, podKey.sandbox)
+		ds.deletePod(podKey)
+		return PodIPv4Address{
+			Name:         podKey.name,
+			Namespace:    podKey.namespace,
+			Sandbox:      podKey.sandbox,
+			IP:           ip,
+			ENI:          eni.ID,
+			DeviceNumber: eni.DeviceNumber,
+			AssignedTime: info.AssignedTime,
+			FromReserve:  info.FromReserve,
+		}, nil
+	}
+	return PodIPv4Address{}, ErrIPNotAssigned
+}
+
+// SetENIDraining stops assigning the IP addresses of an ENI to new pods. RemoveDrainedENIFromStore removes the ENI
+// once its pods are gone.
+func (ds *DataStore) SetENIDraining(eniID string) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	eni, ok := ds.eniIPPools[eniID]
+	if !ok {
+		return errors.New(UnknownENIError)
+	}
+	if eni.IsPrimary {
+		return errors.New(PrimaryENIDrainError)
+	}
+	if !eni.Draining {
+		log.Infof("Draining ENI %s with %d assigned pods", eniID, eni.AssignedIPv4Addresses)
+	}
+	eni.Draining = true
+	return nil
+}
+
+// RemoveDrainedENIFromStore removes a draining ENI that has no pods and no IP address in its cooling period. It
+// returns the ENI that needs to be freed, or an empty string if there is none.
+func (ds *DataStore) RemoveDrainedENIFromStore() string {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	now := ds.now()
+	for _, eni := range ds.eniIPPools {
+		if !eni.Draining || eni.hasPods() || eni.hasIPInCooling(now) {
+			continue
+		}
+		ds.total -= len(eni.IPv4Addresses)
+		log.Infof("RemoveDrainedENIFromStore %s: IP address pool stats: free %d addresses, total: %d, assigned: %d",
+			eni.ID, len(eni.IPv4Addresses), ds.total, ds.assigned)
+		ds.deleteENI(eni)
+		enis.Set(float64(len(ds.eniIPPools)))
+		totalIPs.Set(float64(ds.total))
+		return eni.ID
+	}
+	return ""
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
 )
 
 const (
@@ -31,9 +32,9 @@ const (
 	// its secondary IP addresses is used for a Pod within last addressENICoolingPeriod
 	addressENICoolingPeriod = 1 * time.Minute
 
-	// addressCoolingPeriod is used to ensure an IP not get assigned to a Pod if this IP is used by a different Pod
-	// in addressCoolingPeriod
-	addressCoolingPeriod = 30 * time.Second
+	// AddressCoolingPeriod is used to ensure an IP not get assigned to a Pod if this IP is used by a different Pod
+	// in AddressCoolingPeriod
+	AddressCoolingPeriod = 30 * time.Second
 
 	// DuplicatedENIError is an error when caller tries to add an duplicate ENI to data store
 	DuplicatedENIError = "data store: duplicate ENI"
@@ -52,6 +53,9 @@ const (
 
 	// UnknownENIError is an error when caller tries to access an ENI which is unknown to datastore
 	UnknownENIError = "datastore: unknown ENI"
+
+	// PrimaryENIDrainError is an error when caller tries to drain the primary ENI, which can not be freed
+	PrimaryENIDrainError = "datastore: primary ENI can not be drained"
 )
 
 // ErrUnknownPod is an error when there is no pod in data store matching pod name, namespace, sandbox id
@@ -60,6 +64,15 @@ var ErrUnknownPod = errors.New("datastore: unknown pod")
 // ErrUnknownPodIP is an error where pod's IP address is not found in data store
 var ErrUnknownPodIP = errors.New("datastore: pod using unknown IP address")
 
+// ErrNoAvailableIPs is an error when all the IP addresses in data store are assigned
+var ErrNoAvailableIPs = errors.New("assignPodIPv4AddressUnsafe: no available IP addresses")
+
+// ErrQuotaExceeded is an error when the pod's namespace already has as many IP addresses as its quota allows
+var ErrQuotaExceeded = errors.New("datastore: namespace IP quota exceeded")
+
+// ErrIPNotAssigned is an error when there is no pod in data store using the IP address
+var ErrIPNotAssigned = errors.New("datastore: IP address is not assigned to a pod")
+
 var (
 	enis = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -91,6 +104,53 @@ var (
 			Help: "The number of IPs force removed while they had assigned pods",
 		},
 	)
+	ipReserveSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "awscni_ip_reserve_size",
+			Help: "The number of IP addresses reserved for the pods of the reserved priority classes and namespaces",
+		},
+	)
+	ipReserveAssigned = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "awscni_ip_reserve_assigned",
+			Help: "The number of IP addresses assigned to pods from the IP reserve",
+		},
+	)
+	ipReserveDenied = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "awscni_ip_reserve_denied",
+			Help: "The number of pods that got no IP address because the remaining IP addresses are reserved",
+		},
+	)
+	namespaceAssignedIPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_namespace_assigned_ip_addresses",
+			Help: "The number of IP addresses assigned to the pods of a namespace",
+		},
+		[]string{"namespace"},
+	)
+	namespaceIPQuota = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_namespace_ip_quota",
+			Help: "The maximum number of IP addresses the pods of a namespace may be assigned on the node",
+		},
+		[]string{"namespace"},
+	)
+	namespaceQuotaExceeded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "awscni_namespace_ip_quota_exceeded",
+			Help: "The number of pods that got no IP address because their namespace reached its IP quota",
+		},
+		[]string{"namespace"},
+	)
+	lockWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "awscni_datastore_lock_wait_seconds",
+			Help:    "The time the IP address requests of the pods wait for the lock of the data store",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		},
+		[]string{"op"},
+	)
 	prometheusRegistered = false
 )
 
@@ -108,6 +168,10 @@ type ENIIPPool struct {
 	// IPv4Addresses shows whether each address is assigned, the key is IP address, which must
 	// be in dot-decimal notation with no leading zeros and no whitespace(eg: "10.1.0.253")
 	IPv4Addresses map[string]*AddressInfo
+	// free is the queue of the unassigned addresses, the one released the longest time ago first
+	free freeAddresses
+	// Draining is set when no more pods are assigned IPs of the ENI, so that it is freed once its pods are gone
+	Draining bool `json:",omitempty"`
 }
 
 // AddressInfo contains information about an IP, Exported fields will be marshaled for introspection.
@@ -130,15 +194,58 @@ type PodIPInfo struct {
 	IP string
 	// DeviceNumber is the device number of  pod
 	DeviceNumber int
+	// FromReserve is set when the IP address was assigned from the IP reserve
+	FromReserve bool `json:",omitempty"`
+	// AssignedTime is when the IP address was assigned, or when ipamd restored the pod
+	AssignedTime time.Time
+}
+
+// NamespaceIPUsage is the number of IP addresses assigned to the pods of a namespace
+type NamespaceIPUsage struct {
+	Namespace   string
+	AssignedIPs int
+	// MaxIPs is the namespace's IP quota, 0 if it has none
+	MaxIPs int `json:",omitempty"`
+}
+
+// PodIPv4Address is an IPv4 address assigned to a pod
+type PodIPv4Address struct {
+	Name      string
+	Namespace string
+	Sandbox   string
+	// IP is the IP address of the pod
+	IP string
+	// ENI is the ENI the IP address belongs to
+	ENI          string
+	DeviceNumber int
+	AssignedTime time.Time
+	FromReserve  bool `json:",omitempty"`
+	// UID is the pod's UID, if ipamd found the pod in its informer cache
+	UID string `json:",omitempty"`
 }
 
-// DataStore contains node level ENI/IP
+// DataStore contains node level ENI/IP. The getters only take the read lock, so they don't wait for each other.
 type DataStore struct {
 	total      int
 	assigned   int
 	eniIPPools map[string]*ENIIPPool
-	podsIP     map[PodKey]PodIPInfo
-	lock       sync.RWMutex
+	// eniByIP is the ENI of each IP address in the data store
+	eniByIP map[string]*ENIIPPool
+	podsIP  map[PodKey]PodIPInfo
+	lock    sync.RWMutex
+	// now returns the current time, it is replaced by the tests
+	now func() time.Time
+	// ipReserve is the number of free IP addresses only the pods accepted by canUseIPReserve may be assigned
+	ipReserve         int
+	ipReserveAssigned int
+	canUseIPReserve   func(k8sPod *k8sapi.K8SPodInfo) bool
+	// namespaceQuotas is the maximum number of IP addresses of each namespace that has a quota
+	namespaceQuotas   map[string]int
+	namespaceAssigned map[string]int
+	// placement decides which free IP address a new pod is assigned
+	placement PlacementStrategy
+	// events is the feed of the changes of the data store
+	events *eventFeed
 }
 
 // PodInfos contains pods IP information which uses key name_namespace_sandbox
@@ -161,6 +268,13 @@ func prometheusRegister() {
 		prometheus.MustRegister(assignedIPs)
 		prometheus.MustRegister(forceRemovedENIs)
 		prometheus.MustRegister(forceRemovedIPs)
+		prometheus.MustRegister(ipReserveSize)
+		prometheus.MustRegister(ipReserveAssigned)
+		prometheus.MustRegister(ipReserveDenied)
+		prometheus.MustRegister(namespaceAssignedIPs)
+		prometheus.MustRegister(namespaceIPQuota)
+		prometheus.MustRegister(namespaceQuotaExceeded)
+		prometheus.MustRegister(lockWait)
 		prometheusRegistered = true
 	}
 }
@@ -169,8 +283,14 @@ func prometheusRegister() {
 func NewDataStore() *DataStore {
 	prometheusRegister()
 	return &DataStore{
-		eniIPPools: make(map[string]*ENIIPPool),
-		podsIP:     make(map[PodKey]PodIPInfo),
+		eniIPPools:        make(map[string]*ENIIPPool),
+		eniByIP:           make(map[string]*ENIIPPool),
+		podsIP:            make(map[PodKey]PodIPInfo),
+		now:               time.Now,
+		namespaceQuotas:   make(map[string]int),
+		namespaceAssigned: make(map[string]int),
+		placement:         DefaultPlacementStrategy,
+		events:            newEventFeed(),
 	}
 }
 
@@ -185,13 +305,15 @@ func (ds *DataStore) AddENI(eniID string, deviceNumber int, isPrimary bool) erro
 	if ok {
 		return errors.New(DuplicatedENIError)
 	}
-	ds.eniIPPools[eniID] = &ENIIPPool{
-		createTime:    time.Now(),
+	eni := &ENIIPPool{
+		createTime:    ds.now(),
 		IsPrimary:     isPrimary,
 		ID:            eniID,
 		DeviceNumber:  deviceNumber,
 		IPv4Addresses: make(map[string]*AddressInfo)}
+	ds.eniIPPools[eniID] = eni
 	enis.Set(float64(len(ds.eniIPPools)))
+	ds.publishENIEvent(EventENIAdded, eni, "")
 	return nil
 }
 
@@ -218,7 +340,11 @@ func (ds *DataStore) AddIPv4AddressToStore(eniID string, ipv4 string) error {
 	// Prometheus gauge
 	totalIPs.Set(float64(ds.total))
 
-	curENI.IPv4Addresses[ipv4] = &AddressInfo{Address: ipv4, Assigned: false}
+	addr := &AddressInfo{Address: ipv4, Assigned: false}
+	curENI.IPv4Addresses[ipv4] = addr
+	curENI.free.push(addr)
+	ds.eniByIP[ipv4] = curENI
+	ds.publishENIEvent(EventIPAdded, curENI, ipv4)
 	log.Infof("Added ENI(%s)'s IP %s to datastore", eniID, ipv4)
 	return nil
 }
@@ -249,7 +375,7 @@ func (ds *DataStore) DelIPv4AddressFromStore(eniID string, ipv4 string, force bo
 		decrementAssignedCount(ds, curENI, ipAddr)
 		for key, info := range ds.podsIP {
 			if info.IP == ipv4 {
-				delete(ds.podsIP, key)
+				ds.deletePod(key)
 				break
 			}
 		}
@@ -260,15 +386,25 @@ func (ds *DataStore) DelIPv4AddressFromStore(eniID string, ipv4 string, force bo
 	totalIPs.Set(float64(ds.total))
 
 	delete(curENI.IPv4Addresses, ipv4)
+	curENI.free.remove(ipAddr)
+	delete(ds.eniByIP, ipv4)
+	ds.publishENIEvent(EventIPRemoved, curENI, ipv4)
 
 	log.Infof("Deleted ENI(%s)'s IP %s from datastore", eniID, ipv4)
 	return nil
 }
 
+// lockForPod takes the lock of the data store for the request of a pod, and records how long it waited for it
+func (ds *DataStore) lockForPod(op string) {
+	start := time.Now()
+	ds.lock.Lock()
+	lockWait.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
 // AssignPodIPv4Address assigns an IPv4 address to pod
 // It returns the assigned IPv4 address, device number, error
 func (ds *DataStore) AssignPodIPv4Address(k8sPod *k8sapi.K8SPodInfo) (ip string, deviceNumber int, err error) {
-	ds.lock.Lock()
+	ds.lockForPod("assign")
 	defer ds.lock.Unlock()
 
 	log.Debugf("AssignIPv4Address: IP address pool stats: total: %d, assigned %d", ds.total, ds.assigned)
@@ -294,38 +430,195 @@ func (ds *DataStore) AssignPodIPv4Address(k8sPod *k8sapi.K8SPodInfo) (ip string,
 
 // It returns the assigned IPv4 address, device number, error
 func (ds *DataStore) assignPodIPv4AddressUnsafe(podKey PodKey, k8sPod *k8sapi.K8SPodInfo) (ip string, deviceNumber int, err error) {
-	for _, eni := range ds.eniIPPools {
-		if (k8sPod.IP == "") && (len(eni.IPv4Addresses) == eni.AssignedIPv4Addresses) {
-			// Skip this ENI, since it has no available IP addresses
-			log.Debugf("AssignPodIPv4Address: Skip ENI %s that does not have available addresses", eni.ID)
-			continue
+	if quota, ok := ds.namespaceQuotas[k8sPod.Namespace]; ok && k8sPod.IP == "" && ds.namespaceAssigned[k8sPod.Namespace] >= quota {
+		log.Infof("AssignPodIPv4Address: namespace %s has %d IP addresses, its quota, none for pod %s",
+			k8sPod.Namespace, ds.namespaceAssigned[k8sPod.Namespace], k8sPod.Name)
+		namespaceQuotaExceeded.WithLabelValues(k8sPod.Namespace).Inc()
+		return "", 0, errors.Wrapf(ErrQuotaExceeded, "namespace %s is using all of its %d IP addresses on this node",
+			k8sPod.Namespace, quota)
+	}
+
+	// Only the pods that may use the IP reserve get the last ipReserve free IPs
+	fromReserve := false
+	if ds.ipReserve > 0 && k8sPod.IP == "" && ds.assignableIPs() <= ds.ipReserve {
+		if ds.canUseIPReserve == nil || !ds.canUseIPReserve(k8sPod) {
+			log.Infof("AssignPodIPv4Address: the remaining IP addresses are reserved, none for pod (name %s, namespace %s)",
+				k8sPod.Name, k8sPod.Namespace)
+			ipReserveDenied.Inc()
+			return "", 0, ErrNoAvailableIPs
 		}
-		for _, addr := range eni.IPv4Addresses {
-			if k8sPod.IP == addr.Address {
-				// After L-IPAM restart and built IP warm-pool, it needs to take the existing running pod IP out of the pool.
-				if !addr.Assigned {
-					incrementAssignedCount(ds, eni, addr)
-				}
-				log.Infof("AssignPodIPv4Address: Reassign IP %v to pod (name %s, namespace %s)",
-					addr.Address, k8sPod.Name, k8sPod.Namespace)
-				ds.podsIP[podKey] = PodIPInfo{IP: addr.Address, DeviceNumber: eni.DeviceNumber}
-				return addr.Address, eni.DeviceNumber, nil
-			}
-			if !addr.Assigned && k8sPod.IP == "" && !addr.inCoolingPeriod() {
-				// This is triggered by a pod's Add Network command from CNI plugin
+		fromReserve = true
+	}
+	if k8sPod.IP != "" {
+		if eni, ok := ds.eniByIP[k8sPod.IP]; ok {
+			addr := eni.IPv4Addresses[k8sPod.IP]
+			// After L-IPAM restart and built IP warm-pool, it needs to take the existing running pod IP out of the pool.
+			if !addr.Assigned {
 				incrementAssignedCount(ds, eni, addr)
-				log.Infof("AssignPodIPv4Address: Assign IP %v to pod (name %s, namespace %s sandbox %s)",
-					addr.Address, k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox)
-				ds.podsIP[podKey] = PodIPInfo{IP: addr.Address, DeviceNumber: eni.DeviceNumber}
-				return addr.Address, eni.DeviceNumber, nil
 			}
+			podLogger(k8sPod).With(logger.Fields{ENI: eni.ID, IP: addr.Address}).Infof(
+				"AssignPodIPv4Address: Reassign IP %v to pod", addr.Address)
+			ds.addPod(podKey, PodIPInfo{IP: addr.Address, DeviceNumber: eni.DeviceNumber})
+			return addr.Address, eni.DeviceNumber, nil
 		}
+	} else if eni, addr := ds.selectAddress(); addr != nil {
+		// This is triggered by a pod's Add Network command from CNI plugin
+		incrementAssignedCount(ds, eni, addr)
+		podLogger(k8sPod).With(logger.Fields{ENI: eni.ID, IP: addr.Address}).Infof(
+			"AssignPodIPv4Address: Assign IP %v of ENI %s (%s placement) to pod", addr.Address, eni.ID, ds.placement)
+		ds.addPod(podKey, PodIPInfo{IP: addr.Address, DeviceNumber: eni.DeviceNumber, FromReserve: fromReserve})
+		return addr.Address, eni.DeviceNumber, nil
 	}
 	log.Errorf("DataStore has no available IP addresses")
-	return "", 0, errors.New("assignPodIPv4AddressUnsafe: no available IP addresses")
+	return "", 0, ErrNoAvailableIPs
+}
+
+// assignableIPs returns the number of IP addresses that can be assigned to pods now, which excludes the IPs in their
+// cooling period
+func (ds *DataStore) assignableIPs() int {
+	now := ds.now()
+	assignable := 0
+	for _, eni := range ds.eniIPPools {
+		if !eni.Draining {
+			assignable += eni.free.assignable(now)
+		}
+	}
+	return assignable
+}
+
+// SetIPReserve keeps the last size free IP addresses for the pods canUseIPReserve accepts. canUseIPReserve is called
+// with the data store locked, so it must not call the data store.
+func (ds *DataStore) SetIPReserve(size int, canUseIPReserve func(k8sPod *k8sapi.K8SPodInfo) bool) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.ipReserve = size
+	ds.canUseIPReserve = canUseIPReserve
+	ipReserveSize.Set(float64(size))
+}
+
+// GetIPReserve returns the size of the IP reserve and the number of IP addresses assigned from it
+func (ds *DataStore) GetIPReserve() (size int, assigned int) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+	return ds.ipReserve, ds.ipReserveAssigned
+}
+
+// SetNamespaceQuotas sets the maximum number of IP addresses of the namespaces that have a quota. The pods that
+// already have an IP keep it when a quota is lowered.
+func (ds *DataStore) SetNamespaceQuotas(quotas map[string]int) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	for namespace := range ds.namespaceQuotas {
+		if _, ok := quotas[namespace]; !ok {
+			namespaceIPQuota.DeleteLabelValues(namespace)
+		}
+	}
+	ds.namespaceQuotas = make(map[string]int, len(quotas))
+	for namespace, quota := range quotas {
+		ds.namespaceQuotas[namespace] = quota
+		namespaceIPQuota.WithLabelValues(namespace).Set(float64(quota))
+	}
+}
+
+// GetNamespaceIPUsage returns the IP addresses assigned to each namespace and their quotas, sorted by namespace
+func (ds *DataStore) GetNamespaceIPUsage() []NamespaceIPUsage {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+	usage := make([]NamespaceIPUsage, 0, len(ds.namespaceAssigned))
+	for namespace, assigned := range ds.namespaceAssigned {
+		usage = append(usage, NamespaceIPUsage{Namespace: namespace, AssignedIPs: assigned, MaxIPs: ds.namespaceQuotas[namespace]})
+	}
+	for namespace, quota := range ds.namespaceQuotas {
+		if _, ok := ds.namespaceAssigned[namespace]; !ok {
+			usage = append(usage, NamespaceIPUsage{Namespace: namespace, MaxIPs: quota})
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Namespace < usage[j].Namespace })
+	return usage
+}
+
+// addPod records the IP address of a pod. It must be called with the lock held.
+func (ds *DataStore) addPod(podKey PodKey, info PodIPInfo) {
+	info.AssignedTime = ds.now()
+	ds.podsIP[podKey] = info
+	ds.publishPodEvent(EventPodAssigned, podKey, info)
+	if info.FromReserve {
+		ds.ipReserveAssigned++
+		ipReserveAssigned.Set(float64(ds.ipReserveAssigned))
+	}
+	// The egress IPs are recorded without a namespace
+	if podKey.namespace != "" {
+		ds.namespaceAssigned[podKey.namespace]++
+		namespaceAssignedIPs.WithLabelValues(podKey.namespace).Set(float64(ds.namespaceAssigned[podKey.namespace]))
+	}
+}
+
+// deletePod forgets the IP address of a pod. It must be called with the lock held.
+func (ds *DataStore) deletePod(podKey PodKey) {
+	info, ok := ds.podsIP[podKey]
+	if !ok {
+		return
+	}
+	if info.FromReserve {
+		ds.ipReserveAssigned--
+		ipReserveAssigned.Set(float64(ds.ipReserveAssigned))
+	}
+	if podKey.namespace != "" {
+		ds.namespaceAssigned[podKey.namespace]--
+		if ds.namespaceAssigned[podKey.namespace] <= 0 {
+			delete(ds.namespaceAssigned, podKey.namespace)
+			namespaceAssignedIPs.DeleteLabelValues(podKey.namespace)
+		} else {
+			namespaceAssignedIPs.WithLabelValues(podKey.namespace).Set(float64(ds.namespaceAssigned[podKey.namespace]))
+		}
+	}
+	delete(ds.podsIP, podKey)
+	ds.publishPodEvent(EventPodUnassigned, podKey, info)
+}
+
+// AssignPodIPv4AddressOnENI assigns an IPv4 address of the given ENI to pod. If the pod already has an address of the
+// ENI, that address is returned. If the pod has an IP and that IP is free on the ENI, it is used, otherwise any free
+// IP of the ENI is.
+// It returns the assigned IPv4 address, device number, error
+func (ds *DataStore) AssignPodIPv4AddressOnENI(k8sPod *k8sapi.K8SPodInfo, eniID string) (ip string, deviceNumber int, err error) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	podKey := PodKey{
+		name:      k8sPod.Name,
+		namespace: k8sPod.Namespace,
+		sandbox:   k8sPod.Sandbox,
+	}
+	eni, ok := ds.eniIPPools[eniID]
+	if !ok {
+		return "", 0, errors.New(UnknownENIError)
+	}
+
+	if ipAddr, ok := ds.podsIP[podKey]; ok {
+		if _, ok := eni.IPv4Addresses[ipAddr.IP]; ok {
+			return ipAddr.IP, ipAddr.DeviceNumber, nil
+		}
+		return "", 0, errors.Errorf("AssignPodIPv4AddressOnENI: pod (name %s, namespace %s, sandbox %s) already has IP %s of another ENI",
+			k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox, ipAddr.IP)
+	}
+
+	addr, ok := eni.IPv4Addresses[k8sPod.IP]
+	if !ok || addr.Assigned {
+		addr = eni.free.head(ds.now())
+	}
+	if addr == nil {
+		return "", 0, errors.Errorf("AssignPodIPv4AddressOnENI: no available IP addresses on ENI %s", eniID)
+	}
+
+	incrementAssignedCount(ds, eni, addr)
+	log.Infof("AssignPodIPv4AddressOnENI: Assign IP %v of ENI %s to pod (name %s, namespace %s sandbox %s)",
+		addr.Address, eniID, k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox)
+	ds.addPod(podKey, PodIPInfo{IP: addr.Address, DeviceNumber: eni.DeviceNumber})
+	return addr.Address, eni.DeviceNumber, nil
 }
 
 func incrementAssignedCount(ds *DataStore, eni *ENIIPPool, addr *AddressInfo) {
+	eni.free.remove(addr)
 	ds.assigned++
 	eni.AssignedIPv4Addresses++
 	addr.Assigned = true
@@ -337,16 +630,26 @@ func decrementAssignedCount(ds *DataStore, eni *ENIIPPool, addr *AddressInfo) {
 	ds.assigned--
 	eni.AssignedIPv4Addresses--
 	addr.Assigned = false
-	curTime := time.Now()
+	curTime := ds.now()
 	eni.lastUnassignedTime = curTime
 	addr.UnassignedTime = curTime
+	eni.free.push(addr)
 	// Prometheus gauge
 	assignedIPs.Set(float64(ds.assigned))
 }
 
 // GetStats returns total number of IP addresses and number of assigned IP addresses
+// The free IP addresses of the draining ENIs are left out of the total, so that the pool replaces them.
 func (ds *DataStore) GetStats() (int, int) {
-	return ds.total, ds.assigned
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+	total := ds.total
+	for _, eni := range ds.eniIPPools {
+		if eni.Draining {
+			total -= len(eni.IPv4Addresses) - eni.AssignedIPv4Addresses
+		}
+	}
+	return total, ds.assigned
 }
 
 // IsRequiredForWarmIPTarget determines if this ENI has warm IPs that are required to fulfill whatever WARM_IP_TARGET is
@@ -374,18 +677,19 @@ func (ds *DataStore) isRequiredForMinimumIPTarget(minimumIPTarget int, eni *ENII
 }
 
 func (ds *DataStore) getDeletableENI(warmIPTarget int, minimumIPTarget int) *ENIIPPool {
+	now := ds.now()
 	for _, eni := range ds.eniIPPools {
 		if eni.IsPrimary {
 			log.Debugf("ENI %s cannot be deleted because it is primary", eni.ID)
 			continue
 		}
 
-		if eni.isTooYoung() {
+		if eni.isTooYoung(now) {
 			log.Debugf("ENI %s cannot be deleted because it is too young", eni.ID)
 			continue
 		}
 
-		if eni.hasIPInCooling() {
+		if eni.hasIPInCooling(now) {
 			log.Debugf("ENI %s cannot be deleted because has IPs in cooling", eni.ID)
 			continue
 		}
@@ -412,13 +716,13 @@ func (ds *DataStore) getDeletableENI(warmIPTarget int, minimumIPTarget int) *ENI
 }
 
 // IsTooYoung returns true if the ENI hasn't been around long enough to be deleted.
-func (e *ENIIPPool) isTooYoung() bool {
-	return time.Since(e.createTime) < minLifeTime
+func (e *ENIIPPool) isTooYoung(now time.Time) bool {
+	return now.Sub(e.createTime) < minLifeTime
 }
 
 // HasIPInCooling returns true if an IP address was unassigned recently.
-func (e *ENIIPPool) hasIPInCooling() bool {
-	return time.Since(e.lastUnassignedTime) < addressENICoolingPeriod
+func (e *ENIIPPool) hasIPInCooling(now time.Time) bool {
+	return now.Sub(e.lastUnassignedTime) < addressENICoolingPeriod
 }
 
 // HasPods returns true if the ENI has pods assigned to it.
@@ -428,6 +732,9 @@ func (e *ENIIPPool) hasPods() bool {
 
 // GetENINeedsIP finds an ENI in the datastore that needs more IP addresses allocated
 func (ds *DataStore) GetENINeedsIP(maxIPperENI int, skipPrimary bool) *ENIIPPool {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
 	// NOTE(jaypipes): Some tests rely on key order so we iterate over the IP
 	// pool structs here in sorted key order.
 	// TODO(jaypipes): Don't use a map as the primary iterator vehicle.
@@ -438,6 +745,9 @@ func (ds *DataStore) GetENINeedsIP(maxIPperENI int, skipPrimary bool) *ENIIPPool
 			log.Debugf("Skip the primary ENI for need IP check")
 			continue
 		}
+		if eni.Draining {
+			continue
+		}
 		eniIDs = append(eniIDs, eniID)
 	}
 	sort.Strings(eniIDs)
@@ -469,7 +779,7 @@ func (ds *DataStore) RemoveUnusedENIFromStore(warmIPTarget int, minimumIPTarget
 	ds.total -= eniIPCount
 	log.Infof("RemoveUnusedENIFromStore %s: IP address pool stats: free %d addresses, total: %d, assigned: %d",
 		removableENI, eniIPCount, ds.total, ds.assigned)
-	delete(ds.eniIPPools, removableENI)
+	ds.deleteENI(deletableENI)
 
 	// Prometheus update
 	enis.Set(float64(len(ds.eniIPPools)))
@@ -504,7 +814,7 @@ func (ds *DataStore) RemoveENIFromDataStore(eni string, force bool) error {
 		}
 		for key, info := range ds.podsIP {
 			if info.DeviceNumber == eniIPPool.DeviceNumber {
-				delete(ds.podsIP, key)
+				ds.deletePod(key)
 			}
 		}
 	}
@@ -512,17 +822,37 @@ func (ds *DataStore) RemoveENIFromDataStore(eni string, force bool) error {
 	ds.total -= len(eniIPPool.IPv4Addresses)
 	log.Infof("RemoveENIFromDataStore %s: IP address pool stats: free %d addresses, total: %d, assigned: %d",
 		eni, len(eniIPPool.IPv4Addresses), ds.total, ds.assigned)
-	delete(ds.eniIPPools, eni)
+	ds.deleteENI(eniIPPool)
 
 	// Prometheus gauge
 	enis.Set(float64(len(ds.eniIPPools)))
 	return nil
 }
 
+// deleteENI removes an ENI and its IP addresses from the indexes. It must be called with the lock held.
+func (ds *DataStore) deleteENI(eni *ENIIPPool) {
+	ips := make([]string, 0, len(eni.IPv4Addresses))
+	for ip := range eni.IPv4Addresses {
+		delete(ds.eniByIP, ip)
+		ips = append(ips, ip)
+	}
+	delete(ds.eniIPPools, eni.ID)
+	sort.Strings(ips)
+	for _, ip := range ips {
+		ds.publishENIEvent(EventIPRemoved, eni, ip)
+	}
+	ds.publishENIEvent(EventENIRemoved, eni, "")
+}
+
+// podLogger returns the logger of the requests of the pod
+func podLogger(k8sPod *k8sapi.K8SPodInfo) logger.Entry {
+	return logger.With(logger.Fields{Pod: k8sPod.Name, Namespace: k8sPod.Namespace, Sandbox: k8sPod.Sandbox})
+}
+
 // UnassignPodIPv4Address a) find out the IP address based on PodName and PodNameSpace
 // b)  mark IP address as unassigned c) returns IP address, ENI's device number, error
 func (ds *DataStore) UnassignPodIPv4Address(k8sPod *k8sapi.K8SPodInfo) (ip string, deviceNumber int, err error) {
-	ds.lock.Lock()
+	ds.lockForPod("unassign")
 	defer ds.lock.Unlock()
 	log.Debugf("UnassignPodIPv4Address: IP address pool stats: total:%d, assigned %d, pod(Name: %s, Namespace: %s, Sandbox %s)",
 		ds.total, ds.assigned, k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox)
@@ -539,13 +869,13 @@ func (ds *DataStore) UnassignPodIPv4Address(k8sPod *k8sapi.K8SPodInfo) (ip strin
 		return "", 0, ErrUnknownPod
 	}
 
-	for _, eni := range ds.eniIPPools {
-		ip, ok := eni.IPv4Addresses[ipAddr.IP]
-		if ok && ip.Assigned {
+	if eni, ok := ds.eniByIP[ipAddr.IP]; ok {
+		ip := eni.IPv4Addresses[ipAddr.IP]
+		if ip.Assigned {
 			decrementAssignedCount(ds, eni, ip)
-			log.Infof("UnassignPodIPv4Address: pod (Name: %s, NameSpace %s Sandbox %s)'s ipAddr %s, DeviceNumber%d",
-				k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox, ip.Address, eni.DeviceNumber)
-			delete(ds.podsIP, podKey)
+			podLogger(k8sPod).With(logger.Fields{ENI: eni.ID, IP: ip.Address}).Infof(
+				"UnassignPodIPv4Address: Unassign IP %s of ENI %s, DeviceNumber %d", ip.Address, eni.ID, eni.DeviceNumber)
+			ds.deletePod(podKey)
 			return ip.Address, eni.DeviceNumber, nil
 		}
 	}
@@ -557,8 +887,8 @@ func (ds *DataStore) UnassignPodIPv4Address(k8sPod *k8sapi.K8SPodInfo) (ip strin
 
 // GetPodInfos provides pod IP information to introspection endpoint
 func (ds *DataStore) GetPodInfos() *map[string]PodIPInfo {
-	ds.lock.Lock()
-	defer ds.lock.Unlock()
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
 
 	var podInfos = make(map[string]PodIPInfo, len(ds.podsIP))
 
@@ -572,10 +902,34 @@ func (ds *DataStore) GetPodInfos() *map[string]PodIPInfo {
 	return &podInfos
 }
 
+// GetPodIPv4Addresses returns the IPv4 addresses assigned to pods and their ENIs
+func (ds *DataStore) GetPodIPv4Addresses() []PodIPv4Address {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	addrs := make([]PodIPv4Address, 0, len(ds.podsIP))
+	for podKey, podInfo := range ds.podsIP {
+		addr := PodIPv4Address{
+			Name:         podKey.name,
+			Namespace:    podKey.namespace,
+			Sandbox:      podKey.sandbox,
+			IP:           podInfo.IP,
+			DeviceNumber: podInfo.DeviceNumber,
+			AssignedTime: podInfo.AssignedTime,
+			FromReserve:  podInfo.FromReserve,
+		}
+		if eni, ok := ds.eniByIP[podInfo.IP]; ok {
+			addr.ENI = eni.ID
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
 // GetENIInfos provides ENI IP information to introspection endpoint
 func (ds *DataStore) GetENIInfos() *ENIInfos {
-	ds.lock.Lock()
-	defer ds.lock.Unlock()
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
 
 	var eniInfos = ENIInfos{
 		TotalIPs:    ds.total,
@@ -591,15 +945,29 @@ func (ds *DataStore) GetENIInfos() *ENIInfos {
 
 // GetENIs provides the number of ENI in the datastore
 func (ds *DataStore) GetENIs() int {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+	return len(ds.eniIPPools)
+}
+
+// WithUnusedIPv4Address runs fn with the data store locked, unless the IP address is assigned to a pod or was released
+// after since, so that no pod is assigned the address while fn runs. It returns whether fn ran.
+func (ds *DataStore) WithUnusedIPv4Address(ipv4 string, since time.Time, fn func() error) (bool, error) {
 	ds.lock.Lock()
 	defer ds.lock.Unlock()
-	return len(ds.eniIPPools)
+
+	for _, eni := range ds.eniIPPools {
+		if addr, ok := eni.IPv4Addresses[ipv4]; ok && (addr.Assigned || addr.UnassignedTime.After(since)) {
+			return false, nil
+		}
+	}
+	return true, fn()
 }
 
 // GetENIIPPools returns eni's IP address list
 func (ds *DataStore) GetENIIPPools(eni string) (map[string]*AddressInfo, error) {
-	ds.lock.Lock()
-	defer ds.lock.Unlock()
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
 
 	eniIPPool, ok := ds.eniIPPools[eni]
 	if !ok {
@@ -613,7 +981,7 @@ func (ds *DataStore) GetENIIPPools(eni string) (map[string]*AddressInfo, error)
 	return ipPool, nil
 }
 
-// InCoolingPeriod checks whether an addr is in addressCoolingPeriod
-func (addr AddressInfo) inCoolingPeriod() bool {
-	return time.Since(addr.UnassignedTime) <= addressCoolingPeriod
+// InCoolingPeriod checks whether an addr is in AddressCoolingPeriod
+func (addr AddressInfo) inCoolingPeriod(now time.Time) bool {
+	return now.Sub(addr.UnassignedTime) <= AddressCoolingPeriod
 }
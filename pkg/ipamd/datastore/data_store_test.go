@@ -14,10 +14,14 @@
 package datastore
 
 import (
+	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -346,6 +350,138 @@ func TestPodIPv4Address(t *testing.T) {
 	assert.Equal(t, ds.assigned, 2)
 }
 
+func TestPodIPv4AddressOnENI(t *testing.T) {
+	ds := NewDataStore()
+
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddENI("eni-2", 2, false)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+	_ = ds.AddIPv4AddressToStore("eni-2", "1.1.2.1")
+
+	// Unknown ENI
+	_, _, err := ds.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "egress-1"}, "eni-3")
+	assert.Error(t, err)
+
+	// The preferred IP is used when it is free
+	ip, deviceNumber, err := ds.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "egress-1", IP: "1.1.1.2"}, "eni-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.1.1.2", ip)
+	assert.Equal(t, 1, deviceNumber)
+	assert.Equal(t, 1, ds.eniIPPools["eni-1"].AssignedIPv4Addresses)
+
+	// Assigning again returns the same IP
+	ip, _, err = ds.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "egress-1"}, "eni-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.1.1.2", ip)
+	assert.Equal(t, 1, ds.assigned)
+
+	// The preferred IP is taken, so the other IP of the ENI is used
+	ip, _, err = ds.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "egress-2", IP: "1.1.1.2"}, "eni-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.1.1.1", ip)
+
+	// No IP left on eni-1, even though eni-2 has one
+	_, _, err = ds.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "egress-3"}, "eni-1")
+	assert.Error(t, err)
+
+	// The pod's IP belongs to another ENI
+	_, _, err = ds.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "egress-1"}, "eni-2")
+	assert.Error(t, err)
+
+	_, _, err = ds.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "egress-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ds.assigned)
+}
+
+func TestIPReserve(t *testing.T) {
+	ds := NewDataStore()
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.3")
+	ds.SetIPReserve(2, func(k8sPod *k8sapi.K8SPodInfo) bool {
+		return k8sPod.Namespace == "kube-system"
+	})
+
+	// A restored pod keeps its IP, whatever the reserve
+	_, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "app-1", Namespace: "default", IP: "1.1.1.1"})
+	assert.NoError(t, err)
+
+	// Only the reserved pods get the last IPs
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "app-2", Namespace: "default"})
+	assert.Equal(t, ErrNoAvailableIPs, err)
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "dns", Namespace: "kube-system"})
+	assert.NoError(t, err)
+	assert.False(t, ds.podsIP[PodKey{name: "app-1", namespace: "default"}].FromReserve)
+	assert.True(t, ds.podsIP[PodKey{name: "dns", namespace: "kube-system"}].FromReserve)
+	size, assigned := ds.GetIPReserve()
+	assert.Equal(t, 2, size)
+	assert.Equal(t, 1, assigned)
+
+	_, _, err = ds.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "dns", Namespace: "kube-system"})
+	assert.NoError(t, err)
+	_, assigned = ds.GetIPReserve()
+	assert.Equal(t, 0, assigned)
+}
+
+func TestGetPodIPv4Addresses(t *testing.T) {
+	ds := NewDataStore()
+	clock := &testClock{now: time.Now()}
+	ds.now = clock.Now
+
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddENI("eni-2", 2, false)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-2", "1.1.2.1")
+
+	assert.Empty(t, ds.GetPodIPv4Addresses())
+
+	_, _, err := ds.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1"}, "eni-2")
+	assert.NoError(t, err)
+	assert.Equal(t, []PodIPv4Address{
+		{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1", IP: "1.1.2.1", ENI: "eni-2", DeviceNumber: 2, AssignedTime: clock.now},
+	}, ds.GetPodIPv4Addresses())
+}
+
+func TestGetENIRecords(t *testing.T) {
+	ds := NewDataStore()
+	clock := &testClock{now: time.Now()}
+	ds.now = clock.Now
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddENI("eni-2", 2, false)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+	_ = ds.AddIPv4AddressToStore("eni-2", "1.1.2.1")
+	_, _, _ = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1"})
+	_, _, _ = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-2"})
+	_, _, _ = ds.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-2"})
+
+	records := ds.GetENIRecords(AddressFilter{})
+	assert.Equal(t, 3, records.TotalIPs)
+	assert.Equal(t, 1, records.AssignedIPs)
+	assert.Len(t, records.ENIIPPools, 2)
+	assert.Equal(t, AddressRecord{Address: "1.1.1.1", Assigned: true, PodName: "pod-1", PodNamespace: "ns-1", AssignedTime: clock.now},
+		records.ENIIPPools["eni-1"].IPv4Addresses["1.1.1.1"])
+	assert.Equal(t, AddressRecord{Address: "1.1.1.2", UnassignedTime: clock.now, InCoolingPeriod: true},
+		records.ENIIPPools["eni-1"].IPv4Addresses["1.1.1.2"])
+
+	// An ENI without a selected address is left out
+	records = ds.GetENIRecords(AddressFilter{Namespace: "ns-1"})
+	assert.Len(t, records.ENIIPPools, 1)
+	assert.Len(t, records.ENIIPPools["eni-1"].IPv4Addresses, 1)
+
+	free := false
+	records = ds.GetENIRecords(AddressFilter{Assigned: &free})
+	assert.Len(t, records.ENIIPPools["eni-1"].IPv4Addresses, 1)
+	assert.Len(t, records.ENIIPPools["eni-2"].IPv4Addresses, 1)
+
+	records = ds.GetENIRecords(AddressFilter{ENI: "eni-2"})
+	assert.Len(t, records.ENIIPPools, 1)
+	records = ds.GetENIRecords(AddressFilter{IP: "10.0.0.1"})
+	assert.Empty(t, records.ENIIPPools)
+}
+
 func TestWarmENIInteractions(t *testing.T) {
 	ds := NewDataStore()
 
@@ -401,3 +537,364 @@ func TestWarmENIInteractions(t *testing.T) {
 
 	assert.NotEqual(t, removedEni, secondRemovedEni, "The two removed ENIs should not be the same ENI.")
 }
+
+func TestNamespaceQuotas(t *testing.T) {
+	ds := NewDataStore()
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.3")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.4")
+	ds.SetNamespaceQuotas(map[string]int{"team-a": 1, "team-b": 2})
+
+	_, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "a-1", Namespace: "team-a"})
+	assert.NoError(t, err)
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "a-2", Namespace: "team-a"})
+	assert.Equal(t, ErrQuotaExceeded, errors.Cause(err))
+	assert.EqualError(t, err, "namespace team-a is using all of its 1 IP addresses on this node: "+ErrQuotaExceeded.Error())
+
+	// Namespaces without a quota are not limited
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "c-1", Namespace: "team-c"})
+	assert.NoError(t, err)
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "c-2", Namespace: "team-c"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []NamespaceIPUsage{
+		{Namespace: "team-a", AssignedIPs: 1, MaxIPs: 1},
+		{Namespace: "team-b", MaxIPs: 2},
+		{Namespace: "team-c", AssignedIPs: 2},
+	}, ds.GetNamespaceIPUsage())
+
+	// Releasing an IP makes room in the quota
+	_, _, err = ds.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "a-1", Namespace: "team-a"})
+	assert.NoError(t, err)
+	_, _, err = ds.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "c-1", Namespace: "team-c"})
+	assert.NoError(t, err)
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "a-2", Namespace: "team-a"})
+	assert.NoError(t, err)
+
+	ds.SetNamespaceQuotas(nil)
+	assert.Equal(t, []NamespaceIPUsage{
+		{Namespace: "team-a", AssignedIPs: 1},
+		{Namespace: "team-c", AssignedIPs: 1},
+	}, ds.GetNamespaceIPUsage())
+}
+
+// testClock is the time of a data store in the tests
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// pastCooling moves the clock past the cooling period of the addresses released so far
+func (c *testClock) pastCooling() {
+	c.now = c.now.Add(AddressCoolingPeriod + time.Second)
+}
+
+// newPlacementDataStore returns a data store with 3 ENIs of 4 IPs each
+func newPlacementDataStore(strategy PlacementStrategy) (*DataStore, *testClock) {
+	ds := NewDataStore()
+	clock := &testClock{now: time.Now()}
+	ds.now = clock.Now
+	ds.SetPlacementStrategy(strategy)
+	for device := 1; device <= 3; device++ {
+		eni := fmt.Sprintf("eni-%d", device)
+		_ = ds.AddENI(eni, device, device == 1)
+		for i := 1; i <= 4; i++ {
+			_ = ds.AddIPv4AddressToStore(eni, fmt.Sprintf("1.1.%d.%d", device, i))
+		}
+	}
+	return ds, clock
+}
+
+// assignPods assigns IPs to the pods first to last-1, and returns the number of them on each device
+func assignPods(t *testing.T, ds *DataStore, first, last int) map[int]int {
+	pods := make(map[int]int)
+	for i := first; i < last; i++ {
+		_, device, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: fmt.Sprintf("pod-%d", i), Namespace: "default"})
+		assert.NoError(t, err)
+		pods[device]++
+	}
+	return pods
+}
+
+func unassignPod(t *testing.T, ds *DataStore, i int) string {
+	ip, _, err := ds.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{Name: fmt.Sprintf("pod-%d", i), Namespace: "default"})
+	assert.NoError(t, err)
+	return ip
+}
+
+func TestPlacementPack(t *testing.T) {
+	ds, clock := newPlacementDataStore(PlacementPack)
+	assert.Equal(t, map[int]int{1: 4, 2: 2}, assignPods(t, ds, 0, 6))
+
+	// The ENI with the most pods is filled up first, even if it isn't the first one
+	unassignPod(t, ds, 0)
+	unassignPod(t, ds, 1)
+	unassignPod(t, ds, 2)
+	clock.pastCooling()
+	assert.Equal(t, map[int]int{2: 2}, assignPods(t, ds, 6, 8))
+	assert.Equal(t, 0, ds.eniIPPools["eni-3"].AssignedIPv4Addresses)
+}
+
+func TestPlacementSpread(t *testing.T) {
+	ds, clock := newPlacementDataStore(PlacementSpread)
+	assert.Equal(t, map[int]int{1: 2, 2: 2, 3: 2}, assignPods(t, ds, 0, 6))
+
+	// The ENI with the fewest pods is used first
+	unassignPod(t, ds, 0)
+	unassignPod(t, ds, 3)
+	unassignPod(t, ds, 4)
+	clock.pastCooling()
+	assert.Equal(t, map[int]int{1: 2, 2: 1}, assignPods(t, ds, 6, 9))
+	assert.Equal(t, map[int]int{1: 2, 2: 1, 3: 1}, assignPods(t, ds, 9, 13))
+}
+
+func TestPlacementLRU(t *testing.T) {
+	ds, clock := newPlacementDataStore(PlacementLRU)
+	assignPods(t, ds, 0, 12)
+	var released []string
+	for _, i := range []int{9, 7, 2} {
+		released = append(released, unassignPod(t, ds, i))
+		clock.now = clock.now.Add(time.Second)
+	}
+	clock.pastCooling()
+	released = append(released, unassignPod(t, ds, 0))
+	clock.pastCooling()
+
+	// The IPs are used in the order they were released, whatever their ENI
+	var ips []string
+	for i := 12; i < 16; i++ {
+		ip, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: fmt.Sprintf("pod-%d", i), Namespace: "default"})
+		assert.NoError(t, err)
+		ips = append(ips, ip)
+	}
+	assert.Equal(t, released, ips)
+}
+
+func TestPlacementLRUNeverUsedFirst(t *testing.T) {
+	ds, clock := newPlacementDataStore(PlacementLRU)
+	assignPods(t, ds, 0, 1)
+	unassignPod(t, ds, 0)
+	clock.pastCooling()
+
+	// The IPs that were never assigned go first, by ENI
+	assert.Equal(t, map[int]int{1: 3, 2: 4, 3: 4}, assignPods(t, ds, 1, 12))
+	ip, device, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-12", Namespace: "default"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.1.1.1", ip)
+	assert.Equal(t, 1, device)
+}
+
+func TestPlacementSkipsCoolingIPs(t *testing.T) {
+	for _, strategy := range PlacementStrategies {
+		ds, clock := newPlacementDataStore(strategy)
+		assignPods(t, ds, 0, 12)
+		unassignPod(t, ds, 5)
+		_, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-12", Namespace: "default"})
+		assert.Equal(t, ErrNoAvailableIPs, err, string(strategy))
+
+		clock.pastCooling()
+		assert.Equal(t, 1, len(assignPods(t, ds, 12, 13)), string(strategy))
+	}
+}
+
+// newBenchmarkDataStore returns a data store of 100 ENIs with 60 IPs each, and the given number of pods. Its clock
+// moves a second forward every time it is read, so that the released IPs soon leave their cooling period. Logging is
+// turned off, so that the benchmarks measure the data store rather than the log output, until the returned function
+// restores the logger.
+func newBenchmarkDataStore(b *testing.B, pods int) (*DataStore, func()) {
+	logger := log.Current
+	_ = log.ReplaceLogger(log.Disabled)
+	restoreLogger := func() { _ = log.ReplaceLogger(logger) }
+	ds := NewDataStore()
+	start := time.Now()
+	var ticks int64
+	ds.now = func() time.Time {
+		return start.Add(time.Duration(atomic.AddInt64(&ticks, 1)) * time.Second)
+	}
+	for device := 0; device < 100; device++ {
+		eni := fmt.Sprintf("eni-%d", device)
+		_ = ds.AddENI(eni, device, device == 0)
+		for i := 0; i < 60; i++ {
+			_ = ds.AddIPv4AddressToStore(eni, fmt.Sprintf("10.%d.%d.%d", device/256, device%256, i))
+		}
+	}
+	for i := 0; i < pods; i++ {
+		if _, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: fmt.Sprintf("pod-%d", i), Namespace: "default"}); err != nil {
+			restoreLogger()
+			b.Fatal(err)
+		}
+	}
+	return ds, restoreLogger
+}
+
+func BenchmarkAssignUnassign(b *testing.B) {
+	for _, pods := range []int{1000, 5000} {
+		b.Run(fmt.Sprintf("%d pods", pods), func(b *testing.B) {
+			ds, restoreLogger := newBenchmarkDataStore(b, pods)
+			defer restoreLogger()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pod := &k8sapi.K8SPodInfo{Name: fmt.Sprintf("bench-%d", i), Namespace: "default"}
+				if _, _, err := ds.AssignPodIPv4Address(pod); err != nil {
+					b.Fatal(err)
+				}
+				if _, _, err := ds.UnassignPodIPv4Address(pod); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAssignUnassignParallel(b *testing.B) {
+	for _, pods := range []int{1000, 5000} {
+		b.Run(fmt.Sprintf("%d pods", pods), func(b *testing.B) {
+			ds, restoreLogger := newBenchmarkDataStore(b, pods)
+			defer restoreLogger()
+			var next int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					pod := &k8sapi.K8SPodInfo{Name: fmt.Sprintf("bench-%d", atomic.AddInt64(&next, 1)), Namespace: "default"}
+					if _, _, err := ds.AssignPodIPv4Address(pod); err != nil {
+						b.Error(err)
+						return
+					}
+					if _, _, err := ds.UnassignPodIPv4Address(pod); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkGetStatsWithAssign reads the stats while pods are added and deleted, like the pool manager and the
+// introspection endpoints do
+func BenchmarkGetStatsWithAssign(b *testing.B) {
+	ds, restoreLogger := newBenchmarkDataStore(b, 5000)
+	defer restoreLogger()
+	var next int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&next, 1)
+			if i%10 != 0 {
+				ds.GetStats()
+				continue
+			}
+			pod := &k8sapi.K8SPodInfo{Name: fmt.Sprintf("bench-%d", i), Namespace: "default"}
+			if _, _, err := ds.AssignPodIPv4Address(pod); err != nil {
+				b.Error(err)
+				return
+			}
+			if _, _, err := ds.UnassignPodIPv4Address(pod); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestReleasePodIP(t *testing.T) {
+	ds := NewDataStore()
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+	ip, _, _ := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "s1"})
+
+	pod, err := ds.ReleasePodIP(ip)
+	assert.NoError(t, err)
+	assert.Equal(t, "pod-1", pod.Name)
+	assert.Equal(t, "eni-1", pod.ENI)
+	_, assigned := ds.GetStats()
+	assert.Equal(t, 0, assigned)
+	assert.Empty(t, ds.GetPodIPv4Addresses())
+
+	_, err = ds.ReleasePodIP(ip)
+	assert.Equal(t, ErrIPNotAssigned, err)
+	_, err = ds.ReleasePodIP("10.0.0.1")
+	assert.EqualError(t, err, UnknownIPError)
+}
+
+func TestWithUnusedIPv4Address(t *testing.T) {
+	ds := NewDataStore()
+	clock := &testClock{now: time.Now()}
+	ds.now = clock.Now
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+	ip, _, _ := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "s1"})
+	calls := 0
+	fn := func() error {
+		calls++
+		return nil
+	}
+
+	// An assigned address is left alone
+	ran, err := ds.WithUnusedIPv4Address(ip, clock.now, fn)
+	assert.NoError(t, err)
+	assert.False(t, ran)
+
+	// So is an address released after since
+	since := clock.now
+	clock.now = clock.now.Add(time.Second)
+	_, _ = ds.ReleasePodIP(ip)
+	ran, _ = ds.WithUnusedIPv4Address(ip, since, fn)
+	assert.False(t, ran)
+	assert.Equal(t, 0, calls)
+
+	ran, err = ds.WithUnusedIPv4Address(ip, clock.now, fn)
+	assert.NoError(t, err)
+	assert.True(t, ran)
+	// Addresses that are not in the data store are unused
+	ran, _ = ds.WithUnusedIPv4Address("10.0.0.1", clock.now, fn)
+	assert.True(t, ran)
+	assert.Equal(t, 2, calls)
+
+	_, err = ds.WithUnusedIPv4Address("10.0.0.1", clock.now, func() error { return errors.New("failed") })
+	assert.EqualError(t, err, "failed")
+}
+
+func TestDrainENI(t *testing.T) {
+	ds := NewDataStore()
+	clock := &testClock{now: time.Now()}
+	ds.now = clock.Now
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddENI("eni-2", 2, false)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-2", "1.1.2.1")
+	_ = ds.AddIPv4AddressToStore("eni-2", "1.1.2.2")
+	_, _, _ = ds.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1"}, "eni-2")
+
+	assert.EqualError(t, ds.SetENIDraining("eni-1"), PrimaryENIDrainError)
+	assert.EqualError(t, ds.SetENIDraining("eni-3"), UnknownENIError)
+	assert.NoError(t, ds.SetENIDraining("eni-2"))
+
+	// The free IPs of a draining ENI are not counted or assigned
+	total, assigned := ds.GetStats()
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 1, assigned)
+	assert.Nil(t, ds.GetENINeedsIP(4, true))
+	ip, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.1.1.1", ip)
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-3", Namespace: "ns-1"})
+	assert.Equal(t, ErrNoAvailableIPs, err)
+
+	// The ENI is removed once its pods are gone and its IPs are out of their cooling period
+	assert.Equal(t, "", ds.RemoveDrainedENIFromStore())
+	_, _, _ = ds.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1"})
+	assert.Equal(t, "", ds.RemoveDrainedENIFromStore())
+	clock.now = clock.now.Add(addressENICoolingPeriod)
+	assert.Equal(t, "eni-2", ds.RemoveDrainedENIFromStore())
+	assert.Equal(t, 1, ds.GetENIs())
+	total, _ = ds.GetStats()
+	assert.Equal(t, 1, total)
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datastore
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// eventBufferSize is the number of recent events kept for the subscribers that resume
+	eventBufferSize = 4096
+
+	// subscriberBufferSize is the number of events a subscriber may fall behind before it is dropped
+	subscriberBufferSize = 1024
+)
+
+// EventType is the kind of change of an Event
+type EventType string
+
+const (
+	EventENIAdded      EventType = "ENIAdded"
+	EventENIRemoved    EventType = "ENIRemoved"
+	EventIPAdded       EventType = "IPAdded"
+	EventIPRemoved     EventType = "IPRemoved"
+	EventPodAssigned   EventType = "PodAssigned"
+	EventPodUnassigned EventType = "PodUnassigned"
+	// EventPoolAction is published by ipamd when it allocates or frees IPs and ENIs
+	EventPoolAction EventType = "PoolAction"
+)
+
+// ErrEventsLost is returned when resuming from a sequence number whose next events are no longer kept. The subscriber
+// has to read the state of the data store again.
+var ErrEventsLost = errors.New("datastore: the events after this sequence number are no longer kept")
+
+// Event is a change of the data store, or an action of the pool manager
+type Event struct {
+	// Sequence numbers the events from 1, in the order of the changes. They start again when ipamd restarts, with a
+	// new epoch.
+	Sequence     uint64
+	Time         time.Time
+	Type         EventType
+	ENI          string `json:",omitempty"`
+	DeviceNumber int    `json:",omitempty"`
+	IP           string `json:",omitempty"`
+	PodName      string `json:",omitempty"`
+	PodNamespace string `json:",omitempty"`
+	Sandbox      string `json:",omitempty"`
+	// Action is the pool action of a PoolAction event, like AllocENI or FreeIPs
+	Action  string `json:",omitempty"`
+	Message string `json:",omitempty"`
+	Error   string `json:",omitempty"`
+}
+
+// EventSubscription receives the events published after it subscribed
+type EventSubscription struct {
+	// Events is closed when the subscriber fell behind by more than subscriberBufferSize events. It can resume from the
+	// last event it received.
+	Events <-chan Event
+	events chan Event
+	feed   *eventFeed
+}
+
+// Close stops the subscription
+func (s *EventSubscription) Close() {
+	s.feed.unsubscribe(s)
+}
+
+// eventFeed numbers the events, keeps the last eventBufferSize of them and sends them to the subscribers
+type eventFeed struct {
+	lock        sync.Mutex
+	epoch       string
+	last        uint64
+	events      []Event
+	subscribers map[*EventSubscription]struct{}
+}
+
+func newEventFeed() *eventFeed {
+	return &eventFeed{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 10),
+		subscribers: make(map[*EventSubscription]struct{}),
+	}
+}
+
+func (f *eventFeed) publish(event Event) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.last++
+	event.Sequence = f.last
+	f.events = append(f.events, event)
+	if len(f.events) > eventBufferSize {
+		f.events = f.events[len(f.events)-eventBufferSize:]
+	}
+	for sub := range f.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			log.Warnf("Dropping an event subscriber that is more than %d events behind", subscriberBufferSize)
+			delete(f.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// subscribe returns the kept events after the since sequence number, and subscribes to the next ones
+func (f *eventFeed) subscribe(since uint64) ([]Event, *EventSubscription, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if since > f.last {
+		return nil, nil, errors.Errorf("datastore: unknown event sequence number %d, the last one is %d", since, f.last)
+	}
+	oldest := f.last - uint64(len(f.events)) + 1
+	if since+1 < oldest {
+		return nil, nil, ErrEventsLost
+	}
+	backlog := make([]Event, f.last-since)
+	copy(backlog, f.events[len(f.events)-len(backlog):])
+
+	events := make(chan Event, subscriberBufferSize)
+	sub := &EventSubscription{Events: events, events: events, feed: f}
+	f.subscribers[sub] = struct{}{}
+	return backlog, sub, nil
+}
+
+func (f *eventFeed) unsubscribe(sub *EventSubscription) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.subscribers[sub]; ok {
+		delete(f.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// EventEpoch identifies this run of ipamd. The sequence numbers of the events of different epochs are unrelated.
+func (ds *DataStore) EventEpoch() string {
+	return ds.events.epoch
+}
+
+// LastEventSequence returns the sequence number of the last event, 0 if there was none
+func (ds *DataStore) LastEventSequence() uint64 {
+	ds.events.lock.Lock()
+	defer ds.events.lock.Unlock()
+	return ds.events.last
+}
+
+// SubscribeEvents returns the events after the since sequence number, and subscribes to the next ones. It returns
+// ErrEventsLost if some of the events after since are no longer kept.
+func (ds *DataStore) SubscribeEvents(since uint64) ([]Event, *EventSubscription, error) {
+	return ds.events.subscribe(since)
+}
+
+// PublishEvent publishes an event that isn't a change of the data store, like a pool action
+func (ds *DataStore) PublishEvent(event Event) {
+	event.Time = ds.now()
+	ds.events.publish(event)
+}
+
+// publishENIEvent publishes a change of an ENI, or of one of its IP addresses. It must be called with the lock held,
+// so that the events are in the order of the changes.
+func (ds *DataStore) publishENIEvent(eventType EventType, eni *ENIIPPool, ip string) {
+	ds.events.publish(Event{
+		Time:         ds.now(),
+		Type:         eventType,
+		ENI:          eni.ID,
+		DeviceNumber: eni.DeviceNumber,
+		IP:           ip,
+	})
+}
+
+// publishPodEvent publishes the assignment of a pod's IP address. It must be called with the lock held.
+func (ds *DataStore) publishPodEvent(eventType EventType, podKey PodKey, info PodIPInfo) {
+	event := Event{
+		Time:         ds.now(),
+		Type:         eventType,
+		DeviceNumber: info.DeviceNumber,
+		IP:           info.IP,
+		PodName:      podKey.name,
+		PodNamespace: podKey.namespace,
+		Sandbox:      podKey.sandbox,
+	}
+	if eni, ok := ds.eniByIP[info.IP]; ok {
+		event.ENI = eni.ID
+	}
+	ds.events.publish(event)
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+func TestEvents(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	ds := NewDataStore()
+	ds.now = clock.Now
+
+	backlog, sub, err := ds.SubscribeEvents(0)
+	assert.NoError(t, err)
+	assert.Empty(t, backlog)
+	defer sub.Close()
+
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+	pod := &k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1"}
+	_, _, _ = ds.AssignPodIPv4Address(pod)
+	clock.pastCooling()
+	_, _, _ = ds.UnassignPodIPv4Address(pod)
+	assert.NoError(t, ds.RemoveENIFromDataStore("eni-1", false))
+	ds.PublishEvent(Event{Type: EventPoolAction, Action: "FreeENI", ENI: "eni-1"})
+
+	want := []Event{
+		{Sequence: 1, Type: EventENIAdded, ENI: "eni-1", DeviceNumber: 1},
+		{Sequence: 2, Type: EventIPAdded, ENI: "eni-1", DeviceNumber: 1, IP: "1.1.1.1"},
+		{Sequence: 3, Type: EventIPAdded, ENI: "eni-1", DeviceNumber: 1, IP: "1.1.1.2"},
+		{Sequence: 4, Type: EventPodAssigned, ENI: "eni-1", DeviceNumber: 1, IP: "1.1.1.1", PodName: "pod-1", PodNamespace: "ns-1", Sandbox: "sandbox-1"},
+		{Sequence: 5, Type: EventPodUnassigned, ENI: "eni-1", DeviceNumber: 1, IP: "1.1.1.1", PodName: "pod-1", PodNamespace: "ns-1", Sandbox: "sandbox-1"},
+		{Sequence: 6, Type: EventIPRemoved, ENI: "eni-1", DeviceNumber: 1, IP: "1.1.1.1"},
+		{Sequence: 7, Type: EventIPRemoved, ENI: "eni-1", DeviceNumber: 1, IP: "1.1.1.2"},
+		{Sequence: 8, Type: EventENIRemoved, ENI: "eni-1", DeviceNumber: 1},
+		{Sequence: 9, Type: EventPoolAction, ENI: "eni-1", Action: "FreeENI"},
+	}
+	for _, w := range want {
+		event := <-sub.Events
+		assert.False(t, event.Time.IsZero())
+		event.Time = time.Time{}
+		assert.Equal(t, w, event)
+	}
+	assert.Equal(t, uint64(9), ds.LastEventSequence())
+
+	// Resume after the pod was assigned
+	backlog, resumed, err := ds.SubscribeEvents(4)
+	assert.NoError(t, err)
+	resumed.Close()
+	assert.Len(t, backlog, 5)
+	assert.Equal(t, uint64(5), backlog[0].Sequence)
+	assert.Equal(t, EventPodUnassigned, backlog[0].Type)
+
+	_, _, err = ds.SubscribeEvents(10)
+	assert.Error(t, err)
+}
+
+func TestEventsLost(t *testing.T) {
+	ds := NewDataStore()
+	_, slow, err := ds.SubscribeEvents(0)
+	assert.NoError(t, err)
+	defer slow.Close()
+
+	for i := 0; i < eventBufferSize+1; i++ {
+		ds.PublishEvent(Event{Type: EventPoolAction})
+	}
+
+	// The subscriber that fell behind is dropped, after the events it had room for
+	received := 0
+	for range slow.Events {
+		received++
+	}
+	assert.Equal(t, subscriberBufferSize, received)
+
+	_, _, err = ds.SubscribeEvents(0)
+	assert.Equal(t, ErrEventsLost, err)
+	backlog, sub, err := ds.SubscribeEvents(1)
+	assert.NoError(t, err)
+	sub.Close()
+	assert.Len(t, backlog, eventBufferSize)
+}
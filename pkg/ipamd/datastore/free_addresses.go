@@ -0,0 +1,71 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datastore
+
+import (
+	"sort"
+	"time"
+)
+
+// freeAddresses is the queue of the free IP addresses of an ENI, ordered by the time they were released and then by
+// address. The addresses that were never assigned have a zero UnassignedTime and come first, and a released address
+// is newer than all the others, so it is appended. The head is the address released the longest time ago, and the
+// addresses in their cooling period are at the tail.
+type freeAddresses []*AddressInfo
+
+// addressBefore returns whether a comes before b in the queue
+func addressBefore(a, b *AddressInfo) bool {
+	if !a.UnassignedTime.Equal(b.UnassignedTime) {
+		return a.UnassignedTime.Before(b.UnassignedTime)
+	}
+	return a.Address < b.Address
+}
+
+// push adds a free address to the queue. The UnassignedTime of the address must not change while it is queued.
+func (q *freeAddresses) push(addr *AddressInfo) {
+	queue := *q
+	i := sort.Search(len(queue), func(i int) bool { return addressBefore(addr, queue[i]) })
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = addr
+	*q = queue
+}
+
+// remove takes an address out of the queue, if it is queued
+func (q *freeAddresses) remove(addr *AddressInfo) {
+	queue := *q
+	i := sort.Search(len(queue), func(i int) bool { return !addressBefore(queue[i], addr) })
+	if i == len(queue) || queue[i] != addr {
+		return
+	}
+	if i == 0 {
+		*q = queue[1:]
+		return
+	}
+	*q = append(queue[:i], queue[i+1:]...)
+}
+
+// head returns the address released the longest time ago, or nil if it is in its cooling period or the queue is
+// empty
+func (q freeAddresses) head(now time.Time) *AddressInfo {
+	if len(q) == 0 || q[0].inCoolingPeriod(now) {
+		return nil
+	}
+	return q[0]
+}
+
+// assignable returns the number of queued addresses that are not in their cooling period
+func (q freeAddresses) assignable(now time.Time) int {
+	return sort.Search(len(q), func(i int) bool { return q[i].inCoolingPeriod(now) })
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreeAddresses(t *testing.T) {
+	now := time.Now()
+	neverUsed1 := &AddressInfo{Address: "10.0.0.1"}
+	neverUsed2 := &AddressInfo{Address: "10.0.0.2"}
+	released := &AddressInfo{Address: "10.0.0.3", UnassignedTime: now.Add(-time.Hour)}
+	cooling := &AddressInfo{Address: "10.0.0.4", UnassignedTime: now.Add(-time.Second)}
+
+	var q freeAddresses
+	q.push(cooling)
+	q.push(released)
+	q.push(neverUsed2)
+	q.push(neverUsed1)
+	assert.Equal(t, freeAddresses{neverUsed1, neverUsed2, released, cooling}, q)
+	assert.Equal(t, 3, q.assignable(now))
+	assert.Equal(t, neverUsed1, q.head(now))
+
+	q.remove(neverUsed1)
+	q.remove(released)
+	q.remove(released)
+	assert.Equal(t, freeAddresses{neverUsed2, cooling}, q)
+
+	q.remove(neverUsed2)
+	assert.Nil(t, q.head(now))
+	assert.Equal(t, 0, q.assignable(now))
+	assert.Equal(t, cooling, q.head(now.Add(AddressCoolingPeriod)))
+}
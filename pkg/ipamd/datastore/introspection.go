@@ -0,0 +1,125 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datastore
+
+import (
+	"time"
+)
+
+// AddressFilter selects the IP addresses shown by the introspection endpoints. The empty fields match everything.
+type AddressFilter struct {
+	// Namespace and Pod select the addresses assigned to the pods of a namespace, or to the pods of a name
+	Namespace string
+	Pod       string
+	ENI       string
+	IP        string
+	// Assigned selects the assigned addresses if it points to true, and the free addresses if it points to false
+	Assigned *bool
+}
+
+// addressFilterSet returns whether the filter selects individual addresses rather than whole ENIs
+func (f AddressFilter) addressFilterSet() bool {
+	return f.Namespace != "" || f.Pod != "" || f.IP != "" || f.Assigned != nil
+}
+
+// MatchesPod returns whether the filter selects the address of a pod
+func (f AddressFilter) MatchesPod(addr PodIPv4Address) bool {
+	return (f.Namespace == "" || f.Namespace == addr.Namespace) &&
+		(f.Pod == "" || f.Pod == addr.Name) &&
+		(f.ENI == "" || f.ENI == addr.ENI) &&
+		(f.IP == "" || f.IP == addr.IP) &&
+		(f.Assigned == nil || *f.Assigned)
+}
+
+// ENIRecord is an ENI and its IP addresses, for introspection
+type ENIRecord struct {
+	ID                    string
+	IsPrimary             bool
+	DeviceNumber          int
+	AssignedIPv4Addresses int
+	Draining              bool `json:",omitempty"`
+	IPv4Addresses         map[string]AddressRecord
+}
+
+// AddressRecord is an IP address of an ENI and the pod it is assigned to, for introspection
+type AddressRecord struct {
+	Address         string
+	Assigned        bool
+	UnassignedTime  time.Time
+	InCoolingPeriod bool   `json:",omitempty"`
+	PodName         string `json:",omitempty"`
+	PodNamespace    string `json:",omitempty"`
+	AssignedTime    time.Time
+}
+
+// ENIRecords is the response of the /v1/enis introspection endpoint
+type ENIRecords struct {
+	// TotalIPs and AssignedIPs count all the IP addresses of the data store, whatever the filter
+	TotalIPs    int
+	AssignedIPs int
+	ENIIPPools  map[string]ENIRecord
+}
+
+// GetENIRecords returns the ENIs and the IP addresses the filter selects. An ENI is left out if none of its
+// addresses is selected, unless the filter only selects ENIs.
+func (ds *DataStore) GetENIRecords(filter AddressFilter) ENIRecords {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	now := ds.now()
+	pods := make(map[string]PodKey, len(ds.podsIP))
+	for key, info := range ds.podsIP {
+		pods[info.IP] = key
+	}
+
+	records := ENIRecords{TotalIPs: ds.total, AssignedIPs: ds.assigned, ENIIPPools: make(map[string]ENIRecord)}
+	for _, eni := range ds.eniIPPools {
+		if filter.ENI != "" && filter.ENI != eni.ID {
+			continue
+		}
+		record := ENIRecord{
+			ID:                    eni.ID,
+			IsPrimary:             eni.IsPrimary,
+			DeviceNumber:          eni.DeviceNumber,
+			AssignedIPv4Addresses: eni.AssignedIPv4Addresses,
+			Draining:              eni.Draining,
+			IPv4Addresses:         make(map[string]AddressRecord),
+		}
+		for ip, addr := range eni.IPv4Addresses {
+			addrRecord := AddressRecord{
+				Address:         addr.Address,
+				Assigned:        addr.Assigned,
+				UnassignedTime:  addr.UnassignedTime,
+				InCoolingPeriod: !addr.Assigned && addr.inCoolingPeriod(now),
+			}
+			if key, ok := pods[ip]; ok && addr.Assigned {
+				addrRecord.PodName = key.name
+				addrRecord.PodNamespace = key.namespace
+				addrRecord.AssignedTime = ds.podsIP[key].AssignedTime
+			}
+			if (filter.Namespace != "" && filter.Namespace != addrRecord.PodNamespace) ||
+				(filter.Pod != "" && filter.Pod != addrRecord.PodName) ||
+				(filter.IP != "" && filter.IP != ip) ||
+				(filter.Assigned != nil && *filter.Assigned != addr.Assigned) {
+				continue
+			}
+			record.IPv4Addresses[ip] = addrRecord
+		}
+		if len(record.IPv4Addresses) == 0 && filter.addressFilterSet() {
+			continue
+		}
+		records.ENIIPPools[eni.ID] = record
+	}
+	return records
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datastore
+
+// PlacementStrategy decides which free IP address a new pod is assigned
+type PlacementStrategy string
+
+const (
+	// PlacementPack fills the ENI with the most assigned IPs first, so that the other ENIs become free and can be
+	// released
+	PlacementPack PlacementStrategy = "pack"
+	// PlacementSpread uses the ENI with the fewest assigned IPs, to spread the pods' traffic over the ENIs
+	PlacementSpread PlacementStrategy = "spread"
+	// PlacementLRU uses the IP address that was released the longest time ago on any ENI, so that conntrack entries
+	// and ARP caches of its last pod are least likely to be stale
+	PlacementLRU PlacementStrategy = "lru"
+
+	// DefaultPlacementStrategy is the strategy of a new DataStore
+	DefaultPlacementStrategy = PlacementPack
+)
+
+// PlacementStrategies are the valid placement strategies
+var PlacementStrategies = []PlacementStrategy{PlacementPack, PlacementSpread, PlacementLRU}
+
+// SetPlacementStrategy sets the strategy that decides which free IP address a new pod is assigned
+func (ds *DataStore) SetPlacementStrategy(strategy PlacementStrategy) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.placement = strategy
+}
+
+// selectAddress returns the free IP address the placement strategy picks for a new pod, or nil if there is none.
+// Within an ENI, pack and spread use the address released the longest time ago as well. Ties go to the lowest device
+// number. It only looks at the head of each ENI's free address queue, and must be called with the lock held.
+func (ds *DataStore) selectAddress() (*ENIIPPool, *AddressInfo) {
+	now := ds.now()
+	var bestENI *ENIIPPool
+	var bestAddr *AddressInfo
+	for _, eni := range ds.eniIPPools {
+		if eni.Draining {
+			continue
+		}
+		addr := eni.free.head(now)
+		if addr == nil {
+			continue
+		}
+		if bestENI == nil || ds.placementBefore(eni, addr, bestENI, bestAddr) {
+			bestENI, bestAddr = eni, addr
+		}
+	}
+	return bestENI, bestAddr
+}
+
+// placementBefore returns whether the placement strategy prefers address a of ENI eniA over address b of ENI eniB
+func (ds *DataStore) placementBefore(eniA *ENIIPPool, a *AddressInfo, eniB *ENIIPPool, b *AddressInfo) bool {
+	switch ds.placement {
+	case PlacementSpread:
+		if eniA.AssignedIPv4Addresses != eniB.AssignedIPv4Addresses {
+			return eniA.AssignedIPv4Addresses < eniB.AssignedIPv4Addresses
+		}
+	case PlacementLRU:
+		if !a.UnassignedTime.Equal(b.UnassignedTime) {
+			return a.UnassignedTime.Before(b.UnassignedTime)
+		}
+	default:
+		if eniA.AssignedIPv4Addresses != eniB.AssignedIPv4Addresses {
+			return eniA.AssignedIPv4Addresses > eniB.AssignedIPv4Addresses
+		}
+	}
+	if eniA.DeviceNumber != eniB.DeviceNumber {
+		return eniA.DeviceNumber < eniB.DeviceNumber
+	}
+	return a.Address < b.Address
+}
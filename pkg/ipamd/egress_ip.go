@@ -0,0 +1,177 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+// egressIPSandbox is the sandbox of the datastore entries that reserve the egress IPs. Pods always have a namespace
+// and these entries don't, so they can't clash with a pod.
+const egressIPSandbox = "egress-ip"
+
+// EgressIPInfo contains the egress IP reserved on this node for an EgressIPConfig
+type EgressIPInfo struct {
+	// IP is the egress IP address
+	IP string
+	// PodIPs are the IP addresses of the local pods that use it
+	PodIPs []string
+}
+
+// syncEgressIPs reserves an IP address of the primary ENI for each EgressIPConfig, reports it in the EgressIPConfig's
+// status, and sets up the rules that SNAT the traffic of the selected local pods to it.
+func (c *IPAMContext) syncEgressIPs() {
+	if !c.networkClient.UseEgressIP() {
+		return
+	}
+
+	pods, err := c.k8sClient.K8SGetLocalPodIPs()
+	if err != nil {
+		log.Debugf("syncEgressIPs: failed to get local pods: %v", err)
+		return
+	}
+
+	configs := c.eniConfig.EgressIPConfigs()
+	primaryENI := c.awsClient.GetPrimaryENI()
+	wanted := make(map[string]bool, len(configs))
+	var egressIPs []networkutils.EgressIP
+	var selectors []v1alpha1.EgressIPConfigSpec
+	for _, config := range configs {
+		wanted[config.Name] = true
+		ip, _, err := c.dataStore.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{
+			Name:    config.Name,
+			Sandbox: egressIPSandbox,
+			// Try to keep the address this node had before a restart
+			IP: config.Status.EgressIPs[c.myNodeName],
+		}, primaryENI)
+		if err != nil {
+			log.Warnf("syncEgressIPs: failed to reserve an egress IP for EgressIPConfig %s: %v", config.Name, err)
+			ipamdErrInc("syncEgressIPsReserveFailed")
+			continue
+		}
+		if err = c.eniConfig.SetEgressIPStatus(config.Name, ip); err != nil {
+			log.Warnf("syncEgressIPs: %v", err)
+		}
+		egressIPs = append(egressIPs, networkutils.EgressIP{Name: config.Name, IP: ip})
+		selectors = append(selectors, config.Spec)
+	}
+
+	// A pod selected by several EgressIPConfigs uses the first one by name
+	for _, pod := range pods {
+		if pod.IP == "" {
+			continue
+		}
+		for i := range egressIPs {
+			if egressIPSelects(selectors[i], pod) {
+				egressIPs[i].PodIPs = append(egressIPs[i].PodIPs, pod.IP)
+				break
+			}
+		}
+	}
+
+	c.egressIPsLock.Lock()
+	defer c.egressIPsLock.Unlock()
+	for name := range c.egressIPs {
+		if wanted[name] {
+			continue
+		}
+		log.Infof("syncEgressIPs: releasing the egress IP of deleted EgressIPConfig %s", name)
+		_, _, err = c.dataStore.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{Name: name, Sandbox: egressIPSandbox})
+		if err != nil {
+			log.Warnf("syncEgressIPs: failed to release the egress IP of EgressIPConfig %s: %v", name, err)
+		}
+	}
+
+	if err = c.networkClient.SetupEgressIPs(egressIPs); err != nil {
+		log.Errorf("syncEgressIPs: failed to set up egress IP rules: %v", err)
+		ipamdErrInc("syncEgressIPsSetupFailed")
+	}
+
+	c.egressIPs = make(map[string]EgressIPInfo, len(egressIPs))
+	for _, egressIP := range egressIPs {
+		c.egressIPs[egressIP.Name] = EgressIPInfo{IP: egressIP.IP, PodIPs: egressIP.PodIPs}
+	}
+}
+
+// reserveEgressIPs reserves the egress IPs that the status of the EgressIPConfigs gives this node, so that no pod takes
+// one of them between the start of the RPC server and the first syncEgressIPs. It reads the EgressIPConfigs from the
+// API server, the cache may not be filled yet.
+func (c *IPAMContext) reserveEgressIPs() {
+	if !c.networkClient.UseEgressIP() {
+		return
+	}
+	configs, err := c.eniConfig.ListEgressIPConfigs()
+	if err != nil {
+		log.Warnf("reserveEgressIPs: %v", err)
+		ipamdErrInc("reserveEgressIPsListFailed")
+		return
+	}
+	primaryENI := c.awsClient.GetPrimaryENI()
+	for _, config := range configs {
+		previous := config.Status.EgressIPs[c.myNodeName]
+		if previous == "" {
+			continue
+		}
+		ip, _, err := c.dataStore.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{
+			Name:    config.Name,
+			Sandbox: egressIPSandbox,
+			IP:      previous,
+		}, primaryENI)
+		if err != nil {
+			log.Warnf("reserveEgressIPs: failed to reserve egress IP %s for EgressIPConfig %s: %v", previous, config.Name, err)
+			ipamdErrInc("reserveEgressIPsFailed")
+			continue
+		}
+		if ip != previous {
+			log.Warnf("reserveEgressIPs: egress IP %s of EgressIPConfig %s is not free anymore, reserved %s", previous, config.Name, ip)
+			continue
+		}
+		log.Infof("reserveEgressIPs: reserved egress IP %s for EgressIPConfig %s", ip, config.Name)
+	}
+}
+
+// egressIPSelects returns whether a pod is in one of the namespaces of an EgressIPConfig or has all the labels of
+// its pod selector
+func egressIPSelects(spec v1alpha1.EgressIPConfigSpec, pod *k8sapi.K8SPodInfo) bool {
+	for _, namespace := range spec.Namespaces {
+		if namespace == pod.Namespace {
+			return true
+		}
+	}
+	if len(spec.PodSelector) == 0 {
+		return false
+	}
+	for key, value := range spec.PodSelector {
+		if podValue, ok := pod.Labels[key]; !ok || podValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// GetEgressIPs returns the egress IPs reserved on this node, by EgressIPConfig name
+func (c *IPAMContext) GetEgressIPs() map[string]EgressIPInfo {
+	c.egressIPsLock.RLock()
+	defer c.egressIPsLock.RUnlock()
+
+	egressIPs := make(map[string]EgressIPInfo, len(c.egressIPs))
+	for name, egressIP := range c.egressIPs {
+		egressIPs[name] = egressIP
+	}
+	return egressIPs
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+func TestSyncEgressIPs(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, mockENIConfig := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		networkClient: mockNetwork,
+		eniConfig:     mockENIConfig,
+		dataStore:     datastore.NewDataStore(),
+		myNodeName:    "node-1",
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr03)
+
+	pods := []*k8sapi.K8SPodInfo{
+		{Name: "pod-1", Namespace: "payments", IP: "10.10.10.51"},
+		{Name: "pod-2", Namespace: "default", IP: "10.10.10.52", Labels: map[string]string{"app": "billing"}},
+		{Name: "pod-3", Namespace: "default", IP: "10.10.10.53", Labels: map[string]string{"app": "web"}},
+		{Name: "pod-4", Namespace: "payments", IP: ""},
+	}
+	configs := []v1alpha1.EgressIPConfig{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "billing"},
+			Spec:       v1alpha1.EgressIPConfigSpec{PodSelector: map[string]string{"app": "billing"}},
+			// The node had this address before a restart
+			Status: v1alpha1.EgressIPConfigStatus{EgressIPs: map[string]string{"node-1": ipaddr03}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "payments"},
+			Spec:       v1alpha1.EgressIPConfigSpec{Namespaces: []string{"payments"}},
+		},
+	}
+
+	mockNetwork.EXPECT().UseEgressIP().Return(true)
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return(pods, nil)
+	mockENIConfig.EXPECT().EgressIPConfigs().Return(configs)
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid)
+	mockENIConfig.EXPECT().SetEgressIPStatus("billing", ipaddr03).Return(nil)
+	mockENIConfig.EXPECT().SetEgressIPStatus("payments", ipaddr02).Return(nil)
+	mockNetwork.EXPECT().SetupEgressIPs([]networkutils.EgressIP{
+		{Name: "billing", IP: ipaddr03, PodIPs: []string{"10.10.10.52"}},
+		{Name: "payments", IP: ipaddr02, PodIPs: []string{"10.10.10.51"}},
+	}).Return(nil)
+
+	mockContext.syncEgressIPs()
+	assert.Equal(t, map[string]EgressIPInfo{
+		"billing":  {IP: ipaddr03, PodIPs: []string{"10.10.10.52"}},
+		"payments": {IP: ipaddr02, PodIPs: []string{"10.10.10.51"}},
+	}, mockContext.GetEgressIPs())
+	_, assigned := mockContext.dataStore.GetStats()
+	assert.Equal(t, 2, assigned)
+
+	// Deleting an EgressIPConfig releases its address
+	mockNetwork.EXPECT().UseEgressIP().Return(true)
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return(pods, nil)
+	mockENIConfig.EXPECT().EgressIPConfigs().Return(configs[1:])
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid)
+	mockENIConfig.EXPECT().SetEgressIPStatus("payments", ipaddr02).Return(nil)
+	mockNetwork.EXPECT().SetupEgressIPs([]networkutils.EgressIP{
+		{Name: "payments", IP: ipaddr02, PodIPs: []string{"10.10.10.51"}},
+	}).Return(nil)
+
+	mockContext.syncEgressIPs()
+	assert.Equal(t, map[string]EgressIPInfo{
+		"payments": {IP: ipaddr02, PodIPs: []string{"10.10.10.51"}},
+	}, mockContext.GetEgressIPs())
+	_, assigned = mockContext.dataStore.GetStats()
+	assert.Equal(t, 1, assigned)
+}
+
+func TestReserveEgressIPs(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, mockENIConfig := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		networkClient: mockNetwork,
+		eniConfig:     mockENIConfig,
+		dataStore:     datastore.NewDataStore(),
+		myNodeName:    "node-1",
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr03)
+
+	mockNetwork.EXPECT().UseEgressIP().Return(true)
+	mockENIConfig.EXPECT().ListEgressIPConfigs().Return([]v1alpha1.EgressIPConfig{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "billing"},
+			Status:     v1alpha1.EgressIPConfigStatus{EgressIPs: map[string]string{"node-1": ipaddr03, "node-2": ipaddr02}},
+		},
+		// Not reserved on this node yet, syncEgressIPs picks its address
+		{ObjectMeta: metav1.ObjectMeta{Name: "payments"}},
+	}, nil)
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid)
+
+	mockContext.reserveEgressIPs()
+
+	// A new pod can't take the egress IP of the node before the restart
+	ip, _, err := mockContext.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "default", Sandbox: "sandbox-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, ipaddr02, ip)
+	ip, _, err = mockContext.dataStore.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "billing", Sandbox: egressIPSandbox}, primaryENIid)
+	assert.NoError(t, err)
+	assert.Equal(t, ipaddr03, ip)
+}
+
+func TestSyncEgressIPsDisabled(t *testing.T) {
+	ctrl, _, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{networkClient: mockNetwork}
+	mockNetwork.EXPECT().UseEgressIP().Return(false)
+
+	mockContext.syncEgressIPs()
+	assert.Empty(t, mockContext.GetEgressIPs())
+}
+
+func TestEgressIPSelects(t *testing.T) {
+	pod := &k8sapi.K8SPodInfo{Namespace: "default", Labels: map[string]string{"app": "billing", "tier": "backend"}}
+
+	assert.True(t, egressIPSelects(v1alpha1.EgressIPConfigSpec{Namespaces: []string{"kube-system", "default"}}, pod))
+	assert.True(t, egressIPSelects(v1alpha1.EgressIPConfigSpec{PodSelector: map[string]string{"app": "billing"}}, pod))
+	assert.False(t, egressIPSelects(v1alpha1.EgressIPConfigSpec{PodSelector: map[string]string{"app": "billing", "tier": "frontend"}}, pod))
+	assert.False(t, egressIPSelects(v1alpha1.EgressIPConfigSpec{Namespaces: []string{"kube-system"}}, pod))
+	assert.False(t, egressIPSelects(v1alpha1.EgressIPConfigSpec{}, pod))
+}
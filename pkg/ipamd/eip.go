@@ -0,0 +1,281 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// eipAllocationAnnotation asks for a specific Elastic IP, by allocation ID
+	eipAllocationAnnotation = "k8s.amazonaws.com/eip-allocation-id"
+	// eipPoolAnnotation asks for any free Elastic IP tagged with "k8s.amazonaws.com/eip-pool=<pool>"
+	eipPoolAnnotation = "k8s.amazonaws.com/eip-pool"
+
+	// podEIPSyncInterval is how often the Elastic IP associations are reconciled when no pod was added or deleted
+	podEIPSyncInterval = time.Minute
+	// podEIPRetryInterval is how long to wait before trying again to associate an Elastic IP with a pod
+	podEIPRetryInterval = time.Minute
+)
+
+// PodEIPInfo contains the Elastic IP associated with a pod
+type PodEIPInfo struct {
+	// AllocationID is the allocation ID of the Elastic IP
+	AllocationID string
+	// AssociationID is the ID of the association with the pod's IP address
+	AssociationID string
+	// Pool is the Elastic IP's pool, if the pod asked for a pool
+	Pool string
+
+	pod *k8sapi.K8SPodInfo
+}
+
+// podEIPTarget is a local pod that asks for an Elastic IP
+type podEIPTarget struct {
+	pod *k8sapi.K8SPodInfo
+	eni string
+}
+
+// eipRequest returns the allocation ID or the pool in the pod's annotations. The allocation ID wins if both are set.
+func eipRequest(pod *k8sapi.K8SPodInfo) (allocationID string, pool string) {
+	if allocationID = pod.Annotations[eipAllocationAnnotation]; allocationID != "" {
+		return allocationID, ""
+	}
+	return "", pod.Annotations[eipPoolAnnotation]
+}
+
+// eipMatches returns whether an Elastic IP is the one a pod asks for
+func eipMatches(pod *k8sapi.K8SPodInfo, allocationID string, pool string) bool {
+	wantedAllocationID, wantedPool := eipRequest(pod)
+	if wantedAllocationID != "" {
+		return wantedAllocationID == allocationID
+	}
+	return wantedPool != "" && wantedPool == pool
+}
+
+// StartPodEIPManager associates Elastic IPs with the pods that ask for one in their annotations, and disassociates
+// them when the pods are deleted. It syncs when a pod is added or deleted and every podEIPSyncInterval.
+func (c *IPAMContext) StartPodEIPManager() {
+	ticker := time.NewTicker(podEIPSyncInterval)
+	defer ticker.Stop()
+	for {
+		c.syncPodEIPs()
+		select {
+		case <-ticker.C:
+		case <-c.podEIPSyncCh:
+		}
+	}
+}
+
+// triggerPodEIPSync asks the pod EIP manager to sync without waiting for it
+func (c *IPAMContext) triggerPodEIPSync() {
+	if c.podEIPSyncCh == nil {
+		return
+	}
+	select {
+	case c.podEIPSyncCh <- struct{}{}:
+	default:
+	}
+}
+
+// syncPodEIPs makes the Elastic IP associations of the local pods match their annotations. The first sync adopts the
+// associations made before a restart and removes the stale ones.
+func (c *IPAMContext) syncPodEIPs() {
+	pods, err := c.k8sClient.K8SGetLocalPodIPs()
+	if err != nil {
+		log.Debugf("syncPodEIPs: failed to get local pods: %v", err)
+		return
+	}
+
+	requests := make(map[string]*k8sapi.K8SPodInfo)
+	for _, pod := range pods {
+		if allocationID, pool := eipRequest(pod); allocationID != "" || pool != "" {
+			requests[pod.Namespace+"/"+pod.Name] = pod
+		}
+	}
+	// Use the IP addresses assigned by ipamd, the pod's status might not have its IP yet
+	targets := make(map[string]podEIPTarget)
+	for _, addr := range c.dataStore.GetPodIPv4Addresses() {
+		if addr.Sandbox == egressIPSandbox {
+			continue
+		}
+		if pod, ok := requests[addr.Namespace+"/"+addr.Name]; ok {
+			targets[addr.IP] = podEIPTarget{pod: pod, eni: addr.ENI}
+		}
+	}
+
+	c.podEIPsLock.Lock()
+	defer c.podEIPsLock.Unlock()
+
+	if !c.podEIPsAdopted {
+		if err = c.adoptPodEIPs(targets); err != nil {
+			log.Warnf("syncPodEIPs: %v", err)
+			ipamdErrInc("syncPodEIPsAdoptFailed")
+			return
+		}
+		c.podEIPsAdopted = true
+	}
+
+	for ip, eip := range c.podEIPs {
+		if target, ok := targets[ip]; ok && eipMatches(target.pod, eip.AllocationID, eip.Pool) {
+			continue
+		}
+		if err = c.awsClient.DisassociateElasticIP(eip.AssociationID); err != nil {
+			ipamdErrInc("syncPodEIPsDisassociateFailed")
+			c.k8sClient.K8SRecordPodEvent(eip.pod, v1.EventTypeWarning, "EIPDisassociationFailed",
+				fmt.Sprintf("Failed to disassociate Elastic IP %s from %s: %v", eip.AllocationID, ip, err))
+			continue
+		}
+		delete(c.podEIPs, ip)
+	}
+
+	// An Elastic IP can only be associated with one pod, the others get an event
+	claimed := make(map[string]string)
+	for ip, eip := range c.podEIPs {
+		claimed[eip.AllocationID] = ip
+	}
+	ips := make([]string, 0, len(targets))
+	for ip := range targets {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	now := time.Now()
+	for _, ip := range ips {
+		if _, ok := c.podEIPs[ip]; ok || now.Before(c.podEIPRetries[ip]) {
+			continue
+		}
+		target := targets[ip]
+		allocationID, _ := eipRequest(target.pod)
+		if otherIP, ok := claimed[allocationID]; ok && allocationID != "" {
+			err = errors.Errorf("it is already associated with pod IP %s", otherIP)
+		} else {
+			var eip *PodEIPInfo
+			eip, err = c.associatePodEIP(target, ip)
+			if err == nil {
+				delete(c.podEIPRetries, ip)
+				c.podEIPs[ip] = eip
+				claimed[eip.AllocationID] = ip
+				c.k8sClient.K8SRecordPodEvent(target.pod, v1.EventTypeNormal, "EIPAssociated",
+					fmt.Sprintf("Associated Elastic IP %s with %s", eip.AllocationID, ip))
+				continue
+			}
+		}
+		c.podEIPRetries[ip] = now.Add(podEIPRetryInterval)
+		ipamdErrInc("syncPodEIPsAssociateFailed")
+		log.Warnf("syncPodEIPs: failed to associate an Elastic IP with pod %s/%s: %v", target.pod.Namespace, target.pod.Name, err)
+		c.k8sClient.K8SRecordPodEvent(target.pod, v1.EventTypeWarning, "EIPAssociationFailed",
+			fmt.Sprintf("Failed to associate an Elastic IP with %s: %v", ip, err))
+	}
+
+	for ip := range c.podEIPRetries {
+		if _, ok := targets[ip]; !ok {
+			delete(c.podEIPRetries, ip)
+		}
+	}
+}
+
+// associatePodEIP associates the Elastic IP a pod asks for with its IP address. An allocation ID is only taken over
+// from what it is associated with if it belongs to this node, the cluster or a pool, a pool only gives out
+// unassociated Elastic IPs.
+func (c *IPAMContext) associatePodEIP(target podEIPTarget, ip string) (*PodEIPInfo, error) {
+	allocationID, pool := eipRequest(target.pod)
+	if allocationID != "" {
+		associationID, err := c.awsClient.AssociateElasticIP(allocationID, target.eni, ip, false)
+		if err == awsutils.ErrElasticIPAssociated {
+			var reassociate bool
+			if reassociate, err = c.awsClient.CanReassociateElasticIP(allocationID); err != nil {
+				return nil, err
+			}
+			if !reassociate {
+				return nil, errors.Errorf("Elastic IP %s is associated with something else, and isn't tagged for this "+
+					"node, the cluster or a pool", allocationID)
+			}
+			associationID, err = c.awsClient.AssociateElasticIP(allocationID, target.eni, ip, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &PodEIPInfo{AllocationID: allocationID, AssociationID: associationID, pod: target.pod}, nil
+	}
+
+	allocationIDs, err := c.awsClient.GetFreeElasticIPs(pool)
+	if err != nil {
+		return nil, err
+	}
+	for _, allocationID := range allocationIDs {
+		// Another node might take the same Elastic IP first
+		associationID, err := c.awsClient.AssociateElasticIP(allocationID, target.eni, ip, false)
+		if err != nil {
+			continue
+		}
+		return &PodEIPInfo{AllocationID: allocationID, AssociationID: associationID, Pool: pool, pod: target.pod}, nil
+	}
+	return nil, errors.Errorf("no free Elastic IP in pool %s", pool)
+}
+
+// adoptPodEIPs keeps the Elastic IPs this node associated before a restart that still match their pod, and
+// disassociates the others
+func (c *IPAMContext) adoptPodEIPs(targets map[string]podEIPTarget) error {
+	elasticIPs, err := c.awsClient.GetElasticIPAssociations()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the Elastic IP associations of this node")
+	}
+
+	for _, elasticIP := range elasticIPs {
+		if eip, ok := c.podEIPs[elasticIP.PrivateIP]; ok && eip.AssociationID == elasticIP.AssociationID {
+			// Adopted by an earlier attempt
+			continue
+		}
+		target, ok := targets[elasticIP.PrivateIP]
+		if _, adopted := c.podEIPs[elasticIP.PrivateIP]; ok && !adopted && target.eni == elasticIP.ENIID &&
+			eipMatches(target.pod, elasticIP.AllocationID, elasticIP.Pool) {
+			log.Infof("Adopting Elastic IP %s of pod %s/%s", elasticIP.AllocationID, target.pod.Namespace, target.pod.Name)
+			c.podEIPs[elasticIP.PrivateIP] = &PodEIPInfo{
+				AllocationID:  elasticIP.AllocationID,
+				AssociationID: elasticIP.AssociationID,
+				Pool:          elasticIP.Pool,
+				pod:           target.pod,
+			}
+			continue
+		}
+
+		log.Infof("Removing stale association of Elastic IP %s with %s", elasticIP.AllocationID, elasticIP.PrivateIP)
+		if err = c.awsClient.DisassociateElasticIP(elasticIP.AssociationID); err != nil {
+			return errors.Wrapf(err, "failed to remove stale association of Elastic IP %s", elasticIP.AllocationID)
+		}
+	}
+	return nil
+}
+
+// GetPodEIPs returns the Elastic IPs associated with local pods, by pod IP address
+func (c *IPAMContext) GetPodEIPs() map[string]PodEIPInfo {
+	c.podEIPsLock.Lock()
+	defer c.podEIPsLock.Unlock()
+
+	podEIPs := make(map[string]PodEIPInfo, len(c.podEIPs))
+	for ip, eip := range c.podEIPs {
+		podEIPs[ip] = *eip
+	}
+	return podEIPs
+}
@@ -0,0 +1,186 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+func TestSyncPodEIPs(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		dataStore:     datastore.NewDataStore(),
+		podEIPs:       make(map[string]*PodEIPInfo),
+		podEIPRetries: make(map[string]time.Time),
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = mockContext.dataStore.AddENI(secENIid, secDevice, false)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr03)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(secENIid, ipaddr11)
+
+	pod1 := &k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "default",
+		Annotations: map[string]string{eipAllocationAnnotation: "eipalloc-1"}}
+	pod2 := &k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "default",
+		Annotations: map[string]string{eipPoolAnnotation: "web"}}
+	pod3 := &k8sapi.K8SPodInfo{Name: "pod-3", Namespace: "default"}
+	_, _, _ = mockContext.dataStore.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "default", IP: ipaddr02}, primaryENIid)
+	_, _, _ = mockContext.dataStore.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "default"}, secENIid)
+	_, _, _ = mockContext.dataStore.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "pod-3", Namespace: "default"}, primaryENIid)
+
+	// pod-1's association survived a restart, the one of a deleted pod is stale
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return([]*k8sapi.K8SPodInfo{pod1, pod2, pod3}, nil)
+	mockAWS.EXPECT().GetElasticIPAssociations().Return([]awsutils.ElasticIP{
+		{AllocationID: "eipalloc-1", AssociationID: "eipassoc-1", ENIID: primaryENIid, PrivateIP: ipaddr02},
+		{AllocationID: "eipalloc-9", AssociationID: "eipassoc-9", ENIID: primaryENIid, PrivateIP: ipaddr01},
+	}, nil)
+	mockAWS.EXPECT().DisassociateElasticIP("eipassoc-9").Return(nil)
+	// The first free Elastic IP of the pool is taken by another node meanwhile
+	mockAWS.EXPECT().GetFreeElasticIPs("web").Return([]string{"eipalloc-2", "eipalloc-3"}, nil)
+	mockAWS.EXPECT().AssociateElasticIP("eipalloc-2", secENIid, ipaddr11, false).Return("", errors.New("Resource.AlreadyAssociated"))
+	mockAWS.EXPECT().AssociateElasticIP("eipalloc-3", secENIid, ipaddr11, false).Return("eipassoc-3", nil)
+	mockK8S.EXPECT().K8SRecordPodEvent(pod2, v1.EventTypeNormal, "EIPAssociated", gomock.Any())
+
+	mockContext.syncPodEIPs()
+	assert.Equal(t, map[string]PodEIPInfo{
+		ipaddr02: {AllocationID: "eipalloc-1", AssociationID: "eipassoc-1", pod: pod1},
+		ipaddr11: {AllocationID: "eipalloc-3", AssociationID: "eipassoc-3", Pool: "web", pod: pod2},
+	}, mockContext.GetPodEIPs())
+
+	// Deleting pod-1 disassociates its Elastic IP
+	_, _, _ = mockContext.dataStore.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "default"})
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return([]*k8sapi.K8SPodInfo{pod2, pod3}, nil)
+	mockAWS.EXPECT().DisassociateElasticIP("eipassoc-1").Return(nil)
+
+	mockContext.syncPodEIPs()
+	assert.Equal(t, map[string]PodEIPInfo{
+		ipaddr11: {AllocationID: "eipalloc-3", AssociationID: "eipassoc-3", Pool: "web", pod: pod2},
+	}, mockContext.GetPodEIPs())
+}
+
+func TestSyncPodEIPsFailure(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:      mockAWS,
+		k8sClient:      mockK8S,
+		dataStore:      datastore.NewDataStore(),
+		podEIPs:        make(map[string]*PodEIPInfo),
+		podEIPRetries:  make(map[string]time.Time),
+		podEIPsAdopted: true,
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr03)
+
+	pod1 := &k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "default",
+		Annotations: map[string]string{eipAllocationAnnotation: "eipalloc-1"}}
+	pod2 := &k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "default",
+		Annotations: map[string]string{eipAllocationAnnotation: "eipalloc-1"}}
+	_, _, _ = mockContext.dataStore.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "default", IP: ipaddr02}, primaryENIid)
+	_, _, _ = mockContext.dataStore.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "default", IP: ipaddr03}, primaryENIid)
+
+	// pod-1 fails, so pod-2 can have the Elastic IP they both ask for
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return([]*k8sapi.K8SPodInfo{pod1, pod2}, nil)
+	mockAWS.EXPECT().AssociateElasticIP("eipalloc-1", primaryENIid, ipaddr02, false).Return("", errors.New("UnauthorizedOperation"))
+	mockK8S.EXPECT().K8SRecordPodEvent(pod1, v1.EventTypeWarning, "EIPAssociationFailed", gomock.Any())
+	mockAWS.EXPECT().AssociateElasticIP("eipalloc-1", primaryENIid, ipaddr03, false).Return("eipassoc-1", nil)
+	mockK8S.EXPECT().K8SRecordPodEvent(pod2, v1.EventTypeNormal, "EIPAssociated", gomock.Any())
+
+	mockContext.syncPodEIPs()
+	assert.Contains(t, mockContext.podEIPRetries, ipaddr02)
+
+	// pod-1 isn't retried before podEIPRetryInterval
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return([]*k8sapi.K8SPodInfo{pod1, pod2}, nil)
+	mockContext.syncPodEIPs()
+
+	// When it is retried, the Elastic IP is still used by pod-2
+	mockContext.podEIPRetries[ipaddr02] = time.Time{}
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return([]*k8sapi.K8SPodInfo{pod1, pod2}, nil)
+	mockK8S.EXPECT().K8SRecordPodEvent(pod1, v1.EventTypeWarning, "EIPAssociationFailed", gomock.Any())
+	mockContext.syncPodEIPs()
+	assert.Equal(t, []string{ipaddr03}, podEIPAddresses(mockContext.GetPodEIPs()))
+}
+
+func TestSyncPodEIPsAssociatedElsewhere(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:      mockAWS,
+		k8sClient:      mockK8S,
+		dataStore:      datastore.NewDataStore(),
+		podEIPs:        make(map[string]*PodEIPInfo),
+		podEIPRetries:  make(map[string]time.Time),
+		podEIPsAdopted: true,
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr03)
+
+	pod1 := &k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "default",
+		Annotations: map[string]string{eipAllocationAnnotation: "eipalloc-1"}}
+	pod2 := &k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "default",
+		Annotations: map[string]string{eipAllocationAnnotation: "eipalloc-2"}}
+	_, _, _ = mockContext.dataStore.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "default", IP: ipaddr02}, primaryENIid)
+	_, _, _ = mockContext.dataStore.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "default", IP: ipaddr03}, primaryENIid)
+
+	// eipalloc-1 belongs to something outside the cluster and is left alone, eipalloc-2 is tagged for the cluster
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return([]*k8sapi.K8SPodInfo{pod1, pod2}, nil)
+	mockAWS.EXPECT().AssociateElasticIP("eipalloc-1", primaryENIid, ipaddr02, false).Return("", awsutils.ErrElasticIPAssociated)
+	mockAWS.EXPECT().CanReassociateElasticIP("eipalloc-1").Return(false, nil)
+	mockK8S.EXPECT().K8SRecordPodEvent(pod1, v1.EventTypeWarning, "EIPAssociationFailed", gomock.Any())
+	mockAWS.EXPECT().AssociateElasticIP("eipalloc-2", primaryENIid, ipaddr03, false).Return("", awsutils.ErrElasticIPAssociated)
+	mockAWS.EXPECT().CanReassociateElasticIP("eipalloc-2").Return(true, nil)
+	mockAWS.EXPECT().AssociateElasticIP("eipalloc-2", primaryENIid, ipaddr03, true).Return("eipassoc-2", nil)
+	mockK8S.EXPECT().K8SRecordPodEvent(pod2, v1.EventTypeNormal, "EIPAssociated", gomock.Any())
+
+	mockContext.syncPodEIPs()
+	assert.Contains(t, mockContext.podEIPRetries, ipaddr02)
+	assert.Equal(t, []string{ipaddr03}, podEIPAddresses(mockContext.GetPodEIPs()))
+}
+
+func TestEIPMatches(t *testing.T) {
+	byID := &k8sapi.K8SPodInfo{Annotations: map[string]string{eipAllocationAnnotation: "eipalloc-1", eipPoolAnnotation: "web"}}
+	byPool := &k8sapi.K8SPodInfo{Annotations: map[string]string{eipPoolAnnotation: "web"}}
+
+	assert.True(t, eipMatches(byID, "eipalloc-1", ""))
+	assert.False(t, eipMatches(byID, "eipalloc-2", "web"))
+	assert.True(t, eipMatches(byPool, "eipalloc-2", "web"))
+	assert.False(t, eipMatches(byPool, "eipalloc-2", ""))
+	assert.False(t, eipMatches(&k8sapi.K8SPodInfo{}, "eipalloc-2", ""))
+}
+
+func podEIPAddresses(podEIPs map[string]PodEIPInfo) []string {
+	var ips []string
+	for ip := range podEIPs {
+		ips = append(ips, ip)
+	}
+	return ips
+}
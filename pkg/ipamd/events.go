@@ -0,0 +1,136 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+const (
+	// The actions of the pool manager published to the event feed
+	poolActionAllocENI = "AllocENI"
+	poolActionAllocIPs = "AllocIPs"
+	poolActionFreeENI  = "FreeENI"
+	poolActionFreeIPs  = "FreeIPs"
+
+	// eventKeepaliveInterval is how often a comment is sent on an idle event stream, so that the clients and the
+	// proxies in between don't close it
+	eventKeepaliveInterval = 30 * time.Second
+
+	// eventEpochHeader is the epoch of the event feed, which changes when ipamd restarts
+	eventEpochHeader = "X-Event-Epoch"
+)
+
+// publishPoolAction publishes an action of the pool manager to the event feed
+func (c *IPAMContext) publishPoolAction(action string, eni string, err error, message string) {
+	event := datastore.Event{Type: datastore.EventPoolAction, Action: action, ENI: eni, Message: message}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	c.dataStore.PublishEvent(event)
+}
+
+// eventsV1RequestHandler streams the events of the data store as server-sent events. The stream resumes after the
+// sequence number of the since query parameter or of the Last-Event-ID header, otherwise it starts with the next
+// event. If the epoch query parameter is not the current epoch, or the events to resume from are no longer kept, it
+// returns 410 Gone and the client has to read /v1/pods and /v1/enis again.
+func eventsV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		epoch := ipam.dataStore.EventEpoch()
+		if requested := r.URL.Query().Get("epoch"); requested != "" && requested != epoch {
+			http.Error(w, fmt.Sprintf("epoch %s is over, the current epoch is %s", requested, epoch), http.StatusGone)
+			return
+		}
+		since := ipam.dataStore.LastEventSequence()
+		resume := r.URL.Query().Get("since")
+		if resume == "" {
+			resume = r.Header.Get("Last-Event-ID")
+		}
+		if resume != "" {
+			var err error
+			if since, err = strconv.ParseUint(resume, 10, 64); err != nil {
+				http.Error(w, fmt.Sprintf("invalid sequence number %q", resume), http.StatusBadRequest)
+				return
+			}
+		}
+
+		backlog, sub, err := ipam.dataStore.SubscribeEvents(since)
+		if err == datastore.ErrEventsLost {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set(eventEpochHeader, epoch)
+		w.WriteHeader(http.StatusOK)
+		for _, event := range backlog {
+			if !writeEvent(w, event) {
+				return
+			}
+		}
+		flusher.Flush()
+
+		keepalive := time.NewTicker(eventKeepaliveInterval)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event, ok := <-sub.Events:
+				if !ok {
+					// Dropped for falling behind, the client resumes from its last event
+					return
+				}
+				if !writeEvent(w, event) {
+					return
+				}
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes an event in the server-sent events format. It returns false if the client is gone.
+func writeEvent(w http.ResponseWriter, event datastore.Event) bool {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Failed to marshal event %d: %v", event.Sequence, err)
+		return false
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Sequence, event.Type, data)
+	return err == nil
+}
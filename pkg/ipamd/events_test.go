@@ -0,0 +1,92 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+// readEvent reads the next server-sent event of a stream
+func readEvent(t *testing.T, reader *bufio.Reader) (id string, event datastore.Event) {
+	for {
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return id, event
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+		}
+	}
+}
+
+func TestEventsV1RequestHandler(t *testing.T) {
+	c := &IPAMContext{dataStore: datastore.NewDataStore()}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	server := httptest.NewServer(http.HandlerFunc(eventsV1RequestHandler(c)))
+	defer server.Close()
+
+	// A new stream starts with the next event
+	resp, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	epoch := resp.Header.Get(eventEpochHeader)
+	assert.Equal(t, c.dataStore.EventEpoch(), epoch)
+
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	c.publishPoolAction(poolActionAllocIPs, primaryENIid, nil, "1 IPs on the ENI")
+	reader := bufio.NewReader(resp.Body)
+	id, event := readEvent(t, reader)
+	assert.Equal(t, "2", id)
+	assert.Equal(t, datastore.EventIPAdded, event.Type)
+	assert.Equal(t, ipaddr01, event.IP)
+	_, event = readEvent(t, reader)
+	assert.Equal(t, datastore.EventPoolAction, event.Type)
+	assert.Equal(t, poolActionAllocIPs, event.Action)
+
+	// Resume after the first event, with the Last-Event-ID header of a reconnecting EventSource
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"?epoch="+epoch, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resumed, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resumed.Body.Close()
+	id, event = readEvent(t, bufio.NewReader(resumed.Body))
+	assert.Equal(t, "2", id)
+	assert.Equal(t, datastore.EventIPAdded, event.Type)
+
+	for query, code := range map[string]int{
+		"?epoch=1":  http.StatusGone,
+		"?since=x":  http.StatusBadRequest,
+		"?since=99": http.StatusBadRequest,
+	} {
+		resp, err := http.Get(server.URL + query)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, code, resp.StatusCode, query)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+var (
+	// errPodNotFound and errSeveralPods are returned when the filter of an explain request doesn't select one pod
+	errPodNotFound = errors.New("no pod matches the filter")
+	errSeveralPods = errors.New("the filter matches several pods")
+)
+
+// explainPod finds the pod the filter selects, and follows its traffic to dst through the node
+func (c *IPAMContext) explainPod(filter datastore.AddressFilter, dst net.IP) (networkutils.PodPath, error) {
+	var pods []datastore.PodIPv4Address
+	for _, addr := range c.dataStore.GetPodIPv4Addresses() {
+		// The egress IPs have no namespace, and no veth
+		if addr.Namespace != "" && filter.MatchesPod(addr) {
+			pods = append(pods, addr)
+		}
+	}
+	if len(pods) == 0 {
+		return networkutils.PodPath{}, errPodNotFound
+	}
+	if len(pods) > 1 {
+		names := make([]string, len(pods))
+		for i, pod := range pods {
+			names[i] = pod.Namespace + "/" + pod.Name
+		}
+		return networkutils.PodPath{}, errors.Wrap(errSeveralPods, strings.Join(names, ", "))
+	}
+
+	pod := networkutils.PodNetwork{
+		IP:           net.ParseIP(pods[0].IP),
+		DeviceNumber: pods[0].DeviceNumber,
+		ENI:          pods[0].ENI,
+		ENIs:         make(map[string]string),
+	}
+	for _, cidr := range c.awsClient.GetVPCIPv4CIDRs() {
+		pod.VPCCIDRs = append(pod.VPCCIDRs, *cidr)
+	}
+	// The ENIs are the ones ipamd set up, rather than the ones IMDS and EC2 report right now
+	c.primaryIPLock.Lock()
+	for mac, eni := range c.eniMACs {
+		pod.ENIs[mac] = eni
+	}
+	c.primaryIPLock.Unlock()
+	return c.networkClient.ExplainPodPath(pod, dst)
+}
+
+// explainV1RequestHandler explains how the node routes the traffic of the pod selected by the namespace, pod and ip
+// query parameters to the dst address, and what differs from what the CNI plugin set up for the pod
+func explainV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAddressFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if filter.Pod == "" && filter.IP == "" {
+			http.Error(w, "pod or ip is required", http.StatusBadRequest)
+			return
+		}
+		value := r.URL.Query().Get("dst")
+		dst := net.ParseIP(value)
+		if dst == nil || dst.To4() == nil {
+			http.Error(w, "invalid dst \""+value+"\", must be an IPv4 address", http.StatusBadRequest)
+			return
+		}
+		path, err := ipam.explainPod(filter, dst)
+		switch {
+		case err == errPodNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Cause(err) == errSeveralPods:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			log.Errorf("Failed to explain the pod path: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, path, "pod path")
+	}
+}
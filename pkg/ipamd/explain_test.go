@@ -0,0 +1,73 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+func TestExplainV1RequestHandler(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+	c := &IPAMContext{awsClient: mockAWS, networkClient: mockNetwork, dataStore: datastore.NewDataStore(),
+		eniMACs: map[string]string{secMAC: secENIid}}
+	_ = c.dataStore.AddENI(secENIid, secDevice, false)
+	_ = c.dataStore.AddIPv4AddressToStore(secENIid, ipaddr11)
+	_ = c.dataStore.AddIPv4AddressToStore(secENIid, ipaddr12)
+	_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "a", Namespace: "default", Sandbox: "s1"})
+	_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "a", Namespace: "other", Sandbox: "s2"})
+	handler := explainV1RequestHandler(c)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder
+	}
+	assert.Equal(t, http.StatusBadRequest, get("/v1/explain?dst=8.8.8.8").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/v1/explain?pod=a&dst=example.com").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/v1/explain?pod=a&dst=8.8.8.8").Code)
+	assert.Equal(t, http.StatusNotFound, get("/v1/explain?namespace=default&pod=b&dst=8.8.8.8").Code)
+
+	pods := c.getPodRecords(datastore.AddressFilter{Namespace: "default", Pod: "a"})
+	assert.Len(t, pods, 1)
+	var podIP string
+	for _, pod := range pods {
+		podIP = pod.IP
+	}
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return([]*string{aws.String(vpcCIDR)})
+	mockNetwork.EXPECT().ExplainPodPath(networkutils.PodNetwork{
+		IP:           net.ParseIP(podIP),
+		DeviceNumber: secDevice,
+		ENI:          secENIid,
+		ENIs:         map[string]string{secMAC: secENIid},
+		VPCCIDRs:     []string{vpcCIDR},
+	}, net.ParseIP("8.8.8.8")).Return(networkutils.PodPath{PodIP: podIP, Destination: "8.8.8.8", SNAT: "10.10.10.10"}, nil)
+
+	recorder := get("/v1/explain?namespace=default&pod=a&dst=8.8.8.8")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var path networkutils.PodPath
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &path))
+	assert.Equal(t, "10.10.10.10", path.SNAT)
+}
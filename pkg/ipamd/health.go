@@ -0,0 +1,252 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+const (
+	// healthServiceName is the gRPC health service of ipamd. The status of the empty service name, which
+	// grpc-health-probe checks by default, is the same. Only the liveness checks set it, since the probes restart
+	// aws-node when it fails.
+	healthServiceName = "grpc.health.v1.aws-node"
+
+	// healthCheckInterval is how often the gRPC health status is updated
+	healthCheckInterval = 10 * time.Second
+
+	// endpointUnreachableTimeout is how long an AWS endpoint may fail to answer after its last answer before ipamd is
+	// not ready, so that a few timeouts don't fail the check
+	endpointUnreachableTimeout = 5 * time.Minute
+
+	healthCheckDatastore   = "datastore"
+	healthCheckIMDS        = "imds"
+	healthCheckEC2         = "ec2"
+	healthCheckIPPool      = "ip_pool"
+	healthCheckHostNetwork = "host_network"
+)
+
+// HealthCheck is the result of one of the checks of the health of ipamd
+type HealthCheck struct {
+	Name    string
+	Healthy bool
+	// Liveness is set for the checks that a restart of ipamd may fix. The others only make ipamd not ready, since a
+	// full node or an AWS outage is not fixed by restarting it.
+	Liveness bool
+	Message  string
+}
+
+// HealthStatus is the health of ipamd. It is healthy if all its liveness checks are, and ready if all its checks are.
+type HealthStatus struct {
+	Healthy   bool
+	Ready     bool
+	CheckedAt time.Time
+	Checks    []HealthCheck
+}
+
+// hostNetworkState is the result of the last reconcile of the host network
+type hostNetworkState struct {
+	lock    sync.Mutex
+	err     error
+	checked time.Time
+}
+
+func (s *hostNetworkState) set(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.err = err
+	s.checked = time.Now()
+}
+
+func (s *hostNetworkState) get() (time.Time, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.checked, s.err
+}
+
+// CheckHealth runs the health checks of ipamd
+func (c *IPAMContext) CheckHealth() HealthStatus {
+	now := time.Now()
+	status := HealthStatus{
+		CheckedAt: now,
+		Checks: []HealthCheck{
+			c.checkDatastore(),
+			checkEndpoint(healthCheckIMDS, awsutils.GetEndpointHealth(awsutils.EndpointIMDS), now),
+			checkEndpoint(healthCheckEC2, awsutils.GetEndpointHealth(awsutils.EndpointEC2), now),
+			c.checkIPPool(),
+			c.checkHostNetwork(),
+		},
+	}
+	status.Healthy, status.Ready = true, true
+	for _, check := range status.Checks {
+		status.Ready = status.Ready && check.Healthy
+		if check.Liveness {
+			status.Healthy = status.Healthy && check.Healthy
+		}
+	}
+	return status
+}
+
+func (c *IPAMContext) checkDatastore() HealthCheck {
+	check := HealthCheck{Name: healthCheckDatastore, Liveness: true}
+	if c.dataStore == nil {
+		check.Message = "the datastore is not initialized"
+		return check
+	}
+	enis := c.dataStore.GetENIs()
+	if enis == 0 {
+		check.Message = "the datastore has no ENIs"
+		return check
+	}
+	check.Healthy = true
+	check.Message = fmt.Sprintf("%d ENIs", enis)
+	return check
+}
+
+// checkEndpoint fails if the last call to an AWS endpoint got no answer, and the last answer is older than
+// endpointUnreachableTimeout. An endpoint that wasn't called yet is healthy.
+func checkEndpoint(name string, endpoint awsutils.EndpointHealth, now time.Time) HealthCheck {
+	check := HealthCheck{Name: name, Healthy: true}
+	switch {
+	case endpoint.LastFailure.IsZero() && endpoint.LastResponse.IsZero():
+		check.Message = "not called yet"
+	case endpoint.LastFailure.Before(endpoint.LastResponse):
+		check.Message = fmt.Sprintf("last answered at %s", endpoint.LastResponse.Format(time.RFC3339))
+	case now.Sub(endpoint.LastResponse) <= endpointUnreachableTimeout:
+		check.Message = fmt.Sprintf("last answered at %s, failed since: %s", endpoint.LastResponse.Format(time.RFC3339), endpoint.LastError)
+	default:
+		check.Healthy = false
+		check.Message = fmt.Sprintf("no answer for more than %v: %s", endpointUnreachableTimeout, endpoint.LastError)
+	}
+	return check
+}
+
+// checkIPPool fails if there are no free IPs and the pool can't grow, because the ENIs are full and no ENI can be
+// attached, or because the circuit of the pool increase is open. The pool can grow until the pool policy decided
+// once.
+func (c *IPAMContext) checkIPPool() HealthCheck {
+	check := HealthCheck{Name: healthCheckIPPool}
+	if c.dataStore == nil {
+		check.Message = "the datastore is not initialized"
+		return check
+	}
+	total, assigned := c.dataStore.GetStats()
+	if free := total - assigned; free > 0 {
+		check.Healthy = true
+		check.Message = fmt.Sprintf("%d free IPs", free)
+		return check
+	}
+	if circuit := c.poolBackoff.getState().CircuitState; circuit == circuitOpen.String() {
+		check.Message = "no free IPs, and the circuit of the pool increase is open"
+		return check
+	}
+	// The limits of the last decision of the pool policy, which are updated by the pool manager
+	limits := c.GetPoolPolicyState().Limits
+	enis := c.dataStore.GetENIs()
+	if limits.MaxIPsPerENI == 0 || enis < limits.MaxENIs || total < enis*limits.MaxIPsPerENI {
+		check.Healthy = true
+		check.Message = "no free IPs, the pool can grow"
+		return check
+	}
+	check.Message = fmt.Sprintf("no free IPs, and the %d ENIs are full", enis)
+	return check
+}
+
+func (c *IPAMContext) checkHostNetwork() HealthCheck {
+	check := HealthCheck{Name: healthCheckHostNetwork, Healthy: true, Liveness: true}
+	checked, err := c.hostNetwork.get()
+	switch {
+	case err != nil:
+		check.Healthy = false
+		check.Message = fmt.Sprintf("reconcile failed at %s: %v", checked.Format(time.RFC3339), err)
+	case checked.IsZero():
+		check.Message = "set up"
+	default:
+		check.Message = fmt.Sprintf("reconciled at %s", checked.Format(time.RFC3339))
+	}
+	return check
+}
+
+// servingStatus converts the health of ipamd to a gRPC health status
+func servingStatus(healthy bool) healthpb.HealthCheckResponse_ServingStatus {
+	if healthy {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+// logHealth logs the failed health checks
+func logHealth(status HealthStatus) {
+	if status.Ready {
+		log.Infof("ipamd is healthy and ready")
+		return
+	}
+	for _, check := range status.Checks {
+		switch {
+		case check.Healthy:
+		case check.Liveness:
+			log.Warnf("ipamd is unhealthy, the %s check failed: %s", check.Name, check.Message)
+		default:
+			log.Warnf("ipamd is not ready, the %s check failed: %s", check.Name, check.Message)
+		}
+	}
+}
+
+// setHealth sets the gRPC health status, which is sent to the Watch streams
+func setHealth(hs *health.Server, healthy bool) {
+	hs.SetServingStatus("", servingStatus(healthy))
+	hs.SetServingStatus(healthServiceName, servingStatus(healthy))
+}
+
+// updateHealth runs the health checks, logs them if ipamd became healthy, unhealthy, ready or not ready since the
+// last status, and sets the gRPC health status if it changed. It returns the new status.
+func (c *IPAMContext) updateHealth(hs *health.Server, last HealthStatus) HealthStatus {
+	status := c.CheckHealth()
+	if status.Healthy != last.Healthy || status.Ready != last.Ready {
+		logHealth(status)
+	}
+	if status.Healthy != last.Healthy {
+		setHealth(hs, status.Healthy)
+	}
+	return status
+}
+
+// watchHealth updates the gRPC health status every healthCheckInterval
+func (c *IPAMContext) watchHealth(hs *health.Server, status HealthStatus) {
+	for range time.Tick(healthCheckInterval) {
+		status = c.updateHealth(hs, status)
+	}
+}
+
+// healthzHandler returns the health checks, with a 503 status code if ipamd is unhealthy. The checks that only make
+// ipamd not ready are in the body.
+func healthzHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := ipam.CheckHealth()
+		code := http.StatusOK
+		if !status.Healthy {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSONWithStatus(w, r, code, status, "health status")
+	}
+}
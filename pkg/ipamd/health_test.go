@@ -0,0 +1,180 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+func TestCheckEndpoint(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		endpoint awsutils.EndpointHealth
+		healthy  bool
+	}{
+		{"not called", awsutils.EndpointHealth{}, true},
+		{"answered", awsutils.EndpointHealth{LastResponse: now}, true},
+		{"answered after a failure", awsutils.EndpointHealth{LastResponse: now, LastFailure: now.Add(-time.Minute)}, true},
+		{"failing for a minute", awsutils.EndpointHealth{LastResponse: now.Add(-time.Minute), LastFailure: now}, true},
+		{"failing for too long", awsutils.EndpointHealth{LastResponse: now.Add(-time.Hour), LastFailure: now}, false},
+		{"never answered", awsutils.EndpointHealth{LastFailure: now}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.healthy, checkEndpoint("ec2", tt.endpoint, now).Healthy)
+		})
+	}
+}
+
+func newHealthTestContext() *IPAMContext {
+	c := &IPAMContext{dataStore: datastore.NewDataStore()}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	return c
+}
+
+// failedChecks returns the names of the failed health checks
+func failedChecks(status HealthStatus) []string {
+	var failed []string
+	for _, check := range status.Checks {
+		if !check.Healthy {
+			failed = append(failed, check.Name)
+		}
+	}
+	return failed
+}
+
+func TestCheckHealth(t *testing.T) {
+	assert.Equal(t, []string{healthCheckDatastore, healthCheckIPPool}, failedChecks((&IPAMContext{}).CheckHealth()))
+	assert.False(t, (&IPAMContext{}).CheckHealth().Healthy)
+
+	c := newHealthTestContext()
+	assert.True(t, c.CheckHealth().Healthy)
+	assert.True(t, c.CheckHealth().Ready)
+
+	// No free IPs, but the pool can grow until the pool policy decided
+	_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod", Namespace: "default"})
+	assert.True(t, c.CheckHealth().Healthy)
+	c.poolPolicyState.Limits = PoolLimits{MaxENIs: 2, MaxIPsPerENI: 2}
+	assert.True(t, c.CheckHealth().Healthy)
+	c.poolPolicyState.Limits = PoolLimits{MaxENIs: 1, MaxIPsPerENI: 1}
+	assert.Equal(t, []string{healthCheckIPPool}, failedChecks(c.CheckHealth()))
+	// A full node is not ready, but restarting ipamd doesn't help it
+	assert.True(t, c.CheckHealth().Healthy)
+	assert.False(t, c.CheckHealth().Ready)
+
+	c.poolPolicyState.Limits = PoolLimits{MaxENIs: 2, MaxIPsPerENI: 1}
+	c.poolBackoff = newPoolBackoff()
+	for i := 0; i < circuitBreakerThreshold; i++ {
+		c.poolBackoff.failure(time.Now(), errors.New("failed"))
+	}
+	assert.Equal(t, []string{healthCheckIPPool}, failedChecks(c.CheckHealth()))
+	c.poolBackoff.success()
+
+	c.hostNetwork.set(errors.New("iptables failure"))
+	assert.Equal(t, []string{healthCheckHostNetwork}, failedChecks(c.CheckHealth()))
+	assert.False(t, c.CheckHealth().Healthy)
+	assert.False(t, c.CheckHealth().Ready)
+	c.hostNetwork.set(nil)
+	assert.True(t, c.CheckHealth().Healthy)
+}
+
+func TestHealthWatch(t *testing.T) {
+	c := newHealthTestContext()
+	hs := health.NewServer()
+	setHealth(hs, false)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, hs)
+	go func() { _ = s.Serve(ln) }()
+	defer s.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{Service: healthServiceName})
+	assert.NoError(t, err)
+	resp, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	status := c.updateHealth(hs, HealthStatus{})
+	assert.True(t, status.Healthy)
+	resp, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	c.hostNetwork.set(errors.New("iptables failure"))
+	status = c.updateHealth(hs, status)
+	assert.False(t, status.Healthy)
+	resp, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	// The default service has the same status
+	check, err := hs.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check.Status)
+}
+
+func TestHealthzHandler(t *testing.T) {
+	c := newHealthTestContext()
+
+	recorder := httptest.NewRecorder()
+	healthzHandler(c)(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// The readiness checks are in the body only
+	c.poolPolicyState.Limits = PoolLimits{MaxENIs: 1, MaxIPsPerENI: 1}
+	_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod", Namespace: "default"})
+	recorder = httptest.NewRecorder()
+	healthzHandler(c)(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var status HealthStatus
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.True(t, status.Healthy)
+	assert.False(t, status.Ready)
+	assert.Equal(t, []string{healthCheckIPPool}, failedChecks(status))
+	c.poolPolicyState.Limits = PoolLimits{}
+
+	c.hostNetwork.set(errors.New("iptables failure"))
+	recorder = httptest.NewRecorder()
+	healthzHandler(c)(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	status = HealthStatus{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.False(t, status.Healthy)
+	assert.Equal(t, []string{healthCheckHostNetwork}, failedChecks(status))
+}
@@ -18,13 +18,16 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
 
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/retry"
 )
@@ -38,6 +41,16 @@ const (
 
 	// Environment variable to disable the introspection endpoints
 	envDisableIntrospection = "DISABLE_INTROSPECTION"
+
+	// totalCountHeader is the number of entries the filter of a paged endpoint selects
+	totalCountHeader = "X-Total-Count"
+
+	// introspectionTimeout is how long reading a request's headers and serving a non-streaming endpoint may take
+	introspectionTimeout = 5 * time.Second
+
+	// adminTimeout is how long an admin endpoint may take. The admin actions wait for the ENIs to be set up and for
+	// the IP pool manager, and then call EC2.
+	adminTimeout = 2 * time.Minute
 )
 
 type rootResponse struct {
@@ -89,16 +102,42 @@ func (c *IPAMContext) ServeIntrospection() {
 
 func (c *IPAMContext) setupIntrospectionServer() *http.Server {
 	serverFunctions := map[string]func(w http.ResponseWriter, r *http.Request){
+		"/healthz":                      healthzHandler(c),
 		"/v1/enis":                      eniV1RequestHandler(c),
 		"/v1/eni-configs":               eniConfigRequestHandler(c),
 		"/v1/pods":                      podV1RequestHandler(c),
+		"/v1/egress-ips":                egressIPV1RequestHandler(c),
+		"/v1/pod-eips":                  podEIPV1RequestHandler(c),
+		"/v1/pool-backoff":              poolBackoffV1RequestHandler(c),
+		"/v1/pool-policy":               poolPolicyV1RequestHandler(c),
+		"/v1/warm-pool-schedule":        warmPoolScheduleV1RequestHandler(c),
+		"/v1/namespace-ips":             namespaceIPsV1RequestHandler(c),
+		"/v1/consistency":               consistencyV1RequestHandler(c),
+		"/v1/explain":                   explainV1RequestHandler(c),
 		"/v1/networkutils-env-settings": networkEnvV1RequestHandler(),
 		"/v1/ipamd-env-settings":        ipamdEnvV1RequestHandler(),
 	}
-	paths := make([]string, 0, len(serverFunctions))
+	// The admin endpoints are cut off after adminTimeout instead
+	adminFunctions := map[string]func(w http.ResponseWriter, r *http.Request){
+		"/v1/admin/reconcile":  adminHandler("reconcile", c.adminReconcile),
+		"/v1/admin/release-ip": adminHandler("release-ip", c.adminReleaseIP),
+		"/v1/admin/drain-eni":  adminHandler("drain-eni", c.adminDrainENI),
+		"/v1/admin/log-level":  adminHandler("log-level", c.adminLogLevel),
+	}
+	// The streaming endpoints are not cut off after introspectionTimeout
+	streamingFunctions := map[string]func(w http.ResponseWriter, r *http.Request){
+		"/v1/events": eventsV1RequestHandler(c),
+	}
+	paths := make([]string, 0, len(serverFunctions)+len(adminFunctions)+len(streamingFunctions))
 	for path := range serverFunctions {
 		paths = append(paths, path)
 	}
+	for path := range adminFunctions {
+		paths = append(paths, path)
+	}
+	for path := range streamingFunctions {
+		paths = append(paths, path)
+	}
 	availableCommands := &rootResponse{paths}
 	// Autogenerated list of the above serverFunctions paths
 	availableCommandResponse, err := json.Marshal(&availableCommands)
@@ -113,6 +152,12 @@ func (c *IPAMContext) setupIntrospectionServer() *http.Server {
 	serveMux := http.NewServeMux()
 	serveMux.HandleFunc("/", defaultHandler)
 	for key, fn := range serverFunctions {
+		serveMux.Handle(key, http.TimeoutHandler(http.HandlerFunc(fn), introspectionTimeout, ""))
+	}
+	for key, fn := range adminFunctions {
+		serveMux.Handle(key, http.TimeoutHandler(http.HandlerFunc(fn), adminTimeout, ""))
+	}
+	for key, fn := range streamingFunctions {
 		serveMux.HandleFunc(key, fn)
 	}
 
@@ -128,71 +173,197 @@ func (c *IPAMContext) setupIntrospectionServer() *http.Server {
 	log.Info("Serving introspection endpoints on ", addr)
 
 	server := &http.Server{
-		Addr:         addr,
-		Handler:      loggingServeMux,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+		Addr:              addr,
+		Handler:           loggingServeMux,
+		ReadHeaderTimeout: introspectionTimeout,
+		ConnContext:       peerCredentials,
 	}
 	return server
 }
 
 func eniV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		responseJSON, err := json.Marshal(ipam.dataStore.GetENIInfos())
+		filter, err := parseAddressFilter(r)
 		if err != nil {
-			log.Errorf("Failed to marshal ENI data: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		logErr(w.Write(responseJSON))
+		writeJSON(w, r, ipam.dataStore.GetENIRecords(filter), "ENI data")
 	}
 }
 
 func podV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		responseJSON, err := json.Marshal(ipam.dataStore.GetPodInfos())
+		filter, err := parseAddressFilter(r)
 		if err != nil {
-			log.Errorf("Failed to marshal pod data: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		logErr(w.Write(responseJSON))
+		offset, limit, err := parsePage(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pods := ipam.getPodRecords(filter)
+		keys := make([]string, 0, len(pods))
+		for key := range pods {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		w.Header().Set(totalCountHeader, strconv.Itoa(len(keys)))
+		keys = keys[min(offset, len(keys)):]
+		if limit > 0 && limit < len(keys) {
+			keys = keys[:limit]
+		}
+		page := make(map[string]datastore.PodIPv4Address, len(keys))
+		for _, key := range keys {
+			page[key] = pods[key]
+		}
+		writeJSON(w, r, page, "pod data")
 	}
 }
 
-func eniConfigRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		responseJSON, err := json.Marshal(ipam.eniConfig.Getter())
+// getPodRecords returns the pods the filter selects, keyed by name_namespace_sandbox. The UIDs come from the informer
+// cache, so the pods it hasn't seen yet have none.
+func (c *IPAMContext) getPodRecords(filter datastore.AddressFilter) map[string]datastore.PodIPv4Address {
+	pods := make(map[string]datastore.PodIPv4Address)
+	for _, addr := range c.dataStore.GetPodIPv4Addresses() {
+		if !filter.MatchesPod(addr) {
+			continue
+		}
+		if c.k8sClient != nil && addr.Namespace != "" {
+			if pod, err := c.k8sClient.K8SGetLocalPod(addr.Namespace, addr.Name); err == nil {
+				addr.UID = pod.UID
+			}
+		}
+		pods[addr.Name+"_"+addr.Namespace+"_"+addr.Sandbox] = addr
+	}
+	return pods
+}
+
+// parseAddressFilter returns the filter of the namespace, pod, eni, ip and assigned query parameters
+func parseAddressFilter(r *http.Request) (datastore.AddressFilter, error) {
+	query := r.URL.Query()
+	filter := datastore.AddressFilter{
+		Namespace: query.Get("namespace"),
+		Pod:       query.Get("pod"),
+		ENI:       query.Get("eni"),
+		IP:        query.Get("ip"),
+	}
+	if value := query.Get("assigned"); value != "" {
+		assigned, err := strconv.ParseBool(value)
 		if err != nil {
-			log.Errorf("Failed to marshal ENI config: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
+			return filter, errors.Errorf("invalid assigned %q, must be true or false", value)
+		}
+		filter.Assigned = &assigned
+	}
+	return filter, nil
+}
+
+// parsePage returns the offset and limit query parameters. A limit of 0 returns all the entries after the offset.
+func parsePage(r *http.Request) (offset int, limit int, err error) {
+	query := r.URL.Query()
+	for name, value := range map[string]*int{"offset": &offset, "limit": &limit} {
+		str := query.Get(name)
+		if str == "" {
+			continue
+		}
+		if *value, err = strconv.Atoi(str); err != nil || *value < 0 {
+			return 0, 0, errors.Errorf("invalid %s %q, must be a number of entries", name, str)
 		}
-		logErr(w.Write(responseJSON))
+	}
+	return offset, limit, nil
+}
+
+// writeJSON writes the response of an introspection endpoint. It is indented if the request has the pretty query
+// parameter.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}, what string) {
+	writeJSONWithStatus(w, r, http.StatusOK, v, what)
+}
+
+// writeJSONWithStatus is writeJSON with a status code other than 200
+func writeJSONWithStatus(w http.ResponseWriter, r *http.Request, code int, v interface{}, what string) {
+	var responseJSON []byte
+	var err error
+	if prettyRequested(r) {
+		responseJSON, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		responseJSON, err = json.Marshal(v)
+	}
+	if err != nil {
+		log.Errorf("Failed to marshal %s: %v", what, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	logErr(w.Write(responseJSON))
+}
+
+// prettyRequested returns whether the request has the pretty query parameter, without a value or with a true one
+func prettyRequested(r *http.Request) bool {
+	values, ok := r.URL.Query()["pretty"]
+	if !ok {
+		return false
+	}
+	if values[0] == "" {
+		return true
+	}
+	pretty, _ := strconv.ParseBool(values[0])
+	return pretty
+}
+
+func egressIPV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, ipam.GetEgressIPs(), "egress IP data")
+	}
+}
+
+func podEIPV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, ipam.GetPodEIPs(), "pod Elastic IP data")
+	}
+}
+
+func poolBackoffV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, ipam.GetPoolBackoffState(), "pool backoff data")
+	}
+}
+
+func poolPolicyV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, ipam.GetPoolPolicyState(), "pool policy data")
+	}
+}
+
+func warmPoolScheduleV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, ipam.GetWarmPoolScheduleState(), "warm pool schedule data")
+	}
+}
+
+func namespaceIPsV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, ipam.dataStore.GetNamespaceIPUsage(), "namespace IP data")
+	}
+}
+
+func eniConfigRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, ipam.eniConfig.Getter(), "ENI config")
 	}
 }
 
 func networkEnvV1RequestHandler() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		responseJSON, err := json.Marshal(networkutils.GetConfigForDebug())
-		if err != nil {
-			log.Errorf("Failed to marshal network env var data: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-		logErr(w.Write(responseJSON))
+		writeJSON(w, r, networkutils.GetConfigForDebug(), "network env var data")
 	}
 }
 
 func ipamdEnvV1RequestHandler() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		responseJSON, err := json.Marshal(GetConfigForDebug())
-		if err != nil {
-			log.Errorf("Failed to marshal ipamd env var data: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-		logErr(w.Write(responseJSON))
+		writeJSON(w, r, GetConfigForDebug(), "ipamd env var data")
 	}
 }
 
@@ -0,0 +1,113 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+func newIntrospectionContext(k8sClient k8sapi.K8SAPIs) *IPAMContext {
+	c := &IPAMContext{k8sClient: k8sClient, dataStore: datastore.NewDataStore()}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr03)
+	_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "web-1", Namespace: "default", Sandbox: "s1"})
+	_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "dns", Namespace: "kube-system", Sandbox: "s2"})
+	return c
+}
+
+func introspect(t *testing.T, handler func(http.ResponseWriter, *http.Request), target string, v interface{}) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	if recorder.Code == http.StatusOK && v != nil {
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), v))
+	}
+	return recorder
+}
+
+func TestPodV1RequestHandler(t *testing.T) {
+	ctrl, _, mockK8S, _, _, _ := setup(t)
+	defer ctrl.Finish()
+	mockK8S.EXPECT().K8SGetLocalPod("default", "web-1").Return(&k8sapi.K8SPodInfo{UID: "uid-1"}, nil).AnyTimes()
+	mockK8S.EXPECT().K8SGetLocalPod("kube-system", "dns").Return(nil, k8sapi.ErrPodNotFound).AnyTimes()
+	handler := podV1RequestHandler(newIntrospectionContext(mockK8S))
+
+	var pods map[string]datastore.PodIPv4Address
+	recorder := introspect(t, handler, "/v1/pods", &pods)
+	assert.Equal(t, "2", recorder.Header().Get(totalCountHeader))
+	assert.Len(t, pods, 2)
+	web := pods["web-1_default_s1"]
+	assert.Equal(t, primaryENIid, web.ENI)
+	assert.Equal(t, "uid-1", web.UID)
+	assert.False(t, web.AssignedTime.IsZero())
+	assert.Equal(t, "", pods["dns_kube-system_s2"].UID)
+
+	pods = nil
+	introspect(t, handler, "/v1/pods?namespace=kube-system", &pods)
+	assert.Len(t, pods, 1)
+	assert.Contains(t, pods, "dns_kube-system_s2")
+
+	pods = nil
+	introspect(t, handler, "/v1/pods?assigned=false", &pods)
+	assert.Empty(t, pods)
+
+	// The pages follow the sorted keys
+	pods = nil
+	recorder = introspect(t, handler, "/v1/pods?offset=1&limit=1", &pods)
+	assert.Equal(t, "2", recorder.Header().Get(totalCountHeader))
+	assert.Len(t, pods, 1)
+	assert.Contains(t, pods, "web-1_default_s1")
+
+	assert.Equal(t, http.StatusBadRequest, introspect(t, handler, "/v1/pods?limit=-1", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, introspect(t, handler, "/v1/pods?assigned=maybe", nil).Code)
+}
+
+func TestENIV1RequestHandler(t *testing.T) {
+	handler := eniV1RequestHandler(newIntrospectionContext(nil))
+
+	var enis datastore.ENIRecords
+	introspect(t, handler, "/v1/enis?assigned=false", &enis)
+	assert.Equal(t, 3, enis.TotalIPs)
+	assert.Equal(t, 2, enis.AssignedIPs)
+	assert.Len(t, enis.ENIIPPools[primaryENIid].IPv4Addresses, 1)
+
+	enis = datastore.ENIRecords{}
+	introspect(t, handler, "/v1/enis?pod=dns", &enis)
+	assert.Len(t, enis.ENIIPPools[primaryENIid].IPv4Addresses, 1)
+	for _, addr := range enis.ENIIPPools[primaryENIid].IPv4Addresses {
+		assert.Equal(t, "kube-system", addr.PodNamespace)
+	}
+
+	enis = datastore.ENIRecords{}
+	introspect(t, handler, "/v1/enis?eni=eni-unknown", &enis)
+	assert.Empty(t, enis.ENIIPPools)
+}
+
+func TestWriteJSONPretty(t *testing.T) {
+	handler := poolBackoffV1RequestHandler(&IPAMContext{})
+	assert.False(t, strings.Contains(introspect(t, handler, "/v1/pool-backoff", nil).Body.String(), "\n"))
+	assert.True(t, strings.Contains(introspect(t, handler, "/v1/pool-backoff?pretty", nil).Body.String(), "\n  "))
+	assert.True(t, strings.Contains(introspect(t, handler, "/v1/pool-backoff?pretty=true", nil).Body.String(), "\n  "))
+	assert.False(t, strings.Contains(introspect(t, handler, "/v1/pool-backoff?pretty=false", nil).Body.String(), "\n"))
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+)
+
+// This environment variable is used to specify whether ipamd enforces the per-namespace IP quotas of the IPQuota
+// CRDs. Default is false.
+const envIPQuotas = "AWS_VPC_K8S_CNI_IP_QUOTAS"
+
+// UseIPQuotas returns whether the IP quotas of the IPQuota CRDs are enforced
+func UseIPQuotas() bool {
+	if strValue := os.Getenv(envIPQuotas); strValue != "" {
+		parsedValue, err := strconv.ParseBool(strValue)
+		if err == nil {
+			return parsedValue
+		}
+		log.Error("Failed to parse "+envIPQuotas+"; using default: false", err.Error())
+	}
+	return false
+}
+
+// syncIPQuotas applies the IPQuotas to the data store
+func (c *IPAMContext) syncIPQuotas() {
+	if !c.useIPQuotas {
+		return
+	}
+	c.dataStore.SetNamespaceQuotas(namespaceQuotas(c.eniConfig.IPQuotas()))
+}
+
+// namespaceQuotas returns the lowest limit of the IPQuotas of each namespace
+func namespaceQuotas(ipQuotas []v1alpha1.IPQuota) map[string]int {
+	quotas := make(map[string]int)
+	for _, ipQuota := range ipQuotas {
+		if ipQuota.Spec.MaxIPsPerNode < 0 {
+			log.Warnf("Ignoring IPQuota %s with a negative maxIPsPerNode", ipQuota.Name)
+			continue
+		}
+		for _, namespace := range ipQuota.Spec.Namespaces {
+			if quota, ok := quotas[namespace]; !ok || ipQuota.Spec.MaxIPsPerNode < quota {
+				quotas[namespace] = ipQuota.Spec.MaxIPsPerNode
+			}
+		}
+	}
+	return quotas
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+func TestSyncIPQuotas(t *testing.T) {
+	ctrl, _, _, _, _, mockENIConfig := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		eniConfig:   mockENIConfig,
+		dataStore:   datastore.NewDataStore(),
+		useIPQuotas: true,
+	}
+	mockENIConfig.EXPECT().IPQuotas().Return([]v1alpha1.IPQuota{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "batch"},
+			Spec:       v1alpha1.IPQuotaSpec{Namespaces: []string{"batch", "team-a"}, MaxIPsPerNode: 5},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+			Spec:       v1alpha1.IPQuotaSpec{Namespaces: []string{"batch"}, MaxIPsPerNode: -1},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "teams"},
+			Spec:       v1alpha1.IPQuotaSpec{Namespaces: []string{"team-a", "team-b"}, MaxIPsPerNode: 20},
+		},
+	})
+
+	// The lowest quota of a namespace applies
+	mockContext.syncIPQuotas()
+	assert.Equal(t, []datastore.NamespaceIPUsage{
+		{Namespace: "batch", MaxIPs: 5},
+		{Namespace: "team-a", MaxIPs: 5},
+		{Namespace: "team-b", MaxIPs: 20},
+	}, mockContext.dataStore.GetNamespaceIPUsage())
+
+	// Nothing is synced without AWS_VPC_K8S_CNI_IP_QUOTAS
+	mockContext.useIPQuotas = false
+	mockContext.syncIPQuotas()
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// This environment variable is used to specify the number of free IPs that only the pods of the reserved priority
+	// classes and namespaces are assigned. The warm pool targets apply on top of the reserve. Default is 0.
+	envIPReserve = "AWS_VPC_K8S_CNI_IP_RESERVE"
+
+	// This environment variable is used to specify the comma separated priority classes of the pods that may use the
+	// IP reserve. Default is "system-node-critical,system-cluster-critical".
+	envIPReservePriorityClasses     = "AWS_VPC_K8S_CNI_IP_RESERVE_PRIORITY_CLASSES"
+	defaultIPReservePriorityClasses = "system-node-critical,system-cluster-critical"
+
+	// This environment variable is used to specify the comma separated namespaces of the pods that may use the IP
+	// reserve. Default is none.
+	envIPReserveNamespaces = "AWS_VPC_K8S_CNI_IP_RESERVE_NAMESPACES"
+)
+
+// getIPReserve returns the size of the IP reserve
+func getIPReserve() int {
+	inputStr, found := os.LookupEnv(envIPReserve)
+	if !found {
+		return 0
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envIPReserve, input)
+		return input
+	}
+	log.Errorf("Invalid %s %q, not reserving IPs", envIPReserve, inputStr)
+	return 0
+}
+
+// getIPReserveSet returns the set of the comma separated values of an environment variable
+func getIPReserveSet(envName string, def string) map[string]bool {
+	inputStr, found := os.LookupEnv(envName)
+	if !found {
+		inputStr = def
+	}
+	set := make(map[string]bool)
+	for _, value := range strings.Split(inputStr, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			set[value] = true
+		}
+	}
+	return set
+}
+
+// ipReserveFilter decides which pods may use the IP reserve
+type ipReserveFilter struct {
+	priorityClasses map[string]bool
+	namespaces      map[string]bool
+}
+
+func newIPReserveFilter() *ipReserveFilter {
+	return &ipReserveFilter{
+		priorityClasses: getIPReserveSet(envIPReservePriorityClasses, defaultIPReservePriorityClasses),
+		namespaces:      getIPReserveSet(envIPReserveNamespaces, ""),
+	}
+}
+
+// canUseIPReserve returns whether the pod is in a reserved namespace or has a reserved priority class. It is called
+// with the data store locked, so the priority class must already be in the pod info.
+func (f *ipReserveFilter) canUseIPReserve(k8sPod *k8sapi.K8SPodInfo) bool {
+	return f.namespaces[k8sPod.Namespace] || f.priorityClasses[k8sPod.PriorityClassName]
+}
+
+// setupIPReserve configures the IP reserve of the data store
+func (c *IPAMContext) setupIPReserve() {
+	reserve := getIPReserve()
+	if reserve == 0 {
+		return
+	}
+	filter := newIPReserveFilter()
+	log.Infof("Reserving %d IPs for the priority classes %v and the namespaces %v", reserve, filter.priorityClasses, filter.namespaces)
+	c.dataStore.SetIPReserve(reserve, filter.canUseIPReserve)
+}
+
+// getPodPriorityClass returns the priority class of a pod for the IP reserve, or "" if there is no reserve. It comes
+// from the informer cache, so a pod the informer hasn't seen yet only gets the reserve by its namespace.
+func (c *IPAMContext) getPodPriorityClass(namespace, name string) string {
+	if reserve, _ := c.dataStore.GetIPReserve(); reserve == 0 {
+		return ""
+	}
+	pod, err := c.k8sClient.K8SGetLocalPod(namespace, name)
+	if err != nil {
+		log.Debugf("No priority class for pod %s/%s to check the IP reserve: %v", namespace, name, err)
+		return ""
+	}
+	return pod.PriorityClassName
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+func TestIPReserveFilter(t *testing.T) {
+	defer os.Unsetenv(envIPReserveNamespaces)
+	_ = os.Setenv(envIPReserveNamespaces, "kube-system, monitoring")
+
+	filter := newIPReserveFilter()
+	assert.True(t, filter.canUseIPReserve(&k8sapi.K8SPodInfo{Name: "node-exporter", Namespace: "monitoring"}))
+	assert.True(t, filter.canUseIPReserve(&k8sapi.K8SPodInfo{Name: "critical", Namespace: "default",
+		PriorityClassName: "system-node-critical"}))
+	assert.False(t, filter.canUseIPReserve(&k8sapi.K8SPodInfo{Name: "app", Namespace: "default",
+		PriorityClassName: "high"}))
+	assert.False(t, filter.canUseIPReserve(&k8sapi.K8SPodInfo{Name: "new", Namespace: "default"}))
+}
+
+func TestGetPodPriorityClass(t *testing.T) {
+	ctrl, _, mockK8S, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{k8sClient: mockK8S, dataStore: datastore.NewDataStore()}
+	// Without a reserve the informer isn't asked
+	assert.Equal(t, "", mockContext.getPodPriorityClass("default", "critical"))
+
+	mockContext.dataStore.SetIPReserve(1, newIPReserveFilter().canUseIPReserve)
+	mockK8S.EXPECT().K8SGetLocalPod("default", "critical").Return(
+		&k8sapi.K8SPodInfo{Name: "critical", Namespace: "default", PriorityClassName: "system-node-critical"}, nil)
+	assert.Equal(t, "system-node-critical", mockContext.getPodPriorityClass("default", "critical"))
+
+	mockK8S.EXPECT().K8SGetLocalPod("default", "new").Return(nil, k8sapi.ErrPodNotFound)
+	assert.Equal(t, "", mockContext.getPodPriorityClass("default", "new"))
+}
+
+func TestIPReservePoolStats(t *testing.T) {
+	mockContext := &IPAMContext{
+		maxIPsPerENI: 14,
+		maxENI:       4,
+		warmIPTarget: 2,
+		dataStore:    datastore.NewDataStore(),
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	mockContext.dataStore.SetIPReserve(3, nil)
+
+	// The reserve is kept on top of the warm IPs
+	assert.Equal(t, PoolStats{TotalIPs: 2, AssignedIPs: 3, ENIs: 1}, mockContext.poolStats())
+	actions, _ := mockContext.poolActions()
+	assert.Equal(t, 3, actions.AddIPs)
+}
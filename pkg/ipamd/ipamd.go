@@ -28,6 +28,7 @@ import (
 	log "github.com/cihub/seelog"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
 	"k8s.io/apimachinery/pkg/util/sets"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
@@ -46,7 +47,10 @@ const (
 	ipPoolMonitorInterval       = 5 * time.Second
 	maxRetryCheckENI            = 5
 	eniAttachTime               = 10 * time.Second
+	eniAttachTimeout            = maxRetryCheckENI * eniAttachTime
+	eniMetadataRetryInterval    = 1 * time.Second
 	nodeIPPoolReconcileInterval = 60 * time.Second
+	vpcCIDRRefreshInterval      = 60 * time.Second
 	decreaseIPPoolInterval      = 30 * time.Second
 	maxK8SRetries               = 5
 	retryK8SInterval            = 3 * time.Second
@@ -106,6 +110,20 @@ const (
 	// When it is NOT set or set to false, ipamd will use primary interface security group and subnet for Pod network.
 	envCustomNetworkCfg = "AWS_VPC_K8S_CNI_CUSTOM_NETWORK_CFG"
 
+	// This environment is used to specify whether ipamd associates Elastic IPs with the pods that ask for one with the
+	// "k8s.amazonaws.com/eip-allocation-id" or "k8s.amazonaws.com/eip-pool" annotation. Default is false.
+	envPodEIP = "AWS_VPC_K8S_CNI_POD_EIP"
+
+	// This environment variable is used to specify how many of the attached ENIs are set up at the same time when ipamd
+	// starts. Default is 4.
+	envENISetupConcurrency     = "AWS_VPC_K8S_CNI_ENI_SETUP_CONCURRENCY"
+	defaultENISetupConcurrency = 4
+
+	// This environment variable is used to specify which free IP a new pod is assigned. "pack" fills up one ENI before
+	// using the next, so that unused ENIs can be freed. "spread" uses the ENI with the fewest assigned IPs. "lru" uses
+	// the IP that was released the longest time ago. Default is "pack".
+	envIPPlacement = "AWS_VPC_K8S_CNI_IP_PLACEMENT"
+
 	// eniNoManageTagKey is the tag that may be set on an ENI to indicate ipamd
 	// should not manage it in any form.
 	eniNoManageTagKey = "node.k8s.amazonaws.com/no_manage"
@@ -138,6 +156,12 @@ var (
 			Help: "The maximum number of IP addresses that can be allocated to the instance",
 		},
 	)
+	routingConflicts = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "awscni_routing_conflicts",
+			Help: "The number of IP rules and routes of other agents that collide with the route tables and rule priorities used for pods",
+		},
+	)
 	reconcileCnt = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "awscni_reconcile_count",
@@ -145,18 +169,42 @@ var (
 		},
 		[]string{"fn"},
 	)
-	addIPCnt = prometheus.NewCounter(
+	addIPCnt = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "awscni_add_ip_req_count",
 			Help: "The number of add IP address request",
 		},
+		[]string{"result"},
 	)
 	delIPCnt = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "awscni_del_ip_req_count",
 			Help: "The number of delete IP address request",
 		},
-		[]string{"reason"},
+		[]string{"reason", "result"},
+	)
+	rpcLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "awscni_rpc_handling_seconds",
+			Help:    "The time ipamd takes to handle the requests of the CNI plugin",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		},
+		[]string{"rpc", "result"},
+	)
+	timeToIP = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "awscni_time_to_ip_seconds",
+			Help:    "The time from the first request of a pod that got no IP address because the pool was empty until it got one",
+			Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600, 1200},
+		},
+	)
+	podNetworkSetupLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "awscni_pod_network_setup_seconds",
+			Help:    "The time the CNI plugin takes to set up the network of a pod once it got its IP address",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		},
+		[]string{"result"},
 	)
 	prometheusRegistered = false
 )
@@ -177,12 +225,46 @@ type IPAMContext struct {
 	warmIPTarget         int
 	minimumIPTarget      int
 	primaryIP            map[string]string
+	eniMACs              map[string]string // the ENI IDs by MAC address, for the explain endpoint
+	primaryIPLock        sync.Mutex
 	lastNodeIPPoolAction time.Time
 	lastDecreaseIPPool   time.Time
+	lastVPCCIDRRefresh   time.Time
+	// vpcCIDRsChanged is set when the VPC CIDRs changed and the rules haven't been updated for them yet
+	vpcCIDRsChanged bool
 	// reconcileCooldownCache keeps timestamps of the last time an IP address was unassigned from an ENI,
 	// so that we don't reconcile and add it back too quickly if IMDS lags behind reality.
 	reconcileCooldownCache ReconcileCooldownCache
 	terminating            int32 // Flag to warn that the pod is about to shut down.
+	myNodeName             string
+	// egressIPs are the egress IPs reserved by syncEgressIPs, by EgressIPConfig name
+	egressIPs     map[string]EgressIPInfo
+	egressIPsLock sync.RWMutex
+	// podEIPs are the Elastic IPs associated with pods by syncPodEIPs, by pod IP address
+	podEIPs        map[string]*PodEIPInfo
+	podEIPRetries  map[string]time.Time
+	podEIPsAdopted bool
+	podEIPsLock    sync.Mutex
+	podEIPSyncCh   chan struct{}
+	// eniSetupDone is closed when nodeInit has set up all the attached ENIs
+	eniSetupDone chan struct{}
+	poolBackoff  *poolBackoff
+	poolPolicy   PoolPolicy
+	// poolPolicyState is the last decision of poolPolicy
+	poolPolicyState PoolPolicyState
+	poolPolicyLock  sync.Mutex
+	// useIPQuotas enforces the IPQuotas of the namespaces
+	useIPQuotas bool
+	// warmPoolSchedule switches warmIPTarget, minimumIPTarget and warmENITarget between profiles, if it is set
+	warmPoolSchedule *warmPoolSchedule
+	// poolManagerLock keeps the admin API from changing the pool while the pool manager does
+	poolManagerLock sync.Mutex
+	// hostNetwork is the result of the last reconcile of the SNAT and pod IP rules, for the health checks
+	hostNetwork hostNetworkState
+	// consistency is the last check of the datastore against the kernel, IMDS and EC2
+	consistency consistencyState
+	// podIPWaits are the pods waiting for an IP address because the pool was empty, for the time to IP metric
+	podIPWaits podIPWaits
 }
 
 // Keep track of recently freed IPs to avoid reading stale EC2 metadata
@@ -227,9 +309,20 @@ func prometheusRegister() {
 		prometheus.MustRegister(ipamdActionsInprogress)
 		prometheus.MustRegister(enisMax)
 		prometheus.MustRegister(ipMax)
+		prometheus.MustRegister(routingConflicts)
 		prometheus.MustRegister(reconcileCnt)
 		prometheus.MustRegister(addIPCnt)
 		prometheus.MustRegister(delIPCnt)
+		prometheus.MustRegister(rpcLatency)
+		prometheus.MustRegister(timeToIP)
+		prometheus.MustRegister(podNetworkSetupLatency)
+		prometheus.MustRegister(poolIncreaseCircuitState)
+		prometheus.MustRegister(poolIncreaseFailures)
+		prometheus.MustRegister(warmPoolProfileActive)
+		prometheus.MustRegister(warmPoolTarget)
+		prometheus.MustRegister(adminRequests)
+		prometheus.MustRegister(consistencyMismatches)
+		prometheus.MustRegister(consistencyFixes)
 		prometheusRegistered = true
 	}
 }
@@ -257,7 +350,22 @@ func New(k8sapiClient k8sapi.K8SAPIs, eniConfig *eniconfig.ENIConfigController)
 	c.warmENITarget = getWarmENITarget()
 	c.warmIPTarget = getWarmIPTarget()
 	c.minimumIPTarget = getMinimumIPTarget()
+	c.warmPoolSchedule = getWarmPoolSchedule(WarmPoolTargets{
+		WarmIPTarget:    c.warmIPTarget,
+		MinimumIPTarget: c.minimumIPTarget,
+		WarmENITarget:   c.warmENITarget,
+	})
+	c.applyWarmPoolSchedule(time.Now())
 	c.useCustomNetworking = UseCustomNetworkCfg()
+	c.useIPQuotas = UseIPQuotas()
+	c.myNodeName = os.Getenv("MY_NODE_NAME")
+	c.podEIPs = make(map[string]*PodEIPInfo)
+	c.podEIPRetries = make(map[string]time.Time)
+	c.poolBackoff = newPoolBackoff()
+	c.poolPolicy = getPoolPolicy()
+	if UsePodEIP() {
+		c.podEIPSyncCh = make(chan struct{}, 1)
+	}
 
 	err = c.nodeInit()
 	if err != nil {
@@ -314,33 +422,11 @@ func (c *IPAMContext) nodeInit() error {
 	}
 
 	c.dataStore = datastore.NewDataStore()
-	for _, eni := range enis {
-		log.Debugf("Discovered ENI %s, trying to set it up", eni.ENIID)
-		// Retry ENI sync
-		retry := 0
-		for {
-			retry++
-			if err = c.setupENI(eni.ENIID, eni); err == nil {
-				log.Infof("ENI %s set up.", eni.ENIID)
-				break
-			}
+	c.setupIPReserve()
+	c.dataStore.SetPlacementStrategy(getIPPlacement())
+	c.eniSetupDone = make(chan struct{})
+	eniReady, eniSetup := c.setupENIs(enis)
 
-			if retry > maxRetryCheckENI {
-				log.Errorf("Unable to discover attached IPs for ENI from metadata service")
-				ipamdErrInc("waitENIAttachedMaxRetryExceeded")
-				break
-			}
-
-			log.Warnf("Error trying to set up ENI %s: %v", eni.ENIID, err)
-			if strings.Contains(err.Error(), "setupENINetwork: failed to find the link which uses MAC address") {
-				// If we can't find the matching link for this MAC address, there is no point in retrying for this ENI.
-				log.Errorf("Unable to match link for this ENI, going to the next one.")
-				break
-			}
-			log.Debugf("Unable to discover IPs for this ENI yet (attempt %d/%d)", retry, maxRetryCheckENI)
-			time.Sleep(eniAttachTime)
-		}
-	}
 	localPods, err := c.getLocalPodsWithRetry()
 	if err != nil {
 		log.Warnf("During ipamd init, failed to get Pod information from Kubernetes API Server %v", err)
@@ -350,12 +436,28 @@ func (c *IPAMContext) nodeInit() error {
 	}
 	log.Debugf("getLocalPodsWithRetry() found %d local pods", len(localPods))
 
+	// The running pods only need the ENIs their IPs are on, the others are set up while the RPC server is starting
+	podENIs := localPodENIs(enis, localPods)
+	for _, eni := range podENIs {
+		<-eniReady[eni]
+	}
+	log.Infof("The %d ENIs of the running pods are set up, %d ENIs are still being set up", len(podENIs), len(enis)-len(podENIs))
+
+	// The egress IPs are on the primary ENI
+	if ready, ok := eniReady[c.awsClient.GetPrimaryENI()]; ok {
+		<-ready
+	}
+
 	rules, err := c.networkClient.GetRuleList()
 	if err != nil {
 		log.Errorf("During ipamd init: failed to retrieve IP rule list %v", err)
+		c.checkRoutingConflicts(enis)
+		c.reserveEgressIPs()
+		go c.finishNodeInit(eniSetup)
 		return nil
 	}
 
+	var podIPs []string
 	for _, ip := range localPods {
 		if ip.Sandbox == "" {
 			log.Infof("Skipping Pod %s, Namespace %s, due to no matching sandbox", ip.Name, ip.Namespace)
@@ -366,26 +468,168 @@ func (c *IPAMContext) nodeInit() error {
 			continue
 		}
 		log.Infof("Recovered AddNetwork for Pod %s, Namespace %s, Sandbox %s", ip.Name, ip.Namespace, ip.Sandbox)
-		_, _, err = c.dataStore.AssignPodIPv4Address(ip)
+		podIPs = append(podIPs, ip.IP)
+		_, deviceNumber, err := c.dataStore.AssignPodIPv4Address(ip)
 		if err != nil {
 			ipamdErrInc("nodeInitAssignPodIPv4AddressFailed")
 			log.Warnf("During ipamd init, failed to use pod IP %s returned from Kubernetes API Server %v", ip.IP, err)
+			continue
 		}
 
-		// Update ip rules in case there is a change in VPC CIDRs, AWS_VPC_K8S_CNI_EXTERNALSNAT setting
-		srcIPNet := net.IPNet{IP: net.ParseIP(ip.IP), Mask: net.IPv4Mask(255, 255, 255, 255)}
+		// Move the rules a previous AWS_VPC_K8S_CNI_ROUTE_TABLE_OFFSET or AWS_VPC_K8S_CNI_RULE_PRIORITY_BASE added
+		podIPNet := net.IPNet{IP: net.ParseIP(ip.IP), Mask: net.IPv4Mask(255, 255, 255, 255)}
+		if err = c.networkClient.MigratePodRules(rules, podIPNet, deviceNumber); err != nil {
+			ipamdErrInc("nodeInitMigratePodRulesFailed")
+			log.Errorf("Failed to migrate the IP rules of pod IP %s to the routing layout: %v", ip.IP, err)
+		}
+	}
+	// The migrated rules are checked, and replaced below, as they are now
+	if rules, err = c.networkClient.GetRuleList(); err != nil {
+		log.Errorf("During ipamd init: failed to retrieve IP rule list %v", err)
+	} else if err = c.networkClient.DeleteMigratedRouteTables(rules); err != nil {
+		ipamdErrInc("nodeInitDeleteMigratedRouteTablesFailed")
+		log.Errorf("Failed to delete the route tables of the previous routing layout: %v", err)
+	}
+	c.checkRoutingConflicts(enis)
 
-		err = c.networkClient.UpdateRuleListBySrc(rules, srcIPNet, pbVPCcidrs, !c.networkClient.UseExternalSNAT())
+	for _, podIP := range podIPs {
+		// Update ip rules in case there is a change in VPC CIDRs, AWS_VPC_K8S_CNI_EXTERNALSNAT or
+		// AWS_VPC_K8S_CNI_PER_ENI_SNAT setting
+		err = c.updatePodIPRules(rules, podIP, pbVPCcidrs)
 		if err != nil {
-			log.Errorf("UpdateRuleListBySrc in nodeInit() failed for IP %s: %v", ip.IP, err)
+			log.Errorf("UpdateRuleListBySrc in nodeInit() failed for IP %s: %v", podIP, err)
 		}
 	}
-	// For a new node, attach IPs
+	// The running pods keep their IPs, then the egress IPs are reserved before the RPC server gives IPs to new pods
+	c.reserveEgressIPs()
+	go c.finishNodeInit(eniSetup)
+	return nil
+}
+
+// setupENIs sets up the given ENIs, up to AWS_VPC_K8S_CNI_ENI_SETUP_CONCURRENCY at a time and in the given order. The
+// channel of an ENI is closed once it is set up or given up on, and the WaitGroup is done once all of them are.
+func (c *IPAMContext) setupENIs(enis []awsutils.ENIMetadata) (map[string]chan struct{}, *sync.WaitGroup) {
+	eniReady := make(map[string]chan struct{}, len(enis))
+	queue := make(chan awsutils.ENIMetadata, len(enis))
+	for _, eni := range enis {
+		eniReady[eni.ENIID] = make(chan struct{})
+		queue <- eni
+	}
+	close(queue)
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(getENISetupConcurrency(), len(enis)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for eni := range queue {
+				c.setupENIWithRetry(eni)
+				close(eniReady[eni.ENIID])
+			}
+		}()
+	}
+	return eniReady, &wg
+}
+
+// setupENIWithRetry sets up an ENI found by nodeInit, retrying while its IPs or link are not there yet
+func (c *IPAMContext) setupENIWithRetry(eni awsutils.ENIMetadata) {
+	log.Debugf("Discovered ENI %s, trying to set it up", eni.ENIID)
+	start := time.Now()
+	for retry := 1; ; retry++ {
+		err := c.setupENI(eni.ENIID, eni)
+		if err == nil {
+			log.Infof("ENI %s set up in %v.", eni.ENIID, time.Since(start))
+			return
+		}
+
+		if retry > maxRetryCheckENI {
+			log.Errorf("Unable to discover attached IPs for ENI %s from metadata service", eni.ENIID)
+			ipamdErrInc("waitENIAttachedMaxRetryExceeded")
+			return
+		}
+
+		log.Warnf("Error trying to set up ENI %s: %v", eni.ENIID, err)
+		if strings.Contains(err.Error(), "setupENINetwork: failed to find the link which uses MAC address") {
+			// If we can't find the matching link for this MAC address, there is no point in retrying for this ENI.
+			log.Errorf("Unable to match link for ENI %s, going to the next one.", eni.ENIID)
+			return
+		}
+		log.Debugf("Unable to discover IPs for ENI %s yet (attempt %d/%d)", eni.ENIID, retry, maxRetryCheckENI)
+		time.Sleep(eniAttachTime)
+	}
+}
+
+// localPodENIs returns the ENIs that have the IP address of one of the local pods
+func localPodENIs(enis []awsutils.ENIMetadata, localPods []*k8sapi.K8SPodInfo) []string {
+	podIPs := make(map[string]bool, len(localPods))
+	for _, pod := range localPods {
+		podIPs[pod.IP] = true
+	}
+	var podENIs []string
+	for _, eni := range enis {
+		for _, addr := range eni.IPv4Addresses {
+			if podIPs[aws.StringValue(addr.PrivateIpAddress)] {
+				podENIs = append(podENIs, eni.ENIID)
+				break
+			}
+		}
+	}
+	return podENIs
+}
+
+// finishNodeInit waits for nodeInit to set up the remaining ENIs, and attaches IPs for a new node
+func (c *IPAMContext) finishNodeInit(eniSetup *sync.WaitGroup) {
+	eniSetup.Wait()
+	defer close(c.eniSetupDone)
+
+	// If WARM_IP_TARGET or MINIMUM_IP_TARGET is set, only proceed if we are short of target
+	short, _, warmIPTargetDefined := ipTargetState(c.poolStats(), c.poolLimits())
+	if warmIPTargetDefined && short == 0 {
+		return
+	}
 	increasedPool, err := c.tryAssignIPs()
-	if err == nil && increasedPool {
+	if err != nil {
+		log.Errorf("During ipamd init, failed to assign IPs: %v", err)
+		ipamdErrInc("nodeInitTryAssignIPsFailed")
+		return
+	}
+	if increasedPool {
 		c.updateLastNodeIPPoolAction()
 	}
-	return err
+}
+
+// waitENISetup waits until nodeInit has set up all the attached ENIs
+func (c *IPAMContext) waitENISetup() {
+	if c.eniSetupDone != nil {
+		<-c.eniSetupDone
+	}
+}
+
+// updatePodIPRules replaces the IP rules of a pod's IP address with the ones for the given VPC CIDRs
+func (c *IPAMContext) updatePodIPRules(rules []netlink.Rule, podIP string, vpcCIDRs []string) error {
+	srcIPNet := net.IPNet{IP: net.ParseIP(podIP), Mask: net.IPv4Mask(255, 255, 255, 255)}
+	requiresSNAT := !c.networkClient.UseExternalSNAT() && !c.networkClient.UsePerENISNAT()
+	return c.networkClient.UpdateRuleListBySrc(rules, srcIPNet, vpcCIDRs, requiresSNAT)
+}
+
+// checkRoutingConflicts logs the IP rules and routes of other agents that use the route tables or rule priorities of
+// the routing layout. They are not removed, since the other agent would likely add them back.
+func (c *IPAMContext) checkRoutingConflicts(enis []awsutils.ENIMetadata) {
+	var eniMACs []string
+	for _, eni := range enis {
+		eniMACs = append(eniMACs, eni.MAC)
+	}
+	conflicts, err := c.networkClient.CheckRoutingConflicts(eniMACs)
+	if err != nil {
+		log.Warnf("Failed to check for routing conflicts: %v", err)
+		return
+	}
+	routingConflicts.Set(float64(len(conflicts)))
+	layout := c.networkClient.GetRoutingLayout()
+	for _, conflict := range conflicts {
+		log.Errorf("%s collides with the routing layout (%s), change AWS_VPC_K8S_CNI_ROUTE_TABLE_OFFSET or "+
+			"AWS_VPC_K8S_CNI_RULE_PRIORITY_BASE to avoid it", conflict, layout)
+	}
 }
 
 func (c *IPAMContext) updateIPStats(unmanaged int) {
@@ -457,29 +701,41 @@ func (c *IPAMContext) getLocalPodsWithRetry() ([]*k8sapi.K8SPodInfo, error) {
 
 // StartNodeIPPoolManager monitors the IP pool, add or del them when it is required.
 func (c *IPAMContext) StartNodeIPPoolManager() {
+	// The pool must not change while ENIs found by nodeInit are missing from it
+	c.waitENISetup()
 	sleepDuration := ipPoolMonitorInterval / 2
 	for {
 		time.Sleep(sleepDuration)
+		c.poolManagerLock.Lock()
+		c.applyWarmPoolSchedule(time.Now())
+		c.syncIPQuotas()
 		c.updateIPPoolIfRequired()
+		c.freeDrainedENIs()
+		c.poolManagerLock.Unlock()
 		time.Sleep(sleepDuration)
+		c.poolManagerLock.Lock()
 		c.nodeIPPoolReconcile(nodeIPPoolReconcileInterval)
+		c.refreshVPCCIDRs(vpcCIDRRefreshInterval)
+		c.syncEgressIPs()
+		c.poolManagerLock.Unlock()
 	}
 }
 
 func (c *IPAMContext) updateIPPoolIfRequired() {
-	if c.nodeIPPoolTooLow() {
-		c.increaseIPPool()
-	} else if c.nodeIPPoolTooHigh() {
-		c.decreaseIPPool(decreaseIPPoolInterval)
+	actions, limits := c.poolActions()
+	if actions.AddIPs > 0 {
+		c.increaseIPPool(actions)
+	} else if actions.FreeIPs > 0 {
+		c.decreaseIPPool(decreaseIPPoolInterval, actions.FreeIPs)
 	}
 
-	if c.shouldRemoveExtraENIs() {
-		c.tryFreeENI()
+	if actions.FreeENI {
+		c.tryFreeENI(limits.WarmIPTarget)
 	}
 }
 
-// decreaseIPPool runs every `interval` and attempts to return unused ENIs and IPs
-func (c *IPAMContext) decreaseIPPool(interval time.Duration) {
+// decreaseIPPool runs every `interval` and attempts to return freeIPs unused IPs
+func (c *IPAMContext) decreaseIPPool(interval time.Duration, freeIPs int) {
 	ipamdActionsInprogress.WithLabelValues("decreaseIPPool").Add(float64(1))
 	defer ipamdActionsInprogress.WithLabelValues("decreaseIPPool").Sub(float64(1))
 
@@ -492,7 +748,7 @@ func (c *IPAMContext) decreaseIPPool(interval time.Duration) {
 
 	log.Debugf("Starting to decrease IP pool")
 
-	c.tryUnassignIPsFromAll()
+	c.tryUnassignIPsFromAll(freeIPs)
 
 	c.lastDecreaseIPPool = now
 	c.lastNodeIPPoolAction = now
@@ -501,20 +757,24 @@ func (c *IPAMContext) decreaseIPPool(interval time.Duration) {
 	logPoolStats(total, used, c.maxIPsPerENI)
 }
 
-// tryFreeENI always tries to free one ENI
-func (c *IPAMContext) tryFreeENI() {
+// tryFreeENI always tries to free one ENI that isn't needed for warmIPTarget
+func (c *IPAMContext) tryFreeENI(warmIPTarget int) {
 	if c.isTerminating() {
 		log.Debug("AWS CNI is terminating, not detaching any ENIs")
 		return
 	}
 
-	eni := c.dataStore.RemoveUnusedENIFromStore(c.warmIPTarget, c.minimumIPTarget)
+	// The IP reserve is kept on top of the warm IPs
+	reserve, _ := c.dataStore.GetIPReserve()
+	eni := c.dataStore.RemoveUnusedENIFromStore(warmIPTarget+reserve, c.minimumIPTarget)
 	if eni == "" {
 		return
 	}
 
 	log.Debugf("Start freeing ENI %s", eni)
+	c.teardownENINetwork(eni)
 	err := c.awsClient.FreeENI(eni)
+	c.publishPoolAction(poolActionFreeENI, eni, err, "not needed for the warm pool")
 	if err != nil {
 		ipamdErrInc("decreaseIPPoolFreeENIFailed")
 		log.Errorf("Failed to free ENI %s, err: %v", eni, err)
@@ -522,13 +782,12 @@ func (c *IPAMContext) tryFreeENI() {
 	}
 }
 
-// tryUnassignIPsFromAll determines if there are IPs to free when we have extra IPs beyond the target and warmIPTargetDefined
-// is enabled, deallocate extra IP addresses
-func (c *IPAMContext) tryUnassignIPsFromAll() {
-	if _, over, warmIPTargetDefined := c.ipTargetState(); warmIPTargetDefined && over > 0 {
+// tryUnassignIPsFromAll deallocates the `over` extra IP addresses the pool policy asks to free
+func (c *IPAMContext) tryUnassignIPsFromAll(over int) {
+	if over > 0 {
 		eniInfos := c.dataStore.GetENIInfos()
 		for eniID := range eniInfos.ENIIPPools {
-			ips, err := c.findFreeableIPs(eniID)
+			ips, err := c.findFreeableIPs(eniID, over)
 			if err != nil {
 				log.Errorf("Error finding unassigned IPs: %s", err)
 				return
@@ -554,7 +813,11 @@ func (c *IPAMContext) tryUnassignIPsFromAll() {
 			}
 
 			// Deallocate IPs from the instance if they aren't used by pods.
-			if err := c.awsClient.DeallocIPAddresses(eniID, deletedIPs); err != nil {
+			err = c.awsClient.DeallocIPAddresses(eniID, deletedIPs)
+			if len(deletedIPs) > 0 {
+				c.publishPoolAction(poolActionFreeIPs, eniID, err, strings.Join(deletedIPs, ","))
+			}
+			if err != nil {
 				log.Warnf("Failed to decrease IP pool by removing IPs %v from ENI %s: %s", deletedIPs, eniID, err)
 			} else {
 				log.Debugf("Successfully decreased IP pool by removing IPs %v from ENI %s", deletedIPs, eniID)
@@ -567,9 +830,9 @@ func (c *IPAMContext) tryUnassignIPsFromAll() {
 	}
 }
 
-// findFreeableIPs finds and returns IPs that are not assigned to Pods but are attached
+// findFreeableIPs finds and returns up to `over` IPs that are not assigned to Pods but are attached
 // to ENIs on the node.
-func (c *IPAMContext) findFreeableIPs(eni string) ([]string, error) {
+func (c *IPAMContext) findFreeableIPs(eni string, over int) ([]string, error) {
 	podIPInfos := c.dataStore.GetPodInfos()
 	usedIPs := sets.String{}
 	// Get IPs that are currently in use by pods
@@ -596,7 +859,6 @@ func (c *IPAMContext) findFreeableIPs(eni string) ([]string, error) {
 
 	// Free the number of IPs `over` the warm IP target, unless `over` is greater than the number of available IPs on
 	// this ENI. In that case we should only free the number of available IPs.
-	_, over, _ := c.ipTargetState()
 	numFreeable := min(over, len(availableIPs))
 
 	for _, ip := range availableIPs[:numFreeable] {
@@ -605,19 +867,20 @@ func (c *IPAMContext) findFreeableIPs(eni string) ([]string, error) {
 	return freeableIPs, nil
 }
 
-func (c *IPAMContext) increaseIPPool() {
+// increaseIPPool adds the IPs the pool policy asks for, to an existing ENI or else to a new one
+func (c *IPAMContext) increaseIPPool(actions PoolActions) {
 	log.Debug("Starting to increase IP pool size")
 	ipamdActionsInprogress.WithLabelValues("increaseIPPool").Add(float64(1))
 	defer ipamdActionsInprogress.WithLabelValues("increaseIPPool").Sub(float64(1))
 
-	short, _, warmIPTargetDefined := c.ipTargetState()
-	if warmIPTargetDefined && short == 0 {
-		log.Debugf("Skipping increase IP pool, warm IP target reached")
+	if c.isTerminating() {
+		log.Debug("AWS CNI is terminating, will not try to attach any new IPs or ENIs right now")
 		return
 	}
 
-	if c.isTerminating() {
-		log.Debug("AWS CNI is terminating, will not try to attach any new IPs or ENIs right now")
+	now := time.Now()
+	if !c.poolBackoff.allow(now) {
+		log.Debugf("Skipping increase IP pool, backing off after failures: %+v", c.poolBackoff.getState())
 		return
 	}
 
@@ -628,15 +891,21 @@ func (c *IPAMContext) increaseIPPool() {
 	}
 	if increasedPool {
 		c.updateLastNodeIPPoolAction()
-	} else {
-		// If we did not add an IP, try to add an ENI instead.
-		if c.dataStore.GetENIs() < (c.maxENI - c.unmanagedENI) {
-			if err = c.tryAllocateENI(); err == nil {
-				c.updateLastNodeIPPoolAction()
-			}
-		} else {
-			log.Debugf("Skipping ENI allocation as the instance's max ENI limit of %d is already reached (accounting for %d unmanaged ENIs)", c.maxENI, c.unmanagedENI)
+		c.poolBackoff.success()
+		return
+	}
+	// If we did not add an IP, try to add an ENI instead.
+	if actions.AddENI {
+		if err = c.tryAllocateENI(actions.AddIPs); err == nil {
+			c.updateLastNodeIPPoolAction()
+			c.poolBackoff.success()
+			return
 		}
+	} else {
+		log.Debugf("Skipping ENI allocation as the instance's max ENI limit of %d is already reached (accounting for %d unmanaged ENIs)", c.maxENI, c.unmanagedENI)
+	}
+	if err != nil {
+		c.poolBackoff.failure(now, err)
 	}
 }
 
@@ -647,7 +916,7 @@ func (c *IPAMContext) updateLastNodeIPPoolAction() {
 	logPoolStats(total, used, c.maxIPsPerENI)
 }
 
-func (c *IPAMContext) tryAllocateENI() error {
+func (c *IPAMContext) tryAllocateENI(ipsToAllocate int) error {
 	var securityGroups []*string
 	var subnet string
 
@@ -667,19 +936,14 @@ func (c *IPAMContext) tryAllocateENI() error {
 		subnet = eniCfg.Subnet
 	}
 
-	eni, err := c.awsClient.AllocENI(c.useCustomNetworking, securityGroups, subnet)
+	eni, eniMAC, err := c.awsClient.AllocENI(c.useCustomNetworking, securityGroups, subnet)
 	if err != nil {
 		log.Errorf("Failed to increase pool size due to not able to allocate ENI %v", err)
 		ipamdErrInc("increaseIPPoolAllocENI")
+		c.publishPoolAction(poolActionAllocENI, "", err, "")
 		return err
 	}
 
-	ipsToAllocate := c.maxIPsPerENI
-	short, _, warmIPTargetDefined := c.ipTargetState()
-	if warmIPTargetDefined {
-		ipsToAllocate = short
-	}
-
 	err = c.awsClient.AllocIPAddresses(eni, ipsToAllocate)
 	if err != nil {
 		log.Warnf("Failed to allocate %d IP addresses on an ENI: %v", ipsToAllocate, err)
@@ -687,7 +951,7 @@ func (c *IPAMContext) tryAllocateENI() error {
 		ipamdErrInc("increaseIPPoolAllocIPAddressesFailed")
 	}
 
-	eniMetadata, err := c.waitENIAttached(eni)
+	eniMetadata, err := c.waitENIAttached(eni, eniMAC)
 	if err != nil {
 		ipamdErrInc("increaseIPPoolwaitENIAttachedFailed")
 		log.Errorf("Failed to increase pool size: Unable to discover attached ENI from metadata service %v", err)
@@ -695,6 +959,7 @@ func (c *IPAMContext) tryAllocateENI() error {
 	}
 
 	err = c.setupENI(eni, eniMetadata)
+	c.publishPoolAction(poolActionAllocENI, eni, err, fmt.Sprintf("%d IPs requested", ipsToAllocate))
 	if err != nil {
 		ipamdErrInc("increaseIPPoolsetupENIFailed")
 		log.Errorf("Failed to increase pool size: %v", err)
@@ -705,12 +970,6 @@ func (c *IPAMContext) tryAllocateENI() error {
 
 // For an ENI, try to fill in missing IPs on an existing ENI
 func (c *IPAMContext) tryAssignIPs() (increasedPool bool, err error) {
-	// If WARM_IP_TARGET is set, only proceed if we are short of target
-	short, _, warmIPTargetDefined := c.ipTargetState()
-	if warmIPTargetDefined && short == 0 {
-		return false, nil
-	}
-
 	// Find an ENI where we can add more IPs
 	eni := c.dataStore.GetENINeedsIP(c.maxIPsPerENI, c.useCustomNetworking)
 	if eni != nil && len(eni.IPv4Addresses) < c.maxIPsPerENI {
@@ -723,6 +982,7 @@ func (c *IPAMContext) tryAssignIPs() (increasedPool bool, err error) {
 			err = c.awsClient.AllocIPAddresses(eni.ID, 1)
 			if err != nil {
 				ipamdErrInc("increaseIPPoolAllocIPAddressesFailed")
+				c.publishPoolAction(poolActionAllocIPs, eni.ID, err, "")
 				return false, errors.Wrap(err, fmt.Sprintf("failed to allocate one IP addresses on ENI %s, err: %v", eni.ID, err))
 			}
 		}
@@ -733,6 +993,7 @@ func (c *IPAMContext) tryAssignIPs() (increasedPool bool, err error) {
 			return true, errors.Wrap(err, "failed to get ENI IP addresses during IP allocation")
 		}
 		c.addENIaddressesToDataStore(ec2Addrs, eni.ID)
+		c.publishPoolAction(poolActionAllocIPs, eni.ID, nil, fmt.Sprintf("%d IPs on the ENI", len(ec2Addrs)))
 		return true, nil
 	}
 	return false, nil
@@ -760,10 +1021,37 @@ func (c *IPAMContext) setupENI(eni string, eniMetadata awsutils.ENIMetadata) err
 		}
 	}
 
-	c.primaryIP[eni] = c.addENIaddressesToDataStore(eniMetadata.IPv4Addresses, eni)
+	primaryIP := c.addENIaddressesToDataStore(eniMetadata.IPv4Addresses, eni)
+	c.primaryIPLock.Lock()
+	c.primaryIP[eni] = primaryIP
+	if c.eniMACs == nil {
+		c.eniMACs = make(map[string]string)
+	}
+	c.eniMACs[eniMetadata.MAC] = eni
+	c.primaryIPLock.Unlock()
 	return nil
 }
 
+// teardownENINetwork removes the network set up for an ENI that was removed from the datastore
+func (c *IPAMContext) teardownENINetwork(eni string) {
+	c.primaryIPLock.Lock()
+	eniIP := c.primaryIP[eni]
+	delete(c.primaryIP, eni)
+	for mac, eniID := range c.eniMACs {
+		if eniID == eni {
+			delete(c.eniMACs, mac)
+		}
+	}
+	c.primaryIPLock.Unlock()
+	if eniIP == "" {
+		return
+	}
+	if err := c.networkClient.TeardownENINetwork(eniIP); err != nil {
+		ipamdErrInc("teardownENINetworkFailed")
+		log.Errorf("Failed to tear down the network of ENI %s: %v", eni, err)
+	}
+}
+
 // return primary ip address on the interface
 func (c *IPAMContext) addENIaddressesToDataStore(ec2Addrs []*ec2.NetworkInterfacePrivateIpAddress, eni string) string {
 	var primaryIP string
@@ -798,7 +1086,21 @@ func (c *IPAMContext) getENIaddresses(eni string) ([]*ec2.NetworkInterfacePrivat
 	return nil, "", errors.Errorf("failed to find the ENI's primary address for ENI %s", eni)
 }
 
-func (c *IPAMContext) waitENIAttached(eni string) (awsutils.ENIMetadata, error) {
+// waitENIAttached waits for a new ENI to be attached and returns its metadata. The kernel adds the ENI's link first,
+// which is seen right away with a subscription to link updates, and the instance metadata service usually shows the
+// ENI shortly after. If the link can't be waited for, the instance metadata service is polled like before.
+func (c *IPAMContext) waitENIAttached(eni string, mac string) (awsutils.ENIMetadata, error) {
+	start := time.Now()
+	retryInterval := eniAttachTime
+	if err := c.networkClient.WaitForLink(mac, eniAttachTimeout); err != nil {
+		log.Warnf("Failed to wait for the link of ENI %s, polling the instance metadata service instead: %v", eni, err)
+		ipamdErrInc("waitENIAttachedWaitForLinkFailed")
+	} else {
+		log.Infof("The link of ENI %s was added after %v", eni, time.Since(start))
+		retryInterval = eniMetadataRetryInterval
+	}
+	maxRetries := int(eniAttachTimeout / retryInterval)
+
 	// Wait until the ENI shows up in the instance metadata service
 	retry := 0
 	for {
@@ -809,18 +1111,19 @@ func (c *IPAMContext) waitENIAttached(eni string) (awsutils.ENIMetadata, error)
 			// Verify that the ENI we are waiting for is in the returned list
 			for _, returnedENI := range enis {
 				if eni == returnedENI.ENIID {
+					log.Infof("ENI %s is attached after %v", eni, time.Since(start))
 					return returnedENI, nil
 				}
 			}
-			log.Debugf("Not able to find the right ENI yet (attempt %d/%d)", retry, maxRetryCheckENI)
+			log.Debugf("Not able to find the right ENI yet (attempt %d/%d)", retry, maxRetries)
 		}
 		retry++
-		if retry > maxRetryCheckENI {
+		if retry > maxRetries {
 			ipamdErrInc("waitENIAttachedMaxRetryExceeded")
 			return awsutils.ENIMetadata{}, errors.New("waitENIAttached: giving up trying to retrieve ENIs from metadata service")
 		}
-		log.Debugf("Not able to discover attached ENIs yet (attempt %d/%d)", retry, maxRetryCheckENI)
-		time.Sleep(eniAttachTime)
+		log.Debugf("Not able to discover attached ENIs yet (attempt %d/%d)", retry, maxRetries)
+		time.Sleep(retryInterval)
 	}
 }
 
@@ -864,66 +1167,102 @@ func getWarmENITarget() int {
 	return defaultWarmENITarget
 }
 
-func logPoolStats(total, used, maxAddrsPerENI int) {
-	log.Debugf("IP pool stats: total = %d, used = %d, c.maxIPsPerENI = %d",
-		total, used, maxAddrsPerENI)
+// getIPPlacement returns the placement strategy of new pods' IPs
+func getIPPlacement() datastore.PlacementStrategy {
+	inputStr, found := os.LookupEnv(envIPPlacement)
+	if !found || inputStr == "" {
+		return datastore.DefaultPlacementStrategy
+	}
+	for _, strategy := range datastore.PlacementStrategies {
+		if inputStr == string(strategy) {
+			log.Debugf("Using %s %s", envIPPlacement, strategy)
+			return strategy
+		}
+	}
+	log.Errorf("Unknown %s %q, using the %s placement", envIPPlacement, inputStr, datastore.DefaultPlacementStrategy)
+	return datastore.DefaultPlacementStrategy
 }
 
-// nodeIPPoolTooLow returns true if IP pool is below low threshold
-func (c *IPAMContext) nodeIPPoolTooLow() bool {
-	short, _, warmIPTargetDefined := c.ipTargetState()
-	if warmIPTargetDefined {
-		return short > 0
-	}
+// getENISetupConcurrency returns the number of ENIs nodeInit sets up at the same time
+func getENISetupConcurrency() int {
+	inputStr, found := os.LookupEnv(envENISetupConcurrency)
 
-	total, used := c.dataStore.GetStats()
-	logPoolStats(total, used, c.maxIPsPerENI)
+	if !found {
+		return defaultENISetupConcurrency
+	}
 
-	available := total - used
-	poolTooLow := available < c.maxIPsPerENI*c.warmENITarget || (c.warmENITarget == 0 && available == 0)
-	if poolTooLow {
-		log.Tracef("IP pool is too low: available (%d) < ENI target (%d) * addrsPerENI (%d)", available, c.warmENITarget, c.maxIPsPerENI)
-	} else {
-		log.Tracef("IP pool is NOT too low: available (%d) >= ENI target (%d) * addrsPerENI (%d)", available, c.warmENITarget, c.maxIPsPerENI)
+	if input, err := strconv.Atoi(inputStr); err == nil && input > 0 {
+		log.Debugf("Using %s %v", envENISetupConcurrency, input)
+		return input
 	}
-	return poolTooLow
+	return defaultENISetupConcurrency
 }
 
-// nodeIPPoolTooHigh returns true if IP pool is above high threshold
-func (c *IPAMContext) nodeIPPoolTooHigh() bool {
-	_, over, warmIPTargetDefined := c.ipTargetState()
-	if warmIPTargetDefined {
-		return over > 0
-	}
+func logPoolStats(total, used, maxAddrsPerENI int) {
+	log.Debugf("IP pool stats: total = %d, used = %d, c.maxIPsPerENI = %d",
+		total, used, maxAddrsPerENI)
+}
 
-	// We only ever report the pool being too high if WARM_IP_TARGET is set
-	return false
+func ipamdErrInc(fn string) {
+	ipamdErr.With(prometheus.Labels{"fn": fn}).Inc()
 }
 
-// shouldRemoveExtraENIs returns true if we should attempt to find an ENI to free.  When WARM_IP_TARGET is set, we
-// always check and do verification in getDeletableENI()
-func (c *IPAMContext) shouldRemoveExtraENIs() bool {
-	_, _, warmIPTargetDefined := c.ipTargetState()
-	if warmIPTargetDefined {
-		return true
+// refreshVPCCIDRs picks up the CIDRs associated with or removed from the VPC since ipamd started, and updates the SNAT
+// rules and the IP rules of the pods for them. New pods get the new CIDRs in the AddNetworkReply.
+func (c *IPAMContext) refreshVPCCIDRs(interval time.Duration) {
+	if time.Since(c.lastVPCCIDRRefresh) <= interval {
+		return
 	}
+	c.lastVPCCIDRRefresh = time.Now()
 
-	total, used := c.dataStore.GetStats()
-	logPoolStats(total, used, c.maxIPsPerENI)
+	changed, err := c.awsClient.RefreshVPCIPv4CIDRs()
+	if err != nil {
+		log.Errorf("refreshVPCCIDRs: failed to retrieve VPC CIDRs: %v", err)
+		ipamdErrInc("refreshVPCCIDRsFailed")
+		return
+	}
+	if changed {
+		c.vpcCIDRsChanged = true
+	}
+	if !c.vpcCIDRsChanged {
+		return
+	}
 
-	available := total - used
-	// We need the +1 to make sure we are not going below the WARM_ENI_TARGET.
-	shouldRemoveExtra := available >= (c.warmENITarget+1)*c.maxIPsPerENI
-	if shouldRemoveExtra {
-		log.Tracef("It might be possible to remove extra ENIs because available (%d) >= (ENI target (%d) + 1) * addrsPerENI (%d): ", available, c.warmENITarget, c.maxIPsPerENI)
-	} else {
-		log.Tracef("Its NOT possible to remove extra ENIs because available (%d) < (ENI target (%d) + 1) * addrsPerENI (%d): ", available, c.warmENITarget, c.maxIPsPerENI)
+	vpcCIDRs := c.awsClient.GetVPCIPv4CIDRs()
+	primaryIP := net.ParseIP(c.awsClient.GetLocalIPv4())
+	if err = c.networkClient.UpdateSNATRules(vpcCIDRs, &primaryIP); err != nil {
+		log.Errorf("refreshVPCCIDRs: failed to update SNAT rules: %v", err)
+		ipamdErrInc("refreshVPCCIDRsUpdateSNATRulesFailed")
+		c.hostNetwork.set(errors.Wrap(err, "failed to update SNAT rules"))
+		return
 	}
-	return shouldRemoveExtra
-}
 
-func ipamdErrInc(fn string) {
-	ipamdErr.With(prometheus.Labels{"fn": fn}).Inc()
+	rules, err := c.networkClient.GetRuleList()
+	if err != nil {
+		log.Errorf("refreshVPCCIDRs: failed to retrieve IP rule list: %v", err)
+		ipamdErrInc("refreshVPCCIDRsUpdatePodRulesFailed")
+		c.hostNetwork.set(errors.Wrap(err, "failed to retrieve IP rule list"))
+		return
+	}
+	pbVPCcidrs := aws.StringValueSlice(vpcCIDRs)
+	var ruleErr error
+	for _, addr := range c.dataStore.GetPodIPv4Addresses() {
+		if addr.Sandbox == egressIPSandbox {
+			continue
+		}
+		if err = c.updatePodIPRules(rules, addr.IP, pbVPCcidrs); err != nil {
+			log.Errorf("refreshVPCCIDRs: failed to update IP rules for IP %s: %v", addr.IP, err)
+			ipamdErrInc("refreshVPCCIDRsUpdatePodRulesFailed")
+			ruleErr = errors.Wrapf(err, "failed to update IP rules for IP %s", addr.IP)
+		}
+	}
+	c.hostNetwork.set(ruleErr)
+	// Try again next time if a pod's rules couldn't be updated
+	updated := ruleErr == nil
+	c.vpcCIDRsChanged = !updated
+	if updated {
+		log.Infof("refreshVPCCIDRs: updated SNAT and pod IP rules for VPC CIDRs %v", pbVPCcidrs)
+	}
 }
 
 // nodeIPPoolReconcile reconcile ENI and IP info from metadata service and IP addresses in datastore
@@ -987,6 +1326,7 @@ func (c *IPAMContext) nodeIPPoolReconcile(interval time.Duration) {
 			ipamdErrInc("eniReconcileDel")
 			continue
 		}
+		c.teardownENINetwork(eni)
 		reconcileCnt.With(prometheus.Labels{"fn": "eniReconcileDel"}).Inc()
 	}
 	log.Debug("Successfully Reconciled ENI/IP pool")
@@ -1079,6 +1419,18 @@ func UseCustomNetworkCfg() bool {
 	return false
 }
 
+// UsePodEIP returns whether Elastic IPs are associated with the pods that ask for one
+func UsePodEIP() bool {
+	if strValue := os.Getenv(envPodEIP); strValue != "" {
+		parsedValue, err := strconv.ParseBool(strValue)
+		if err == nil {
+			return parsedValue
+		}
+		log.Error("Failed to parse "+envPodEIP+"; using default: false", err.Error())
+	}
+	return false
+}
+
 func getWarmIPTarget() int {
 	inputStr, found := os.LookupEnv(envWarmIPTarget)
 
@@ -1125,33 +1477,6 @@ func filterUnmanagedENIs(enis []awsutils.ENIMetadata) ([]awsutils.ENIMetadata, i
 	return ret, numFiltered
 }
 
-// ipTargetState determines the number of IPs `short` or `over` our WARM_IP_TARGET,
-// accounting for the MINIMUM_IP_TARGET
-func (c *IPAMContext) ipTargetState() (short int, over int, enabled bool) {
-	if c.warmIPTarget == noWarmIPTarget && c.minimumIPTarget == noMinimumIPTarget {
-		// there is no WARM_IP_TARGET defined and no MINIMUM_IP_TARGET, fallback to use all IP addresses on ENI
-		return 0, 0, false
-	}
-
-	total, assigned := c.dataStore.GetStats()
-	available := total - assigned
-
-	// short is greater than 0 when we have fewer available IPs than the warm IP target
-	short = max(c.warmIPTarget-available, 0)
-
-	// short is greater than the warm IP target alone when we have fewer total IPs than the minimum target
-	short = max(short, c.minimumIPTarget-total)
-
-	// over is the number of available IPs we have beyond the warm IP target
-	over = max(available-c.warmIPTarget, 0)
-
-	// over is less than the warm IP target alone if it would imply reducing total IPs below the minimum target
-	over = max(min(over, total-c.minimumIPTarget), 0)
-
-	log.Tracef("Current warm IP stats: target: %d, total: %d, assigned: %d, available: %d, short: %d, over %d", c.warmIPTarget, total, assigned, available, short, over)
-	return short, over, true
-}
-
 // setTerminating atomically sets the terminating flag.
 func (c *IPAMContext) setTerminating() {
 	atomic.StoreInt32(&c.terminating, 1)
@@ -1164,9 +1489,20 @@ func (c *IPAMContext) isTerminating() bool {
 // GetConfigForDebug returns the active values of the configuration env vars (for debugging purposes).
 func GetConfigForDebug() map[string]interface{} {
 	return map[string]interface{}{
-		envWarmIPTarget:     getWarmIPTarget(),
-		envWarmENITarget:    getWarmENITarget(),
-		envCustomNetworkCfg: UseCustomNetworkCfg(),
+		envWarmIPTarget:             getWarmIPTarget(),
+		envWarmENITarget:            getWarmENITarget(),
+		envCustomNetworkCfg:         UseCustomNetworkCfg(),
+		envPodEIP:                   UsePodEIP(),
+		envENISetupConcurrency:      getENISetupConcurrency(),
+		envPoolPolicy:               getPoolPolicyName(),
+		envPredictiveMinWarmIPs:     getPredictiveWarmIPs(envPredictiveMinWarmIPs, defaultPredictiveMinWarmIPs),
+		envPredictiveMaxWarmIPs:     getPredictiveWarmIPs(envPredictiveMaxWarmIPs, 0),
+		envIPReserve:                getIPReserve(),
+		envIPQuotas:                 UseIPQuotas(),
+		envIPPlacement:              getIPPlacement(),
+		envAdminTokenFile:           os.Getenv(envAdminTokenFile),
+		envConsistencyCheckInterval: getConsistencyCheckInterval().String(),
+		envConsistencyAutoFix:       useConsistencyAutoFix(),
 	}
 }
 
@@ -14,15 +14,19 @@
 package ipamd
 
 import (
+	"errors"
 	"net"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
@@ -33,6 +37,7 @@ import (
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
 	mock_k8sapi "github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi/mocks"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
 	mock_networkutils "github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils/mocks"
 )
 
@@ -131,6 +136,7 @@ func TestNodeInit(t *testing.T) {
 	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return(cidrs)
 	mockAWS.EXPECT().GetPrimaryENImac().Return("")
 	mockNetwork.EXPECT().SetupHostNetwork(vpcCIDR, cidrs, "", &primaryIP).Return(nil)
+	mockNetwork.EXPECT().GetRoutingLayout().Return(networkutils.DefaultRoutingLayout)
 
 	mockAWS.EXPECT().GetPrimaryENI().AnyTimes().Return(primaryENIid)
 
@@ -154,16 +160,156 @@ func TestNodeInit(t *testing.T) {
 		Name: k8sName, K8SUID: "pod-uid"}
 	mockCRI.EXPECT().GetRunningPodSandboxes().Return(criList, nil)
 
-	var rules []netlink.Rule
-	mockNetwork.EXPECT().GetRuleList().Return(rules, nil)
+	// The to-pod rule of a previous rule priority base is migrated before the rules are checked and updated
+	podIP := net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.IPv4Mask(255, 255, 255, 255)}
+	staleRules := []netlink.Rule{{Priority: 2048, Dst: &podIP, Table: unix.RT_TABLE_MAIN}}
+	migratedRules := []netlink.Rule{{Priority: networkutils.DefaultRoutingLayout.ToPodRulePriority(), Dst: &podIP,
+		Table: unix.RT_TABLE_MAIN}}
+	gomock.InOrder(
+		mockNetwork.EXPECT().GetRuleList().Return(staleRules, nil),
+		mockNetwork.EXPECT().MigratePodRules(staleRules, podIP, primaryDevice).Return(nil),
+		mockNetwork.EXPECT().GetRuleList().Return(migratedRules, nil),
+		mockNetwork.EXPECT().DeleteMigratedRouteTables(migratedRules).Return(nil),
+		mockNetwork.EXPECT().CheckRoutingConflicts([]string{primaryMAC, secMAC}).Return(
+			[]string{"IP rule 512: from <nil> to 0.0.0.0/0 table 100"}, nil),
+		mockNetwork.EXPECT().UpdateRuleListBySrc(migratedRules, podIP, gomock.Any(), true),
+	)
 
 	mockNetwork.EXPECT().UseExternalSNAT().Return(false)
-	mockNetwork.EXPECT().UpdateRuleListBySrc(gomock.Any(), gomock.Any(), gomock.Any(), true)
+	mockNetwork.EXPECT().UsePerENISNAT().Return(false)
+	mockNetwork.EXPECT().UseEgressIP().Return(false)
 	// Add IPs
 	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), gomock.Any())
 
 	err := mockContext.nodeInit()
 	assert.NoError(t, err)
+	mockContext.waitENISetup()
+}
+
+func TestNodeInitDoesNotWaitForUnusedENIs(t *testing.T) {
+	ctrl, mockAWS, mockK8S, mockCRI, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+	primary := true
+	notPrimary := false
+	testAddr1 := ipaddr01
+	testAddr2 := ipaddr02
+	testAddr11 := ipaddr11
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		maxIPsPerENI:  14,
+		maxENI:        4,
+		warmENITarget: 1,
+		primaryIP:     make(map[string]string),
+		criClient:     mockCRI,
+		networkClient: mockNetwork}
+
+	eni1 := awsutils.ENIMetadata{
+		ENIID:          primaryENIid,
+		MAC:            primaryMAC,
+		DeviceNumber:   primaryDevice,
+		SubnetIPv4CIDR: primarySubnet,
+		IPv4Addresses: []*ec2.NetworkInterfacePrivateIpAddress{
+			{PrivateIpAddress: &testAddr1, Primary: &primary},
+			{PrivateIpAddress: &testAddr2, Primary: &notPrimary},
+		},
+	}
+	eni2 := awsutils.ENIMetadata{
+		ENIID:          secENIid,
+		MAC:            secMAC,
+		DeviceNumber:   secDevice,
+		SubnetIPv4CIDR: secSubnet,
+		IPv4Addresses: []*ec2.NetworkInterfacePrivateIpAddress{
+			{PrivateIpAddress: &testAddr11, Primary: &primary},
+		},
+	}
+	var cidrs []*string
+	mockAWS.EXPECT().GetENILimit().Return(4, nil)
+	mockAWS.EXPECT().GetENIipLimit().Return(14, nil)
+	mockAWS.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{eni1, eni2}, nil)
+	mockAWS.EXPECT().GetVPCIPv4CIDR().Return(vpcCIDR)
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return(cidrs)
+	mockAWS.EXPECT().GetPrimaryENImac().Return("")
+	mockAWS.EXPECT().GetLocalIPv4().Return(ipaddr01)
+	mockAWS.EXPECT().GetPrimaryENI().AnyTimes().Return(primaryENIid)
+	mockNetwork.EXPECT().SetupHostNetwork(gomock.Any(), cidrs, "", gomock.Any()).Return(nil)
+	mockNetwork.EXPECT().CheckRoutingConflicts([]string{primaryMAC, secMAC}).Return(nil, nil)
+	mockNetwork.EXPECT().GetRoutingLayout().Return(networkutils.DefaultRoutingLayout)
+
+	// The secondary ENI has no pods, so nodeInit returns while it is still being set up
+	secENISetup := make(chan struct{})
+	mockNetwork.EXPECT().SetupENINetwork(ipaddr11, secMAC, secDevice, secSubnet).DoAndReturn(
+		func(eniIP string, mac string, deviceNumber int, subnetCIDR string) error {
+			<-secENISetup
+			return nil
+		})
+
+	k8sName := "/k8s_POD_" + "pod1" + "_" + "default" + "_" + "pod-uid" + "_0"
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return([]*k8sapi.K8SPodInfo{{Name: "pod1",
+		Namespace: "default", UID: "pod-uid", IP: ipaddr02}}, nil)
+	criList := map[string]*cri.SandboxInfo{"pod-uid": {ID: "sandbox-id", Name: k8sName, K8SUID: "pod-uid"}}
+	mockCRI.EXPECT().GetRunningPodSandboxes().Return(criList, nil)
+	mockNetwork.EXPECT().GetRuleList().Return(nil, nil).Times(2)
+	mockNetwork.EXPECT().MigratePodRules(nil, gomock.Any(), primaryDevice).Return(nil)
+	mockNetwork.EXPECT().DeleteMigratedRouteTables(nil).Return(nil)
+	mockNetwork.EXPECT().UseExternalSNAT().Return(false)
+	mockNetwork.EXPECT().UsePerENISNAT().Return(false)
+	mockNetwork.EXPECT().UpdateRuleListBySrc(gomock.Any(), gomock.Any(), gomock.Any(), true)
+	mockNetwork.EXPECT().UseEgressIP().Return(false)
+
+	err := mockContext.nodeInit()
+	assert.NoError(t, err)
+	total, assigned := mockContext.dataStore.GetStats()
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 1, assigned)
+	select {
+	case <-mockContext.eniSetupDone:
+		t.Fatal("ENI setup finished before the secondary ENI was set up")
+	default:
+	}
+
+	// Once all ENIs are set up, IPs are added for a new node
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), gomock.Any())
+	mockAWS.EXPECT().DescribeENI(gomock.Any()).Return(nil, nil, nil, nil)
+	close(secENISetup)
+	mockContext.waitENISetup()
+	mockContext.primaryIPLock.Lock()
+	assert.Equal(t, ipaddr11, mockContext.primaryIP[secENIid])
+	assert.Equal(t, secENIid, mockContext.eniMACs[secMAC])
+	mockContext.primaryIPLock.Unlock()
+}
+
+func TestFinishNodeInitWithWarmIPTargetMet(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		dataStore:     datastoreWith3FreeIPs(),
+		maxIPsPerENI:  14,
+		maxENI:        4,
+		warmENITarget: 1,
+		warmIPTarget:  3,
+		eniSetupDone:  make(chan struct{}),
+	}
+
+	// The 3 free IPs meet WARM_IP_TARGET, so no AllocIPAddresses is expected even though the ENI has room
+	mockContext.finishNodeInit(&sync.WaitGroup{})
+	mockContext.waitENISetup()
+}
+
+func TestGetENISetupConcurrency(t *testing.T) {
+	defer os.Unsetenv(envENISetupConcurrency)
+
+	_ = os.Unsetenv(envENISetupConcurrency)
+	assert.Equal(t, defaultENISetupConcurrency, getENISetupConcurrency())
+
+	_ = os.Setenv(envENISetupConcurrency, "8")
+	assert.Equal(t, 8, getENISetupConcurrency())
+
+	_ = os.Setenv(envENISetupConcurrency, "0")
+	assert.Equal(t, defaultENISetupConcurrency, getENISetupConcurrency())
 }
 
 func TestIncreaseIPPoolDefault(t *testing.T) {
@@ -215,10 +361,11 @@ func testIncreaseIPPool(t *testing.T, useENIConfig bool) {
 
 	if useENIConfig {
 		mockENIConfig.EXPECT().MyENIConfig().Return(podENIConfig, nil)
-		mockAWS.EXPECT().AllocENI(true, sg, podENIConfig.Subnet).Return(eni2, nil)
+		mockAWS.EXPECT().AllocENI(true, sg, podENIConfig.Subnet).Return(eni2, secMAC, nil)
 	} else {
-		mockAWS.EXPECT().AllocENI(false, nil, "").Return(eni2, nil)
+		mockAWS.EXPECT().AllocENI(false, nil, "").Return(eni2, secMAC, nil)
 	}
+	mockNetwork.EXPECT().WaitForLink(secMAC, eniAttachTimeout).Return(nil)
 
 	mockAWS.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{
 		{
@@ -257,7 +404,26 @@ func testIncreaseIPPool(t *testing.T, useENIConfig bool) {
 	mockAWS.EXPECT().AllocIPAddresses(eni2, 14)
 	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid)
 
-	mockContext.increaseIPPool()
+	actions, _ := mockContext.poolActions()
+	mockContext.increaseIPPool(actions)
+}
+
+func TestWaitENIAttachedWithoutLinkUpdates(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{awsClient: mockAWS, networkClient: mockNetwork}
+
+	// Without link updates, the instance metadata service is polled
+	mockNetwork.EXPECT().WaitForLink(secMAC, eniAttachTimeout).Return(errors.New("failed to subscribe to link updates"))
+	mockAWS.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{
+		{ENIID: primaryENIid, MAC: primaryMAC, DeviceNumber: primaryDevice},
+		{ENIID: secENIid, MAC: secMAC, DeviceNumber: secDevice},
+	}, nil)
+
+	eniMetadata, err := mockContext.waitENIAttached(secENIid, secMAC)
+	assert.NoError(t, err)
+	assert.Equal(t, secDevice, eniMetadata.DeviceNumber)
 }
 
 func TestTryAddIPToENI(t *testing.T) {
@@ -297,8 +463,9 @@ func TestTryAddIPToENI(t *testing.T) {
 		sg = append(sg, aws.String(sgID))
 	}
 
-	mockAWS.EXPECT().AllocENI(false, nil, "").Return(secENIid, nil)
+	mockAWS.EXPECT().AllocENI(false, nil, "").Return(secENIid, secMAC, nil)
 	mockAWS.EXPECT().AllocIPAddresses(secENIid, warmIpTarget)
+	mockNetwork.EXPECT().WaitForLink(secMAC, eniAttachTimeout).Return(nil)
 	mockAWS.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{
 		{
 			ENIID:          primaryENIid,
@@ -333,7 +500,8 @@ func TestTryAddIPToENI(t *testing.T) {
 	mockNetwork.EXPECT().SetupENINetwork(gomock.Any(), secMAC, secDevice, secSubnet)
 	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid)
 
-	mockContext.increaseIPPool()
+	actions, _ := mockContext.poolActions()
+	mockContext.increaseIPPool(actions)
 }
 
 func TestNodeIPPoolReconcile(t *testing.T) {
@@ -402,13 +570,64 @@ func TestNodeIPPoolReconcile(t *testing.T) {
 	assert.Equal(t, len(curENIs.ENIIPPools), 1)
 	assert.Equal(t, curENIs.TotalIPs, 0)
 
-	// remove eni
+	// remove eni, and its network with it
 	mockAWS.EXPECT().GetAttachedENIs().Return(nil, nil)
+	mockNetwork.EXPECT().TeardownENINetwork(ipaddr01).Return(nil)
 
 	mockContext.nodeIPPoolReconcile(0)
 	curENIs = mockContext.dataStore.GetENIInfos()
 	assert.Equal(t, len(curENIs.ENIIPPools), 0)
 	assert.Equal(t, curENIs.TotalIPs, 0)
+	assert.NotContains(t, mockContext.primaryIP, primaryENIid)
+}
+
+func TestRefreshVPCCIDRs(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		networkClient: mockNetwork,
+		dataStore:     datastore.NewDataStore(),
+	}
+	_ = mockContext.dataStore.AddENI(secENIid, secDevice, false)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(secENIid, ipaddr11)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(secENIid, ipaddr12)
+	_, _, _ = mockContext.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "default", IP: ipaddr11})
+	_, _, _ = mockContext.dataStore.AssignPodIPv4AddressOnENI(&k8sapi.K8SPodInfo{Name: "egress", Sandbox: egressIPSandbox}, secENIid)
+
+	// Unchanged
+	mockAWS.EXPECT().RefreshVPCIPv4CIDRs().Return(false, nil)
+	mockContext.refreshVPCCIDRs(0)
+
+	// A CIDR was added, but updating the SNAT rules fails
+	vpcCIDRs := []*string{aws.String(vpcCIDR), aws.String("100.64.0.0/16")}
+	primaryIP := net.ParseIP(ipaddr01)
+	mockAWS.EXPECT().RefreshVPCIPv4CIDRs().Return(true, nil)
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return(vpcCIDRs)
+	mockAWS.EXPECT().GetLocalIPv4().Return(ipaddr01)
+	mockNetwork.EXPECT().UpdateSNATRules(vpcCIDRs, &primaryIP).Return(errors.New("iptables failure"))
+	mockContext.refreshVPCCIDRs(0)
+	assert.False(t, mockContext.checkHostNetwork().Healthy)
+
+	// The rules are updated on the next try, the egress IP has no rules
+	var rules []netlink.Rule
+	mockAWS.EXPECT().RefreshVPCIPv4CIDRs().Return(false, nil)
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return(vpcCIDRs)
+	mockAWS.EXPECT().GetLocalIPv4().Return(ipaddr01)
+	mockNetwork.EXPECT().UpdateSNATRules(vpcCIDRs, &primaryIP).Return(nil)
+	mockNetwork.EXPECT().GetRuleList().Return(rules, nil)
+	mockNetwork.EXPECT().UseExternalSNAT().Return(false)
+	mockNetwork.EXPECT().UsePerENISNAT().Return(false)
+	mockNetwork.EXPECT().UpdateRuleListBySrc(rules,
+		net.IPNet{IP: net.ParseIP(ipaddr11), Mask: net.IPv4Mask(255, 255, 255, 255)},
+		[]string{vpcCIDR, "100.64.0.0/16"}, true).Return(nil)
+	mockContext.refreshVPCCIDRs(0)
+	assert.False(t, mockContext.vpcCIDRsChanged)
+	assert.True(t, mockContext.checkHostNetwork().Healthy)
+
+	// Not refreshed again before the interval
+	mockContext.refreshVPCCIDRs(time.Hour)
 }
 
 func TestGetWarmENITarget(t *testing.T) {
@@ -428,6 +647,19 @@ func TestGetWarmENITarget(t *testing.T) {
 	assert.Equal(t, warmIPTarget, noWarmIPTarget)
 }
 
+func TestGetIPPlacement(t *testing.T) {
+	defer os.Unsetenv(envIPPlacement)
+
+	_ = os.Unsetenv(envIPPlacement)
+	assert.Equal(t, datastore.PlacementPack, getIPPlacement())
+
+	_ = os.Setenv(envIPPlacement, "spread")
+	assert.Equal(t, datastore.PlacementSpread, getIPPlacement())
+
+	_ = os.Setenv(envIPPlacement, "random")
+	assert.Equal(t, datastore.PlacementPack, getIPPlacement())
+}
+
 func TestGetWarmIPTargetState(t *testing.T) {
 	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
 	defer ctrl.Finish()
@@ -442,11 +674,11 @@ func TestGetWarmIPTargetState(t *testing.T) {
 
 	mockContext.dataStore = datastore.NewDataStore()
 
-	_, _, warmIPTargetDefined := mockContext.ipTargetState()
+	_, _, warmIPTargetDefined := ipTargetState(mockContext.poolStats(), mockContext.poolLimits())
 	assert.False(t, warmIPTargetDefined)
 
 	mockContext.warmIPTarget = 5
-	short, over, warmIPTargetDefined := mockContext.ipTargetState()
+	short, over, warmIPTargetDefined := ipTargetState(mockContext.poolStats(), mockContext.poolLimits())
 	assert.True(t, warmIPTargetDefined)
 	assert.Equal(t, 5, short)
 	assert.Equal(t, 0, over)
@@ -456,7 +688,7 @@ func TestGetWarmIPTargetState(t *testing.T) {
 	_ = mockContext.dataStore.AddIPv4AddressToStore("eni-1", "1.1.1.1")
 	_ = mockContext.dataStore.AddIPv4AddressToStore("eni-1", "1.1.1.2")
 
-	short, over, warmIPTargetDefined = mockContext.ipTargetState()
+	short, over, warmIPTargetDefined = ipTargetState(mockContext.poolStats(), mockContext.poolLimits())
 	assert.True(t, warmIPTargetDefined)
 	assert.Equal(t, 3, short)
 	assert.Equal(t, 0, over)
@@ -466,13 +698,13 @@ func TestGetWarmIPTargetState(t *testing.T) {
 	_ = mockContext.dataStore.AddIPv4AddressToStore("eni-1", "1.1.1.4")
 	_ = mockContext.dataStore.AddIPv4AddressToStore("eni-1", "1.1.1.5")
 
-	short, over, warmIPTargetDefined = mockContext.ipTargetState()
+	short, over, warmIPTargetDefined = ipTargetState(mockContext.poolStats(), mockContext.poolLimits())
 	assert.True(t, warmIPTargetDefined)
 	assert.Equal(t, 0, short)
 	assert.Equal(t, 0, over)
 }
 
-func TestIPAMContext_nodeIPPoolTooLow(t *testing.T) {
+func TestIPAMContext_poolActionsAddIPs(t *testing.T) {
 	ctrl, mockAWS, mockK8S, _, mockNetwork, mockENIConfig := setup(t)
 	defer ctrl.Finish()
 
@@ -511,8 +743,9 @@ func TestIPAMContext_nodeIPPoolTooLow(t *testing.T) {
 				warmENITarget:       tt.fields.warmENITarget,
 				warmIPTarget:        tt.fields.warmIPTarget,
 			}
-			if got := c.nodeIPPoolTooLow(); got != tt.want {
-				t.Errorf("nodeIPPoolTooLow() = %v, want %v", got, tt.want)
+			actions, _ := c.poolActions()
+			if got := actions.AddIPs > 0; got != tt.want {
+				t.Errorf("poolActions().AddIPs > 0 = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -0,0 +1,259 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/retry"
+)
+
+const (
+	// circuitBreakerThreshold is the number of consecutive failures to increase the pool that opens the circuit
+	circuitBreakerThreshold = 10
+
+	// circuitOpenDuration is how long the pool is not increased once the circuit is open. After that, one attempt is
+	// made to find out if the failures are over.
+	circuitOpenDuration = 15 * time.Minute
+
+	// demandResetInterval is the minimum time between two resets of the backoff by pods that got no IP
+	demandResetInterval = time.Minute
+
+	poolBackoffJitter   = 0.2
+	poolBackoffMultiple = 2
+)
+
+// failureClass groups the errors of the pool increase that need the same backoff
+type failureClass string
+
+const (
+	failureThrottling failureClass = "throttling"
+	failureLimit      failureClass = "limit"
+	failureSubnetFull failureClass = "subnet_full"
+	failurePermission failureClass = "permission"
+	failureOther      failureClass = "other"
+)
+
+// failureBackoffs are the first and the maximum backoff of each failure class. Throttling clears up quickly, while
+// missing permissions and full subnets need someone to fix them.
+var failureBackoffs = map[failureClass][2]time.Duration{
+	failureThrottling: {5 * time.Second, 2 * time.Minute},
+	failureLimit:      {30 * time.Second, 10 * time.Minute},
+	failureSubnetFull: {30 * time.Second, 10 * time.Minute},
+	failurePermission: {time.Minute, 30 * time.Minute},
+	failureOther:      {10 * time.Second, 5 * time.Minute},
+}
+
+// classifyPoolFailure returns the failure class of an error from AllocENI or AllocIPAddresses
+func classifyPoolFailure(err error) failureClass {
+	aerr, ok := errors.Cause(err).(awserr.Error)
+	if !ok {
+		return failureOther
+	}
+	switch aerr.Code() {
+	case "RequestLimitExceeded", "Throttling", "ThrottlingException":
+		return failureThrottling
+	case "AttachmentLimitExceeded", "NetworkInterfaceLimitExceeded", "PrivateIpAddressLimitExceeded":
+		return failureLimit
+	case "InsufficientFreeAddressesInSubnet":
+		return failureSubnetFull
+	case "UnauthorizedOperation", "AccessDenied", "AuthFailure":
+		return failurePermission
+	}
+	return failureOther
+}
+
+// circuitState is the state of the circuit breaker of the pool increase
+type circuitState int
+
+const (
+	// circuitClosed lets the pool increase run, with a backoff after failures
+	circuitClosed circuitState = iota
+	// circuitHalfOpen lets one attempt run after the circuit was open
+	circuitHalfOpen
+	// circuitOpen stops the pool increase after persistent failures
+	circuitOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitHalfOpen:
+		return "half-open"
+	case circuitOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+var (
+	poolIncreaseCircuitState = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "awscni_pool_increase_circuit_state",
+			Help: "The state of the circuit breaker of the IP pool increase: 0 closed, 1 half-open, 2 open",
+		},
+	)
+	poolIncreaseFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "awscni_pool_increase_failures",
+			Help: "The number of failures to increase the IP pool, by failure class",
+		},
+		[]string{"class"},
+	)
+)
+
+// PoolBackoffState is the state of the backoff and circuit breaker of the pool increase, for introspection
+type PoolBackoffState struct {
+	CircuitState        string
+	ConsecutiveFailures int
+	LastFailureClass    string `json:",omitempty"`
+	LastError           string `json:",omitempty"`
+	RetryAt             time.Time
+}
+
+// poolBackoff keeps increaseIPPool from calling EC2 on every tick while it keeps failing. Each failure class has its
+// own exponential backoff, and the circuit opens after circuitBreakerThreshold consecutive failures.
+type poolBackoff struct {
+	lock                sync.Mutex
+	backoffs            map[failureClass]*retry.SimpleBackoff
+	state               circuitState
+	consecutiveFailures int
+	lastFailureClass    failureClass
+	lastError           string
+	retryAt             time.Time
+	lastDemandReset     time.Time
+}
+
+func newPoolBackoff() *poolBackoff {
+	backoffs := make(map[failureClass]*retry.SimpleBackoff, len(failureBackoffs))
+	for class, durations := range failureBackoffs {
+		backoffs[class] = retry.NewSimpleBackoff(durations[0], durations[1], poolBackoffJitter, poolBackoffMultiple)
+	}
+	return &poolBackoff{backoffs: backoffs}
+}
+
+// allow returns whether the pool increase may call EC2 now. A nil poolBackoff always allows it.
+func (b *poolBackoff) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if now.Before(b.retryAt) {
+		return false
+	}
+	if b.state == circuitOpen {
+		log.Infof("Trying to increase the IP pool again after the circuit was open for %v", circuitOpenDuration)
+		b.setState(circuitHalfOpen)
+	}
+	return true
+}
+
+// success closes the circuit and resets the backoffs
+func (b *poolBackoff) success() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state != circuitClosed {
+		log.Infof("Increased the IP pool, closing the circuit")
+	}
+	b.setState(circuitClosed)
+	b.consecutiveFailures = 0
+	b.lastFailureClass = ""
+	b.lastError = ""
+	b.retryAt = time.Time{}
+	for _, backoff := range b.backoffs {
+		backoff.Reset()
+	}
+}
+
+// failure delays the next pool increase by the backoff of the error's class, or opens the circuit
+func (b *poolBackoff) failure(now time.Time, err error) {
+	class := classifyPoolFailure(err)
+	poolIncreaseFailures.WithLabelValues(string(class)).Inc()
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.consecutiveFailures++
+	b.lastFailureClass = class
+	b.lastError = err.Error()
+	if b.state == circuitHalfOpen || b.consecutiveFailures >= circuitBreakerThreshold {
+		if b.state != circuitOpen {
+			log.Errorf("Failed to increase the IP pool %d times in a row (%s), not trying again for %v: %v",
+				b.consecutiveFailures, class, circuitOpenDuration, err)
+		}
+		b.setState(circuitOpen)
+		b.retryAt = now.Add(circuitOpenDuration)
+		return
+	}
+	delay := b.backoffs[class].Duration()
+	log.Warnf("Failed to increase the IP pool (%s), trying again in %v", class, delay)
+	b.retryAt = now.Add(delay)
+}
+
+// demand lets the next pool increase run right away because a pod got no IP. An open circuit becomes half-open, so a
+// single attempt is made. It does nothing if the last reset was less than demandResetInterval ago, so that pods
+// that keep failing don't undo the backoff.
+func (b *poolBackoff) demand(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.retryAt.IsZero() || now.Before(b.lastDemandReset.Add(demandResetInterval)) {
+		return
+	}
+	log.Infof("A pod is waiting for an IP, resetting the IP pool increase backoff (circuit %s)", b.state)
+	b.lastDemandReset = now
+	b.retryAt = time.Time{}
+	if b.state == circuitOpen {
+		b.setState(circuitHalfOpen)
+	}
+}
+
+// setState must be called with the lock held
+func (b *poolBackoff) setState(state circuitState) {
+	b.state = state
+	poolIncreaseCircuitState.Set(float64(state))
+}
+
+// getState returns the state of the backoff for introspection
+func (b *poolBackoff) getState() PoolBackoffState {
+	if b == nil {
+		return PoolBackoffState{CircuitState: circuitClosed.String()}
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return PoolBackoffState{
+		CircuitState:        b.state.String(),
+		ConsecutiveFailures: b.consecutiveFailures,
+		LastFailureClass:    string(b.lastFailureClass),
+		LastError:           b.lastError,
+		RetryAt:             b.retryAt,
+	}
+}
+
+// GetPoolBackoffState returns the state of the backoff and circuit breaker of the IP pool increase
+func (c *IPAMContext) GetPoolBackoffState() PoolBackoffState {
+	return c.poolBackoff.getState()
+}
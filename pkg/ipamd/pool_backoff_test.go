@@ -0,0 +1,136 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/golang/mock/gomock"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+func TestClassifyPoolFailure(t *testing.T) {
+	assert.Equal(t, failureThrottling, classifyPoolFailure(awserr.New("RequestLimitExceeded", "", nil)))
+	assert.Equal(t, failureLimit, classifyPoolFailure(
+		pkgerrors.Wrap(awserr.New("AttachmentLimitExceeded", "", nil), "AllocENI: error attaching ENI")))
+	assert.Equal(t, failureSubnetFull, classifyPoolFailure(awserr.New("InsufficientFreeAddressesInSubnet", "", nil)))
+	assert.Equal(t, failurePermission, classifyPoolFailure(awserr.New("UnauthorizedOperation", "", nil)))
+	assert.Equal(t, failureOther, classifyPoolFailure(awserr.New("InternalError", "", nil)))
+	assert.Equal(t, failureOther, classifyPoolFailure(errors.New("no free device number")))
+}
+
+func TestPoolBackoff(t *testing.T) {
+	b := newPoolBackoff()
+	now := time.Now()
+	assert.True(t, b.allow(now))
+
+	// Each failure class has its own backoff
+	b.failure(now, awserr.New("RequestLimitExceeded", "", nil))
+	assert.False(t, b.allow(now))
+	assert.True(t, b.allow(now.Add(7*time.Second)))
+	b.failure(now, awserr.New("UnauthorizedOperation", "", nil))
+	assert.False(t, b.allow(now.Add(59*time.Second)))
+	assert.True(t, b.allow(now.Add(73*time.Second)))
+
+	// The backoff grows with the failures of a class
+	b.failure(now, awserr.New("UnauthorizedOperation", "", nil))
+	assert.False(t, b.allow(now.Add(119*time.Second)))
+	assert.Equal(t, 3, b.getState().ConsecutiveFailures)
+	assert.Equal(t, "permission", b.getState().LastFailureClass)
+
+	b.success()
+	assert.True(t, b.allow(now))
+	assert.Equal(t, PoolBackoffState{CircuitState: "closed"}, b.getState())
+	b.failure(now, awserr.New("UnauthorizedOperation", "", nil))
+	assert.True(t, b.allow(now.Add(73*time.Second)))
+}
+
+func TestPoolBackoffCircuitBreaker(t *testing.T) {
+	b := newPoolBackoff()
+	now := time.Now()
+	err := awserr.New("InsufficientFreeAddressesInSubnet", "", nil)
+	for i := 0; i < circuitBreakerThreshold-1; i++ {
+		b.failure(now, err)
+	}
+	assert.Equal(t, "closed", b.getState().CircuitState)
+	b.failure(now, err)
+	assert.Equal(t, "open", b.getState().CircuitState)
+	assert.Equal(t, now.Add(circuitOpenDuration), b.getState().RetryAt)
+	assert.False(t, b.allow(now.Add(circuitOpenDuration-time.Second)))
+
+	// One attempt after the circuit was open, which opens it again if it fails
+	assert.True(t, b.allow(now.Add(circuitOpenDuration)))
+	assert.Equal(t, "half-open", b.getState().CircuitState)
+	b.failure(now.Add(circuitOpenDuration), err)
+	assert.Equal(t, "open", b.getState().CircuitState)
+
+	now = now.Add(2 * circuitOpenDuration)
+	assert.True(t, b.allow(now))
+	b.success()
+	assert.Equal(t, "closed", b.getState().CircuitState)
+	assert.Equal(t, 0, b.getState().ConsecutiveFailures)
+}
+
+func TestPoolBackoffDemand(t *testing.T) {
+	b := newPoolBackoff()
+	now := time.Now()
+	for i := 0; i < circuitBreakerThreshold; i++ {
+		b.failure(now, errors.New("failed"))
+	}
+	assert.False(t, b.allow(now))
+
+	// A pod waiting for an IP allows one attempt right away
+	b.demand(now)
+	assert.Equal(t, "half-open", b.getState().CircuitState)
+	assert.True(t, b.allow(now))
+	b.failure(now, errors.New("failed"))
+	assert.False(t, b.allow(now))
+
+	// Pods that keep waiting don't undo the backoff
+	b.demand(now.Add(time.Second))
+	assert.False(t, b.allow(now.Add(time.Second)))
+	b.demand(now.Add(demandResetInterval))
+	assert.True(t, b.allow(now.Add(demandResetInterval)))
+}
+
+func TestIncreaseIPPoolBacksOff(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		maxIPsPerENI:  14,
+		maxENI:        4,
+		warmENITarget: 1,
+		dataStore:     datastore.NewDataStore(),
+		poolBackoff:   newPoolBackoff(),
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+
+	// Only one attempt while backing off
+	mockAWS.EXPECT().AllocIPAddresses(primaryENIid, gomock.Any()).Return(awserr.New("UnauthorizedOperation", "", nil)).Times(2)
+	mockAWS.EXPECT().AllocENI(false, nil, "").Return("", "", awserr.New("UnauthorizedOperation", "", nil))
+	actions, _ := mockContext.poolActions()
+	mockContext.increaseIPPool(actions)
+	actions, _ = mockContext.poolActions()
+	mockContext.increaseIPPool(actions)
+	assert.Equal(t, "permission", mockContext.GetPoolBackoffState().LastFailureClass)
+}
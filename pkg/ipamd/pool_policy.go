@@ -0,0 +1,249 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// This environment variable is used to specify the policy that decides when the IP pool grows and shrinks.
+	// Default is "default", which uses WARM_ENI_TARGET, WARM_IP_TARGET and MINIMUM_IP_TARGET. "predictive" computes
+	// the warm IP target from the rate of added pods.
+	envPoolPolicy = "AWS_VPC_K8S_CNI_POOL_POLICY"
+
+	defaultPoolPolicyName = "default"
+)
+
+// PoolStats is the state of the IP pool a PoolPolicy decides on
+type PoolStats struct {
+	// TotalIPs is the number of IPs in the pool, without the primary IPs of the ENIs
+	TotalIPs int
+	// AssignedIPs is the number of IPs assigned to pods, plus the size of the IP reserve so that the reserve is kept on
+	// top of the warm pool
+	AssignedIPs int
+	// ENIs is the number of ENIs in the pool
+	ENIs int
+}
+
+// PoolLimits are the limits of the instance and the targets configured for the IP pool
+type PoolLimits struct {
+	// MaxENIs is the number of ENIs ipamd may attach, without the unmanaged ENIs
+	MaxENIs         int
+	MaxIPsPerENI    int
+	WarmENITarget   int
+	WarmIPTarget    int
+	MinimumIPTarget int
+}
+
+// PoolActions are the changes of the IP pool a PoolPolicy asks for
+type PoolActions struct {
+	// AddIPs is the number of IPs the pool is short. ipamd fills up an ENI that has room for more IPs, or else attaches
+	// an ENI with AddIPs IPs if AddENI is set.
+	AddIPs int
+	AddENI bool
+	// FreeIPs is the number of unassigned IPs to release
+	FreeIPs int
+	// FreeENI frees an ENI without pods, if the data store finds one that isn't needed for WarmIPTarget and
+	// MINIMUM_IP_TARGET
+	FreeENI bool
+}
+
+// PoolPolicy decides how the IP pool should change. It is called by the pool manager on every tick, so it must not
+// block.
+type PoolPolicy interface {
+	// Name is the value of AWS_VPC_K8S_CNI_POOL_POLICY that selects the policy
+	Name() string
+	// Decide returns the changes of the IP pool for its current state
+	Decide(stats PoolStats, limits PoolLimits) PoolActions
+}
+
+// podObserver is implemented by the pool policies that learn from the pods added to and deleted from the node. The
+// key identifies the pod's sandbox.
+type podObserver interface {
+	PodAdded(key string)
+	PodDeleted(key string)
+}
+
+// poolLimiter is implemented by the pool policies that set some of the limits themselves, such as the warm IP target.
+// Limits returns the limits of the last decision.
+type poolLimiter interface {
+	Limits(limits PoolLimits) PoolLimits
+}
+
+// poolPolicyExplainer is implemented by the pool policies that can explain their last decision
+type poolPolicyExplainer interface {
+	Explain() interface{}
+}
+
+// PoolPolicyState is the last decision of the pool policy, for introspection
+type PoolPolicyState struct {
+	Policy      string
+	DecidedAt   time.Time
+	Stats       PoolStats
+	Limits      PoolLimits
+	Actions     PoolActions
+	Explanation interface{} `json:",omitempty"`
+}
+
+// poolPolicies are the policies that can be selected with AWS_VPC_K8S_CNI_POOL_POLICY
+var poolPolicies = map[string]func() PoolPolicy{
+	defaultPoolPolicyName:    func() PoolPolicy { return defaultPoolPolicy{} },
+	predictivePoolPolicyName: newPredictivePoolPolicy,
+}
+
+// getPoolPolicyName returns the name of the policy selected with AWS_VPC_K8S_CNI_POOL_POLICY, or of the default policy
+// if it is not set or unknown
+func getPoolPolicyName() string {
+	name := os.Getenv(envPoolPolicy)
+	if _, ok := poolPolicies[name]; !ok {
+		return defaultPoolPolicyName
+	}
+	return name
+}
+
+// getPoolPolicy returns the policy selected with AWS_VPC_K8S_CNI_POOL_POLICY, or the default policy if it is not set
+// or unknown
+func getPoolPolicy() PoolPolicy {
+	name := getPoolPolicyName()
+	if selected := os.Getenv(envPoolPolicy); selected != "" && selected != name {
+		log.Errorf("Unknown %s %q, using the %s pool policy", envPoolPolicy, selected, defaultPoolPolicyName)
+	}
+	log.Debugf("Using %s %s", envPoolPolicy, name)
+	return poolPolicies[name]()
+}
+
+// defaultPoolPolicy keeps WARM_IP_TARGET free IPs, and no less than MINIMUM_IP_TARGET IPs in total, when one of them
+// is set. Otherwise it keeps WARM_ENI_TARGET ENIs worth of free IPs.
+type defaultPoolPolicy struct{}
+
+func (defaultPoolPolicy) Name() string {
+	return defaultPoolPolicyName
+}
+
+func (defaultPoolPolicy) Decide(stats PoolStats, limits PoolLimits) PoolActions {
+	var actions PoolActions
+	short, over, warmIPTargetDefined := ipTargetState(stats, limits)
+	if warmIPTargetDefined {
+		actions.AddIPs = short
+		// The pool is only ever reported too high if WARM_IP_TARGET is set
+		actions.FreeIPs = over
+		// getDeletableENI checks that the ENI isn't needed for the targets
+		actions.FreeENI = true
+	} else {
+		available := stats.TotalIPs - stats.AssignedIPs
+		if available < limits.MaxIPsPerENI*limits.WarmENITarget || (limits.WarmENITarget == 0 && available == 0) {
+			log.Tracef("IP pool is too low: available (%d) < ENI target (%d) * addrsPerENI (%d)", available, limits.WarmENITarget, limits.MaxIPsPerENI)
+			actions.AddIPs = limits.MaxIPsPerENI
+		}
+		// We need the +1 to make sure we are not going below the WARM_ENI_TARGET.
+		actions.FreeENI = available >= (limits.WarmENITarget+1)*limits.MaxIPsPerENI
+		if actions.FreeENI {
+			log.Tracef("It might be possible to remove extra ENIs because available (%d) >= (ENI target (%d) + 1) * addrsPerENI (%d)", available, limits.WarmENITarget, limits.MaxIPsPerENI)
+		}
+	}
+	actions.AddENI = actions.AddIPs > 0 && stats.ENIs < limits.MaxENIs
+	return actions
+}
+
+// ipTargetState determines the number of IPs `short` or `over` our WARM_IP_TARGET,
+// accounting for the MINIMUM_IP_TARGET
+func ipTargetState(stats PoolStats, limits PoolLimits) (short int, over int, enabled bool) {
+	if limits.WarmIPTarget == noWarmIPTarget && limits.MinimumIPTarget == noMinimumIPTarget {
+		// there is no WARM_IP_TARGET defined and no MINIMUM_IP_TARGET, fallback to use all IP addresses on ENI
+		return 0, 0, false
+	}
+
+	total, assigned := stats.TotalIPs, stats.AssignedIPs
+	available := total - assigned
+
+	// short is greater than 0 when we have fewer available IPs than the warm IP target
+	short = max(limits.WarmIPTarget-available, 0)
+
+	// short is greater than the warm IP target alone when we have fewer total IPs than the minimum target
+	short = max(short, limits.MinimumIPTarget-total)
+
+	// over is the number of available IPs we have beyond the warm IP target
+	over = max(available-limits.WarmIPTarget, 0)
+
+	// over is less than the warm IP target alone if it would imply reducing total IPs below the minimum target
+	over = max(min(over, total-limits.MinimumIPTarget), 0)
+
+	log.Tracef("Current warm IP stats: target: %d, total: %d, assigned: %d, available: %d, short: %d, over %d", limits.WarmIPTarget, total, assigned, available, short, over)
+	return short, over, true
+}
+
+// poolActions asks the pool policy how the IP pool should change, and returns the limits it decided with
+func (c *IPAMContext) poolActions() (PoolActions, PoolLimits) {
+	policy := c.poolPolicy
+	if policy == nil {
+		policy = defaultPoolPolicy{}
+	}
+	stats, limits := c.poolStats(), c.poolLimits()
+	logPoolStats(stats.TotalIPs, stats.AssignedIPs, c.maxIPsPerENI)
+	actions := policy.Decide(stats, limits)
+	log.Tracef("The %s pool policy decided %+v for %+v", policy.Name(), actions, stats)
+	if limiter, ok := policy.(poolLimiter); ok {
+		limits = limiter.Limits(limits)
+	}
+
+	state := PoolPolicyState{Policy: policy.Name(), DecidedAt: time.Now(), Stats: stats, Limits: limits, Actions: actions}
+	if explainer, ok := policy.(poolPolicyExplainer); ok {
+		state.Explanation = explainer.Explain()
+	}
+	c.poolPolicyLock.Lock()
+	c.poolPolicyState = state
+	c.poolPolicyLock.Unlock()
+	return actions, limits
+}
+
+// GetPoolPolicyState returns the last decision of the pool policy
+func (c *IPAMContext) GetPoolPolicyState() PoolPolicyState {
+	c.poolPolicyLock.Lock()
+	defer c.poolPolicyLock.Unlock()
+	return c.poolPolicyState
+}
+
+// observePodAdded tells the pool policy about a pod that got an IP, if it learns from pods
+func (c *IPAMContext) observePodAdded(key string) {
+	if observer, ok := c.poolPolicy.(podObserver); ok {
+		observer.PodAdded(key)
+	}
+}
+
+// observePodDeleted tells the pool policy about a pod that released its IP, if it learns from pods
+func (c *IPAMContext) observePodDeleted(key string) {
+	if observer, ok := c.poolPolicy.(podObserver); ok {
+		observer.PodDeleted(key)
+	}
+}
+
+func (c *IPAMContext) poolStats() PoolStats {
+	total, assigned := c.dataStore.GetStats()
+	reserve, _ := c.dataStore.GetIPReserve()
+	return PoolStats{TotalIPs: total, AssignedIPs: assigned + reserve, ENIs: c.dataStore.GetENIs()}
+}
+
+func (c *IPAMContext) poolLimits() PoolLimits {
+	return PoolLimits{
+		MaxENIs:         c.maxENI - c.unmanagedENI,
+		MaxIPsPerENI:    c.maxIPsPerENI,
+		WarmENITarget:   c.warmENITarget,
+		WarmIPTarget:    c.warmIPTarget,
+		MinimumIPTarget: c.minimumIPTarget,
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultPoolPolicy(t *testing.T) {
+	warmENI := PoolLimits{MaxENIs: 3, MaxIPsPerENI: 10, WarmENITarget: 1}
+	warmIP := PoolLimits{MaxENIs: 3, MaxIPsPerENI: 10, WarmENITarget: 1, WarmIPTarget: 5}
+	minimumIP := PoolLimits{MaxENIs: 3, MaxIPsPerENI: 10, WarmIPTarget: 2, MinimumIPTarget: 15}
+
+	tests := []struct {
+		name   string
+		stats  PoolStats
+		limits PoolLimits
+		want   PoolActions
+	}{
+		{"new node, warm ENI", PoolStats{ENIs: 1}, warmENI, PoolActions{AddIPs: 10, AddENI: true}},
+		{"one ENI of free IPs", PoolStats{TotalIPs: 10, ENIs: 1}, warmENI, PoolActions{}},
+		{"one IP short of an ENI of free IPs", PoolStats{TotalIPs: 10, AssignedIPs: 1, ENIs: 1}, warmENI, PoolActions{AddIPs: 10, AddENI: true}},
+		{"max ENIs reached", PoolStats{TotalIPs: 30, AssignedIPs: 25, ENIs: 3}, warmENI, PoolActions{AddIPs: 10}},
+		{"two ENIs of free IPs", PoolStats{TotalIPs: 20, ENIs: 2}, warmENI, PoolActions{FreeENI: true}},
+		{"new node, warm IP", PoolStats{ENIs: 1}, warmIP, PoolActions{AddIPs: 5, AddENI: true, FreeENI: true}},
+		{"warm IPs reached", PoolStats{TotalIPs: 8, AssignedIPs: 3, ENIs: 1}, warmIP, PoolActions{FreeENI: true}},
+		{"too many warm IPs", PoolStats{TotalIPs: 10, AssignedIPs: 3, ENIs: 1}, warmIP, PoolActions{FreeIPs: 2, FreeENI: true}},
+		{"below minimum IPs", PoolStats{TotalIPs: 10, ENIs: 1}, minimumIP, PoolActions{AddIPs: 5, AddENI: true, FreeENI: true}},
+		{"minimum IPs kept", PoolStats{TotalIPs: 15, ENIs: 2}, minimumIP, PoolActions{FreeENI: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, defaultPoolPolicy{}.Decide(tt.stats, tt.limits))
+		})
+	}
+}
+
+func TestGetPoolPolicy(t *testing.T) {
+	defer os.Unsetenv(envPoolPolicy)
+
+	_ = os.Unsetenv(envPoolPolicy)
+	assert.Equal(t, defaultPoolPolicyName, getPoolPolicy().Name())
+
+	_ = os.Setenv(envPoolPolicy, "default")
+	assert.Equal(t, defaultPoolPolicyName, getPoolPolicy().Name())
+
+	_ = os.Setenv(envPoolPolicy, "predictive")
+	assert.Equal(t, predictivePoolPolicyName, getPoolPolicy().Name())
+	assert.Equal(t, predictivePoolPolicyName, getPoolPolicyName())
+
+	_ = os.Setenv(envPoolPolicy, "unknown")
+	assert.Equal(t, defaultPoolPolicyName, getPoolPolicy().Name())
+	assert.Equal(t, defaultPoolPolicyName, getPoolPolicyName())
+}
@@ -0,0 +1,246 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+const (
+	predictivePoolPolicyName = "predictive"
+
+	// This environment variable is used to specify the fewest free IPs the predictive pool policy keeps. Default is 1.
+	envPredictiveMinWarmIPs     = "AWS_VPC_K8S_CNI_PREDICTIVE_MIN_WARM_IPS"
+	defaultPredictiveMinWarmIPs = 1
+
+	// This environment variable is used to specify the most free IPs the predictive pool policy keeps. Default is the
+	// number of IPs of an ENI.
+	envPredictiveMaxWarmIPs = "AWS_VPC_K8S_CNI_PREDICTIVE_MAX_WARM_IPS"
+
+	// predictionWindow is how far back the rate of added pods is measured
+	predictionWindow = 10 * time.Minute
+
+	// burstWindow is a shorter window, so that the rate follows a burst of pods right away
+	burstWindow = time.Minute
+
+	// maxLifetimeSamples is the number of recent pod lifetimes the mean lifetime is computed from
+	maxLifetimeSamples = 100
+
+	// maxTrackedPodAge is how long the start of a pod is remembered. Older pods don't change the mean lifetime much.
+	maxTrackedPodAge = 24 * time.Hour
+
+	// defaultAPILatency is used for an AWS API until a call to it succeeded
+	defaultAPILatency = time.Second
+)
+
+// PredictiveExplanation is how the predictive pool policy came to its last warm IP target
+type PredictiveExplanation struct {
+	// AddsPerMinute is the rate of added pods, the higher one of the last 10 minutes and the last minute
+	AddsPerMinute     float64
+	AddsInWindow      int
+	AddsInBurstWindow int
+	// ReleasesPerMinute is the rate at which the assigned IPs are expected to be released, from the mean pod lifetime
+	ReleasesPerMinute      float64
+	MeanPodLifetimeSeconds float64
+	PodLifetimeSamples     int
+	// AllocationLatencySeconds is the latency of adding IPs to an ENI, or of adding an ENI if they are all full
+	AllocationLatencySeconds float64
+	ENIAllocation            bool
+	// LeadTimeSeconds is how long a pod waits for new IPs: the allocation latency plus the pool manager interval
+	LeadTimeSeconds float64
+	// ExpectedDemand is the number of pods expected to be added within the lead time
+	ExpectedDemand float64
+	// CoolingIPs is the number of released IPs expected to be in their cooling period, when they can't be reused
+	CoolingIPs   float64
+	MinWarmIPs   int
+	MaxWarmIPs   int
+	WarmIPTarget int
+	Reason       string
+}
+
+// predictivePoolPolicy sizes the warm pool to cover the pods expected to be added while new IPs are allocated. It
+// keeps WarmIPTarget free IPs like the default policy does with WARM_IP_TARGET, but computes the target from the
+// rate of added pods, their lifetimes and the measured EC2 latency.
+type predictivePoolPolicy struct {
+	minWarmIPs int
+	// maxWarmIPs is the number of IPs of an ENI if it is 0
+	maxWarmIPs int
+	apiLatency func(api string) (time.Duration, bool)
+	now        func() time.Time
+
+	lock        sync.Mutex
+	adds        []time.Time
+	podStarts   map[string]time.Time
+	lifetimes   []time.Duration
+	explanation PredictiveExplanation
+}
+
+func newPredictivePoolPolicy() PoolPolicy {
+	return &predictivePoolPolicy{
+		minWarmIPs: getPredictiveWarmIPs(envPredictiveMinWarmIPs, defaultPredictiveMinWarmIPs),
+		maxWarmIPs: getPredictiveWarmIPs(envPredictiveMaxWarmIPs, 0),
+		apiLatency: awsutils.APILatency,
+		now:        time.Now,
+		podStarts:  make(map[string]time.Time),
+	}
+}
+
+// getPredictiveWarmIPs returns a positive bound of the warm IP target from the environment
+func getPredictiveWarmIPs(envName string, def int) int {
+	inputStr, found := os.LookupEnv(envName)
+	if !found {
+		return def
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input > 0 {
+		log.Debugf("Using %s %v", envName, input)
+		return input
+	}
+	log.Errorf("Invalid %s %q, using the default", envName, inputStr)
+	return def
+}
+
+func (p *predictivePoolPolicy) Name() string {
+	return predictivePoolPolicyName
+}
+
+// PodAdded counts a pod that got an IP
+func (p *predictivePoolPolicy) PodAdded(key string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := p.now()
+	p.adds = append(p.adds, now)
+	p.podStarts[key] = now
+}
+
+// PodDeleted records the lifetime of a pod that released its IP
+func (p *predictivePoolPolicy) PodDeleted(key string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	start, ok := p.podStarts[key]
+	if !ok {
+		// The pod was added before ipamd started
+		return
+	}
+	delete(p.podStarts, key)
+	p.lifetimes = append(p.lifetimes, p.now().Sub(start))
+	if len(p.lifetimes) > maxLifetimeSamples {
+		p.lifetimes = p.lifetimes[len(p.lifetimes)-maxLifetimeSamples:]
+	}
+}
+
+func (p *predictivePoolPolicy) Decide(stats PoolStats, limits PoolLimits) PoolActions {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := p.now()
+	p.forget(now)
+
+	e := PredictiveExplanation{
+		AddsInWindow: len(p.adds),
+		MinWarmIPs:   p.minWarmIPs,
+		MaxWarmIPs:   p.maxWarmIPs,
+	}
+	if e.MaxWarmIPs == 0 {
+		e.MaxWarmIPs = limits.MaxIPsPerENI
+	}
+	for _, added := range p.adds {
+		if now.Sub(added) <= burstWindow {
+			e.AddsInBurstWindow++
+		}
+	}
+	e.AddsPerMinute = math.Max(float64(e.AddsInWindow)/predictionWindow.Minutes(), float64(e.AddsInBurstWindow)/burstWindow.Minutes())
+
+	var meanLifetime time.Duration
+	for _, lifetime := range p.lifetimes {
+		meanLifetime += lifetime
+	}
+	e.PodLifetimeSamples = len(p.lifetimes)
+	if e.PodLifetimeSamples > 0 {
+		meanLifetime /= time.Duration(e.PodLifetimeSamples)
+		e.MeanPodLifetimeSeconds = meanLifetime.Seconds()
+		if meanLifetime > 0 {
+			e.ReleasesPerMinute = float64(stats.AssignedIPs) / meanLifetime.Minutes()
+		}
+	}
+
+	// New IPs go to an ENI that has room for them, or else to a new ENI
+	e.ENIAllocation = stats.TotalIPs >= stats.ENIs*limits.MaxIPsPerENI
+	latency := p.latency("AssignPrivateIpAddresses")
+	if e.ENIAllocation {
+		latency += p.latency("CreateNetworkInterface") + p.latency("AttachNetworkInterface")
+	}
+	e.AllocationLatencySeconds = latency.Seconds()
+	leadTime := latency + ipPoolMonitorInterval
+	e.LeadTimeSeconds = leadTime.Seconds()
+
+	e.ExpectedDemand = e.AddsPerMinute * leadTime.Minutes()
+	// Short-lived pods release IPs that can't be assigned again until their cooling period is over
+	e.CoolingIPs = math.Min(e.AddsPerMinute, e.ReleasesPerMinute) * datastore.AddressCoolingPeriod.Minutes()
+
+	target := int(math.Ceil(e.ExpectedDemand + e.CoolingIPs))
+	e.WarmIPTarget = max(e.MinWarmIPs, min(target, e.MaxWarmIPs))
+	e.Reason = fmt.Sprintf("%.1f pods/min over a %.1fs lead time need %.1f IPs, plus %.1f IPs in their cooling period; %d IPs bounded to [%d, %d]",
+		e.AddsPerMinute, e.LeadTimeSeconds, e.ExpectedDemand, e.CoolingIPs, target, e.MinWarmIPs, e.MaxWarmIPs)
+	p.explanation = e
+
+	limits.WarmIPTarget = e.WarmIPTarget
+	return defaultPoolPolicy{}.Decide(stats, limits)
+}
+
+// Limits returns the limits with the last warm IP target
+func (p *predictivePoolPolicy) Limits(limits PoolLimits) PoolLimits {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	limits.WarmIPTarget = p.explanation.WarmIPTarget
+	return limits
+}
+
+// Explain returns how the last warm IP target was computed
+func (p *predictivePoolPolicy) Explain() interface{} {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.explanation
+}
+
+// latency returns the average latency of an AWS API, or defaultAPILatency if it wasn't measured yet
+func (p *predictivePoolPolicy) latency(api string) time.Duration {
+	if latency, ok := p.apiLatency(api); ok {
+		return latency
+	}
+	return defaultAPILatency
+}
+
+// forget drops the pods added before the prediction window, and the starts of pods older than maxTrackedPodAge. It
+// must be called with the lock held.
+func (p *predictivePoolPolicy) forget(now time.Time) {
+	i := 0
+	for i < len(p.adds) && now.Sub(p.adds[i]) > predictionWindow {
+		i++
+	}
+	p.adds = p.adds[i:]
+	for key, start := range p.podStarts {
+		if now.Sub(start) > maxTrackedPodAge {
+			delete(p.podStarts, key)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+func newTestPredictivePolicy(now *time.Time) *predictivePoolPolicy {
+	return &predictivePoolPolicy{
+		minWarmIPs: 1,
+		apiLatency: func(api string) (time.Duration, bool) {
+			switch api {
+			case "AssignPrivateIpAddresses":
+				return time.Second, true
+			case "CreateNetworkInterface":
+				return 2 * time.Second, true
+			}
+			// AttachNetworkInterface was not measured yet
+			return 0, false
+		},
+		now:       func() time.Time { return *now },
+		podStarts: make(map[string]time.Time),
+	}
+}
+
+func TestPredictivePoolPolicyIdle(t *testing.T) {
+	now := time.Now()
+	p := newTestPredictivePolicy(&now)
+	limits := PoolLimits{MaxENIs: 3, MaxIPsPerENI: 10}
+
+	// Without pods being added, the policy keeps the minimum
+	actions := p.Decide(PoolStats{TotalIPs: 5, ENIs: 1}, limits)
+	assert.Equal(t, PoolActions{FreeIPs: 4, FreeENI: true}, actions)
+	e := p.Explain().(PredictiveExplanation)
+	assert.Equal(t, 0.0, e.AddsPerMinute)
+	assert.Equal(t, 10, e.MaxWarmIPs)
+	assert.False(t, e.ENIAllocation)
+	assert.Equal(t, 1.0, e.AllocationLatencySeconds)
+	assert.Equal(t, 6.0, e.LeadTimeSeconds)
+}
+
+func TestPredictivePoolPolicyRate(t *testing.T) {
+	now := time.Now()
+	p := newTestPredictivePolicy(&now)
+	limits := PoolLimits{MaxENIs: 3, MaxIPsPerENI: 100}
+
+	// 100 pods over 10 minutes are 10 pods per minute
+	for i := 0; i < 100; i++ {
+		p.PodAdded(fmt.Sprintf("pod-%d", i))
+		now = now.Add(6 * time.Second)
+	}
+	p.Decide(PoolStats{TotalIPs: 150, AssignedIPs: 100, ENIs: 2}, limits)
+	e := p.Explain().(PredictiveExplanation)
+	assert.Equal(t, 100, e.AddsInWindow)
+	assert.Equal(t, 10, e.AddsInBurstWindow)
+	assert.InDelta(t, 10.0, e.AddsPerMinute, 0.001)
+	assert.InDelta(t, 1.0, e.ExpectedDemand, 0.001)
+	assert.Equal(t, 1, e.WarmIPTarget)
+
+	// A burst of 60 pods within the last minute takes over the rate
+	for i := 0; i < 60; i++ {
+		p.PodAdded(fmt.Sprintf("burst-%d", i))
+	}
+	p.Decide(PoolStats{TotalIPs: 180, AssignedIPs: 160, ENIs: 2}, limits)
+	e = p.Explain().(PredictiveExplanation)
+	assert.Equal(t, 70, e.AddsInBurstWindow)
+	assert.InDelta(t, 70.0, e.AddsPerMinute, 0.001)
+	assert.InDelta(t, 7.0, e.ExpectedDemand, 0.001)
+	assert.Equal(t, 7, e.WarmIPTarget)
+
+	// The pods added before the prediction window are forgotten
+	now = now.Add(predictionWindow + time.Second)
+	p.Decide(PoolStats{TotalIPs: 180, AssignedIPs: 160, ENIs: 2}, limits)
+	e = p.Explain().(PredictiveExplanation)
+	assert.Equal(t, 0, e.AddsInWindow)
+	assert.Equal(t, 1, e.WarmIPTarget)
+}
+
+func TestPredictivePoolPolicyENILatency(t *testing.T) {
+	now := time.Now()
+	p := newTestPredictivePolicy(&now)
+	for i := 0; i < 60; i++ {
+		p.PodAdded(fmt.Sprintf("pod-%d", i))
+	}
+
+	// All ENIs are full, so new IPs need a new ENI
+	actions := p.Decide(PoolStats{TotalIPs: 20, AssignedIPs: 20, ENIs: 2}, PoolLimits{MaxENIs: 3, MaxIPsPerENI: 10})
+	e := p.Explain().(PredictiveExplanation)
+	assert.True(t, e.ENIAllocation)
+	assert.Equal(t, 1.0+2.0+defaultAPILatency.Seconds(), e.AllocationLatencySeconds)
+	assert.InDelta(t, 9.0, e.ExpectedDemand, 0.001)
+	assert.Equal(t, PoolActions{AddIPs: 9, AddENI: true, FreeENI: true}, actions)
+}
+
+func TestPredictivePoolPolicyCooling(t *testing.T) {
+	now := time.Now()
+	p := newTestPredictivePolicy(&now)
+	p.maxWarmIPs = 3
+
+	// Pods that live for 10 seconds
+	for i := 0; i < 6; i++ {
+		key := fmt.Sprintf("pod-%d", i)
+		p.PodAdded(key)
+		now = now.Add(10 * time.Second)
+		p.PodDeleted(key)
+	}
+	// A pod that was added before ipamd started
+	p.PodDeleted("unknown")
+
+	p.Decide(PoolStats{TotalIPs: 10, AssignedIPs: 1, ENIs: 1}, PoolLimits{MaxENIs: 3, MaxIPsPerENI: 10})
+	e := p.Explain().(PredictiveExplanation)
+	assert.Equal(t, 6, e.PodLifetimeSamples)
+	assert.Equal(t, 10.0, e.MeanPodLifetimeSeconds)
+	assert.InDelta(t, 6.0, e.ReleasesPerMinute, 0.001)
+	assert.InDelta(t, 6.0, e.AddsPerMinute, 0.001)
+	assert.InDelta(t, 6.0*datastore.AddressCoolingPeriod.Minutes(), e.CoolingIPs, 0.001)
+	// 0.6 pods for the lead time plus 3 cooling IPs are bounded to the maximum
+	assert.Equal(t, 3, e.WarmIPTarget)
+	assert.Contains(t, e.Reason, "4 IPs bounded to [1, 3]")
+}
+
+func TestGetPredictiveWarmIPs(t *testing.T) {
+	defer os.Unsetenv(envPredictiveMaxWarmIPs)
+
+	_ = os.Unsetenv(envPredictiveMaxWarmIPs)
+	assert.Equal(t, 0, getPredictiveWarmIPs(envPredictiveMaxWarmIPs, 0))
+
+	_ = os.Setenv(envPredictiveMaxWarmIPs, "20")
+	assert.Equal(t, 20, getPredictiveWarmIPs(envPredictiveMaxWarmIPs, 0))
+
+	_ = os.Setenv(envPredictiveMaxWarmIPs, "-1")
+	assert.Equal(t, 0, getPredictiveWarmIPs(envPredictiveMaxWarmIPs, 0))
+}
+
+func TestPoolActionsRecordsPredictiveState(t *testing.T) {
+	now := time.Now()
+	p := newTestPredictivePolicy(&now)
+	mockContext := &IPAMContext{
+		maxIPsPerENI: 10,
+		maxENI:       3,
+		dataStore:    datastore.NewDataStore(),
+		poolPolicy:   p,
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+
+	mockContext.observePodAdded("pod")
+	assert.Len(t, p.adds, 1)
+	_, limits := mockContext.poolActions()
+	// The warm IP target the policy computed is the one ENIs are freed with
+	assert.Equal(t, 1, limits.WarmIPTarget)
+	state := mockContext.GetPoolPolicyState()
+	assert.Equal(t, predictivePoolPolicyName, state.Policy)
+	assert.Equal(t, PoolActions{AddIPs: 1, AddENI: true, FreeENI: true}, state.Actions)
+	assert.Equal(t, limits, state.Limits)
+	assert.Equal(t, 1, state.Explanation.(PredictiveExplanation).AddsInWindow)
+
+	mockContext.observePodDeleted("pod")
+	assert.Len(t, p.lifetimes, 1)
+}
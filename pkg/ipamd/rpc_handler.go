@@ -17,7 +17,9 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"github.com/pkg/errors"
@@ -30,11 +32,28 @@ import (
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/tracing"
 	pb "github.com/aws/amazon-vpc-cni-k8s/rpc"
 )
 
 const (
 	ipamdgRPCaddress = "127.0.0.1:50051"
+
+	// errorCodeQuotaExceeded and errorCodeNoAvailableIPs are the ErrorCodes of the AddNetworkReply
+	errorCodeQuotaExceeded  = "QuotaExceeded"
+	errorCodeNoAvailableIPs = "NoAvailableIPs"
+
+	// rpcResultSuccess, rpcResultUnknownPod, rpcResultUnknownPodIP and rpcResultError are the result labels of the RPC
+	// metrics, along with the ErrorCodes
+	rpcResultSuccess      = "Success"
+	rpcResultUnknownPod   = "UnknownPod"
+	rpcResultUnknownPodIP = "UnknownPodIP"
+	rpcResultError        = "Error"
+
+	// podIPWaitExpiry is how long a pod that got no IP address is remembered. Kubelet deletes the sandbox of the pod
+	// before it retries, so a DelNetwork doesn't mean that the pod is gone.
+	podIPWaitExpiry = time.Hour
 )
 
 // server controls RPC service responses.
@@ -44,13 +63,23 @@ type server struct {
 
 // AddNetwork processes CNI add network request and return an IP address for container
 func (s *server) AddNetwork(ctx context.Context, in *pb.AddNetworkRequest) (*pb.AddNetworkReply, error) {
-	log.Infof("Received AddNetwork for NS %s, Pod %s, NameSpace %s, Sandbox %s, ifname %s",
-		in.Netns, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID, in.IfName)
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "ipamd.AddNetwork", tracing.KindServer)
+	span.SetAttribute("k8s.pod.name", in.K8S_POD_NAME)
+	span.SetAttribute("k8s.namespace.name", in.K8S_POD_NAMESPACE)
+	span.SetAttribute("cni.request_id", logger.RequestIDFromContext(ctx))
+	rlog := requestLogger(ctx, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID)
+	rlog.Infof("Received AddNetwork for NS %s, ifname %s", in.Netns, in.IfName)
 
+	// The priority class is looked up before the data store is locked
+	priorityClass := s.ipamContext.getPodPriorityClass(in.K8S_POD_NAMESPACE, in.K8S_POD_NAME)
+	_, assignSpan := tracing.Start(ctx, "datastore.AssignPodIPv4Address", tracing.KindInternal)
 	addr, deviceNumber, err := s.ipamContext.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{
-		Name:      in.K8S_POD_NAME,
-		Namespace: in.K8S_POD_NAMESPACE,
-		Sandbox:   in.K8S_POD_INFRA_CONTAINER_ID})
+		Name:              in.K8S_POD_NAME,
+		Namespace:         in.K8S_POD_NAMESPACE,
+		Sandbox:           in.K8S_POD_INFRA_CONTAINER_ID,
+		PriorityClassName: priorityClass})
+	assignSpan.End(err)
 
 	var pbVPCcidrs []string
 	for _, cidr := range s.ipamContext.awsClient.GetVPCIPv4CIDRs() {
@@ -66,25 +95,59 @@ func (s *server) AddNetwork(ctx context.Context, in *pb.AddNetworkRequest) (*pb.
 		}
 	}
 
+	layout := s.ipamContext.networkClient.GetRoutingLayout()
 	resp := pb.AddNetworkReply{
-		Success:         err == nil,
-		IPv4Addr:        addr,
-		IPv4Subnet:      "",
-		DeviceNumber:    int32(deviceNumber),
-		UseExternalSNAT: useExternalSNAT,
-		VPCcidrs:        pbVPCcidrs,
+		Success:          err == nil,
+		IPv4Addr:         addr,
+		IPv4Subnet:       "",
+		DeviceNumber:     int32(deviceNumber),
+		UseExternalSNAT:  useExternalSNAT,
+		VPCcidrs:         pbVPCcidrs,
+		UsePerENISNAT:    s.ipamContext.networkClient.UsePerENISNAT(),
+		RouteTableOffset: int32(layout.TableOffset),
+		RulePriorityBase: int32(layout.PriorityBase),
+	}
+
+	if err != nil {
+		resp.ErrorMessage = err.Error()
+	}
+	podName := in.K8S_POD_NAMESPACE + "/" + in.K8S_POD_NAME
+	switch errors.Cause(err) {
+	case nil:
+		s.ipamContext.observePodAdded(in.K8S_POD_INFRA_CONTAINER_ID)
+		s.ipamContext.triggerPodEIPSync()
+		if wait, ok := s.ipamContext.podIPWaits.done(podName, time.Now()); ok {
+			timeToIP.Observe(wait.Seconds())
+		}
+	case datastore.ErrNoAvailableIPs:
+		resp.ErrorCode = errorCodeNoAvailableIPs
+		s.ipamContext.poolBackoff.demand(time.Now())
+		s.ipamContext.podIPWaits.start(podName, time.Now())
+	case datastore.ErrQuotaExceeded:
+		resp.ErrorCode = errorCodeQuotaExceeded
 	}
 
-	log.Infof("Send AddNetworkReply: IPv4Addr %s, DeviceNumber: %d, err: %v", addr, deviceNumber, err)
-	addIPCnt.Inc()
+	rlog.With(logger.Fields{IP: addr}).Infof("Send AddNetworkReply: IPv4Addr %s, DeviceNumber: %d, err: %v", addr, deviceNumber, err)
+	result := rpcResult(err)
+	addIPCnt.With(prometheus.Labels{"result": result}).Inc()
+	rpcLatency.With(prometheus.Labels{"rpc": "AddNetwork", "result": result}).Observe(time.Since(start).Seconds())
+	span.SetAttribute("cni.ipv4_address", addr)
+	span.SetAttribute("cni.result", result)
+	span.End(err)
 	return &resp, nil
 }
 
 func (s *server) DelNetwork(ctx context.Context, in *pb.DelNetworkRequest) (*pb.DelNetworkReply, error) {
-	log.Infof("Received DelNetwork for IP %s, Pod %s, Namespace %s, Sandbox %s",
-		in.IPv4Addr, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID)
-	delIPCnt.With(prometheus.Labels{"reason": in.Reason}).Inc()
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "ipamd.DelNetwork", tracing.KindServer)
+	span.SetAttribute("k8s.pod.name", in.K8S_POD_NAME)
+	span.SetAttribute("k8s.namespace.name", in.K8S_POD_NAMESPACE)
+	span.SetAttribute("cni.reason", in.Reason)
+	span.SetAttribute("cni.request_id", logger.RequestIDFromContext(ctx))
+	rlog := requestLogger(ctx, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID)
+	rlog.Infof("Received DelNetwork for IP %s, reason %s", in.IPv4Addr, in.Reason)
 
+	_, unassignSpan := tracing.Start(ctx, "datastore.UnassignPodIPv4Address", tracing.KindInternal)
 	ip, deviceNumber, err := s.ipamContext.dataStore.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{
 		Name:      in.K8S_POD_NAME,
 		Namespace: in.K8S_POD_NAMESPACE,
@@ -96,9 +159,121 @@ func (s *server) DelNetwork(ctx context.Context, in *pb.DelNetworkRequest) (*pb.
 			Name:      in.K8S_POD_NAME,
 			Namespace: in.K8S_POD_NAMESPACE})
 	}
-	log.Infof("Send DelNetworkReply: IPv4Addr %s, DeviceNumber: %d, err: %v", ip, deviceNumber, err)
+	unassignSpan.End(err)
+	rlog.With(logger.Fields{IP: ip}).Infof("Send DelNetworkReply: IPv4Addr %s, DeviceNumber: %d, err: %v", ip, deviceNumber, err)
+	if err == nil {
+		s.ipamContext.observePodDeleted(in.K8S_POD_INFRA_CONTAINER_ID)
+		s.ipamContext.triggerPodEIPSync()
+	}
+	result := rpcResult(err)
+	delIPCnt.With(prometheus.Labels{"reason": in.Reason, "result": result}).Inc()
+	rpcLatency.With(prometheus.Labels{"rpc": "DelNetwork", "result": result}).Observe(time.Since(start).Seconds())
+	span.SetAttribute("cni.ipv4_address", ip)
+	span.SetAttribute("cni.result", result)
+	span.End(err)
+
+	layout := s.ipamContext.networkClient.GetRoutingLayout()
+	return &pb.DelNetworkReply{
+		Success:          err == nil,
+		IPv4Addr:         ip,
+		DeviceNumber:     int32(deviceNumber),
+		RouteTableOffset: int32(layout.TableOffset),
+		RulePriorityBase: int32(layout.PriorityBase),
+	}, err
+}
+
+// ReportNetworkSetup records how long the CNI plugin took to set up the network of a pod
+func (s *server) ReportNetworkSetup(ctx context.Context, in *pb.ReportNetworkSetupRequest) (*pb.ReportNetworkSetupReply, error) {
+	start := time.Now()
+	requestLogger(ctx, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID).With(logger.Fields{IP: in.IPv4Addr}).Debugf(
+		"Received ReportNetworkSetup: success %v, %.3fs", in.Success, in.SetupSeconds)
+	result := rpcResultSuccess
+	if !in.Success {
+		result = rpcResultError
+	}
+	if in.SetupSeconds >= 0 {
+		podNetworkSetupLatency.With(prometheus.Labels{"result": result}).Observe(in.SetupSeconds)
+	}
+	rpcLatency.With(prometheus.Labels{"rpc": "ReportNetworkSetup", "result": rpcResultSuccess}).Observe(time.Since(start).Seconds())
+	return &pb.ReportNetworkSetupReply{}, nil
+}
+
+// requestLogger returns the logger of a request about a pod, with the request ID the plugin sent
+func requestLogger(ctx context.Context, pod string, namespace string, sandbox string) logger.Entry {
+	return logger.With(logger.Fields{
+		RequestID: logger.RequestIDFromContext(ctx),
+		Pod:       pod,
+		Namespace: namespace,
+		Sandbox:   sandbox,
+	})
+}
+
+// chainUnaryServer returns an interceptor that calls the interceptors in order, the first one being the outermost
+func chainUnaryServer(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+// rpcResult is the result label of the RPC metrics for the error of a request
+func rpcResult(err error) string {
+	switch errors.Cause(err) {
+	case nil:
+		return rpcResultSuccess
+	case datastore.ErrNoAvailableIPs:
+		return errorCodeNoAvailableIPs
+	case datastore.ErrQuotaExceeded:
+		return errorCodeQuotaExceeded
+	case datastore.ErrUnknownPod:
+		return rpcResultUnknownPod
+	case datastore.ErrUnknownPodIP:
+		return rpcResultUnknownPodIP
+	default:
+		return rpcResultError
+	}
+}
+
+// podIPWaits keeps when the pods that got no IP address because the pool was empty first asked for one, by
+// namespace/name since the sandbox of the pod changes when kubelet retries
+type podIPWaits struct {
+	since map[string]time.Time
+	lock  sync.Mutex
+}
+
+// start records that the pod is waiting for an IP address, unless it already was
+func (w *podIPWaits) start(pod string, now time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.since == nil {
+		w.since = make(map[string]time.Time)
+	}
+	for name, since := range w.since {
+		if now.Sub(since) > podIPWaitExpiry {
+			delete(w.since, name)
+		}
+	}
+	if _, ok := w.since[pod]; !ok {
+		w.since[pod] = now
+	}
+}
 
-	return &pb.DelNetworkReply{Success: err == nil, IPv4Addr: ip, DeviceNumber: int32(deviceNumber)}, err
+// done returns how long the pod waited for the IP address it just got, if it waited
+func (w *podIPWaits) done(pod string, now time.Time) (time.Duration, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	since, ok := w.since[pod]
+	if !ok {
+		return 0, false
+	}
+	delete(w.since, pod)
+	return now.Sub(since), true
 }
 
 // RunRPCHandler handles request from gRPC
@@ -110,11 +285,14 @@ func (c *IPAMContext) RunRPCHandler() error {
 		log.Errorf("Failed to listen gRPC port: %v", err)
 		return errors.Wrap(err, "ipamd: failed to listen to gRPC port")
 	}
-	s := grpc.NewServer()
+	// grpc-go doesn't chain the interceptors of a server
+	s := grpc.NewServer(grpc.UnaryInterceptor(chainUnaryServer(tracing.UnaryServerInterceptor(), logger.UnaryServerInterceptor())))
 	pb.RegisterCNIBackendServer(s, &server{ipamContext: c})
 	hs := health.NewServer()
-	// TODO: Implement watch once the status is check is handled correctly.
-	hs.SetServingStatus("grpc.health.v1.aws-node", healthpb.HealthCheckResponse_SERVING)
+	status := c.CheckHealth()
+	logHealth(status)
+	setHealth(hs, status.Healthy)
+	go c.watchHealth(hs, status)
 	healthpb.RegisterHealthServer(s, hs)
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
@@ -15,10 +15,16 @@ package ipamd
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
 	"github.com/aws/aws-sdk-go/aws"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	pb "github.com/aws/amazon-vpc-cni-k8s/rpc"
 
@@ -55,21 +61,31 @@ func TestServer_AddNetwork(t *testing.T) {
 	testCases := []struct {
 		name               string
 		useExternalSNAT    bool
+		usePerENISNAT      bool
 		vpcCIDRs           []*string
 		snatExclusionCIDRs []string
 	}{
 		{
 			"VPC CIDRs",
 			true,
+			false,
 			vpcCIDRs,
 			nil,
 		},
 		{
 			"SNAT Exclusion CIDRs",
 			false,
+			false,
 			vpcCIDRs,
 			[]string{"10.12.0.0/16", "10.13.0.0/16"},
 		},
+		{
+			"Per ENI SNAT",
+			false,
+			true,
+			vpcCIDRs,
+			nil,
+		},
 	}
 	for _, tc := range testCases {
 		mockAWS.EXPECT().GetVPCIPv4CIDRs().Return(tc.vpcCIDRs)
@@ -77,11 +93,16 @@ func TestServer_AddNetwork(t *testing.T) {
 		if !tc.useExternalSNAT {
 			mockNetwork.EXPECT().GetExcludeSNATCIDRs().Return(tc.snatExclusionCIDRs)
 		}
+		mockNetwork.EXPECT().UsePerENISNAT().Return(tc.usePerENISNAT)
+		mockNetwork.EXPECT().GetRoutingLayout().Return(networkutils.RoutingLayout{TableOffset: 100, PriorityBase: 2048})
 
 		addNetworkReply, err := rpcServer.AddNetwork(context.TODO(), addNetworkRequest)
 		assert.NoError(t, err, tc.name)
 
 		assert.Equal(t, tc.useExternalSNAT, addNetworkReply.UseExternalSNAT, tc.name)
+		assert.Equal(t, tc.usePerENISNAT, addNetworkReply.UsePerENISNAT, tc.name)
+		assert.Equal(t, int32(100), addNetworkReply.RouteTableOffset, tc.name)
+		assert.Equal(t, int32(2048), addNetworkReply.RulePriorityBase, tc.name)
 
 		var expectedCIDRs []string
 		for _, cidr := range tc.vpcCIDRs {
@@ -91,3 +112,127 @@ func TestServer_AddNetwork(t *testing.T) {
 		assert.Equal(t, expectedCIDRs, addNetworkReply.VPCcidrs, tc.name)
 	}
 }
+
+func TestServer_AddNetworkQuotaExceeded(t *testing.T) {
+	ctrl, mockAWS, mockK8S, mockCRI, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		criClient:     mockCRI,
+		networkClient: mockNetwork,
+		dataStore:     datastore.NewDataStore(),
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	mockContext.dataStore.SetNamespaceQuotas(map[string]int{"ns": 0})
+
+	rpcServer := server{ipamContext: mockContext}
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return(nil)
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true)
+	mockNetwork.EXPECT().UsePerENISNAT().Return(false)
+	mockNetwork.EXPECT().GetRoutingLayout().Return(networkutils.DefaultRoutingLayout)
+
+	addNetworkReply, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		K8S_POD_NAME:               "pod",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "cid",
+	})
+	assert.NoError(t, err)
+	assert.False(t, addNetworkReply.Success)
+	assert.Equal(t, errorCodeQuotaExceeded, addNetworkReply.ErrorCode)
+	assert.Contains(t, addNetworkReply.ErrorMessage, "namespace ns is using all of its 0 IP addresses")
+}
+
+func TestServer_AddNetworkTimeToIP(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		networkClient: mockNetwork,
+		dataStore:     datastore.NewDataStore(),
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	rpcServer := server{ipamContext: mockContext}
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return(nil).Times(2)
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true).Times(2)
+	mockNetwork.EXPECT().UsePerENISNAT().Return(false).Times(2)
+	mockNetwork.EXPECT().GetRoutingLayout().Return(networkutils.DefaultRoutingLayout).Times(2)
+
+	// The pool is empty
+	reply, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		K8S_POD_NAME: "pod", K8S_POD_NAMESPACE: "ns", K8S_POD_INFRA_CONTAINER_ID: "cid1"})
+	assert.NoError(t, err)
+	assert.Equal(t, errorCodeNoAvailableIPs, reply.ErrorCode)
+	assert.Contains(t, mockContext.podIPWaits.since, "ns/pod")
+
+	// Kubelet retries with another sandbox once there is an IP address
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	reply, err = rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		K8S_POD_NAME: "pod", K8S_POD_NAMESPACE: "ns", K8S_POD_INFRA_CONTAINER_ID: "cid2"})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	assert.NotContains(t, mockContext.podIPWaits.since, "ns/pod")
+}
+
+func TestPodIPWaits(t *testing.T) {
+	var waits podIPWaits
+	now := time.Now()
+	_, ok := waits.done("ns/a", now)
+	assert.False(t, ok)
+
+	waits.start("ns/a", now)
+	// A retry doesn't restart the wait
+	waits.start("ns/a", now.Add(5*time.Second))
+	wait, ok := waits.done("ns/a", now.Add(8*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 8*time.Second, wait)
+	_, ok = waits.done("ns/a", now.Add(9*time.Second))
+	assert.False(t, ok)
+
+	// The pods that never got an IP address are forgotten
+	waits.start("ns/b", now)
+	waits.start("ns/c", now.Add(podIPWaitExpiry+time.Second))
+	_, ok = waits.done("ns/b", now.Add(podIPWaitExpiry+time.Second))
+	assert.False(t, ok)
+}
+
+func TestRPCResult(t *testing.T) {
+	assert.Equal(t, rpcResultSuccess, rpcResult(nil))
+	assert.Equal(t, errorCodeNoAvailableIPs, rpcResult(datastore.ErrNoAvailableIPs))
+	assert.Equal(t, errorCodeQuotaExceeded, rpcResult(datastore.ErrQuotaExceeded))
+	assert.Equal(t, rpcResultUnknownPod, rpcResult(datastore.ErrUnknownPod))
+	assert.Equal(t, rpcResultUnknownPodIP, rpcResult(datastore.ErrUnknownPodIP))
+	assert.Equal(t, rpcResultError, rpcResult(errors.New("invalid pod with multiple IP addresses")))
+}
+
+func TestServer_ReportNetworkSetup(t *testing.T) {
+	rpcServer := server{ipamContext: &IPAMContext{}}
+	reply, err := rpcServer.ReportNetworkSetup(context.TODO(), &pb.ReportNetworkSetupRequest{
+		K8S_POD_NAME: "pod", K8S_POD_NAMESPACE: "ns", IPv4Addr: ipaddr01, Success: true, SetupSeconds: 0.025})
+	assert.NoError(t, err)
+	assert.NotNil(t, reply)
+}
+
+func TestChainUnaryServer(t *testing.T) {
+	var calls []string
+	interceptor := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			calls = append(calls, name)
+			return handler(ctx, req)
+		}
+	}
+	chained := chainUnaryServer(interceptor("first"), logger.UnaryServerInterceptor(), interceptor("last"))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", "8a1f"))
+	reply, err := chained(ctx, "request", &grpc.UnaryServerInfo{FullMethod: "/rpc.CNIBackend/AddNetwork"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			calls = append(calls, "handler")
+			return logger.RequestIDFromContext(ctx), nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, "8a1f", reply)
+	assert.Equal(t, []string{"first", "last", "handler"}, calls)
+}
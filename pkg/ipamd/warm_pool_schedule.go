@@ -0,0 +1,246 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/cron"
+)
+
+const (
+	// This environment variable is used to specify profiles of warm pool targets that are switched on a schedule. It
+	// is a JSON list of profiles with a name, a cron schedule in UTC and any of warmIPTarget, minimumIPTarget and
+	// warmENITarget. A profile is active from the time its schedule fires until another one fires. The targets it
+	// doesn't set are those of WARM_IP_TARGET, MINIMUM_IP_TARGET and WARM_ENI_TARGET.
+	envWarmPoolSchedule = "AWS_VPC_K8S_CNI_WARM_POOL_SCHEDULE"
+
+	// baseWarmPoolProfile is the name of the targets of WARM_IP_TARGET, MINIMUM_IP_TARGET and WARM_ENI_TARGET, which
+	// are used until a profile's schedule fires
+	baseWarmPoolProfile = "base"
+
+	// warmPoolScheduleLookback is how far back the schedules are searched for the active profile when ipamd starts
+	warmPoolScheduleLookback = 31 * 24 * time.Hour
+)
+
+var (
+	warmPoolProfileActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_warm_pool_profile_active",
+			Help: "Whether a warm pool profile of AWS_VPC_K8S_CNI_WARM_POOL_SCHEDULE is active (1) or not (0)",
+		},
+		[]string{"profile"},
+	)
+	warmPoolTarget = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_warm_pool_target",
+			Help: "The warm pool targets of the active warm pool profile",
+		},
+		[]string{"target"},
+	)
+)
+
+// WarmPoolProfile is a set of warm pool targets that is activated by a schedule
+type WarmPoolProfile struct {
+	Name            string `json:"name"`
+	Schedule        string `json:"schedule"`
+	WarmIPTarget    *int   `json:"warmIPTarget,omitempty"`
+	MinimumIPTarget *int   `json:"minimumIPTarget,omitempty"`
+	WarmENITarget   *int   `json:"warmENITarget,omitempty"`
+
+	cron *cron.Schedule
+}
+
+// WarmPoolTargets are the targets the pool manager uses
+type WarmPoolTargets struct {
+	WarmIPTarget    int
+	MinimumIPTarget int
+	WarmENITarget   int
+}
+
+// WarmPoolScheduleState is the active warm pool profile, for introspection
+type WarmPoolScheduleState struct {
+	ActiveProfile string
+	ActiveSince   time.Time
+	Targets       WarmPoolTargets
+	Profiles      []WarmPoolProfile
+}
+
+// warmPoolSchedule switches the warm pool targets between the profiles of AWS_VPC_K8S_CNI_WARM_POOL_SCHEDULE
+type warmPoolSchedule struct {
+	base     WarmPoolTargets
+	profiles []WarmPoolProfile
+
+	lock sync.Mutex
+	// active is the index of the active profile, or -1 for the base targets
+	active      int
+	activeSince time.Time
+	// evaluated is the last minute the schedules were checked
+	evaluated time.Time
+}
+
+// parseWarmPoolSchedule parses the profiles of AWS_VPC_K8S_CNI_WARM_POOL_SCHEDULE
+func parseWarmPoolSchedule(input string) ([]WarmPoolProfile, error) {
+	var profiles []WarmPoolProfile
+	if err := json.Unmarshal([]byte(input), &profiles); err != nil {
+		return nil, errors.Wrap(err, "invalid warm pool schedule")
+	}
+	names := make(map[string]bool, len(profiles))
+	for i := range profiles {
+		profile := &profiles[i]
+		if profile.Name == "" || profile.Name == baseWarmPoolProfile || names[profile.Name] {
+			return nil, errors.Errorf("warm pool profile %d must have a unique name other than %q", i, baseWarmPoolProfile)
+		}
+		names[profile.Name] = true
+		var err error
+		if profile.cron, err = cron.Parse(profile.Schedule); err != nil {
+			return nil, errors.Wrapf(err, "warm pool profile %s", profile.Name)
+		}
+		for _, target := range []*int{profile.WarmIPTarget, profile.MinimumIPTarget, profile.WarmENITarget} {
+			if target != nil && *target < 0 {
+				return nil, errors.Errorf("warm pool profile %s has a negative target", profile.Name)
+			}
+		}
+	}
+	return profiles, nil
+}
+
+// getWarmPoolSchedule returns the schedule of AWS_VPC_K8S_CNI_WARM_POOL_SCHEDULE, or nil if it is not set or invalid
+func getWarmPoolSchedule(base WarmPoolTargets) *warmPoolSchedule {
+	inputStr, found := os.LookupEnv(envWarmPoolSchedule)
+	if !found || inputStr == "" {
+		return nil
+	}
+	profiles, err := parseWarmPoolSchedule(inputStr)
+	if err != nil {
+		log.Errorf("Ignoring %s: %v", envWarmPoolSchedule, err)
+		return nil
+	}
+	log.Debugf("Using %s with %d profiles", envWarmPoolSchedule, len(profiles))
+	return &warmPoolSchedule{base: base, profiles: profiles, active: -1}
+}
+
+// update activates the profile whose schedule fired last, and returns whether the active profile changed or was
+// determined for the first time. The schedules are checked once a minute.
+func (s *warmPoolSchedule) update(now time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	minute := now.UTC().Truncate(time.Minute)
+	if !minute.After(s.evaluated) {
+		return false
+	}
+
+	first := s.evaluated.IsZero()
+	active, since := s.active, s.activeSince
+	if first {
+		// Find the profile that would be active if ipamd had been running
+		for i := range s.profiles {
+			fired, ok := s.profiles[i].cron.Prev(minute, warmPoolScheduleLookback)
+			if ok && fired.After(since) {
+				active, since = i, fired
+			}
+		}
+	} else {
+		// Usually only the current minute, unless the pool manager was blocked
+		for t := s.evaluated.Add(time.Minute); !t.After(minute); t = t.Add(time.Minute) {
+			for i := range s.profiles {
+				if s.profiles[i].cron.Matches(t) {
+					active, since = i, t
+				}
+			}
+		}
+	}
+	s.evaluated = minute
+
+	changed := first || active != s.active
+	s.active, s.activeSince = active, since
+	return changed
+}
+
+// targets returns the targets of the active profile
+func (s *warmPoolSchedule) targets() (string, WarmPoolTargets) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.activeTargets()
+}
+
+// activeTargets must be called with the lock held
+func (s *warmPoolSchedule) activeTargets() (string, WarmPoolTargets) {
+	if s.active < 0 {
+		return baseWarmPoolProfile, s.base
+	}
+	profile := s.profiles[s.active]
+	targets := s.base
+	if profile.WarmIPTarget != nil {
+		targets.WarmIPTarget = *profile.WarmIPTarget
+	}
+	if profile.MinimumIPTarget != nil {
+		targets.MinimumIPTarget = *profile.MinimumIPTarget
+	}
+	if profile.WarmENITarget != nil {
+		targets.WarmENITarget = *profile.WarmENITarget
+	}
+	return profile.Name, targets
+}
+
+func (s *warmPoolSchedule) getState() WarmPoolScheduleState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	name, targets := s.activeTargets()
+	return WarmPoolScheduleState{
+		ActiveProfile: name,
+		ActiveSince:   s.activeSince,
+		Targets:       targets,
+		Profiles:      s.profiles,
+	}
+}
+
+// applyWarmPoolSchedule switches the warm pool targets to the active profile of the schedule, if there is one
+func (c *IPAMContext) applyWarmPoolSchedule(now time.Time) {
+	if c.warmPoolSchedule == nil || !c.warmPoolSchedule.update(now) {
+		return
+	}
+	name, targets := c.warmPoolSchedule.targets()
+	log.Infof("Switching to warm pool profile %s: %+v", name, targets)
+	c.warmIPTarget = targets.WarmIPTarget
+	c.minimumIPTarget = targets.MinimumIPTarget
+	c.warmENITarget = targets.WarmENITarget
+
+	warmPoolProfileActive.WithLabelValues(baseWarmPoolProfile).Set(0)
+	for _, profile := range c.warmPoolSchedule.profiles {
+		warmPoolProfileActive.WithLabelValues(profile.Name).Set(0)
+	}
+	warmPoolProfileActive.WithLabelValues(name).Set(1)
+	warmPoolTarget.WithLabelValues("warm_ip").Set(float64(targets.WarmIPTarget))
+	warmPoolTarget.WithLabelValues("minimum_ip").Set(float64(targets.MinimumIPTarget))
+	warmPoolTarget.WithLabelValues("warm_eni").Set(float64(targets.WarmENITarget))
+}
+
+// GetWarmPoolScheduleState returns the active warm pool profile. Without a schedule, the base targets are active.
+func (c *IPAMContext) GetWarmPoolScheduleState() WarmPoolScheduleState {
+	if c.warmPoolSchedule == nil {
+		return WarmPoolScheduleState{
+			ActiveProfile: baseWarmPoolProfile,
+			Targets:       WarmPoolTargets{WarmIPTarget: c.warmIPTarget, MinimumIPTarget: c.minimumIPTarget, WarmENITarget: c.warmENITarget},
+		}
+	}
+	return c.warmPoolSchedule.getState()
+}
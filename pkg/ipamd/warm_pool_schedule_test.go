@@ -0,0 +1,111 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testWarmPoolSchedule = `[
+	{"name": "day", "schedule": "30 6 * * 1-5", "warmIPTarget": 10, "minimumIPTarget": 30},
+	{"name": "night", "schedule": "0 20 * * *", "warmIPTarget": 2}
+]`
+
+func TestParseWarmPoolSchedule(t *testing.T) {
+	profiles, err := parseWarmPoolSchedule(testWarmPoolSchedule)
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 2)
+	assert.Equal(t, 10, *profiles[0].WarmIPTarget)
+	assert.Nil(t, profiles[1].MinimumIPTarget)
+
+	for _, input := range []string{
+		`{}`,
+		`[{"schedule": "* * * * *"}]`,
+		`[{"name": "base", "schedule": "* * * * *"}]`,
+		`[{"name": "a", "schedule": "* * * * *"}, {"name": "a", "schedule": "* * * * *"}]`,
+		`[{"name": "a", "schedule": "* * * *"}]`,
+		`[{"name": "a", "schedule": "* * * * *", "warmENITarget": -1}]`,
+	} {
+		_, err := parseWarmPoolSchedule(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestWarmPoolSchedule(t *testing.T) {
+	profiles, err := parseWarmPoolSchedule(testWarmPoolSchedule)
+	assert.NoError(t, err)
+	base := WarmPoolTargets{WarmIPTarget: 5, MinimumIPTarget: 0, WarmENITarget: 1}
+	s := &warmPoolSchedule{base: base, profiles: profiles, active: -1}
+
+	// Monday 2019-12-02 07:00 UTC, after the day profile fired
+	now := time.Date(2019, 12, 2, 7, 0, 10, 0, time.UTC)
+	assert.True(t, s.update(now))
+	name, targets := s.targets()
+	assert.Equal(t, "day", name)
+	assert.Equal(t, WarmPoolTargets{WarmIPTarget: 10, MinimumIPTarget: 30, WarmENITarget: 1}, targets)
+	assert.Equal(t, time.Date(2019, 12, 2, 6, 30, 0, 0, time.UTC), s.getState().ActiveSince)
+
+	// Nothing changes within a minute, nor until the next schedule fires
+	assert.False(t, s.update(now.Add(30*time.Second)))
+	assert.False(t, s.update(now.Add(12*time.Hour)))
+
+	// The night profile fired while the pool manager wasn't running
+	assert.True(t, s.update(time.Date(2019, 12, 2, 20, 1, 0, 0, time.UTC)))
+	name, targets = s.targets()
+	assert.Equal(t, "night", name)
+	assert.Equal(t, WarmPoolTargets{WarmIPTarget: 2, MinimumIPTarget: 0, WarmENITarget: 1}, targets)
+
+	// The day profile doesn't fire on Saturday
+	assert.False(t, s.update(time.Date(2019, 12, 7, 6, 30, 0, 0, time.UTC)))
+	assert.Equal(t, "night", s.getState().ActiveProfile)
+}
+
+func TestWarmPoolScheduleBase(t *testing.T) {
+	profiles, err := parseWarmPoolSchedule(`[{"name": "new-year", "schedule": "0 0 1 1 *", "warmIPTarget": 20}]`)
+	assert.NoError(t, err)
+	base := WarmPoolTargets{WarmIPTarget: 5}
+	s := &warmPoolSchedule{base: base, profiles: profiles, active: -1}
+
+	// The schedule didn't fire within the lookback
+	assert.True(t, s.update(time.Date(2019, 12, 2, 7, 0, 0, 0, time.UTC)))
+	name, targets := s.targets()
+	assert.Equal(t, baseWarmPoolProfile, name)
+	assert.Equal(t, base, targets)
+}
+
+func TestApplyWarmPoolSchedule(t *testing.T) {
+	defer os.Unsetenv(envWarmPoolSchedule)
+	_ = os.Setenv(envWarmPoolSchedule, testWarmPoolSchedule)
+
+	mockContext := &IPAMContext{warmIPTarget: 5, warmENITarget: 1}
+	mockContext.warmPoolSchedule = getWarmPoolSchedule(WarmPoolTargets{WarmIPTarget: 5, WarmENITarget: 1})
+	mockContext.applyWarmPoolSchedule(time.Date(2019, 12, 2, 7, 0, 0, 0, time.UTC))
+	assert.Equal(t, 10, mockContext.warmIPTarget)
+	assert.Equal(t, 30, mockContext.minimumIPTarget)
+	assert.Equal(t, 1, mockContext.warmENITarget)
+	assert.Equal(t, PoolLimits{WarmENITarget: 1, WarmIPTarget: 10, MinimumIPTarget: 30}, mockContext.poolLimits())
+	assert.Equal(t, "day", mockContext.GetWarmPoolScheduleState().ActiveProfile)
+
+	// An invalid schedule is ignored
+	_ = os.Setenv(envWarmPoolSchedule, "[")
+	assert.Nil(t, getWarmPoolSchedule(WarmPoolTargets{}))
+	mockContext = &IPAMContext{warmIPTarget: 5}
+	mockContext.applyWarmPoolSchedule(time.Now())
+	assert.Equal(t, WarmPoolScheduleState{ActiveProfile: baseWarmPoolProfile, Targets: WarmPoolTargets{WarmIPTarget: 5}},
+		mockContext.GetWarmPoolScheduleState())
+}
@@ -17,10 +17,14 @@ import (
 	"github.com/operator-framework/operator-sdk/pkg/k8sclient"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,6 +43,8 @@ const (
 // K8SAPIs defines interface to use kubelet introspection API
 type K8SAPIs interface {
 	K8SGetLocalPodIPs() ([]*K8SPodInfo, error)
+	K8SGetLocalPod(namespace, name string) (*K8SPodInfo, error)
+	K8SRecordPodEvent(pod *K8SPodInfo, eventType, reason, message string)
 }
 
 // K8SPodInfo provides pod info
@@ -52,11 +58,20 @@ type K8SPodInfo struct {
 	// IP is pod's ipv4 address
 	IP  string
 	UID string
+	// Labels are the pod's labels
+	Labels map[string]string
+	// Annotations are the pod's annotations
+	Annotations map[string]string
+	// PriorityClassName is the pod's priority class
+	PriorityClassName string
 }
 
 // ErrInformerNotSynced indicates that it has not synced with API server yet
 var ErrInformerNotSynced = errors.New("discovery: informer not synced")
 
+// ErrPodNotFound indicates that the pod is not in the informer cache
+var ErrPodNotFound = errors.New("discovery: pod not found")
+
 // Controller defines global context for discovery controller
 type Controller struct {
 	workerPods     map[string]*K8SPodInfo
@@ -69,6 +84,9 @@ type Controller struct {
 	kubeClient kubernetes.Interface
 	myNodeName string
 	synced     bool
+
+	recorder     record.EventRecorder
+	recorderOnce sync.Once
 }
 
 // NewController creates a new DiscoveryController
@@ -186,7 +204,7 @@ func (d *Controller) K8SGetLocalPodIPs() ([]*K8SPodInfo, error) {
 	defer d.workerPodsLock.Unlock()
 
 	for _, pod := range d.workerPods {
-		log.Infof("K8SGetLocalPodIPs discovered local Pods: %s %s %s %s",
+		log.Debugf("K8SGetLocalPodIPs discovered local Pods: %s %s %s %s",
 			pod.Name, pod.Namespace, pod.IP, pod.UID)
 		localPods = append(localPods, pod)
 	}
@@ -194,6 +212,39 @@ func (d *Controller) K8SGetLocalPodIPs() ([]*K8SPodInfo, error) {
 	return localPods, nil
 }
 
+// K8SGetLocalPod returns a pod of the local node from the informer cache
+func (d *Controller) K8SGetLocalPod(namespace, name string) (*K8SPodInfo, error) {
+	if !d.synced {
+		return nil, ErrInformerNotSynced
+	}
+
+	d.workerPodsLock.RLock()
+	defer d.workerPodsLock.RUnlock()
+	pod, ok := d.workerPods[namespace+"/"+name]
+	if !ok {
+		return nil, ErrPodNotFound
+	}
+	return pod, nil
+}
+
+// K8SRecordPodEvent records an event on a pod. Events are sent in the background and dropped if the API server
+// can't be reached.
+func (d *Controller) K8SRecordPodEvent(pod *K8SPodInfo, eventType, reason, message string) {
+	d.recorderOnce.Do(func() {
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: d.kubeClient.CoreV1().Events("")})
+		d.recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: cniPodName, Host: d.myNodeName})
+	})
+
+	d.recorder.Event(&v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        types.UID(pod.UID),
+	}, eventType, reason, message)
+}
+
 // The rest of logic/code are taken from kubernetes/client-go/examples/workqueue
 func newController(queue workqueue.RateLimitingInterface, indexer cache.Indexer, informer cache.Controller) *controller {
 	return &controller{
@@ -260,10 +311,13 @@ func (d *Controller) handlePodUpdate(key string) error {
 
 		// Save pod info
 		d.workerPods[key] = &K8SPodInfo{
-			Name:      podName,
-			Namespace: pod.GetNamespace(),
-			IP:        pod.Status.PodIP,
-			UID:       string(pod.GetUID()),
+			Name:              podName,
+			Namespace:         pod.GetNamespace(),
+			IP:                pod.Status.PodIP,
+			UID:               string(pod.GetUID()),
+			Labels:            pod.GetLabels(),
+			Annotations:       pod.GetAnnotations(),
+			PriorityClassName: pod.Spec.PriorityClassName,
 		}
 
 		log.Infof("Add/Update for Pod %s on my node, namespace = %s, IP = %s", podName, d.workerPods[key].Namespace, d.workerPods[key].IP)
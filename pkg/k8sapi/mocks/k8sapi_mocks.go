@@ -47,6 +47,19 @@ func (m *MockK8SAPIs) EXPECT() *MockK8SAPIsMockRecorder {
 	return m.recorder
 }
 
+// K8SGetLocalPod mocks base method
+func (m *MockK8SAPIs) K8SGetLocalPod(arg0, arg1 string) (*k8sapi.K8SPodInfo, error) {
+	ret := m.ctrl.Call(m, "K8SGetLocalPod", arg0, arg1)
+	ret0, _ := ret[0].(*k8sapi.K8SPodInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// K8SGetLocalPod indicates an expected call of K8SGetLocalPod
+func (mr *MockK8SAPIsMockRecorder) K8SGetLocalPod(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "K8SGetLocalPod", reflect.TypeOf((*MockK8SAPIs)(nil).K8SGetLocalPod), arg0, arg1)
+}
+
 // K8SGetLocalPodIPs mocks base method
 func (m *MockK8SAPIs) K8SGetLocalPodIPs() ([]*k8sapi.K8SPodInfo, error) {
 	ret := m.ctrl.Call(m, "K8SGetLocalPodIPs")
@@ -59,3 +72,13 @@ func (m *MockK8SAPIs) K8SGetLocalPodIPs() ([]*k8sapi.K8SPodInfo, error) {
 func (mr *MockK8SAPIsMockRecorder) K8SGetLocalPodIPs() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "K8SGetLocalPodIPs", reflect.TypeOf((*MockK8SAPIs)(nil).K8SGetLocalPodIPs))
 }
+
+// K8SRecordPodEvent mocks base method
+func (m *MockK8SAPIs) K8SRecordPodEvent(arg0 *k8sapi.K8SPodInfo, arg1, arg2, arg3 string) {
+	m.ctrl.Call(m, "K8SRecordPodEvent", arg0, arg1, arg2, arg3)
+}
+
+// K8SRecordPodEvent indicates an expected call of K8SRecordPodEvent
+func (mr *MockK8SAPIsMockRecorder) K8SRecordPodEvent(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "K8SRecordPodEvent", reflect.TypeOf((*MockK8SAPIs)(nil).K8SRecordPodEvent), arg0, arg1, arg2, arg3)
+}
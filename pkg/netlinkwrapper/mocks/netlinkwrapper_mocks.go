@@ -182,6 +182,18 @@ func (mr *MockNetLinkMockRecorder) LinkSetUp(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LinkSetUp", reflect.TypeOf((*MockNetLink)(nil).LinkSetUp), arg0)
 }
 
+// LinkSubscribe mocks base method
+func (m *MockNetLink) LinkSubscribe(arg0 chan<- netlink.LinkUpdate, arg1 <-chan struct{}) error {
+	ret := m.ctrl.Call(m, "LinkSubscribe", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LinkSubscribe indicates an expected call of LinkSubscribe
+func (mr *MockNetLinkMockRecorder) LinkSubscribe(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LinkSubscribe", reflect.TypeOf((*MockNetLink)(nil).LinkSubscribe), arg0, arg1)
+}
+
 // NeighAdd mocks base method
 func (m *MockNetLink) NeighAdd(arg0 *netlink.Neigh) error {
 	ret := m.ctrl.Call(m, "NeighAdd", arg0)
@@ -256,6 +268,19 @@ func (mr *MockNetLinkMockRecorder) RouteList(arg0, arg1 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RouteList", reflect.TypeOf((*MockNetLink)(nil).RouteList), arg0, arg1)
 }
 
+// RouteListFiltered mocks base method
+func (m *MockNetLink) RouteListFiltered(arg0 int, arg1 *netlink.Route, arg2 uint64) ([]netlink.Route, error) {
+	ret := m.ctrl.Call(m, "RouteListFiltered", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]netlink.Route)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RouteListFiltered indicates an expected call of RouteListFiltered
+func (mr *MockNetLinkMockRecorder) RouteListFiltered(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RouteListFiltered", reflect.TypeOf((*MockNetLink)(nil).RouteListFiltered), arg0, arg1, arg2)
+}
+
 // RouteReplace mocks base method
 func (m *MockNetLink) RouteReplace(arg0 *netlink.Route) error {
 	ret := m.ctrl.Call(m, "RouteReplace", arg0)
@@ -41,8 +41,12 @@ type NetLink interface {
 	LinkList() ([]netlink.Link, error)
 	// LinkSetDown is equivalent to: `ip link set $link down`
 	LinkSetDown(link netlink.Link) error
+	// LinkSubscribe sends the link updates of the host to ch until done is closed, equivalent to: `ip monitor link`
+	LinkSubscribe(ch chan<- netlink.LinkUpdate, done <-chan struct{}) error
 	// RouteList gets a list of routes in the system.
 	RouteList(link netlink.Link, family int) ([]netlink.Route, error)
+	// RouteListFiltered gets a list of routes in the system that match a filter, in any table
+	RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error)
 	// RouteAdd will add a route to the route table
 	RouteAdd(route *netlink.Route) error
 	// RouteReplace will replace the route in the route table
@@ -109,10 +113,18 @@ func (*netLink) LinkSetDown(link netlink.Link) error {
 	return netlink.LinkSetDown(link)
 }
 
+func (*netLink) LinkSubscribe(ch chan<- netlink.LinkUpdate, done <-chan struct{}) error {
+	return netlink.LinkSubscribe(ch, done)
+}
+
 func (*netLink) RouteList(link netlink.Link, family int) ([]netlink.Route, error) {
 	return netlink.RouteList(link, family)
 }
 
+func (*netLink) RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error) {
+	return netlink.RouteListFiltered(family, filter, filterMask)
+}
+
 func (*netLink) RouteAdd(route *netlink.Route) error {
 	return netlink.RouteAdd(route)
 }
@@ -0,0 +1,667 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package networkutils
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// maxChainDepth bounds the jumps followed between iptables chains
+const maxChainDepth = 16
+
+// PodNetwork is what ExplainPodPath needs to know about a pod and the node
+type PodNetwork struct {
+	IP           net.IP
+	DeviceNumber int
+	// ENI is the ENI of the pod's IP address, and ENIs the attached ENIs by MAC address
+	ENI      string
+	ENIs     map[string]string
+	VPCCIDRs []string
+}
+
+// PodPathStep is a step of the path between a pod and a destination
+type PodPathStep struct {
+	Name   string
+	Detail string `json:",omitempty"`
+	// Problem is what is missing or wrong compared with what the CNI plugin and ipamd set up, if anything
+	Problem string `json:",omitempty"`
+}
+
+// PodPath explains how the node routes the traffic of a pod to a destination
+type PodPath struct {
+	PodIP       string
+	Destination string
+	// Table, Interface, MAC and ENI are where the traffic to the destination leaves the node
+	Table     int    `json:",omitempty"`
+	Interface string `json:",omitempty"`
+	MAC       string `json:",omitempty"`
+	ENI       string `json:",omitempty"`
+	// SNAT is the source address of the traffic once the node translated it, or empty if it keeps the pod's IP
+	// address
+	SNAT  string `json:",omitempty"`
+	Steps []PodPathStep
+}
+
+// HasProblems returns whether a step differs from what the CNI plugin and ipamd set up
+func (p PodPath) HasProblems() bool {
+	for _, step := range p.Steps {
+		if step.Problem != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PodPath) addStep(name string, detail string, problem string) {
+	p.Steps = append(p.Steps, PodPathStep{Name: name, Detail: detail, Problem: problem})
+}
+
+// podPathView is the state of the node that ExplainPodPath reads
+type podPathView struct {
+	rules  []netlink.Rule
+	routes []netlink.Route
+	links  map[int]netlink.Link
+	// chains are the rules of the iptables chains, by table and chain
+	chains map[string]map[string][][]string
+}
+
+// ExplainPodPath follows the traffic of a pod to a destination through the IP rules, the route tables and the
+// iptables chains of the node, and compares them with what the CNI plugin sets up for the pod
+func (n *linuxNetwork) ExplainPodPath(pod PodNetwork, dst net.IP) (PodPath, error) {
+	var v podPathView
+	var err error
+	if v.rules, err = n.GetRuleList(); err != nil {
+		return PodPath{}, errors.Wrap(err, "explain pod path: failed to list the IP rules")
+	}
+	v.routes, err = n.netLink.RouteListFiltered(unix.AF_INET, &netlink.Route{Table: unix.RT_TABLE_UNSPEC},
+		netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return PodPath{}, errors.Wrap(err, "explain pod path: failed to list the routes")
+	}
+	links, err := n.netLink.LinkList()
+	if err != nil {
+		return PodPath{}, errors.Wrap(err, "explain pod path: failed to list the links")
+	}
+	v.links = make(map[int]netlink.Link, len(links))
+	for _, link := range links {
+		v.links[link.Attrs().Index] = link
+	}
+
+	ipt, err := n.newIptables()
+	if err != nil {
+		return PodPath{}, errors.Wrap(err, "explain pod path: failed to create iptables")
+	}
+	v.chains = make(map[string]map[string][][]string)
+	for table, builtin := range map[string]string{"nat": "POSTROUTING", "mangle": "PREROUTING"} {
+		chains, err := ipt.ListChains(table)
+		if err != nil {
+			return PodPath{}, errors.Wrapf(err, "explain pod path: failed to list iptables %s chains", table)
+		}
+		v.chains[table] = make(map[string][][]string)
+		for _, chain := range chains {
+			if chain != builtin && !strings.HasPrefix(chain, "AWS-") {
+				continue
+			}
+			rules, err := ipt.List(table, chain)
+			if err != nil {
+				return PodPath{}, errors.Wrapf(err, "explain pod path: failed to list iptables %s chain %s", table, chain)
+			}
+			for _, rule := range rules {
+				ruleSpec, err := parseRuleSpec(rule)
+				if err != nil || len(ruleSpec) == 0 {
+					// The policy of a chain, "-P POSTROUTING ACCEPT"
+					continue
+				}
+				v.chains[table][chain] = append(v.chains[table][chain], ruleSpec)
+			}
+		}
+	}
+	return n.explainPodPath(pod, dst, v), nil
+}
+
+// explainPodPath follows the traffic of a pod through the view. The routing decision approximates the kernel's: the
+// rules are tried by priority, and the first table with a route to the destination is used.
+func (n *linuxNetwork) explainPodPath(pod PodNetwork, dst net.IP, v podPathView) PodPath {
+	path := PodPath{PodIP: pod.IP.String(), Destination: dst.String()}
+	podNet := &net.IPNet{IP: pod.IP.To4(), Mask: net.CIDRMask(32, 32)}
+
+	veth := n.explainVeth(&path, v, podNet)
+	n.explainPodRules(&path, v, pod, podNet)
+
+	p := packet{src: pod.IP, dst: dst, iif: veth}
+	if n.useEgressIP {
+		p.mark = n.explainEgressMark(&path, v, podNet)
+	}
+	rule, route := v.route(p)
+	if route == nil {
+		path.addStep("route", "", "no route to the destination")
+		return path
+	}
+	path.Table = rule.Table
+	path.addStep("rule", describeRule(*rule), "")
+	path.addStep("route", v.describeRoute(route), "")
+	if route.Type == unix.RTN_LOCAL {
+		path.addStep("interface", "delivered to the node", "")
+		return path
+	}
+
+	var out string
+	if link, ok := v.links[route.LinkIndex]; ok {
+		out = link.Attrs().Name
+		path.Interface = out
+		path.MAC = link.Attrs().HardwareAddr.String()
+		path.ENI = pod.ENIs[path.MAC]
+	}
+	switch {
+	case path.ENI != "":
+		problem := ""
+		if pod.DeviceNumber > 0 && rule.Table == n.layout.ENIRouteTable(pod.DeviceNumber) && path.ENI != pod.ENI {
+			problem = fmt.Sprintf("the route table of the pod's ENI %s leaves through %s", pod.ENI, path.ENI)
+		}
+		path.addStep("interface", fmt.Sprintf("%s (%s), ENI %s", out, path.MAC, path.ENI), problem)
+	case out != "":
+		path.addStep("interface", out, "")
+	default:
+		path.addStep("interface", "", fmt.Sprintf("no link with index %d", route.LinkIndex))
+	}
+
+	n.explainSNAT(&path, v, p, out)
+	n.explainConnmark(&path, v, p)
+	return path
+}
+
+// explainVeth finds the veth of the pod through the route the CNI plugin adds to it in the main table
+func (n *linuxNetwork) explainVeth(path *PodPath, v podPathView, podNet *net.IPNet) string {
+	for _, route := range v.routes {
+		if route.Table != unix.RT_TABLE_MAIN || !sameIPNet(route.Dst, podNet) {
+			continue
+		}
+		link, ok := v.links[route.LinkIndex]
+		if !ok || link.Type() != "veth" {
+			continue
+		}
+		name := link.Attrs().Name
+		if link.Attrs().Flags&net.FlagUp == 0 {
+			path.addStep("veth", name, "the pod's veth is down")
+		} else {
+			path.addStep("veth", fmt.Sprintf("%s, %s", name, v.describeRoute(&route)), "")
+		}
+		return name
+	}
+	path.addStep("veth", "", "no route to the pod's IP address through a veth in the main table")
+	return ""
+}
+
+// explainPodRules compares the rules of the pod's IP address with the ones setupNS adds: a rule to the pod that looks
+// up the main table, and for the pods of secondary ENIs rules from the pod that look up the ENI's table
+func (n *linuxNetwork) explainPodRules(path *PodPath, v podPathView, pod PodNetwork, podNet *net.IPNet) {
+	var toPod, fromPod []netlink.Rule
+	for _, rule := range v.rules {
+		switch {
+		case rule.Priority == n.layout.ToPodRulePriority() && sameIPNet(rule.Dst, podNet) && rule.Src == nil:
+			toPod = append(toPod, rule)
+		case rule.Priority == n.layout.FromPodRulePriority() && sameIPNet(rule.Src, podNet):
+			fromPod = append(fromPod, rule)
+		}
+	}
+
+	switch {
+	case len(toPod) == 0:
+		path.addStep("to-pod rule", "", fmt.Sprintf("no rule to the pod at priority %d", n.layout.ToPodRulePriority()))
+	case toPod[0].Table != unix.RT_TABLE_MAIN:
+		path.addStep("to-pod rule", describeRule(toPod[0]), "the rule to the pod doesn't look up the main table")
+	default:
+		path.addStep("to-pod rule", describeRule(toPod[0]), "")
+	}
+
+	if pod.DeviceNumber == 0 {
+		if len(fromPod) > 0 {
+			path.addStep("from-pod rules", describeRules(fromPod),
+				"the pod is on the primary ENI, but has rules from its IP address")
+		} else {
+			path.addStep("from-pod rules", "none, the pod is on the primary ENI", "")
+		}
+		return
+	}
+
+	table := n.layout.ENIRouteTable(pod.DeviceNumber)
+	want := []*net.IPNet{nil}
+	if !n.useExternalSNAT {
+		want = nil
+		for _, cidr := range pod.VPCCIDRs {
+			if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+				want = append(want, ipNet)
+			}
+		}
+	}
+	var missing []string
+	for _, dst := range want {
+		found := false
+		for _, rule := range fromPod {
+			if sameIPNet(rule.Dst, dst) && rule.Table == table {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, describeRule(netlink.Rule{Priority: n.layout.FromPodRulePriority(), Src: podNet,
+				Dst: dst, Table: table}))
+		}
+	}
+	problem := ""
+	if len(missing) > 0 {
+		problem = "missing " + strings.Join(missing, ", ")
+	}
+	path.addStep("from-pod rules", describeRules(fromPod), problem)
+
+	var tableRoutes []string
+	for i := range v.routes {
+		if v.routes[i].Table == table {
+			tableRoutes = append(tableRoutes, v.describeRoute(&v.routes[i]))
+		}
+	}
+	if len(tableRoutes) == 0 {
+		path.addStep("ENI route table", "", fmt.Sprintf("route table %d of device %d is empty", table, pod.DeviceNumber))
+	} else {
+		path.addStep("ENI route table", strings.Join(tableRoutes, ", "), "")
+	}
+}
+
+// explainEgressMark returns the mark of the egress IP of the pod, if it has one
+func (n *linuxNetwork) explainEgressMark(path *PodPath, v podPathView, podNet *net.IPNet) uint32 {
+	for _, spec := range v.chains["mangle"][egressIPMarkChain] {
+		m := parseIptablesMatch(spec)
+		if m.target == "MARK" && sameIPNet(m.src, podNet) {
+			path.addStep("egress IP mark", fmt.Sprintf("%#x, %s", m.setMark, m.comment), "")
+			return m.setMark
+		}
+	}
+	return 0
+}
+
+// explainSNAT follows the traffic through the SNAT chains of the nat table
+func (n *linuxNetwork) explainSNAT(path *PodPath, v podPathView, p packet, out string) {
+	if n.useExternalSNAT {
+		path.addStep("SNAT", "none, "+envExternalSNAT+" is set and the traffic keeps the pod's IP address", "")
+		return
+	}
+	nat := v.chains["nat"]
+	found := false
+	for _, spec := range nat["POSTROUTING"] {
+		if parseIptablesMatch(spec).target == "AWS-SNAT-CHAIN-0" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		path.addStep("SNAT", "", "no jump to AWS-SNAT-CHAIN-0 in the nat POSTROUTING chain")
+		return
+	}
+	var d snatDecision
+	if !walkNATChain(nat, "AWS-SNAT-CHAIN-0", p, out, &d, 0) || d.toSource == "" {
+		reason := d.reason
+		if reason == "" {
+			reason = "no SNAT rule matched"
+		}
+		path.addStep("SNAT", "none, "+reason, "")
+		return
+	}
+	path.SNAT = d.toSource
+	path.addStep("SNAT", fmt.Sprintf("to %s by %s", d.toSource, d.rule), "")
+}
+
+// explainConnmark describes how the replies to the connections from the primary ENI are routed back through it
+func (n *linuxNetwork) explainConnmark(path *PodPath, v podPathView, p packet) {
+	if !n.nodePortSupportEnabled {
+		path.addStep("connmark", "none, "+envNodePortSupport+" is disabled", "")
+		return
+	}
+	mark := n.mainENIMark
+	var missing []string
+	found := false
+	for _, rule := range v.rules {
+		if rule.Priority == n.layout.HostRulePriority() && rule.Mark == int(mark) && rule.Table == unix.RT_TABLE_MAIN {
+			found = true
+			break
+		}
+	}
+	if !found {
+		missing = append(missing, describeRule(netlink.Rule{Priority: n.layout.HostRulePriority(), Mark: int(mark),
+			Mask: int(mark), Table: unix.RT_TABLE_MAIN}))
+	}
+	for _, option := range []string{"--set-mark", "--restore-mark"} {
+		found := false
+		for _, spec := range v.chains["mangle"]["PREROUTING"] {
+			if m := parseIptablesMatch(spec); m.target == "CONNMARK" && m.connmark == option {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, "mangle PREROUTING CONNMARK "+option)
+		}
+	}
+
+	detail := fmt.Sprintf("connections to node ports through the primary ENI are marked %#x", mark)
+	p.mark |= mark
+	if rule, route := v.route(p); route != nil {
+		detail += fmt.Sprintf(", the pod's replies match %s and take %s", describeRule(*rule), v.describeRoute(route))
+	}
+	problem := ""
+	if len(missing) > 0 {
+		problem = "missing " + strings.Join(missing, ", ")
+	}
+	path.addStep("connmark", detail, problem)
+}
+
+// packet is what the IP rules and the iptables rules match
+type packet struct {
+	src  net.IP
+	dst  net.IP
+	iif  string
+	mark uint32
+}
+
+func ruleMatches(rule netlink.Rule, p packet) bool {
+	mask := uint32(0xffffffff)
+	if rule.Mask > 0 {
+		mask = uint32(rule.Mask)
+	}
+	match := (rule.Src == nil || rule.Src.Contains(p.src)) &&
+		(rule.Dst == nil || rule.Dst.Contains(p.dst)) &&
+		(rule.IifName == "" || rule.IifName == p.iif) &&
+		// The output interface only matches the traffic of local sockets
+		rule.OifName == "" &&
+		(rule.Mark <= 0 || p.mark&mask == uint32(rule.Mark))
+	if rule.Invert {
+		return !match
+	}
+	return match
+}
+
+// route returns the rule and the route the kernel uses for a packet
+func (v podPathView) route(p packet) (*netlink.Rule, *netlink.Route) {
+	rules := make([]netlink.Rule, len(v.rules))
+	copy(rules, v.rules)
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+	for i := range rules {
+		if !ruleMatches(rules[i], p) {
+			continue
+		}
+		if route := v.longestMatch(rules[i].Table, p.dst); route != nil {
+			return &rules[i], route
+		}
+	}
+	return nil, nil
+}
+
+// longestMatch returns the most specific route of a table to dst
+func (v podPathView) longestMatch(table int, dst net.IP) *netlink.Route {
+	var best *netlink.Route
+	bestOnes := -1
+	for i := range v.routes {
+		route := &v.routes[i]
+		if route.Table != table {
+			continue
+		}
+		ones := 0
+		if route.Dst != nil {
+			if !route.Dst.Contains(dst) {
+				continue
+			}
+			ones, _ = route.Dst.Mask.Size()
+		}
+		if ones > bestOnes {
+			best, bestOnes = route, ones
+		}
+	}
+	return best
+}
+
+func tableName(table int) string {
+	switch table {
+	case unix.RT_TABLE_MAIN:
+		return "main"
+	case unix.RT_TABLE_LOCAL:
+		return "local"
+	case unix.RT_TABLE_DEFAULT:
+		return "default"
+	}
+	return strconv.Itoa(table)
+}
+
+// describeRule formats a rule as `ip rule` does
+func describeRule(rule netlink.Rule) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d:", rule.Priority)
+	if rule.Invert {
+		b.WriteString(" not")
+	}
+	if rule.Src != nil {
+		fmt.Fprintf(&b, " from %s", rule.Src)
+	} else {
+		b.WriteString(" from all")
+	}
+	if rule.Dst != nil {
+		fmt.Fprintf(&b, " to %s", rule.Dst)
+	}
+	if rule.IifName != "" {
+		fmt.Fprintf(&b, " iif %s", rule.IifName)
+	}
+	if rule.Mark > 0 {
+		fmt.Fprintf(&b, " fwmark %#x", rule.Mark)
+		if rule.Mask > 0 {
+			fmt.Fprintf(&b, "/%#x", rule.Mask)
+		}
+	}
+	fmt.Fprintf(&b, " lookup %s", tableName(rule.Table))
+	return b.String()
+}
+
+func describeRules(rules []netlink.Rule) string {
+	descriptions := make([]string, len(rules))
+	for i, rule := range rules {
+		descriptions[i] = describeRule(rule)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// describeRoute formats a route as `ip route` does
+func (v podPathView) describeRoute(route *netlink.Route) string {
+	var b strings.Builder
+	if route.Type == unix.RTN_LOCAL {
+		b.WriteString("local ")
+	}
+	if route.Dst != nil {
+		b.WriteString(route.Dst.String())
+	} else {
+		b.WriteString("default")
+	}
+	if route.Gw != nil {
+		fmt.Fprintf(&b, " via %s", route.Gw)
+	}
+	if link, ok := v.links[route.LinkIndex]; ok {
+		fmt.Fprintf(&b, " dev %s", link.Attrs().Name)
+	}
+	fmt.Fprintf(&b, " table %s", tableName(route.Table))
+	return b.String()
+}
+
+// sameIPNet returns whether two rule or route selectors are the same, nil being all addresses
+func sameIPNet(a *net.IPNet, b *net.IPNet) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.String() == b.String()
+}
+
+// iptablesMatch is the part of an iptables rule that ExplainPodPath understands
+type iptablesMatch struct {
+	src      *net.IPNet
+	dst      *net.IPNet
+	negDst   bool
+	out      string
+	mark     uint32
+	markMask uint32
+	comment  string
+	target   string
+	toSource string
+	setMark  uint32
+	connmark string
+}
+
+// parseIptablesMatch parses a rulespec as returned by parseRuleSpec
+func parseIptablesMatch(spec []string) iptablesMatch {
+	var m iptablesMatch
+	negate := false
+	for i := 0; i < len(spec); i++ {
+		arg := spec[i]
+		value := ""
+		if i+1 < len(spec) {
+			value = spec[i+1]
+		}
+		switch arg {
+		case "!":
+			negate = true
+			continue
+		case "-s", "--source":
+			m.src = parseIPNet(value)
+			i++
+		case "-d", "--destination":
+			m.dst, m.negDst = parseIPNet(value), negate
+			i++
+		case "-o", "--out-interface":
+			m.out = value
+			i++
+		case "--mark":
+			m.mark, m.markMask = parseMark(value)
+			i++
+		case "--set-xmark", "--set-mark":
+			// iptables -S shows the --set-mark of CONNMARK as --set-xmark
+			if m.target == "CONNMARK" {
+				m.connmark = "--set-mark"
+			}
+			m.setMark, _ = parseMark(value)
+			i++
+		case "--restore-mark":
+			m.connmark = arg
+		case "--comment":
+			m.comment = value
+			i++
+		case "-j", "--jump":
+			m.target = value
+			i++
+		case "--to-source":
+			m.toSource = value
+			i++
+		case "-m", "--match", "--dst-type", "--mask":
+			i++
+		}
+		negate = false
+	}
+	return m
+}
+
+func parseIPNet(value string) *net.IPNet {
+	if !strings.Contains(value, "/") {
+		value += "/32"
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil
+	}
+	return ipNet
+}
+
+// parseMark parses a "value/mask" mark
+func parseMark(value string) (uint32, uint32) {
+	parts := strings.SplitN(value, "/", 2)
+	mark, _ := strconv.ParseUint(parts[0], 0, 32)
+	mask := uint64(0xffffffff)
+	if len(parts) == 2 {
+		mask, _ = strconv.ParseUint(parts[1], 0, 32)
+	}
+	return uint32(mark), uint32(mask)
+}
+
+func (m iptablesMatch) matches(p packet, out string) bool {
+	if m.src != nil && !m.src.Contains(p.src) {
+		return false
+	}
+	if m.dst != nil && m.dst.Contains(p.dst) == m.negDst {
+		return false
+	}
+	if m.out != "" {
+		if strings.HasSuffix(m.out, "+") {
+			if !strings.HasPrefix(out, strings.TrimSuffix(m.out, "+")) {
+				return false
+			}
+		} else if m.out != out {
+			return false
+		}
+	}
+	return m.markMask == 0 || p.mark&m.markMask == m.mark
+}
+
+// snatDecision is how the SNAT chains translate a packet
+type snatDecision struct {
+	toSource string
+	// rule is the chain and comment of the SNAT rule
+	rule string
+	// reason is why the packet was not translated
+	reason string
+}
+
+// walkNATChain follows a packet through a nat chain and the chains it jumps to, and returns whether a rule decided
+// what to do with it
+func walkNATChain(chains map[string][][]string, chain string, p packet, out string, d *snatDecision, depth int) bool {
+	if depth > maxChainDepth {
+		return false
+	}
+	for _, spec := range chains[chain] {
+		m := parseIptablesMatch(spec)
+		if !m.matches(p, out) {
+			if m.negDst && m.dst != nil && m.dst.Contains(p.dst) {
+				d.reason = fmt.Sprintf("the destination is in %s (%s)", m.dst, m.comment)
+			}
+			continue
+		}
+		switch m.target {
+		case "SNAT":
+			d.toSource = m.toSource
+			d.rule = chain
+			if m.comment != "" {
+				d.rule += " \"" + m.comment + "\""
+			}
+			return true
+		case "ACCEPT":
+			d.reason = "accepted by " + chain
+			return true
+		case "RETURN":
+			return false
+		}
+		if _, ok := chains[m.target]; ok && walkNATChain(chains, m.target, p, out, d, depth+1) {
+			return true
+		}
+	}
+	return false
+}
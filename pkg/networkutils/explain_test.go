@@ -0,0 +1,234 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package networkutils
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, ipNet, _ := net.ParseCIDR(cidr)
+	return ipNet
+}
+
+func mustParseMAC(mac string) net.HardwareAddr {
+	hw, _ := net.ParseMAC(mac)
+	return hw
+}
+
+// explainTestView is a node with a pod 10.0.1.5 on the ENI of device 2, and another pod 10.0.0.20 on the primary ENI
+func explainTestView() podPathView {
+	eth0 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 2, Name: "eth0", HardwareAddr: mustParseMAC(testMAC1)}}
+	eth1 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 3, Name: "eth1", HardwareAddr: mustParseMAC(testMAC2)}}
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Index: 10, Name: "enia1b2c3", Flags: net.FlagUp}}
+	otherVeth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Index: 11, Name: "enid4e5f6", Flags: net.FlagUp}}
+	gw := net.ParseIP("10.0.0.1")
+	return podPathView{
+		rules: []netlink.Rule{
+			{Priority: 0, Table: unix.RT_TABLE_LOCAL},
+			{Priority: 512, Dst: mustParseCIDR("10.0.1.5/32"), Table: unix.RT_TABLE_MAIN},
+			{Priority: 512, Dst: mustParseCIDR("10.0.0.20/32"), Table: unix.RT_TABLE_MAIN},
+			{Priority: 1024, Mark: 0x80, Mask: 0x80, Table: unix.RT_TABLE_MAIN},
+			{Priority: 1536, Src: mustParseCIDR("10.0.1.5/32"), Dst: mustParseCIDR("10.0.0.0/16"), Table: 2},
+			{Priority: 32766, Table: unix.RT_TABLE_MAIN},
+		},
+		routes: []netlink.Route{
+			{Table: unix.RT_TABLE_LOCAL, Type: unix.RTN_LOCAL, Dst: mustParseCIDR("10.0.0.10/32"), LinkIndex: 2},
+			{Table: unix.RT_TABLE_MAIN, Gw: gw, LinkIndex: 2},
+			{Table: unix.RT_TABLE_MAIN, Dst: mustParseCIDR("10.0.0.0/24"), LinkIndex: 2, Scope: netlink.SCOPE_LINK},
+			{Table: unix.RT_TABLE_MAIN, Dst: mustParseCIDR("10.0.1.5/32"), LinkIndex: 10, Scope: netlink.SCOPE_LINK},
+			{Table: unix.RT_TABLE_MAIN, Dst: mustParseCIDR("10.0.0.20/32"), LinkIndex: 11, Scope: netlink.SCOPE_LINK},
+			{Table: 2, Gw: gw, LinkIndex: 3},
+			{Table: 2, Dst: mustParseCIDR("10.0.0.1/32"), LinkIndex: 3, Scope: netlink.SCOPE_LINK},
+		},
+		links: map[int]netlink.Link{2: eth0, 3: eth1, 10: veth, 11: otherVeth},
+		chains: map[string]map[string][][]string{
+			"nat": {
+				"POSTROUTING": {{"-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-0"}},
+				"AWS-SNAT-CHAIN-0": {{"!", "-d", "10.0.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN",
+					"-j", "AWS-SNAT-CHAIN-1"}},
+				"AWS-SNAT-CHAIN-1": {{"!", "-d", "172.16.0.0/12", "-m", "comment", "--comment",
+					"AWS SNAT CHAIN EXCLUSION", "-j", "AWS-SNAT-CHAIN-2"}},
+				"AWS-SNAT-CHAIN-2": {{"-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type",
+					"LOCAL", "-j", "SNAT", "--to-source", "10.0.0.10", "--random-fully"}},
+			},
+			"mangle": {
+				"PREROUTING": {
+					{"-i", "eth0", "-m", "comment", "--comment", "AWS, primary ENI", "-m", "addrtype", "--dst-type",
+						"LOCAL", "--limit-iface-in", "-j", "CONNMARK", "--set-xmark", "0x80/0x80"},
+					{"-i", "eni+", "-m", "comment", "--comment", "AWS, primary ENI", "-j", "CONNMARK",
+						"--restore-mark", "--nfmask", "0x80", "--ctmask", "0x80"},
+				},
+			},
+		},
+	}
+}
+
+func explainTestNetwork() *linuxNetwork {
+	return &linuxNetwork{layout: DefaultRoutingLayout, nodePortSupportEnabled: true, mainENIMark: 0x80}
+}
+
+func explainTestPod() PodNetwork {
+	return PodNetwork{
+		IP:           net.ParseIP("10.0.1.5"),
+		DeviceNumber: 2,
+		ENI:          "eni-2",
+		ENIs:         map[string]string{testMAC1: "eni-0", testMAC2: "eni-2"},
+		VPCCIDRs:     []string{"10.0.0.0/16"},
+	}
+}
+
+func steps(path PodPath) map[string]PodPathStep {
+	result := make(map[string]PodPathStep, len(path.Steps))
+	for _, step := range path.Steps {
+		result[step.Name] = step
+	}
+	return result
+}
+
+func TestExplainPodPathInVPC(t *testing.T) {
+	path := explainTestNetwork().explainPodPath(explainTestPod(), net.ParseIP("10.0.2.7"), explainTestView())
+
+	assert.False(t, path.HasProblems(), "%+v", path.Steps)
+	assert.Equal(t, 2, path.Table)
+	assert.Equal(t, "eth1", path.Interface)
+	assert.Equal(t, "eni-2", path.ENI)
+	assert.Empty(t, path.SNAT)
+	s := steps(path)
+	assert.Equal(t, "enia1b2c3, 10.0.1.5/32 dev enia1b2c3 table main", s["veth"].Detail)
+	assert.Equal(t, "512: from all to 10.0.1.5/32 lookup main", s["to-pod rule"].Detail)
+	assert.Equal(t, "1536: from 10.0.1.5/32 to 10.0.0.0/16 lookup 2", s["rule"].Detail)
+	assert.Equal(t, "default via 10.0.0.1 dev eth1 table 2", s["route"].Detail)
+	assert.Equal(t, "none, the destination is in 10.0.0.0/16 (AWS SNAT CHAIN)", s["SNAT"].Detail)
+	assert.Contains(t, s["connmark"].Detail, "1024: from all fwmark 0x80/0x80 lookup main")
+	assert.Contains(t, s["connmark"].Detail, "dev eth0 table main")
+}
+
+func TestExplainPodPathOutsideVPC(t *testing.T) {
+	path := explainTestNetwork().explainPodPath(explainTestPod(), net.ParseIP("8.8.8.8"), explainTestView())
+
+	assert.False(t, path.HasProblems(), "%+v", path.Steps)
+	assert.Equal(t, unix.RT_TABLE_MAIN, path.Table)
+	assert.Equal(t, "eth0", path.Interface)
+	assert.Equal(t, "eni-0", path.ENI)
+	assert.Equal(t, "10.0.0.10", path.SNAT)
+	assert.Equal(t, `to 10.0.0.10 by AWS-SNAT-CHAIN-2 "AWS, SNAT"`, steps(path)["SNAT"].Detail)
+
+	// An excluded CIDR keeps the pod's IP address
+	path = explainTestNetwork().explainPodPath(explainTestPod(), net.ParseIP("172.16.3.4"), explainTestView())
+	assert.Empty(t, path.SNAT)
+	assert.Equal(t, "none, the destination is in 172.16.0.0/12 (AWS SNAT CHAIN EXCLUSION)", steps(path)["SNAT"].Detail)
+}
+
+func TestExplainPodPathToPodOnNode(t *testing.T) {
+	path := explainTestNetwork().explainPodPath(explainTestPod(), net.ParseIP("10.0.0.20"), explainTestView())
+
+	assert.False(t, path.HasProblems(), "%+v", path.Steps)
+	assert.Equal(t, "enid4e5f6", path.Interface)
+	assert.Equal(t, "512: from all to 10.0.0.20/32 lookup main", steps(path)["rule"].Detail)
+}
+
+func TestExplainPodPathToNode(t *testing.T) {
+	path := explainTestNetwork().explainPodPath(explainTestPod(), net.ParseIP("10.0.0.10"), explainTestView())
+
+	assert.Equal(t, unix.RT_TABLE_LOCAL, path.Table)
+	assert.Equal(t, "delivered to the node", steps(path)["interface"].Detail)
+}
+
+func TestExplainPodPathMissingSetup(t *testing.T) {
+	v := explainTestView()
+	// Only the main and local rules are left, the veth is down and the SNAT chain has no jump
+	v.rules = []netlink.Rule{v.rules[0], v.rules[5]}
+	v.links[10].Attrs().Flags = 0
+	v.chains["nat"]["POSTROUTING"] = nil
+	v.chains["mangle"]["PREROUTING"] = v.chains["mangle"]["PREROUTING"][1:]
+
+	path := explainTestNetwork().explainPodPath(explainTestPod(), net.ParseIP("10.0.2.7"), v)
+
+	assert.True(t, path.HasProblems())
+	s := steps(path)
+	assert.Equal(t, "the pod's veth is down", s["veth"].Problem)
+	assert.Equal(t, "no rule to the pod at priority 512", s["to-pod rule"].Problem)
+	assert.Equal(t, "missing 1536: from 10.0.1.5/32 to 10.0.0.0/16 lookup 2", s["from-pod rules"].Problem)
+	// Without the from-pod rule, the traffic leaves through the primary ENI
+	assert.Equal(t, "eth0", path.Interface)
+	assert.Equal(t, "no jump to AWS-SNAT-CHAIN-0 in the nat POSTROUTING chain", s["SNAT"].Problem)
+	assert.Equal(t, "missing 1024: from all fwmark 0x80/0x80 lookup main, "+
+		"mangle PREROUTING CONNMARK --set-mark", s["connmark"].Problem)
+}
+
+func TestExplainPodPathExternalSNAT(t *testing.T) {
+	n := explainTestNetwork()
+	n.useExternalSNAT = true
+	n.nodePortSupportEnabled = false
+	v := explainTestView()
+	v.rules[4].Dst = nil
+
+	path := n.explainPodPath(explainTestPod(), net.ParseIP("8.8.8.8"), v)
+
+	assert.False(t, path.HasProblems(), "%+v", path.Steps)
+	assert.Equal(t, "eth1", path.Interface)
+	assert.Empty(t, path.SNAT)
+	s := steps(path)
+	assert.Contains(t, s["SNAT"].Detail, envExternalSNAT)
+	assert.Contains(t, s["connmark"].Detail, envNodePortSupport)
+
+	// With external SNAT, the pod needs a rule without a destination
+	path = n.explainPodPath(explainTestPod(), net.ParseIP("8.8.8.8"), explainTestView())
+	assert.Equal(t, "missing 1536: from 10.0.1.5/32 lookup 2", steps(path)["from-pod rules"].Problem)
+}
+
+func TestExplainPodPathWrongENI(t *testing.T) {
+	pod := explainTestPod()
+	pod.ENIs[testMAC2] = "eni-3"
+	path := explainTestNetwork().explainPodPath(pod, net.ParseIP("10.0.2.7"), explainTestView())
+
+	assert.Equal(t, "the route table of the pod's ENI eni-2 leaves through eni-3", steps(path)["interface"].Problem)
+}
+
+func TestExplainPodPathEgressIP(t *testing.T) {
+	n := explainTestNetwork()
+	n.useEgressIP = true
+	v := explainTestView()
+	v.chains["mangle"][egressIPMarkChain] = [][]string{
+		{"-s", "10.0.1.5/32", "-m", "comment", "--comment", "AWS, egress IP web", "-j", "MARK", "--set-xmark", "0x100/0x3f00"},
+	}
+	v.chains["nat"]["AWS-SNAT-CHAIN-2"] = [][]string{
+		{"-m", "comment", "--comment", "AWS SNAT CHAIN EGRESS", "-j", egressIPSNATChain},
+	}
+	v.chains["nat"][egressIPSNATChain] = [][]string{
+		{"-o", "eth0", "-m", "mark", "--mark", "0x100/0x3f00", "-m", "comment", "--comment", "AWS, egress IP web",
+			"-j", "SNAT", "--to-source", "10.0.0.50"},
+		{"-m", "comment", "--comment", "AWS, SNAT", "-j", "SNAT", "--to-source", "10.0.0.10"},
+	}
+
+	path := n.explainPodPath(explainTestPod(), net.ParseIP("8.8.8.8"), v)
+
+	assert.Equal(t, "10.0.0.50", path.SNAT)
+	assert.Equal(t, "0x100, AWS, egress IP web", steps(path)["egress IP mark"].Detail)
+}
+
+func TestExplainPodPathNoRoute(t *testing.T) {
+	v := explainTestView()
+	v.routes = v.routes[:1]
+
+	path := explainTestNetwork().explainPodPath(explainTestPod(), net.ParseIP("8.8.8.8"), v)
+
+	assert.Equal(t, "no route to the destination", steps(path)["route"].Problem)
+}
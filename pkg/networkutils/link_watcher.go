@@ -0,0 +1,144 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package networkutils
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/netlinkwrapper"
+)
+
+// linkUpdatesBuffer is the number of link updates that can be queued before the netlink socket is not read anymore
+const linkUpdatesBuffer = 64
+
+// linkWatcher subscribes to the link updates of the host, so that the link of a new ENI is found as soon as the
+// kernel adds it instead of polling for it
+type linkWatcher struct {
+	netLink netlinkwrapper.NetLink
+
+	lock       sync.Mutex
+	subscribed bool
+	// waiters are notified when a link with their MAC address is added
+	waiters map[string][]chan netlink.Link
+}
+
+func newLinkWatcher(netLink netlinkwrapper.NetLink) *linkWatcher {
+	return &linkWatcher{
+		netLink: netLink,
+		waiters: make(map[string][]chan netlink.Link),
+	}
+}
+
+// subscribe starts the subscription to link updates if it isn't running. The subscription stops if the netlink socket
+// fails, and is started again by the next wait. It must be called with the lock held.
+func (w *linkWatcher) subscribe() error {
+	if w.subscribed {
+		return nil
+	}
+	updates := make(chan netlink.LinkUpdate, linkUpdatesBuffer)
+	if err := w.netLink.LinkSubscribe(updates, nil); err != nil {
+		return errors.Wrap(err, "failed to subscribe to link updates")
+	}
+	w.subscribed = true
+	go w.run(updates)
+	return nil
+}
+
+func (w *linkWatcher) run(updates <-chan netlink.LinkUpdate) {
+	for update := range updates {
+		if update.Header.Type != unix.RTM_NEWLINK || update.Link == nil {
+			continue
+		}
+		mac := update.Attrs().HardwareAddr.String()
+		w.lock.Lock()
+		for _, waiter := range w.waiters[mac] {
+			select {
+			case waiter <- update.Link:
+			default:
+			}
+		}
+		w.lock.Unlock()
+	}
+
+	log.Warnf("The subscription to link updates stopped")
+	w.lock.Lock()
+	w.subscribed = false
+	w.lock.Unlock()
+}
+
+// wait returns the link with the given MAC address, waiting up to timeout for the kernel to add it
+func (w *linkWatcher) wait(mac string, timeout time.Duration) (netlink.Link, error) {
+	waiter := make(chan netlink.Link, 1)
+	w.lock.Lock()
+	if err := w.subscribe(); err != nil {
+		w.lock.Unlock()
+		return nil, err
+	}
+	w.waiters[mac] = append(w.waiters[mac], waiter)
+	w.lock.Unlock()
+	defer w.removeWaiter(mac, waiter)
+
+	// The link might have been added before the waiter was registered
+	links, err := w.netLink.LinkList()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list links")
+	}
+	for _, link := range links {
+		if link.Attrs().HardwareAddr.String() == mac {
+			return link, nil
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case link := <-waiter:
+		return link, nil
+	case <-timer.C:
+		return nil, errors.Errorf("no interface found which uses mac address %s after %v", mac, timeout)
+	}
+}
+
+func (w *linkWatcher) removeWaiter(mac string, waiter chan netlink.Link) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	waiters := w.waiters[mac]
+	for i := range waiters {
+		if waiters[i] == waiter {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(w.waiters, mac)
+	} else {
+		w.waiters[mac] = waiters
+	}
+}
+
+// WaitForLink waits up to timeout for the kernel to add the link with the given MAC address
+func (n *linuxNetwork) WaitForLink(mac string, timeout time.Duration) error {
+	link, err := n.links.wait(mac, timeout)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Found the link %s which uses mac address %s", link.Attrs().Name, mac)
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package networkutils
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestWaitForLink(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	watcher := newLinkWatcher(mockNetLink)
+	ln := &linuxNetwork{netLink: mockNetLink, links: watcher}
+
+	hwAddr1, _ := net.ParseMAC(testMAC1)
+	hwAddr2, _ := net.ParseMAC(testMAC2)
+	eth1 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", HardwareAddr: hwAddr1}}
+	eth2 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth2", HardwareAddr: hwAddr2}}
+
+	var updates chan<- netlink.LinkUpdate
+	mockNetLink.EXPECT().LinkSubscribe(gomock.Any(), nil).DoAndReturn(
+		func(ch chan<- netlink.LinkUpdate, done <-chan struct{}) error {
+			updates = ch
+			return nil
+		})
+
+	// The link is already there
+	mockNetLink.EXPECT().LinkList().Return([]netlink.Link{eth1}, nil)
+	assert.NoError(t, ln.WaitForLink(testMAC1, time.Minute))
+
+	// The link is added while waiting for it, after an unrelated update
+	mockNetLink.EXPECT().LinkList().Return([]netlink.Link{eth1}, nil).Do(func() {
+		go func() {
+			updates <- netlink.LinkUpdate{Header: unix.NlMsghdr{Type: unix.RTM_NEWLINK}, Link: eth1}
+			updates <- netlink.LinkUpdate{Header: unix.NlMsghdr{Type: unix.RTM_NEWLINK}, Link: eth2}
+		}()
+	})
+	assert.NoError(t, ln.WaitForLink(testMAC2, time.Minute))
+
+	mockNetLink.EXPECT().LinkList().Return([]netlink.Link{eth1}, nil)
+	assert.Error(t, ln.WaitForLink(testMAC2, 0))
+	assert.Empty(t, watcher.waiters)
+
+	// The subscription is started again after it stops
+	close(updates)
+	for i := 0; i < 100 && isSubscribed(watcher); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, isSubscribed(watcher))
+	mockNetLink.EXPECT().LinkSubscribe(gomock.Any(), nil).Return(errors.New("socket error"))
+	assert.Error(t, ln.WaitForLink(testMAC2, time.Minute))
+}
+
+func isSubscribed(watcher *linkWatcher) bool {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	return watcher.subscribed
+}
@@ -20,7 +20,9 @@ package mock_networkutils
 import (
 	net "net"
 	reflect "reflect"
+	time "time"
 
+	networkutils "github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
 	gomock "github.com/golang/mock/gomock"
 	netlink "github.com/vishvananda/netlink"
 )
@@ -48,6 +50,55 @@ func (m *MockNetworkAPIs) EXPECT() *MockNetworkAPIsMockRecorder {
 	return m.recorder
 }
 
+// CheckRoutingConflicts mocks base method
+func (m *MockNetworkAPIs) CheckRoutingConflicts(arg0 []string) ([]string, error) {
+	ret := m.ctrl.Call(m, "CheckRoutingConflicts", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CheckRoutingConflicts indicates an expected call of CheckRoutingConflicts
+func (mr *MockNetworkAPIsMockRecorder) CheckRoutingConflicts(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckRoutingConflicts", reflect.TypeOf((*MockNetworkAPIs)(nil).CheckRoutingConflicts), arg0)
+}
+
+// DeleteMigratedRouteTables mocks base method
+func (m *MockNetworkAPIs) DeleteMigratedRouteTables(arg0 []netlink.Rule) error {
+	ret := m.ctrl.Call(m, "DeleteMigratedRouteTables", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMigratedRouteTables indicates an expected call of DeleteMigratedRouteTables
+func (mr *MockNetworkAPIsMockRecorder) DeleteMigratedRouteTables(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMigratedRouteTables", reflect.TypeOf((*MockNetworkAPIs)(nil).DeleteMigratedRouteTables), arg0)
+}
+
+// DeletePodRoute mocks base method
+func (m *MockNetworkAPIs) DeletePodRoute(arg0 net.IPNet) error {
+	ret := m.ctrl.Call(m, "DeletePodRoute", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePodRoute indicates an expected call of DeletePodRoute
+func (mr *MockNetworkAPIsMockRecorder) DeletePodRoute(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePodRoute", reflect.TypeOf((*MockNetworkAPIs)(nil).DeletePodRoute), arg0)
+}
+
+// DeletePodRules mocks base method
+func (m *MockNetworkAPIs) DeletePodRules(arg0 net.IPNet) error {
+	ret := m.ctrl.Call(m, "DeletePodRules", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePodRules indicates an expected call of DeletePodRules
+func (mr *MockNetworkAPIsMockRecorder) DeletePodRules(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePodRules", reflect.TypeOf((*MockNetworkAPIs)(nil).DeletePodRules), arg0)
+}
+
 // DeleteRuleListBySrc mocks base method
 func (m *MockNetworkAPIs) DeleteRuleListBySrc(arg0 net.IPNet) error {
 	ret := m.ctrl.Call(m, "DeleteRuleListBySrc", arg0)
@@ -60,6 +111,19 @@ func (mr *MockNetworkAPIsMockRecorder) DeleteRuleListBySrc(arg0 interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRuleListBySrc", reflect.TypeOf((*MockNetworkAPIs)(nil).DeleteRuleListBySrc), arg0)
 }
 
+// ExplainPodPath mocks base method
+func (m *MockNetworkAPIs) ExplainPodPath(arg0 networkutils.PodNetwork, arg1 net.IP) (networkutils.PodPath, error) {
+	ret := m.ctrl.Call(m, "ExplainPodPath", arg0, arg1)
+	ret0, _ := ret[0].(networkutils.PodPath)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExplainPodPath indicates an expected call of ExplainPodPath
+func (mr *MockNetworkAPIsMockRecorder) ExplainPodPath(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExplainPodPath", reflect.TypeOf((*MockNetworkAPIs)(nil).ExplainPodPath), arg0, arg1)
+}
+
 // GetExcludeSNATCIDRs mocks base method
 func (m *MockNetworkAPIs) GetExcludeSNATCIDRs() []string {
 	ret := m.ctrl.Call(m, "GetExcludeSNATCIDRs")
@@ -72,6 +136,31 @@ func (mr *MockNetworkAPIsMockRecorder) GetExcludeSNATCIDRs() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExcludeSNATCIDRs", reflect.TypeOf((*MockNetworkAPIs)(nil).GetExcludeSNATCIDRs))
 }
 
+// GetPodRoutes mocks base method
+func (m *MockNetworkAPIs) GetPodRoutes() (map[string]string, error) {
+	ret := m.ctrl.Call(m, "GetPodRoutes")
+	ret0, _ := ret[0].(map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPodRoutes indicates an expected call of GetPodRoutes
+func (mr *MockNetworkAPIsMockRecorder) GetPodRoutes() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPodRoutes", reflect.TypeOf((*MockNetworkAPIs)(nil).GetPodRoutes))
+}
+
+// GetRoutingLayout mocks base method
+func (m *MockNetworkAPIs) GetRoutingLayout() networkutils.RoutingLayout {
+	ret := m.ctrl.Call(m, "GetRoutingLayout")
+	ret0, _ := ret[0].(networkutils.RoutingLayout)
+	return ret0
+}
+
+// GetRoutingLayout indicates an expected call of GetRoutingLayout
+func (mr *MockNetworkAPIsMockRecorder) GetRoutingLayout() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRoutingLayout", reflect.TypeOf((*MockNetworkAPIs)(nil).GetRoutingLayout))
+}
+
 // GetRuleList mocks base method
 func (m *MockNetworkAPIs) GetRuleList() ([]netlink.Rule, error) {
 	ret := m.ctrl.Call(m, "GetRuleList")
@@ -98,6 +187,18 @@ func (mr *MockNetworkAPIsMockRecorder) GetRuleListBySrc(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRuleListBySrc", reflect.TypeOf((*MockNetworkAPIs)(nil).GetRuleListBySrc), arg0, arg1)
 }
 
+// MigratePodRules mocks base method
+func (m *MockNetworkAPIs) MigratePodRules(arg0 []netlink.Rule, arg1 net.IPNet, arg2 int) error {
+	ret := m.ctrl.Call(m, "MigratePodRules", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MigratePodRules indicates an expected call of MigratePodRules
+func (mr *MockNetworkAPIsMockRecorder) MigratePodRules(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MigratePodRules", reflect.TypeOf((*MockNetworkAPIs)(nil).MigratePodRules), arg0, arg1, arg2)
+}
+
 // SetupENINetwork mocks base method
 func (m *MockNetworkAPIs) SetupENINetwork(arg0, arg1 string, arg2 int, arg3 string) error {
 	ret := m.ctrl.Call(m, "SetupENINetwork", arg0, arg1, arg2, arg3)
@@ -110,6 +211,18 @@ func (mr *MockNetworkAPIsMockRecorder) SetupENINetwork(arg0, arg1, arg2, arg3 in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetupENINetwork", reflect.TypeOf((*MockNetworkAPIs)(nil).SetupENINetwork), arg0, arg1, arg2, arg3)
 }
 
+// SetupEgressIPs mocks base method
+func (m *MockNetworkAPIs) SetupEgressIPs(arg0 []networkutils.EgressIP) error {
+	ret := m.ctrl.Call(m, "SetupEgressIPs", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetupEgressIPs indicates an expected call of SetupEgressIPs
+func (mr *MockNetworkAPIsMockRecorder) SetupEgressIPs(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetupEgressIPs", reflect.TypeOf((*MockNetworkAPIs)(nil).SetupEgressIPs), arg0)
+}
+
 // SetupHostNetwork mocks base method
 func (m *MockNetworkAPIs) SetupHostNetwork(arg0 *net.IPNet, arg1 []*string, arg2 string, arg3 *net.IP) error {
 	ret := m.ctrl.Call(m, "SetupHostNetwork", arg0, arg1, arg2, arg3)
@@ -122,6 +235,18 @@ func (mr *MockNetworkAPIsMockRecorder) SetupHostNetwork(arg0, arg1, arg2, arg3 i
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetupHostNetwork", reflect.TypeOf((*MockNetworkAPIs)(nil).SetupHostNetwork), arg0, arg1, arg2, arg3)
 }
 
+// TeardownENINetwork mocks base method
+func (m *MockNetworkAPIs) TeardownENINetwork(arg0 string) error {
+	ret := m.ctrl.Call(m, "TeardownENINetwork", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TeardownENINetwork indicates an expected call of TeardownENINetwork
+func (mr *MockNetworkAPIsMockRecorder) TeardownENINetwork(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TeardownENINetwork", reflect.TypeOf((*MockNetworkAPIs)(nil).TeardownENINetwork), arg0)
+}
+
 // UpdateRuleListBySrc mocks base method
 func (m *MockNetworkAPIs) UpdateRuleListBySrc(arg0 []netlink.Rule, arg1 net.IPNet, arg2 []string, arg3 bool) error {
 	ret := m.ctrl.Call(m, "UpdateRuleListBySrc", arg0, arg1, arg2, arg3)
@@ -134,6 +259,30 @@ func (mr *MockNetworkAPIsMockRecorder) UpdateRuleListBySrc(arg0, arg1, arg2, arg
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRuleListBySrc", reflect.TypeOf((*MockNetworkAPIs)(nil).UpdateRuleListBySrc), arg0, arg1, arg2, arg3)
 }
 
+// UpdateSNATRules mocks base method
+func (m *MockNetworkAPIs) UpdateSNATRules(arg0 []*string, arg1 *net.IP) error {
+	ret := m.ctrl.Call(m, "UpdateSNATRules", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateSNATRules indicates an expected call of UpdateSNATRules
+func (mr *MockNetworkAPIsMockRecorder) UpdateSNATRules(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSNATRules", reflect.TypeOf((*MockNetworkAPIs)(nil).UpdateSNATRules), arg0, arg1)
+}
+
+// UseEgressIP mocks base method
+func (m *MockNetworkAPIs) UseEgressIP() bool {
+	ret := m.ctrl.Call(m, "UseEgressIP")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// UseEgressIP indicates an expected call of UseEgressIP
+func (mr *MockNetworkAPIsMockRecorder) UseEgressIP() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseEgressIP", reflect.TypeOf((*MockNetworkAPIs)(nil).UseEgressIP))
+}
+
 // UseExternalSNAT mocks base method
 func (m *MockNetworkAPIs) UseExternalSNAT() bool {
 	ret := m.ctrl.Call(m, "UseExternalSNAT")
@@ -145,3 +294,27 @@ func (m *MockNetworkAPIs) UseExternalSNAT() bool {
 func (mr *MockNetworkAPIsMockRecorder) UseExternalSNAT() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseExternalSNAT", reflect.TypeOf((*MockNetworkAPIs)(nil).UseExternalSNAT))
 }
+
+// UsePerENISNAT mocks base method
+func (m *MockNetworkAPIs) UsePerENISNAT() bool {
+	ret := m.ctrl.Call(m, "UsePerENISNAT")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// UsePerENISNAT indicates an expected call of UsePerENISNAT
+func (mr *MockNetworkAPIsMockRecorder) UsePerENISNAT() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UsePerENISNAT", reflect.TypeOf((*MockNetworkAPIs)(nil).UsePerENISNAT))
+}
+
+// WaitForLink mocks base method
+func (m *MockNetworkAPIs) WaitForLink(arg0 string, arg1 time.Duration) error {
+	ret := m.ctrl.Call(m, "WaitForLink", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WaitForLink indicates an expected call of WaitForLink
+func (mr *MockNetworkAPIsMockRecorder) WaitForLink(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitForLink", reflect.TypeOf((*MockNetworkAPIs)(nil).WaitForLink), arg0, arg1)
+}
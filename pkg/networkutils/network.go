@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/bits"
 	"net"
 	"os"
 	"reflect"
@@ -42,16 +43,13 @@ import (
 )
 
 const (
+	// The IP rule priorities are set by the RoutingLayout. With the default one:
 	// 0 - 511 can be used other higher priorities
-	toPodRulePriority = 512
-
-	// 513 - 1023, can be used priority lower than toPodRulePriority but higher than default nonVPC CIDR rule
-
+	// 512 is for the to-pod rules (ip rule to <pod IP> table main)
+	// 513 - 1023, can be used priority lower than the to-pod rules but higher than default nonVPC CIDR rule
 	// 1024 is reserved for (ip rule not to <VPC's subnet> table main)
-	hostRulePriority = 1024
-
-	// 1025 - 1535 can be used priority lower than fromPodRulePriority but higher than default nonVPC CIDR rule
-	fromPodRulePriority = 1536
+	// 1025 - 1535 can be used priority lower than the from-pod rules but higher than default nonVPC CIDR rule
+	// 1536 is for the from-pod rules (ip rule from <pod IP> table <ENI table>)
 
 	mainRoutingTable = unix.RT_TABLE_MAIN
 
@@ -71,6 +69,35 @@ const (
 	// Defaults to hashrandom.
 	envRandomizeSNAT = "AWS_VPC_K8S_CNI_RANDOMIZESNAT"
 
+	// This environment is used to specify whether pods on secondary ENIs should be SNATed to the primary IP of their
+	// own ENI, and send non-VPC traffic out of that ENI, instead of being SNATed to the node's primary IP and leaving
+	// through the primary ENI. It has no effect when AWS_VPC_K8S_CNI_EXTERNALSNAT is set. Defaults to false.
+	envPerENISNAT = "AWS_VPC_K8S_CNI_PER_ENI_SNAT"
+
+	// perENISNATChain holds one SNAT rule per secondary ENI, matched on the outgoing interface, followed by the
+	// SNAT rule to the node's primary IP for everything else.
+	perENISNATChain = "AWS-SNAT-ENI"
+
+	// envEgressIP is the name of the environment variable that enables egress IPs. When it is "true", ipamd reserves
+	// an IP address of the primary ENI for each EgressIPConfig, and non-VPC traffic of the pods it selects that leaves
+	// through the primary ENI is SNATed to that address instead of the primary IP. Pods are selected with a firewall
+	// mark. It is ignored when AWS_VPC_K8S_CNI_EXTERNALSNAT is "true". Defaults to false.
+	envEgressIP = "AWS_VPC_K8S_CNI_EGRESS_IP"
+
+	// envEgressIPMarkMask is the name of the environment variable that overrides the firewall mark bits used to select
+	// the pods of each egress IP. The number of egress IPs per node is limited by the number of values that fit in it.
+	envEgressIPMarkMask = "AWS_VPC_K8S_CNI_EGRESS_IP_MARK_MASK"
+
+	// defaultEgressIPMarkMask leaves out our connmark (0x80), kube-proxy's marks (0x4000 and 0x8000) and the bits
+	// Calico uses by default (0xffff0000), which allows 63 egress IPs.
+	defaultEgressIPMarkMask = 0x3f00
+
+	// egressIPSNATChain SNATs marked non-VPC traffic to the egress IPs
+	egressIPSNATChain = "AWS-SNAT-EGRESS"
+
+	// egressIPMarkChain marks the traffic of the pods that use an egress IP
+	egressIPMarkChain = "AWS-EGRESS-MARK"
+
 	// envNodePortSupport is the name of environment variable that configures whether we implement support for
 	// NodePorts on the primary ENI. This requires that we add additional iptables rules and loosen the kernel's
 	// RPF check as described below. Defaults to true.
@@ -111,29 +138,77 @@ const (
 type NetworkAPIs interface {
 	// SetupNodeNetwork performs node level network configuration
 	SetupHostNetwork(vpcCIDR *net.IPNet, vpcCIDRs []*string, primaryMAC string, primaryAddr *net.IP) error
+	// UpdateSNATRules rebuilds the SNAT chains set up by SetupHostNetwork for a new list of VPC CIDRs
+	UpdateSNATRules(vpcCIDRs []*string, primaryAddr *net.IP) error
+	// WaitForLink waits for the kernel to add the link with the given MAC address, such as the link of a new ENI
+	WaitForLink(mac string, timeout time.Duration) error
 	// SetupENINetwork performs eni level network configuration
-	SetupENINetwork(eniIP string, mac string, table int, subnetCIDR string) error
+	SetupENINetwork(eniIP string, mac string, deviceNumber int, subnetCIDR string) error
+	// TeardownENINetwork removes the ENI level network configuration of a freed or detached ENI, by its primary IP
+	TeardownENINetwork(eniIP string) error
+	// GetRoutingLayout returns the route table numbering and rule priorities for pod traffic
+	GetRoutingLayout() RoutingLayout
+	// CheckRoutingConflicts returns the IP rules and routes of other agents that collide with the routing layout
+	CheckRoutingConflicts(eniMACs []string) ([]string, error)
 	UseExternalSNAT() bool
+	UsePerENISNAT() bool
+	UseEgressIP() bool
+	// SetupEgressIPs makes the traffic of the given pods use their egress IP
+	SetupEgressIPs(egressIPs []EgressIP) error
 	GetExcludeSNATCIDRs() []string
 	GetRuleList() ([]netlink.Rule, error)
 	GetRuleListBySrc(ruleList []netlink.Rule, src net.IPNet) ([]netlink.Rule, error)
 	UpdateRuleListBySrc(ruleList []netlink.Rule, src net.IPNet, toCIDRs []string, toFlag bool) error
 	DeleteRuleListBySrc(src net.IPNet) error
+	// DeletePodRules deletes the to-pod and from-pod rules of an IP address
+	DeletePodRules(podIP net.IPNet) error
+	// DeletePodRoute deletes the host route of an IP address
+	DeletePodRoute(podIP net.IPNet) error
+	// MigratePodRules moves the IP rules of an IP address to the rule priorities and route table of the routing layout
+	MigratePodRules(ruleList []netlink.Rule, podIP net.IPNet, deviceNumber int) error
+	// DeleteMigratedRouteTables deletes the routes of the route tables MigratePodRules moved the rules away from
+	DeleteMigratedRouteTables(ruleList []netlink.Rule) error
+	// GetPodRoutes returns the veths that the routes of the main table send the pod IP addresses to, by IP address
+	GetPodRoutes() (map[string]string, error)
+	// ExplainPodPath follows the traffic of a pod to a destination through the rules, routes and iptables of the node
+	ExplainPodPath(pod PodNetwork, dst net.IP) (PodPath, error)
+}
+
+// EgressIP is an address that non-VPC traffic of a set of pods is SNATed to
+type EgressIP struct {
+	// Name is the name of the EgressIPConfig the address is reserved for
+	Name string
+	// IP is the egress IP address, which must belong to the primary ENI
+	IP string
+	// PodIPs are the IP addresses of the pods that use the egress IP
+	PodIPs []string
 }
 
 type linuxNetwork struct {
 	useExternalSNAT        bool
+	usePerENISNAT          bool
+	useEgressIP            bool
+	egressIPMarkMask       uint32
 	excludeSNATCIDRs       []string
 	typeOfSNAT             snatType
 	nodePortSupportEnabled bool
 	connmark               uint32
 	mtu                    int
+	layout                 RoutingLayout
 
 	netLink     netlinkwrapper.NetLink
+	links       *linkWatcher
 	ns          nswrapper.NS
 	newIptables func() (iptablesIface, error)
 	mainENIMark uint32
 	openFile    func(name string, flag int, perm os.FileMode) (stringWriteCloser, error)
+
+	// primaryIntf is the name of the primary ENI's interface, found by SetupHostNetwork
+	primaryIntf string
+	// egressIPMarks keeps the firewall mark of each egress IP by name
+	egressIPMarks map[string]uint32
+	// migratedTables keeps the route tables of a previous routing layout that MigratePodRules moved rules away from
+	migratedTables map[int]bool
 }
 
 type iptablesIface interface {
@@ -159,15 +234,21 @@ const (
 
 // New creates a linuxNetwork object
 func New() NetworkAPIs {
+	netLink := netlinkwrapper.NewNetLink()
 	return &linuxNetwork{
 		useExternalSNAT:        useExternalSNAT(),
+		usePerENISNAT:          usePerENISNAT(),
+		useEgressIP:            UseEgressIP(),
+		egressIPMarkMask:       getEgressIPMarkMask(),
 		excludeSNATCIDRs:       getExcludeSNATCIDRs(),
 		typeOfSNAT:             typeOfSNAT(),
 		nodePortSupportEnabled: nodePortSupportEnabled(),
 		mainENIMark:            getConnmark(),
 		mtu:                    GetEthernetMTU(""),
+		layout:                 getRoutingLayout(),
 
-		netLink: netlinkwrapper.NewNetLink(),
+		netLink: netLink,
+		links:   newLinkWatcher(netLink),
 		ns:      nswrapper.NewNS(),
 		newIptables: func() (iptablesIface, error) {
 			ipt, err := iptables.New()
@@ -214,7 +295,7 @@ func (n *linuxNetwork) SetupHostNetwork(vpcCIDR *net.IPNet, vpcCIDRs []*string,
 	hostRule := n.netLink.NewRule()
 	hostRule.Dst = vpcCIDR
 	hostRule.Table = mainRoutingTable
-	hostRule.Priority = hostRulePriority
+	hostRule.Priority = n.layout.HostRulePriority()
 	hostRule.Invert = true
 
 	// Cleanup previous rule first before CNI 1.3
@@ -256,6 +337,7 @@ func (n *linuxNetwork) SetupHostNetwork(vpcCIDR *net.IPNet, vpcCIDRs []*string,
 	if err = n.netLink.LinkSetMTU(link, n.mtu); err != nil {
 		return errors.Wrapf(err, "setupHostNetwork: failed to set MTU to %d for %s", n.mtu, primaryIntf)
 	}
+	n.primaryIntf = link.Attrs().Name
 
 	// If node port support is enabled, add a rule that will force force marked traffic out of the main ENI.  We then
 	// add iptables rules below that will mark traffic that needs this special treatment.  In particular NodePort
@@ -266,7 +348,7 @@ func (n *linuxNetwork) SetupHostNetwork(vpcCIDR *net.IPNet, vpcCIDRs []*string,
 	mainENIRule.Mark = int(n.mainENIMark)
 	mainENIRule.Mask = int(n.mainENIMark)
 	mainENIRule.Table = mainRoutingTable
-	mainENIRule.Priority = hostRulePriority
+	mainENIRule.Priority = n.layout.HostRulePriority()
 	// If this is a restart, cleanup previous rule first
 	err = n.netLink.RuleDel(mainENIRule)
 	if err != nil && !containsNoSuchRule(err) {
@@ -287,6 +369,102 @@ func (n *linuxNetwork) SetupHostNetwork(vpcCIDR *net.IPNet, vpcCIDRs []*string,
 		return errors.Wrap(err, "host network setup: failed to create iptables")
 	}
 
+	iptableRules, err := n.snatRules(ipt, vpcCIDRs, primaryAddr)
+	if err != nil {
+		return err
+	}
+	log.Debugf("iptableRules: %v", iptableRules)
+
+	iptableRules = append(iptableRules, iptablesRule{
+		name:        "connmark for primary ENI",
+		shouldExist: n.nodePortSupportEnabled,
+		table:       "mangle",
+		chain:       "PREROUTING",
+		rule: []string{
+			"-m", "comment", "--comment", "AWS, primary ENI",
+			"-i", primaryIntf,
+			"-m", "addrtype", "--dst-type", "LOCAL", "--limit-iface-in",
+			"-j", "CONNMARK", "--set-mark", fmt.Sprintf("%#x/%#x", n.mainENIMark, n.mainENIMark),
+		},
+	})
+
+	iptableRules = append(iptableRules, iptablesRule{
+		name:        "connmark restore for primary ENI",
+		shouldExist: n.nodePortSupportEnabled,
+		table:       "mangle",
+		chain:       "PREROUTING",
+		rule: []string{
+			"-m", "comment", "--comment", "AWS, primary ENI",
+			"-i", "eni+", "-j", "CONNMARK", "--restore-mark", "--mask", fmt.Sprintf("%#x", n.mainENIMark),
+		},
+	})
+
+	if n.useEgressIP {
+		log.Debugf("Setup Host Network: iptables -N %s -t mangle", egressIPMarkChain)
+		if err := ipt.NewChain("mangle", egressIPMarkChain); err != nil && !containChainExistErr(err) {
+			log.Errorf("ipt.NewChain error for chain [%s]: %v", egressIPMarkChain, err)
+			return errors.Wrapf(err, "host network setup: failed to add chain")
+		}
+		iptableRules = append(iptableRules, iptablesRule{
+			name:        "egress IP marks for pod traffic",
+			shouldExist: true,
+			table:       "mangle",
+			chain:       "PREROUTING",
+			rule:        egressIPMarkJumpRule(),
+		})
+	}
+
+	// remove pre-1.3 AWS SNAT rules
+	iptableRules = append(iptableRules, iptablesRule{
+		name:        fmt.Sprintf("rule for primary address %s", primaryAddr),
+		shouldExist: false,
+		table:       "nat",
+		chain:       "POSTROUTING",
+		rule: []string{
+			"!", "-d", vpcCIDR.String(),
+			"-m", "comment", "--comment", "AWS, SNAT",
+			"-m", "addrtype", "!", "--dst-type", "LOCAL",
+			"-j", "SNAT", "--to-source", primaryAddr.String()}})
+
+	if err = applyIptablesRules(ipt, iptableRules); err != nil {
+		return err
+	}
+
+	if !n.usePerENISNAT {
+		// Clean up the per-ENI SNAT rules in case the setting was turned off
+		if err := deleteChainIfExists(ipt, "nat", perENISNATChain); err != nil {
+			return errors.Wrapf(err, "host network setup: failed to remove chain %s", perENISNATChain)
+		}
+	}
+	if !n.useEgressIP {
+		// Clean up the egress IP rules in case the setting was turned off
+		if err := deleteChainIfExists(ipt, "nat", egressIPSNATChain); err != nil {
+			return errors.Wrapf(err, "host network setup: failed to remove chain %s", egressIPSNATChain)
+		}
+		if err := deleteChainIfExists(ipt, "mangle", egressIPMarkChain, egressIPMarkJumpRule()...); err != nil {
+			return errors.Wrapf(err, "host network setup: failed to remove chain %s", egressIPMarkChain)
+		}
+	}
+	return nil
+}
+
+// UpdateSNATRules rebuilds the SNAT chains for non-VPC outbound traffic after the VPC CIDRs changed
+func (n *linuxNetwork) UpdateSNATRules(vpcCIDRs []*string, primaryAddr *net.IP) error {
+	log.Info("Updating SNAT rules for the new VPC CIDRs")
+	ipt, err := n.newIptables()
+	if err != nil {
+		return errors.Wrap(err, "update SNAT rules: failed to create iptables")
+	}
+	iptableRules, err := n.snatRules(ipt, vpcCIDRs, primaryAddr)
+	if err != nil {
+		return err
+	}
+	return applyIptablesRules(ipt, iptableRules)
+}
+
+// snatRules creates the SNAT chains for non-VPC outbound traffic and returns the rules to add to them, followed by
+// the stale rules to remove from them
+func (n *linuxNetwork) snatRules(ipt iptablesIface, vpcCIDRs []*string, primaryAddr *net.IP) ([]iptablesRule, error) {
 	type snatCIDR struct {
 		cidr        string
 		isExclusion bool
@@ -302,7 +480,7 @@ func (n *linuxNetwork) SetupHostNetwork(vpcCIDR *net.IPNet, vpcCIDRs []*string,
 	// if excludeSNATCIDRs or vpcCIDRs have changed they need to be cleared
 	snatStaleRulesToCheck, err := listCurrentSNATRules(ipt)
 	if err != nil {
-		return errors.Wrapf(err, "host network setup: failed to get SNAT chain rules to clear")
+		return nil, errors.Wrapf(err, "host network setup: failed to get SNAT chain rules to clear")
 	}
 
 	// build IPTABLES chain for SNAT of non-VPC outbound traffic and excluded CIDRs
@@ -312,7 +490,7 @@ func (n *linuxNetwork) SetupHostNetwork(vpcCIDR *net.IPNet, vpcCIDRs []*string,
 		log.Debugf("Setup Host Network: iptables -N %s -t nat", chain)
 		if err := ipt.NewChain("nat", chain); err != nil && !containChainExistErr(err) {
 			log.Errorf("ipt.NewChain error for chain [%s]: %v", chain, err)
-			return errors.Wrapf(err, "host network setup: failed to add chain")
+			return nil, errors.Wrapf(err, "host network setup: failed to add chain")
 		}
 		chains = append(chains, chain)
 	}
@@ -350,23 +528,47 @@ func (n *linuxNetwork) SetupHostNetwork(vpcCIDR *net.IPNet, vpcCIDRs []*string,
 	}
 
 	// Prepare the Desired Rule for SNAT Rule
-	snatRule := []string{"-m", "comment", "--comment", "AWS, SNAT",
+	snatRule := append([]string{"-m", "comment", "--comment", "AWS, SNAT",
 		"-m", "addrtype", "!", "--dst-type", "LOCAL",
-		"-j", "SNAT", "--to-source", primaryAddr.String()}
-	if n.typeOfSNAT == randomHashSNAT {
-		snatRule = append(snatRule, "--random")
+		"-j", "SNAT", "--to-source", primaryAddr.String()}, n.snatRandomFlags(ipt)...)
+
+	lastChain := chains[len(chains)-1]
+	if n.useEgressIP {
+		// Hand over to the egress IP chain. SetupEgressIPs inserts the rules for marked traffic at the top of it, so
+		// the rules below have to stay at the end of that chain.
+		log.Debugf("Setup Host Network: iptables -N %s -t nat", egressIPSNATChain)
+		if err := ipt.NewChain("nat", egressIPSNATChain); err != nil && !containChainExistErr(err) {
+			log.Errorf("ipt.NewChain error for chain [%s]: %v", egressIPSNATChain, err)
+			return nil, errors.Wrapf(err, "host network setup: failed to add chain")
+		}
+		iptableRules = append(iptableRules, iptablesRule{
+			name:        "jump to egress IP SNAT rules for non-VPC outbound traffic",
+			shouldExist: !n.useExternalSNAT,
+			table:       "nat",
+			chain:       lastChain,
+			rule: []string{
+				"-m", "comment", "--comment", "AWS SNAT CHAIN EGRESS", "-j", egressIPSNATChain,
+			}})
+		lastChain = egressIPSNATChain
 	}
-	if n.typeOfSNAT == randomPRNGSNAT {
-		if ipt.HasRandomFully() {
-			snatRule = append(snatRule, "--random-fully")
-		} else {
-			log.Warn("prng (--random-fully) requested, but iptables version does not support it. " +
-				"Falling back to hashrandom (--random)")
-			snatRule = append(snatRule, "--random")
+	if n.usePerENISNAT {
+		// Hand over to the per-ENI chain. SetupENINetwork inserts the rules for secondary ENIs at the top of it, so
+		// the SNAT to the primary IP has to stay the last rule of that chain.
+		log.Debugf("Setup Host Network: iptables -N %s -t nat", perENISNATChain)
+		if err := ipt.NewChain("nat", perENISNATChain); err != nil && !containChainExistErr(err) {
+			log.Errorf("ipt.NewChain error for chain [%s]: %v", perENISNATChain, err)
+			return nil, errors.Wrapf(err, "host network setup: failed to add chain")
 		}
+		iptableRules = append(iptableRules, iptablesRule{
+			name:        "jump to per-ENI SNAT rules for non-VPC outbound traffic",
+			shouldExist: !n.useExternalSNAT,
+			table:       "nat",
+			chain:       lastChain,
+			rule: []string{
+				"-m", "comment", "--comment", "AWS SNAT CHAIN PER ENI", "-j", perENISNATChain,
+			}})
+		lastChain = perENISNATChain
 	}
-
-	lastChain := chains[len(chains)-1]
 	iptableRules = append(iptableRules, iptablesRule{
 		name:        "last SNAT rule for non-VPC outbound traffic",
 		shouldExist: !n.useExternalSNAT,
@@ -392,45 +594,11 @@ func (n *linuxNetwork) SetupHostNetwork(vpcCIDR *net.IPNet, vpcCIDRs []*string,
 		}
 	}
 
-	iptableRules = append(iptableRules, snatStaleRulesToClear...)
-	log.Debugf("iptableRules: %v", iptableRules)
-
-	iptableRules = append(iptableRules, iptablesRule{
-		name:        "connmark for primary ENI",
-		shouldExist: n.nodePortSupportEnabled,
-		table:       "mangle",
-		chain:       "PREROUTING",
-		rule: []string{
-			"-m", "comment", "--comment", "AWS, primary ENI",
-			"-i", primaryIntf,
-			"-m", "addrtype", "--dst-type", "LOCAL", "--limit-iface-in",
-			"-j", "CONNMARK", "--set-mark", fmt.Sprintf("%#x/%#x", n.mainENIMark, n.mainENIMark),
-		},
-	})
-
-	iptableRules = append(iptableRules, iptablesRule{
-		name:        "connmark restore for primary ENI",
-		shouldExist: n.nodePortSupportEnabled,
-		table:       "mangle",
-		chain:       "PREROUTING",
-		rule: []string{
-			"-m", "comment", "--comment", "AWS, primary ENI",
-			"-i", "eni+", "-j", "CONNMARK", "--restore-mark", "--mask", fmt.Sprintf("%#x", n.mainENIMark),
-		},
-	})
-
-	// remove pre-1.3 AWS SNAT rules
-	iptableRules = append(iptableRules, iptablesRule{
-		name:        fmt.Sprintf("rule for primary address %s", primaryAddr),
-		shouldExist: false,
-		table:       "nat",
-		chain:       "POSTROUTING",
-		rule: []string{
-			"!", "-d", vpcCIDR.String(),
-			"-m", "comment", "--comment", "AWS, SNAT",
-			"-m", "addrtype", "!", "--dst-type", "LOCAL",
-			"-j", "SNAT", "--to-source", primaryAddr.String()}})
+	return append(iptableRules, snatStaleRulesToClear...), nil
+}
 
+// applyIptablesRules adds the rules that should exist and are missing, and deletes the ones that shouldn't exist
+func applyIptablesRules(ipt iptablesIface, iptableRules []iptablesRule) error {
 	for _, rule := range iptableRules {
 		log.Debugf("execute iptable rule : %s", rule.name)
 
@@ -457,6 +625,58 @@ func (n *linuxNetwork) SetupHostNetwork(vpcCIDR *net.IPNet, vpcCIDRs []*string,
 	return nil
 }
 
+// egressIPMarkJumpRule returns the rule of the mangle PREROUTING chain that sends pod traffic to egressIPMarkChain
+func egressIPMarkJumpRule() []string {
+	return []string{"-m", "comment", "--comment", "AWS, egress IP", "-i", "eni+", "-j", egressIPMarkChain}
+}
+
+// snatRandomFlags returns the port randomisation flags to add to SNAT rules
+func (n *linuxNetwork) snatRandomFlags(ipt iptablesIface) []string {
+	switch n.typeOfSNAT {
+	case randomHashSNAT:
+		return []string{"--random"}
+	case randomPRNGSNAT:
+		if ipt.HasRandomFully() {
+			return []string{"--random-fully"}
+		}
+		log.Warn("prng (--random-fully) requested, but iptables version does not support it. " +
+			"Falling back to hashrandom (--random)")
+		return []string{"--random"}
+	}
+	return nil
+}
+
+// deleteChainIfExists removes an iptables chain. If the chain is referenced from PREROUTING, the jump rule must be
+// given so that it is removed first.
+func deleteChainIfExists(ipt iptablesIface, table, chain string, preroutingJump ...string) error {
+	existingChains, err := ipt.ListChains(table)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list iptables %s chains", table)
+	}
+	for _, existing := range existingChains {
+		if existing != chain {
+			continue
+		}
+		if len(preroutingJump) > 0 {
+			exists, err := ipt.Exists(table, "PREROUTING", preroutingJump...)
+			if err != nil {
+				return err
+			}
+			if exists {
+				if err := ipt.Delete(table, "PREROUTING", preroutingJump...); err != nil {
+					return err
+				}
+			}
+		}
+		log.Debugf("Removing iptables chain %s from table %s", chain, table)
+		if err := ipt.ClearChain(table, chain); err != nil {
+			return err
+		}
+		return ipt.DeleteChain(table, chain)
+	}
+	return nil
+}
+
 func listCurrentSNATRules(ipt iptablesIface) ([]iptablesRule, error) {
 	var toClear []iptablesRule
 	log.Debug("Setup Host Network: loading existing iptables nat SNAT exclusion rules")
@@ -466,7 +686,7 @@ func listCurrentSNATRules(ipt iptablesIface) ([]iptablesRule, error) {
 		return nil, errors.Wrap(err, "host network setup: failed to list iptables nat chains")
 	}
 	for _, chain := range existingChains {
-		if !strings.HasPrefix(chain, "AWS-SNAT-CHAIN") {
+		if !strings.HasPrefix(chain, "AWS-SNAT-CHAIN") && chain != perENISNATChain && chain != egressIPSNATChain {
 			continue
 		}
 		rules, err := ipt.List("nat", chain)
@@ -474,25 +694,45 @@ func listCurrentSNATRules(ipt iptablesIface) ([]iptablesRule, error) {
 			return nil, errors.Wrap(err, fmt.Sprintf("host network setup: failed to list iptables nat chain %s", chain))
 		}
 		for i, rule := range rules {
-			r := csv.NewReader(strings.NewReader(rule))
-			r.Comma = ' '
-			ruleSpec, err := r.Read()
+			ruleSpec, err := parseRuleSpec(rule)
 			if err != nil {
 				return nil, errors.Wrap(err, fmt.Sprintf("host network setup: failed to parse iptables nat chain %s rule %s", chain, rule))
 			}
+			if chain == perENISNATChain && len(ruleSpec) > 0 && ruleSpec[0] == "-o" {
+				// The rules for each ENI are managed by SetupENINetwork
+				continue
+			}
+			if chain == egressIPSNATChain && len(ruleSpec) > 0 && ruleSpec[0] == "-o" {
+				// The rules for each egress IP are managed by SetupEgressIPs
+				continue
+			}
 			log.Debugf("host network setup: found potentially stale SNAT rule for chain %s: %v", chain, ruleSpec)
 			toClear = append(toClear, iptablesRule{
 				name:        fmt.Sprintf("[%d] %s", i, chain),
 				shouldExist: false, // To trigger ipt.Delete for stale rules
 				table:       "nat",
 				chain:       chain,
-				rule:        ruleSpec[2:], //drop action and chain name
+				rule:        ruleSpec,
 			})
 		}
 	}
 	return toClear, nil
 }
 
+// parseRuleSpec splits a rule as returned by `iptables -S` into its rulespec, dropping the action and chain name
+func parseRuleSpec(rule string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(rule))
+	r.Comma = ' '
+	ruleSpec, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(ruleSpec) < 2 {
+		return nil, errors.Errorf("unexpected iptables rule %q", rule)
+	}
+	return ruleSpec[2:], nil
+}
+
 func containChainExistErr(err error) bool {
 	return strings.Contains(err.Error(), "Chain already exists")
 }
@@ -529,14 +769,26 @@ func containsNoSuchRule(err error) bool {
 	return false
 }
 
+func containsRuleExists(err error) bool {
+	if errno, ok := err.(syscall.Errno); ok {
+		return errno == syscall.EEXIST
+	}
+	return false
+}
+
 // GetConfigForDebug returns the active values of the configuration env vars (for debugging purposes).
 func GetConfigForDebug() map[string]interface{} {
 	return map[string]interface{}{
 		envExternalSNAT:     useExternalSNAT(),
+		envPerENISNAT:       usePerENISNAT(),
+		envEgressIP:         UseEgressIP(),
+		envEgressIPMarkMask: getEgressIPMarkMask(),
 		envExcludeSNATCIDRs: getExcludeSNATCIDRs(),
 		envNodePortSupport:  nodePortSupportEnabled(),
 		envConnmark:         getConnmark(),
 		envRandomizeSNAT:    typeOfSNAT(),
+		envRouteTableOffset: getRoutingLayout().TableOffset,
+		envRulePriorityBase: getRoutingLayout().PriorityBase,
 	}
 }
 
@@ -551,6 +803,33 @@ func useExternalSNAT() bool {
 	return getBoolEnvVar(envExternalSNAT, false)
 }
 
+// UsePerENISNAT returns whether pods on secondary ENIs are SNATed to the primary IP of their ENI and route all of
+// their traffic through it. It is always false when UseExternalSNAT is true.
+func (n *linuxNetwork) UsePerENISNAT() bool {
+	return usePerENISNAT()
+}
+
+func usePerENISNAT() bool {
+	if useExternalSNAT() {
+		return false
+	}
+	return getBoolEnvVar(envPerENISNAT, false)
+}
+
+// UseEgressIP returns whether the non-VPC traffic of the pods selected by an EgressIPConfig is SNATed to an egress IP
+// reserved for it. It is always false when UseExternalSNAT is true.
+func (n *linuxNetwork) UseEgressIP() bool {
+	return n.useEgressIP
+}
+
+// UseEgressIP returns whether egress IPs are enabled, see linuxNetwork.UseEgressIP
+func UseEgressIP() bool {
+	if useExternalSNAT() {
+		return false
+	}
+	return getBoolEnvVar(envEgressIP, false)
+}
+
 // GetExcludeSNATCIDRs returns a list of cidrs that should be excluded from SNAT if UseExternalSNAT is false,
 // otherwise it returns an empty list.
 func (n *linuxNetwork) GetExcludeSNATCIDRs() []string {
@@ -637,6 +916,24 @@ func getConnmark() uint32 {
 	return defaultConnmark
 }
 
+func getEgressIPMarkMask() uint32 {
+	if maskStr := os.Getenv(envEgressIPMarkMask); maskStr != "" {
+		mask, err := strconv.ParseUint(maskStr, 0, 32)
+		if err != nil {
+			log.Error("Failed to parse "+envEgressIPMarkMask+"; will use ", defaultEgressIPMarkMask, err.Error())
+			return defaultEgressIPMarkMask
+		}
+		values := mask >> uint(bits.TrailingZeros64(mask))
+		if mask == 0 || values&(values+1) != 0 || uint32(mask)&getConnmark() != 0 {
+			log.Errorf("%s %#x is empty, not contiguous or overlaps the connmark; will use %#x",
+				envEgressIPMarkMask, mask, defaultEgressIPMarkMask)
+			return defaultEgressIPMarkMask
+		}
+		return uint32(mask)
+	}
+	return defaultEgressIPMarkMask
+}
+
 // LinkByMac returns linux netlink based on interface MAC
 func LinkByMac(mac string, netLink netlinkwrapper.NetLink, retryInterval time.Duration) (netlink.Link, error) {
 	// The adapter might not be immediately available, so we perform retries
@@ -671,9 +968,254 @@ func LinkByMac(mac string, netLink netlinkwrapper.NetLink, retryInterval time.Du
 	}
 }
 
-// SetupENINetwork adds default route to route table (eni-<eni_table>)
-func (n *linuxNetwork) SetupENINetwork(eniIP string, eniMAC string, eniTable int, eniSubnetCIDR string) error {
-	return setupENINetwork(eniIP, eniMAC, eniTable, eniSubnetCIDR, n.netLink, retryLinkByMacInterval, retryRouteAddInterval, n.mtu)
+// SetupENINetwork adds default route to the ENI's route table
+func (n *linuxNetwork) SetupENINetwork(eniIP string, eniMAC string, deviceNumber int, eniSubnetCIDR string) error {
+	if deviceNumber == 0 {
+		log.Debugf("Skipping set up ENI network for primary interface")
+		return nil
+	}
+	err := setupENINetwork(eniIP, eniMAC, n.layout.ENIRouteTable(deviceNumber), eniSubnetCIDR, n.netLink,
+		retryLinkByMacInterval, retryRouteAddInterval, n.mtu)
+	if err != nil || !n.usePerENISNAT {
+		return err
+	}
+
+	link, err := LinkByMac(eniMAC, n.netLink, retryLinkByMacInterval)
+	if err != nil {
+		return errors.Wrapf(err, "setupENINetwork: failed to find the link which uses MAC address %s", eniMAC)
+	}
+	ipt, err := n.newIptables()
+	if err != nil {
+		return errors.Wrap(err, "setupENINetwork: failed to create iptables")
+	}
+	return n.setupENISNAT(ipt, link.Attrs().Name, eniIP)
+}
+
+// setupENISNAT makes non-VPC traffic leaving through the ENI's interface use the ENI's primary IP as its source
+func (n *linuxNetwork) setupENISNAT(ipt iptablesIface, linkName string, eniIP string) error {
+	snatRule := append([]string{"-o", linkName,
+		"-m", "comment", "--comment", "AWS, SNAT ENI",
+		"-m", "addrtype", "!", "--dst-type", "LOCAL",
+		"-j", "SNAT", "--to-source", eniIP}, n.snatRandomFlags(ipt)...)
+
+	// An interface name can be reused by a new ENI after the previous one was detached, so remove any rule for
+	// this interface that SNATs to a different address.
+	rules, err := ipt.List("nat", perENISNATChain)
+	if err != nil {
+		return errors.Wrapf(err, "setupENINetwork: failed to list iptables nat chain %s", perENISNATChain)
+	}
+	exists := false
+	for _, rule := range rules {
+		ruleSpec, err := parseRuleSpec(rule)
+		if err != nil {
+			return errors.Wrapf(err, "setupENINetwork: failed to parse iptables nat chain %s rule %s", perENISNATChain, rule)
+		}
+		if len(ruleSpec) < 2 || ruleSpec[0] != "-o" || ruleSpec[1] != linkName {
+			continue
+		}
+		if reflect.DeepEqual(ruleSpec, snatRule) {
+			exists = true
+			continue
+		}
+		log.Debugf("Removing stale SNAT rule for %s: %v", linkName, ruleSpec)
+		if err = ipt.Delete("nat", perENISNATChain, ruleSpec...); err != nil {
+			return errors.Wrapf(err, "setupENINetwork: failed to delete stale SNAT rule for %s", linkName)
+		}
+	}
+	if exists {
+		return nil
+	}
+
+	log.Infof("Adding SNAT rule for traffic leaving %s to use source IP %s", linkName, eniIP)
+	if err = ipt.Insert("nat", perENISNATChain, 1, snatRule...); err != nil {
+		return errors.Wrapf(err, "setupENINetwork: failed to add SNAT rule for %s", linkName)
+	}
+	return nil
+}
+
+// TeardownENINetwork removes the per-ENI SNAT rules to the primary IP of an ENI that is gone. Its interface name can be
+// reused by the next ENI, which must not SNAT to an address that isn't on the node anymore.
+func (n *linuxNetwork) TeardownENINetwork(eniIP string) error {
+	if !n.usePerENISNAT {
+		return nil
+	}
+	ipt, err := n.newIptables()
+	if err != nil {
+		return errors.Wrap(err, "teardownENINetwork: failed to create iptables")
+	}
+	return teardownENISNAT(ipt, eniIP)
+}
+
+// teardownENISNAT deletes the rules of the per-ENI SNAT chain that SNAT to the ENI's primary IP
+func teardownENISNAT(ipt iptablesIface, eniIP string) error {
+	rules, err := ipt.List("nat", perENISNATChain)
+	if err != nil {
+		return errors.Wrapf(err, "teardownENINetwork: failed to list iptables nat chain %s", perENISNATChain)
+	}
+	for _, rule := range rules {
+		ruleSpec, err := parseRuleSpec(rule)
+		if err != nil {
+			return errors.Wrapf(err, "teardownENINetwork: failed to parse iptables nat chain %s rule %s", perENISNATChain, rule)
+		}
+		if len(ruleSpec) < 2 || ruleSpec[0] != "-o" || !snatsTo(ruleSpec, eniIP) {
+			continue
+		}
+		log.Infof("Removing SNAT rule for traffic leaving %s to use source IP %s", ruleSpec[1], eniIP)
+		if err = ipt.Delete("nat", perENISNATChain, ruleSpec...); err != nil {
+			return errors.Wrapf(err, "teardownENINetwork: failed to delete SNAT rule for %s", ruleSpec[1])
+		}
+	}
+	return nil
+}
+
+// snatsTo returns whether the rulespec SNATs to the address
+func snatsTo(ruleSpec []string, addr string) bool {
+	for i := 0; i+1 < len(ruleSpec); i++ {
+		if ruleSpec[i] == "--to-source" && ruleSpec[i+1] == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupEgressIPs marks the traffic of each egress IP's pods, and SNATs marked non-VPC traffic leaving through the
+// primary ENI to the egress IP. The rules of egress IPs that are not given anymore are removed.
+func (n *linuxNetwork) SetupEgressIPs(egressIPs []EgressIP) error {
+	if !n.useEgressIP {
+		return nil
+	}
+	ipt, err := n.newIptables()
+	if err != nil {
+		return errors.Wrap(err, "setupEgressIPs: failed to create iptables")
+	}
+	return n.setupEgressIPs(ipt, egressIPs)
+}
+
+func (n *linuxNetwork) setupEgressIPs(ipt iptablesIface, egressIPs []EgressIP) error {
+	marks := n.egressIPMarksFor(egressIPs)
+
+	var snatRules, markRules [][]string
+	for _, egressIP := range egressIPs {
+		mark, ok := marks[egressIP.Name]
+		if !ok {
+			continue
+		}
+		markValue := fmt.Sprintf("%#x/%#x", mark, n.egressIPMarkMask)
+		comment := "AWS, egress IP " + egressIP.Name
+		snatRules = append(snatRules, append([]string{"-o", n.primaryIntf,
+			"-m", "mark", "--mark", markValue,
+			"-m", "comment", "--comment", comment,
+			"-j", "SNAT", "--to-source", egressIP.IP}, n.snatRandomFlags(ipt)...))
+		for _, podIP := range egressIP.PodIPs {
+			markRules = append(markRules, []string{"-s", podIP + "/32",
+				"-m", "comment", "--comment", comment,
+				"-j", "MARK", "--set-xmark", markValue})
+		}
+	}
+
+	// The rules without an interface at the end of the SNAT chain are managed by SetupHostNetwork
+	isEgressIPRule := func(ruleSpec []string) bool { return len(ruleSpec) > 0 && ruleSpec[0] == "-o" }
+	if err := syncChainRules(ipt, "nat", egressIPSNATChain, snatRules, isEgressIPRule, true); err != nil {
+		return errors.Wrap(err, "setupEgressIPs: failed to update SNAT rules")
+	}
+	if err := syncChainRules(ipt, "mangle", egressIPMarkChain, markRules, nil, false); err != nil {
+		return errors.Wrap(err, "setupEgressIPs: failed to update mark rules")
+	}
+	return nil
+}
+
+// egressIPMarksFor returns the firewall mark of each egress IP. An egress IP keeps its mark for as long as it is
+// given, so that changing the set of egress IPs does not move the traffic of other pods between them.
+func (n *linuxNetwork) egressIPMarksFor(egressIPs []EgressIP) map[string]uint32 {
+	if n.egressIPMarks == nil {
+		n.egressIPMarks = make(map[string]uint32)
+	}
+	names := make(map[string]bool, len(egressIPs))
+	for _, egressIP := range egressIPs {
+		names[egressIP.Name] = true
+	}
+	used := make(map[uint32]bool)
+	for name, mark := range n.egressIPMarks {
+		if !names[name] {
+			delete(n.egressIPMarks, name)
+			continue
+		}
+		used[mark] = true
+	}
+
+	shift := uint(bits.TrailingZeros32(n.egressIPMarkMask))
+	maxValue := n.egressIPMarkMask >> shift
+	value := uint32(1)
+	for _, egressIP := range egressIPs {
+		if _, ok := n.egressIPMarks[egressIP.Name]; ok {
+			continue
+		}
+		for value <= maxValue && used[value<<shift] {
+			value++
+		}
+		if value > maxValue {
+			log.Errorf("No firewall mark left for egress IP %s, %s %#x allows %d egress IPs",
+				egressIP.Name, envEgressIPMarkMask, n.egressIPMarkMask, maxValue)
+			continue
+		}
+		n.egressIPMarks[egressIP.Name] = value << shift
+		used[value<<shift] = true
+	}
+	return n.egressIPMarks
+}
+
+// syncChainRules makes the rules of a chain for which isManaged returns true (all rules if it is nil) match the
+// desired ones. Missing rules are inserted at the top of the chain if insert is true and appended otherwise.
+func syncChainRules(ipt iptablesIface, table, chain string, desired [][]string, isManaged func([]string) bool, insert bool) error {
+	rules, err := ipt.List(table, chain)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list iptables %s chain %s", table, chain)
+	}
+	found := make([]bool, len(desired))
+	for _, rule := range rules {
+		ruleSpec, err := parseRuleSpec(rule)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse iptables %s chain %s rule %s", table, chain, rule)
+		}
+		if len(ruleSpec) == 0 || (isManaged != nil && !isManaged(ruleSpec)) {
+			continue
+		}
+		keep := false
+		for i, desiredRule := range desired {
+			if !found[i] && reflect.DeepEqual(desiredRule, ruleSpec) {
+				found[i] = true
+				keep = true
+				break
+			}
+		}
+		if keep {
+			continue
+		}
+		log.Debugf("Removing stale rule from iptables %s chain %s: %v", table, chain, ruleSpec)
+		if err := ipt.Delete(table, chain, ruleSpec...); err != nil {
+			return errors.Wrapf(err, "failed to delete iptables %s chain %s rule %v", table, chain, ruleSpec)
+		}
+	}
+	for j := range desired {
+		i := j
+		if insert {
+			// Insert in reverse so that the rules end up in the desired order
+			i = len(desired) - 1 - j
+		}
+		if found[i] {
+			continue
+		}
+		log.Debugf("Adding rule to iptables %s chain %s: %v", table, chain, desired[i])
+		if insert {
+			err = ipt.Insert(table, chain, 1, desired[i]...)
+		} else {
+			err = ipt.Append(table, chain, desired[i]...)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to add iptables %s chain %s rule %v", table, chain, desired[i])
+		}
+	}
+	return nil
 }
 
 func setupENINetwork(eniIP string, eniMAC string, eniTable int, eniSubnetCIDR string, netLink netlinkwrapper.NetLink,
@@ -898,7 +1440,7 @@ func (n *linuxNetwork) UpdateRuleListBySrc(ruleList []netlink.Rule, src net.IPNe
 			_, podRule.Dst, _ = net.ParseCIDR(cidr)
 			podRule.Src = &src
 			podRule.Table = srcRuleTable
-			podRule.Priority = fromPodRulePriority
+			podRule.Priority = n.layout.FromPodRulePriority()
 
 			err = n.netLink.RuleAdd(podRule)
 			if err != nil {
@@ -917,7 +1459,7 @@ func (n *linuxNetwork) UpdateRuleListBySrc(ruleList []netlink.Rule, src net.IPNe
 
 		podRule.Src = &src
 		podRule.Table = srcRuleTable
-		podRule.Priority = fromPodRulePriority
+		podRule.Priority = n.layout.FromPodRulePriority()
 
 		err = n.netLink.RuleAdd(podRule)
 		if err != nil {
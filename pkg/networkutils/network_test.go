@@ -460,6 +460,50 @@ func TestSetupHostNetworkCleansUpStaleSNATRules(t *testing.T) {
 		}, mockIptables.dataplaneState)
 }
 
+func TestUpdateSNATRules(t *testing.T) {
+	ctrl, _, _, _, mockIptables := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{
+		excludeSNATCIDRs: []string{"10.12.0.0/16"},
+		newIptables: func() (iptablesIface, error) {
+			return mockIptables, nil
+		},
+	}
+
+	_ = mockIptables.Append("nat", "AWS-SNAT-CHAIN-0", "!", "-d", "10.10.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-1")
+	_ = mockIptables.Append("nat", "AWS-SNAT-CHAIN-1", "!", "-d", "10.12.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN EXCLUSION", "-j", "AWS-SNAT-CHAIN-2")
+	_ = mockIptables.Append("nat", "AWS-SNAT-CHAIN-2", "-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.20")
+	_ = mockIptables.Append("nat", "POSTROUTING", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-0")
+
+	// A CIDR was associated with the VPC
+	vpcCIDRs := []*string{aws.String("10.10.0.0/16"), aws.String("10.11.0.0/16")}
+	err := ln.UpdateSNATRules(vpcCIDRs, &testENINetIP)
+	assert.NoError(t, err)
+	assert.Equal(t,
+		map[string]map[string][][]string{
+			"nat": {
+				"AWS-SNAT-CHAIN-0": [][]string{{"!", "-d", "10.10.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-1"}},
+				"AWS-SNAT-CHAIN-1": [][]string{{"!", "-d", "10.11.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-2"}},
+				"AWS-SNAT-CHAIN-2": [][]string{{"!", "-d", "10.12.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN EXCLUSION", "-j", "AWS-SNAT-CHAIN-3"}},
+				"AWS-SNAT-CHAIN-3": [][]string{{"-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.20"}},
+				"POSTROUTING":      [][]string{{"-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-0"}}},
+		}, mockIptables.dataplaneState)
+
+	// And removed again
+	err = ln.UpdateSNATRules(vpcCIDRs[:1], &testENINetIP)
+	assert.NoError(t, err)
+	assert.Equal(t,
+		map[string]map[string][][]string{
+			"nat": {
+				"AWS-SNAT-CHAIN-0": [][]string{{"!", "-d", "10.10.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-1"}},
+				"AWS-SNAT-CHAIN-1": [][]string{{"!", "-d", "10.12.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN EXCLUSION", "-j", "AWS-SNAT-CHAIN-2"}},
+				"AWS-SNAT-CHAIN-2": [][]string{{"-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.20"}},
+				"AWS-SNAT-CHAIN-3": [][]string{},
+				"POSTROUTING":      [][]string{{"-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-0"}}},
+		}, mockIptables.dataplaneState)
+}
+
 func TestSetupHostNetworkExcludedSNATCIDRsIdempotent(t *testing.T) {
 	ctrl, mockNetLink, _, mockNS, mockIptables := setup(t)
 	defer ctrl.Finish()
@@ -522,6 +566,260 @@ func TestSetupHostNetworkExcludedSNATCIDRsIdempotent(t *testing.T) {
 		}, mockIptables.dataplaneState)
 }
 
+func TestSetupHostNetworkPerENISNAT(t *testing.T) {
+	ctrl, mockNetLink, _, mockNS, mockIptables := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{
+		useExternalSNAT: false,
+		usePerENISNAT:   true,
+		mainENIMark:     defaultConnmark,
+		mtu:             testMTU,
+
+		netLink: mockNetLink,
+		ns:      mockNS,
+		newIptables: func() (iptablesIface, error) {
+			return mockIptables, nil
+		},
+	}
+
+	mockPrimaryInterfaceLookup(ctrl, mockNetLink)
+	mockNetLink.EXPECT().LinkSetMTU(gomock.Any(), testMTU).Return(nil)
+	var hostRule netlink.Rule
+	mockNetLink.EXPECT().NewRule().Return(&hostRule)
+	mockNetLink.EXPECT().RuleDel(&hostRule)
+	var mainENIRule netlink.Rule
+	mockNetLink.EXPECT().NewRule().Return(&mainENIRule)
+	mockNetLink.EXPECT().RuleDel(&mainENIRule)
+
+	// Rule left behind by a previous ENI that used eth2
+	_ = mockIptables.Append("nat", perENISNATChain, "-o", "eth2", "-m", "comment", "--comment", "AWS, SNAT ENI", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.99")
+
+	vpcCIDRs := []*string{aws.String("10.10.0.0/16")}
+	err := ln.SetupHostNetwork(testENINetIPNet, vpcCIDRs, loopback, &testENINetIP)
+	assert.NoError(t, err)
+
+	err = ln.setupENISNAT(mockIptables, "eth1", "10.10.10.21")
+	assert.NoError(t, err)
+	err = ln.setupENISNAT(mockIptables, "eth2", "10.10.10.22")
+	assert.NoError(t, err)
+	// Setting up the same ENI twice is a no-op
+	err = ln.setupENISNAT(mockIptables, "eth1", "10.10.10.21")
+	assert.NoError(t, err)
+
+	assert.Equal(t,
+		map[string]map[string][][]string{
+			"nat": {
+				"AWS-SNAT-CHAIN-0": [][]string{{"!", "-d", "10.10.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-1"}},
+				"AWS-SNAT-CHAIN-1": [][]string{{"-m", "comment", "--comment", "AWS SNAT CHAIN PER ENI", "-j", "AWS-SNAT-ENI"}},
+				"AWS-SNAT-ENI": [][]string{
+					{"-o", "eth2", "-m", "comment", "--comment", "AWS, SNAT ENI", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.22"},
+					{"-o", "eth1", "-m", "comment", "--comment", "AWS, SNAT ENI", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.21"},
+					{"-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.20"},
+				},
+				"POSTROUTING": [][]string{{"-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-0"}}},
+		}, mockIptables.dataplaneState)
+
+	// Freeing the ENI of eth2 removes its rule, and leaves the others
+	err = teardownENISNAT(mockIptables, "10.10.10.22")
+	assert.NoError(t, err)
+	err = teardownENISNAT(mockIptables, "10.10.10.20")
+	assert.NoError(t, err)
+	assert.Equal(t,
+		[][]string{
+			{"-o", "eth1", "-m", "comment", "--comment", "AWS, SNAT ENI", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.21"},
+			{"-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.20"},
+		}, mockIptables.dataplaneState["nat"][perENISNATChain])
+}
+
+func TestSetupHostNetworkPerENISNATDisabled(t *testing.T) {
+	ctrl, mockNetLink, _, mockNS, mockIptables := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{
+		useExternalSNAT: false,
+		usePerENISNAT:   false,
+		mainENIMark:     defaultConnmark,
+		mtu:             testMTU,
+
+		netLink: mockNetLink,
+		ns:      mockNS,
+		newIptables: func() (iptablesIface, error) {
+			return mockIptables, nil
+		},
+	}
+
+	mockPrimaryInterfaceLookup(ctrl, mockNetLink)
+	mockNetLink.EXPECT().LinkSetMTU(gomock.Any(), testMTU).Return(nil)
+	var hostRule netlink.Rule
+	mockNetLink.EXPECT().NewRule().Return(&hostRule)
+	mockNetLink.EXPECT().RuleDel(&hostRule)
+	var mainENIRule netlink.Rule
+	mockNetLink.EXPECT().NewRule().Return(&mainENIRule)
+	mockNetLink.EXPECT().RuleDel(&mainENIRule)
+
+	// Rules from a previous run with per-ENI SNAT enabled
+	_ = mockIptables.Append("nat", "AWS-SNAT-CHAIN-0", "!", "-d", "10.10.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-1")
+	_ = mockIptables.Append("nat", "AWS-SNAT-CHAIN-1", "-m", "comment", "--comment", "AWS SNAT CHAIN PER ENI", "-j", "AWS-SNAT-ENI")
+	_ = mockIptables.Append("nat", perENISNATChain, "-o", "eth1", "-m", "comment", "--comment", "AWS, SNAT ENI", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.21")
+	_ = mockIptables.Append("nat", perENISNATChain, "-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.20")
+	_ = mockIptables.Append("nat", "POSTROUTING", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-0")
+
+	vpcCIDRs := []*string{aws.String("10.10.0.0/16")}
+	err := ln.SetupHostNetwork(testENINetIPNet, vpcCIDRs, loopback, &testENINetIP)
+	assert.NoError(t, err)
+
+	assert.Equal(t,
+		map[string]map[string][][]string{
+			"nat": {
+				"AWS-SNAT-CHAIN-0": [][]string{{"!", "-d", "10.10.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-1"}},
+				"AWS-SNAT-CHAIN-1": [][]string{{"-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.20"}},
+				"POSTROUTING":      [][]string{{"-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-0"}}},
+		}, mockIptables.dataplaneState)
+}
+
+func TestSetupHostNetworkEgressIP(t *testing.T) {
+	ctrl, mockNetLink, _, mockNS, mockIptables := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{
+		useExternalSNAT:  false,
+		useEgressIP:      true,
+		egressIPMarkMask: defaultEgressIPMarkMask,
+		mainENIMark:      defaultConnmark,
+		mtu:              testMTU,
+
+		netLink: mockNetLink,
+		ns:      mockNS,
+		newIptables: func() (iptablesIface, error) {
+			return mockIptables, nil
+		},
+	}
+
+	eth0 := mock_netlink.NewMockLink(ctrl)
+	mockNetLink.EXPECT().LinkList().Return([]netlink.Link{eth0}, nil)
+	eth0.EXPECT().Attrs().AnyTimes().Return(&netlink.LinkAttrs{Name: "eth0", HardwareAddr: net.HardwareAddr{}})
+	mockNetLink.EXPECT().LinkSetMTU(gomock.Any(), testMTU).Return(nil)
+	var hostRule netlink.Rule
+	mockNetLink.EXPECT().NewRule().Return(&hostRule)
+	mockNetLink.EXPECT().RuleDel(&hostRule)
+	var mainENIRule netlink.Rule
+	mockNetLink.EXPECT().NewRule().Return(&mainENIRule)
+	mockNetLink.EXPECT().RuleDel(&mainENIRule)
+
+	// SNAT rule left behind by a run without egress IPs
+	_ = mockIptables.Append("nat", "AWS-SNAT-CHAIN-1", "-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.20")
+
+	vpcCIDRs := []*string{aws.String("10.10.0.0/16")}
+	err := ln.SetupHostNetwork(testENINetIPNet, vpcCIDRs, loopback, &testENINetIP)
+	assert.NoError(t, err)
+
+	err = ln.setupEgressIPs(mockIptables, []EgressIP{
+		{Name: "blue", IP: "10.10.10.31", PodIPs: []string{"10.10.10.41", "10.10.10.42"}},
+		{Name: "green", IP: "10.10.10.32", PodIPs: []string{"10.10.10.43"}},
+	})
+	assert.NoError(t, err)
+	// Removing an egress IP keeps the marks of the others
+	err = ln.setupEgressIPs(mockIptables, []EgressIP{
+		{Name: "green", IP: "10.10.10.32", PodIPs: []string{"10.10.10.43", "10.10.10.44"}},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t,
+		map[string]map[string][][]string{
+			"nat": {
+				"AWS-SNAT-CHAIN-0": [][]string{{"!", "-d", "10.10.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-1"}},
+				"AWS-SNAT-CHAIN-1": [][]string{{"-m", "comment", "--comment", "AWS SNAT CHAIN EGRESS", "-j", "AWS-SNAT-EGRESS"}},
+				"AWS-SNAT-EGRESS": [][]string{
+					{"-o", "eth0", "-m", "mark", "--mark", "0x200/0x3f00", "-m", "comment", "--comment", "AWS, egress IP green", "-j", "SNAT", "--to-source", "10.10.10.32"},
+					{"-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.20"},
+				},
+				"POSTROUTING": [][]string{{"-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-0"}}},
+			"mangle": {
+				"AWS-EGRESS-MARK": [][]string{
+					{"-s", "10.10.10.43/32", "-m", "comment", "--comment", "AWS, egress IP green", "-j", "MARK", "--set-xmark", "0x200/0x3f00"},
+					{"-s", "10.10.10.44/32", "-m", "comment", "--comment", "AWS, egress IP green", "-j", "MARK", "--set-xmark", "0x200/0x3f00"},
+				},
+				"PREROUTING": [][]string{{"-m", "comment", "--comment", "AWS, egress IP", "-i", "eni+", "-j", "AWS-EGRESS-MARK"}}},
+		}, mockIptables.dataplaneState)
+}
+
+func TestSetupHostNetworkEgressIPDisabled(t *testing.T) {
+	ctrl, mockNetLink, _, mockNS, mockIptables := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{
+		useExternalSNAT: false,
+		useEgressIP:     false,
+		mainENIMark:     defaultConnmark,
+		mtu:             testMTU,
+
+		netLink: mockNetLink,
+		ns:      mockNS,
+		newIptables: func() (iptablesIface, error) {
+			return mockIptables, nil
+		},
+	}
+
+	mockPrimaryInterfaceLookup(ctrl, mockNetLink)
+	mockNetLink.EXPECT().LinkSetMTU(gomock.Any(), testMTU).Return(nil)
+	var hostRule netlink.Rule
+	mockNetLink.EXPECT().NewRule().Return(&hostRule)
+	mockNetLink.EXPECT().RuleDel(&hostRule)
+	var mainENIRule netlink.Rule
+	mockNetLink.EXPECT().NewRule().Return(&mainENIRule)
+	mockNetLink.EXPECT().RuleDel(&mainENIRule)
+
+	// Rules from a previous run with egress IPs enabled
+	_ = mockIptables.Append("nat", "AWS-SNAT-CHAIN-0", "!", "-d", "10.10.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-1")
+	_ = mockIptables.Append("nat", "AWS-SNAT-CHAIN-1", "-m", "comment", "--comment", "AWS SNAT CHAIN EGRESS", "-j", "AWS-SNAT-EGRESS")
+	_ = mockIptables.Append("nat", egressIPSNATChain, "-o", "eth0", "-m", "mark", "--mark", "0x100/0x3f00", "-m", "comment", "--comment", "AWS, egress IP blue", "-j", "SNAT", "--to-source", "10.10.10.31")
+	_ = mockIptables.Append("nat", egressIPSNATChain, "-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.20")
+	_ = mockIptables.Append("nat", "POSTROUTING", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-0")
+	_ = mockIptables.Append("mangle", egressIPMarkChain, "-s", "10.10.10.41/32", "-m", "comment", "--comment", "AWS, egress IP blue", "-j", "MARK", "--set-xmark", "0x100/0x3f00")
+	_ = mockIptables.Append("mangle", "PREROUTING", egressIPMarkJumpRule()...)
+
+	vpcCIDRs := []*string{aws.String("10.10.0.0/16")}
+	err := ln.SetupHostNetwork(testENINetIPNet, vpcCIDRs, loopback, &testENINetIP)
+	assert.NoError(t, err)
+
+	assert.Equal(t,
+		map[string]map[string][][]string{
+			"nat": {
+				"AWS-SNAT-CHAIN-0": [][]string{{"!", "-d", "10.10.0.0/16", "-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-1"}},
+				"AWS-SNAT-CHAIN-1": [][]string{{"-m", "comment", "--comment", "AWS, SNAT", "-m", "addrtype", "!", "--dst-type", "LOCAL", "-j", "SNAT", "--to-source", "10.10.10.20"}},
+				"POSTROUTING":      [][]string{{"-m", "comment", "--comment", "AWS SNAT CHAIN", "-j", "AWS-SNAT-CHAIN-0"}}},
+			"mangle": {
+				"PREROUTING": [][]string{}},
+		}, mockIptables.dataplaneState)
+}
+
+func TestEgressIPMarks(t *testing.T) {
+	ln := &linuxNetwork{egressIPMarkMask: 0x300}
+
+	marks := ln.egressIPMarksFor([]EgressIP{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}})
+	assert.Equal(t, map[string]uint32{"a": 0x100, "b": 0x200, "c": 0x300}, marks)
+
+	// The mark of a removed egress IP is reused
+	marks = ln.egressIPMarksFor([]EgressIP{{Name: "b"}, {Name: "c"}, {Name: "d"}})
+	assert.Equal(t, map[string]uint32{"b": 0x200, "c": 0x300, "d": 0x100}, marks)
+}
+
+func TestLoadEgressIPMarkMaskFromEnv(t *testing.T) {
+	defer os.Unsetenv(envEgressIPMarkMask)
+
+	_ = os.Setenv(envEgressIPMarkMask, "0xf000")
+	assert.Equal(t, uint32(0xf000), getEgressIPMarkMask())
+
+	// Not contiguous
+	_ = os.Setenv(envEgressIPMarkMask, "0x5000")
+	assert.Equal(t, uint32(defaultEgressIPMarkMask), getEgressIPMarkMask())
+
+	// Overlaps the connmark
+	_ = os.Setenv(envEgressIPMarkMask, "0x180")
+	assert.Equal(t, uint32(defaultEgressIPMarkMask), getEgressIPMarkMask())
+}
+
 func TestSetupHostNetworkMultipleCIDRs(t *testing.T) {
 	ctrl, mockNetLink, _, mockNS, mockIptables := setup(t)
 	defer ctrl.Finish()
@@ -605,6 +903,11 @@ func (ipt *mockIptables) Exists(table, chainName string, rulespec ...string) (bo
 }
 
 func (ipt *mockIptables) Insert(table, chain string, pos int, rulespec ...string) error {
+	if ipt.dataplaneState[table] == nil {
+		ipt.dataplaneState[table] = map[string][][]string{}
+	}
+	rules := ipt.dataplaneState[table][chain]
+	ipt.dataplaneState[table][chain] = append(rules[:pos-1], append([][]string{rulespec}, rules[pos-1:]...)...)
 	return nil
 }
 
@@ -656,10 +959,14 @@ func (ipt *mockIptables) NewChain(table, chain string) error {
 }
 
 func (ipt *mockIptables) ClearChain(table, chain string) error {
+	if _, ok := ipt.dataplaneState[table][chain]; ok {
+		ipt.dataplaneState[table][chain] = nil
+	}
 	return nil
 }
 
 func (ipt *mockIptables) DeleteChain(table, chain string) error {
+	delete(ipt.dataplaneState[table], chain)
 	return nil
 }
 
@@ -0,0 +1,327 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package networkutils
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/netlinkwrapper"
+)
+
+const (
+	// envRouteTableOffset is the name of the environment variable that sets the number added to an ENI's device
+	// number to get its route table. Defaults to 0, so ENI 2 uses table 2.
+	envRouteTableOffset = "AWS_VPC_K8S_CNI_ROUTE_TABLE_OFFSET"
+
+	// envRulePriorityBase is the name of the environment variable that sets the priority of the to-pod IP rules. The
+	// host rules use the next 512 priorities and the from-pod rules the 512 after them. Defaults to 512.
+	envRulePriorityBase = "AWS_VPC_K8S_CNI_RULE_PRIORITY_BASE"
+
+	// rulePriorityStep is the gap between the to-pod, host and from-pod rule priorities
+	rulePriorityStep = 512
+
+	// maxRulePriority is the last priority that is looked up before the main table's rule
+	maxRulePriority = 32765
+
+	// maxRouteTableDevices is the number of device numbers a route table is reserved for. No instance type
+	// comes close to it, and it keeps the default layout below the kernel's reserved tables.
+	maxRouteTableDevices = 128
+)
+
+// RoutingLayout is the numbering of the ENI route tables and the priorities of the IP rules for pod traffic. ipamd
+// and the CNI plugin must agree on it, so ipamd sends it to the plugin with every pod.
+type RoutingLayout struct {
+	// TableOffset is added to a secondary ENI's device number to get its route table
+	TableOffset int
+	// PriorityBase is the priority of the to-pod rules
+	PriorityBase int
+}
+
+// DefaultRoutingLayout is the layout used before it was configurable: the device number is the route table and the
+// rule priorities are 512, 1024 and 1536
+var DefaultRoutingLayout = RoutingLayout{TableOffset: 0, PriorityBase: rulePriorityStep}
+
+// ENIRouteTable returns the route table of a secondary ENI. Pods on the primary ENI use the main table.
+func (l RoutingLayout) ENIRouteTable(deviceNumber int) int {
+	return l.TableOffset + deviceNumber
+}
+
+// ToPodRulePriority is the priority of the "to <pod IP> lookup main" rules
+func (l RoutingLayout) ToPodRulePriority() int {
+	return l.PriorityBase
+}
+
+// HostRulePriority is the priority of the "not to <VPC CIDR> lookup main" and the connmark rules
+func (l RoutingLayout) HostRulePriority() int {
+	return l.PriorityBase + rulePriorityStep
+}
+
+// FromPodRulePriority is the priority of the "from <pod IP> lookup <ENI table>" rules
+func (l RoutingLayout) FromPodRulePriority() int {
+	return l.PriorityBase + 2*rulePriorityStep
+}
+
+// Validate returns an error if a route table of the layout is reserved by the kernel, or if a rule priority is
+// looked up after the main table's rule
+func (l RoutingLayout) Validate() error {
+	first, last := l.ENIRouteTable(1), l.ENIRouteTable(maxRouteTableDevices)
+	if l.TableOffset < 0 || last > math.MaxInt32 {
+		return errors.Errorf("route table offset %d is out of range", l.TableOffset)
+	}
+	for _, reserved := range []int{unix.RT_TABLE_COMPAT, unix.RT_TABLE_DEFAULT, unix.RT_TABLE_MAIN, unix.RT_TABLE_LOCAL} {
+		if reserved >= first && reserved <= last {
+			return errors.Errorf("route tables %d-%d include reserved table %d", first, last, reserved)
+		}
+	}
+	if l.PriorityBase <= 0 || l.FromPodRulePriority() > maxRulePriority {
+		return errors.Errorf("rule priority base %d is out of range", l.PriorityBase)
+	}
+	return nil
+}
+
+func (l RoutingLayout) String() string {
+	return fmt.Sprintf("tables %d-%d, priorities %d/%d/%d", l.ENIRouteTable(1), l.ENIRouteTable(maxRouteTableDevices),
+		l.ToPodRulePriority(), l.HostRulePriority(), l.FromPodRulePriority())
+}
+
+// getRoutingLayout reads the routing layout from AWS_VPC_K8S_CNI_ROUTE_TABLE_OFFSET and
+// AWS_VPC_K8S_CNI_RULE_PRIORITY_BASE. An invalid layout falls back to the default one.
+func getRoutingLayout() RoutingLayout {
+	layout := DefaultRoutingLayout
+	if offset := os.Getenv(envRouteTableOffset); offset != "" {
+		parsed, err := strconv.Atoi(offset)
+		if err != nil {
+			log.Errorf("Failed to parse %s; will use the default routing layout: %v", envRouteTableOffset, err)
+			return DefaultRoutingLayout
+		}
+		layout.TableOffset = parsed
+	}
+	if base := os.Getenv(envRulePriorityBase); base != "" {
+		parsed, err := strconv.Atoi(base)
+		if err != nil {
+			log.Errorf("Failed to parse %s; will use the default routing layout: %v", envRulePriorityBase, err)
+			return DefaultRoutingLayout
+		}
+		layout.PriorityBase = parsed
+	}
+	if err := layout.Validate(); err != nil {
+		log.Errorf("Invalid routing layout; will use the default one (%s): %v", DefaultRoutingLayout, err)
+		return DefaultRoutingLayout
+	}
+	return layout
+}
+
+// GetRoutingLayout returns the route table numbering and rule priorities for pod traffic
+func (n *linuxNetwork) GetRoutingLayout() RoutingLayout {
+	return n.layout
+}
+
+// CheckRoutingConflicts looks for IP rules and routes set up by something else that collide with the routing layout:
+// rules at one of its priorities that don't look like ours, rules at other priorities that look up one of its route
+// tables, and routes in those tables through an interface that isn't one of the given ENIs. Each conflict is
+// returned as a description.
+func (n *linuxNetwork) CheckRoutingConflicts(eniMACs []string) ([]string, error) {
+	firstTable, lastTable := n.layout.ENIRouteTable(1), n.layout.ENIRouteTable(maxRouteTableDevices)
+	inENITables := func(table int) bool {
+		return table >= firstTable && table <= lastTable
+	}
+
+	rules, err := n.netLink.RuleList(unix.AF_INET)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list IP rules")
+	}
+	var conflicts []string
+	for _, rule := range rules {
+		var ours bool
+		switch rule.Priority {
+		case n.layout.ToPodRulePriority():
+			ours = rule.Table == mainRoutingTable && rule.Src == nil && isHostCIDR(rule.Dst)
+		case n.layout.HostRulePriority():
+			ours = rule.Table == mainRoutingTable && rule.Src == nil
+		case n.layout.FromPodRulePriority():
+			ours = (rule.Table == mainRoutingTable || inENITables(rule.Table)) && isHostCIDR(rule.Src)
+		default:
+			ours = !inENITables(rule.Table)
+		}
+		if !ours {
+			conflicts = append(conflicts, fmt.Sprintf("IP rule %d: from %s to %s table %d",
+				rule.Priority, rule.Src, rule.Dst, rule.Table))
+		}
+	}
+
+	ourLinks := make(map[int]bool)
+	links, err := n.netLink.LinkList()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list links")
+	}
+	for _, link := range links {
+		for _, mac := range eniMACs {
+			if link.Attrs().HardwareAddr.String() == mac {
+				ourLinks[link.Attrs().Index] = true
+			}
+		}
+	}
+	routes, err := n.netLink.RouteListFiltered(unix.AF_INET, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list routes")
+	}
+	for _, route := range routes {
+		if inENITables(route.Table) && !ourLinks[route.LinkIndex] {
+			conflicts = append(conflicts, fmt.Sprintf("route %s in table %d", route, route.Table))
+		}
+	}
+	return conflicts, nil
+}
+
+// GetPodRoutes returns the veths that the routes of the main table send the pod IP addresses to, by IP address
+func (n *linuxNetwork) GetPodRoutes() (map[string]string, error) {
+	return PodRoutes(n.netLink)
+}
+
+// PodRoutes returns the veths that the routes of the main table send the pod IP addresses to, by IP address. The CNI
+// plugin adds a link scoped /32 route to the host side of the veth of each pod.
+func PodRoutes(netLink netlinkwrapper.NetLink) (map[string]string, error) {
+	links, err := netLink.LinkList()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list links")
+	}
+	veths := make(map[int]string)
+	for _, link := range links {
+		if link.Type() == "veth" {
+			veths[link.Attrs().Index] = link.Attrs().Name
+		}
+	}
+	routes, err := netLink.RouteList(nil, unix.AF_INET)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list routes")
+	}
+	podRoutes := make(map[string]string)
+	for _, route := range routes {
+		if veth, ok := veths[route.LinkIndex]; ok && route.Scope == netlink.SCOPE_LINK && isHostCIDR(route.Dst) {
+			podRoutes[route.Dst.IP.String()] = veth
+		}
+	}
+	return podRoutes, nil
+}
+
+// DeletePodRules deletes the to-pod and from-pod rules of an IP address, as the CNI plugin does when it deletes a pod
+func (n *linuxNetwork) DeletePodRules(podIP net.IPNet) error {
+	toPodRule := n.netLink.NewRule()
+	toPodRule.Dst = &podIP
+	toPodRule.Priority = n.layout.ToPodRulePriority()
+	if err := n.netLink.RuleDel(toPodRule); err != nil && !containsNoSuchRule(err) {
+		return errors.Wrapf(err, "failed to delete the rule to %s", podIP.String())
+	}
+	return n.DeleteRuleListBySrc(podIP)
+}
+
+// DeletePodRoute deletes the link scoped /32 route the CNI plugin adds to the host side of the veth of a pod
+func (n *linuxNetwork) DeletePodRoute(podIP net.IPNet) error {
+	route := netlink.Route{Scope: netlink.SCOPE_LINK, Dst: &podIP, Table: mainRoutingTable}
+	if err := n.netLink.RouteDel(&route); err != nil && !netlinkwrapper.IsNotExistsError(err) {
+		return errors.Wrapf(err, "failed to delete the route to %s", podIP.String())
+	}
+	return nil
+}
+
+// MigratePodRules moves the to-pod and from-pod rules of an IP address that a previous routing layout added to the
+// rule priorities and route table of the current one. The from-pod rules of a pod on the primary ENI keep their table.
+func (n *linuxNetwork) MigratePodRules(ruleList []netlink.Rule, podIP net.IPNet, deviceNumber int) error {
+	for _, rule := range ruleList {
+		rule := rule
+		priority, table := rule.Priority, rule.Table
+		switch {
+		case rule.Src == nil && rule.Dst != nil && rule.Dst.IP.Equal(podIP.IP) && rule.Table == mainRoutingTable:
+			priority = n.layout.ToPodRulePriority()
+		case rule.Src != nil && rule.Src.IP.Equal(podIP.IP):
+			priority = n.layout.FromPodRulePriority()
+			if deviceNumber > 0 {
+				table = n.layout.ENIRouteTable(deviceNumber)
+			}
+		default:
+			continue
+		}
+		if priority == rule.Priority && table == rule.Table {
+			continue
+		}
+		migrated := n.netLink.NewRule()
+		migrated.Src = rule.Src
+		migrated.Dst = rule.Dst
+		migrated.Table = table
+		migrated.Priority = priority
+		if err := n.netLink.RuleDel(&rule); err != nil && !containsNoSuchRule(err) {
+			return errors.Wrapf(err, "failed to delete the rule from %s to %s", rule.Src, rule.Dst)
+		}
+		if err := n.netLink.RuleAdd(migrated); err != nil && !containsRuleExists(err) {
+			return errors.Wrapf(err, "failed to add the rule from %s to %s", migrated.Src, migrated.Dst)
+		}
+		log.Infof("Migrated IP rule %d: from %s to %s table %d to priority %d table %d",
+			rule.Priority, rule.Src, rule.Dst, rule.Table, migrated.Priority, migrated.Table)
+		if rule.Table != table && rule.Table != mainRoutingTable {
+			if n.migratedTables == nil {
+				n.migratedTables = make(map[int]bool)
+			}
+			n.migratedTables[rule.Table] = true
+		}
+	}
+	return nil
+}
+
+// DeleteMigratedRouteTables deletes the routes of the route tables that MigratePodRules moved from-pod rules away
+// from, so that they don't collide with whatever uses the table IDs next. A table that a rule of ruleList still looks
+// up, or that belongs to the current layout, is kept.
+func (n *linuxNetwork) DeleteMigratedRouteTables(ruleList []netlink.Rule) error {
+	inUse := make(map[int]bool)
+	for _, rule := range ruleList {
+		inUse[rule.Table] = true
+	}
+	first, last := n.layout.ENIRouteTable(1), n.layout.ENIRouteTable(maxRouteTableDevices)
+	for table := range n.migratedTables {
+		if inUse[table] || (table >= first && table <= last) {
+			log.Infof("Keeping route table %d of the previous routing layout, it is still in use", table)
+			continue
+		}
+		routes, err := n.netLink.RouteListFiltered(unix.AF_INET, &netlink.Route{Table: table}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list the routes of route table %d", table)
+		}
+		for _, route := range routes {
+			route := route
+			if err := n.netLink.RouteDel(&route); err != nil && !netlinkwrapper.IsNotExistsError(err) {
+				return errors.Wrapf(err, "failed to delete the route to %s in route table %d", route.Dst, table)
+			}
+		}
+		log.Infof("Deleted the %d routes of route table %d of the previous routing layout", len(routes), table)
+		delete(n.migratedTables, table)
+	}
+	return nil
+}
+
+// isHostCIDR returns whether an IP rule selector is a single address, as the pod rules use
+func isHostCIDR(ipNet *net.IPNet) bool {
+	if ipNet == nil {
+		return false
+	}
+	ones, bits := ipNet.Mask.Size()
+	return ones == 32 && bits == 32
+}
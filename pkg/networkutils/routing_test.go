@@ -0,0 +1,253 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package networkutils
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/netlinkwrapper/mock_netlink"
+)
+
+func TestRoutingLayout(t *testing.T) {
+	layout := RoutingLayout{TableOffset: 100, PriorityBase: 2048}
+	assert.Equal(t, 102, layout.ENIRouteTable(2))
+	assert.Equal(t, 2048, layout.ToPodRulePriority())
+	assert.Equal(t, 2560, layout.HostRulePriority())
+	assert.Equal(t, 3072, layout.FromPodRulePriority())
+
+	assert.NoError(t, DefaultRoutingLayout.Validate())
+	assert.NoError(t, layout.Validate())
+	assert.NoError(t, RoutingLayout{TableOffset: 1000, PriorityBase: 30000}.Validate())
+	// Tables 201-328 include the main table
+	assert.Error(t, RoutingLayout{TableOffset: 200, PriorityBase: 512}.Validate())
+	assert.Error(t, RoutingLayout{TableOffset: -1, PriorityBase: 512}.Validate())
+	assert.Error(t, RoutingLayout{TableOffset: 0, PriorityBase: 0}.Validate())
+	// The from-pod rules would come after the main table's rule
+	assert.Error(t, RoutingLayout{TableOffset: 0, PriorityBase: 32000}.Validate())
+}
+
+func TestLoadRoutingLayoutFromEnv(t *testing.T) {
+	defer os.Unsetenv(envRouteTableOffset)
+	defer os.Unsetenv(envRulePriorityBase)
+
+	assert.Equal(t, DefaultRoutingLayout, getRoutingLayout())
+
+	_ = os.Setenv(envRouteTableOffset, "100")
+	_ = os.Setenv(envRulePriorityBase, "2048")
+	assert.Equal(t, RoutingLayout{TableOffset: 100, PriorityBase: 2048}, getRoutingLayout())
+
+	_ = os.Setenv(envRouteTableOffset, "200")
+	assert.Equal(t, DefaultRoutingLayout, getRoutingLayout())
+
+	_ = os.Setenv(envRouteTableOffset, "100")
+	_ = os.Setenv(envRulePriorityBase, "high")
+	assert.Equal(t, DefaultRoutingLayout, getRoutingLayout())
+}
+
+func TestCheckRoutingConflicts(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{netLink: mockNetLink, layout: DefaultRoutingLayout}
+
+	_, vpcCIDR, _ := net.ParseCIDR("10.10.0.0/16")
+	_, podIP, _ := net.ParseCIDR("10.10.10.20/32")
+	_, otherCIDR, _ := net.ParseCIDR("192.168.0.0/24")
+	mockNetLink.EXPECT().RuleList(unix.AF_INET).Return([]netlink.Rule{
+		{Priority: 0, Table: unix.RT_TABLE_LOCAL},
+		{Priority: 512, Dst: podIP, Table: unix.RT_TABLE_MAIN},
+		{Priority: 1024, Dst: vpcCIDR, Invert: true, Table: unix.RT_TABLE_MAIN},
+		{Priority: 1536, Src: podIP, Dst: vpcCIDR, Table: 2},
+		{Priority: 32766, Table: unix.RT_TABLE_MAIN},
+		// Another agent using our priority, and one using our table
+		{Priority: 512, Dst: otherCIDR, Table: 300},
+		{Priority: 100, Src: otherCIDR, Table: 3},
+	}, nil)
+
+	eniMAC, _ := net.ParseMAC(testMAC1)
+	otherMAC, _ := net.ParseMAC(testMAC2)
+	eniLink := mock_netlink.NewMockLink(ctrl)
+	otherLink := mock_netlink.NewMockLink(ctrl)
+	eniLink.EXPECT().Attrs().Return(&netlink.LinkAttrs{Index: 3, HardwareAddr: eniMAC}).AnyTimes()
+	otherLink.EXPECT().Attrs().Return(&netlink.LinkAttrs{Index: 4, HardwareAddr: otherMAC}).AnyTimes()
+	mockNetLink.EXPECT().LinkList().Return([]netlink.Link{eniLink, otherLink}, nil)
+	mockNetLink.EXPECT().RouteListFiltered(unix.AF_INET, gomock.Any(), uint64(netlink.RT_FILTER_TABLE)).Return([]netlink.Route{
+		{LinkIndex: 3, Table: 2},
+		{LinkIndex: 4, Table: unix.RT_TABLE_MAIN},
+		{LinkIndex: 4, Table: 3},
+	}, nil)
+
+	conflicts, err := ln.CheckRoutingConflicts([]string{testMAC1})
+	assert.NoError(t, err)
+	assert.Len(t, conflicts, 3)
+	assert.Contains(t, conflicts[0], "IP rule 512: from <nil> to 192.168.0.0/24 table 300")
+	assert.Contains(t, conflicts[1], "IP rule 100: from 192.168.0.0/24 to <nil> table 3")
+	assert.Contains(t, conflicts[2], "in table 3")
+}
+
+func TestGetPodRoutes(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{netLink: mockNetLink, layout: DefaultRoutingLayout}
+
+	veth := mock_netlink.NewMockLink(ctrl)
+	eth := mock_netlink.NewMockLink(ctrl)
+	veth.EXPECT().Type().Return("veth").AnyTimes()
+	veth.EXPECT().Attrs().Return(&netlink.LinkAttrs{Index: 5, Name: "eni1a2b3c"}).AnyTimes()
+	eth.EXPECT().Type().Return("device").AnyTimes()
+	mockNetLink.EXPECT().LinkList().Return([]netlink.Link{veth, eth}, nil)
+	_, podIP, _ := net.ParseCIDR("10.10.10.20/32")
+	_, vpcCIDR, _ := net.ParseCIDR("10.10.0.0/16")
+	mockNetLink.EXPECT().RouteList(nil, unix.AF_INET).Return([]netlink.Route{
+		{LinkIndex: 5, Dst: podIP, Scope: netlink.SCOPE_LINK},
+		{LinkIndex: 2, Dst: vpcCIDR, Scope: netlink.SCOPE_LINK},
+		{LinkIndex: 5, Dst: vpcCIDR, Scope: netlink.SCOPE_LINK},
+	}, nil)
+
+	routes, err := ln.GetPodRoutes()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"10.10.10.20": "eni1a2b3c"}, routes)
+}
+
+func TestDeletePodRules(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	layout := RoutingLayout{TableOffset: 100, PriorityBase: 2048}
+	ln := &linuxNetwork{netLink: mockNetLink, layout: layout}
+
+	_, podIP, _ := net.ParseCIDR("10.10.10.20/32")
+	_, vpcCIDR, _ := net.ParseCIDR("10.10.0.0/16")
+	fromPodRule := netlink.Rule{Priority: layout.FromPodRulePriority(), Src: podIP, Dst: vpcCIDR, Table: 102}
+	toPodRule := netlink.NewRule()
+	mockNetLink.EXPECT().NewRule().Return(toPodRule)
+	mockNetLink.EXPECT().RuleDel(toPodRule).Return(nil)
+	mockNetLink.EXPECT().RuleList(unix.AF_INET).Return([]netlink.Rule{
+		{Priority: layout.ToPodRulePriority(), Dst: vpcCIDR, Table: unix.RT_TABLE_MAIN},
+		fromPodRule,
+	}, nil)
+	mockNetLink.EXPECT().RuleDel(&fromPodRule).Return(nil)
+
+	assert.NoError(t, ln.DeletePodRules(*podIP))
+	assert.Equal(t, layout.ToPodRulePriority(), toPodRule.Priority)
+	assert.Equal(t, podIP.String(), toPodRule.Dst.String())
+}
+
+func TestMigratePodRules(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	layout := RoutingLayout{TableOffset: 100, PriorityBase: 2048}
+	ln := &linuxNetwork{netLink: mockNetLink, layout: layout}
+
+	_, podIP, _ := net.ParseCIDR("10.10.10.20/32")
+	_, otherPodIP, _ := net.ParseCIDR("10.10.10.21/32")
+	_, vpcCIDR, _ := net.ParseCIDR("10.10.0.0/16")
+	staleToPodRule := netlink.Rule{Priority: 512, Dst: podIP, Table: unix.RT_TABLE_MAIN}
+	staleFromPodRule := netlink.Rule{Priority: 1536, Src: podIP, Dst: vpcCIDR, Table: 2}
+	rules := []netlink.Rule{
+		staleToPodRule,
+		staleFromPodRule,
+		// Already in the layout
+		{Priority: layout.FromPodRulePriority(), Src: podIP, Dst: otherPodIP, Table: 102},
+		// Another pod's
+		{Priority: 512, Dst: otherPodIP, Table: unix.RT_TABLE_MAIN},
+	}
+	toPodRule, fromPodRule := netlink.NewRule(), netlink.NewRule()
+	mockNetLink.EXPECT().NewRule().Return(toPodRule)
+	mockNetLink.EXPECT().NewRule().Return(fromPodRule)
+	gomock.InOrder(
+		mockNetLink.EXPECT().RuleDel(&staleToPodRule).Return(nil),
+		mockNetLink.EXPECT().RuleAdd(toPodRule).Return(nil),
+		mockNetLink.EXPECT().RuleDel(&staleFromPodRule).Return(nil),
+		mockNetLink.EXPECT().RuleAdd(fromPodRule).Return(syscall.EEXIST),
+	)
+
+	assert.NoError(t, ln.MigratePodRules(rules, *podIP, 2))
+	assert.Equal(t, layout.ToPodRulePriority(), toPodRule.Priority)
+	assert.Equal(t, unix.RT_TABLE_MAIN, toPodRule.Table)
+	assert.Equal(t, podIP.String(), toPodRule.Dst.String())
+	assert.Equal(t, layout.FromPodRulePriority(), fromPodRule.Priority)
+	assert.Equal(t, 102, fromPodRule.Table)
+	assert.Equal(t, vpcCIDR.String(), fromPodRule.Dst.String())
+}
+
+func TestDeleteMigratedRouteTables(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	layout := RoutingLayout{TableOffset: 100, PriorityBase: 2048}
+	ln := &linuxNetwork{netLink: mockNetLink, layout: layout}
+
+	// The from-pod rules of two pods move from tables 2 and 3 of the default layout to tables 102 and 103
+	_, podIP, _ := net.ParseCIDR("10.10.10.20/32")
+	_, otherPodIP, _ := net.ParseCIDR("10.10.10.21/32")
+	_, vpcCIDR, _ := net.ParseCIDR("10.10.0.0/16")
+	fromPodRule := netlink.Rule{Priority: 1536, Src: podIP, Dst: vpcCIDR, Table: 2}
+	otherFromPodRule := netlink.Rule{Priority: 1536, Src: otherPodIP, Dst: vpcCIDR, Table: 3}
+	mockNetLink.EXPECT().NewRule().Return(netlink.NewRule()).Times(2)
+	mockNetLink.EXPECT().RuleDel(gomock.Any()).Return(nil).Times(2)
+	mockNetLink.EXPECT().RuleAdd(gomock.Any()).Return(nil).Times(2)
+	assert.NoError(t, ln.MigratePodRules([]netlink.Rule{fromPodRule}, *podIP, 2))
+	assert.NoError(t, ln.MigratePodRules([]netlink.Rule{otherFromPodRule}, *otherPodIP, 3))
+
+	// Another rule still looks up table 3, so only the routes of table 2 are deleted
+	_, defaultDst, _ := net.ParseCIDR("0.0.0.0/0")
+	staleRoutes := []netlink.Route{
+		{Dst: defaultDst, Gw: net.ParseIP("10.10.0.1"), LinkIndex: 3, Table: 2},
+		{Dst: vpcCIDR, LinkIndex: 3, Scope: netlink.SCOPE_LINK, Table: 2},
+	}
+	mockNetLink.EXPECT().RouteListFiltered(unix.AF_INET, &netlink.Route{Table: 2}, uint64(netlink.RT_FILTER_TABLE)).
+		Return(staleRoutes, nil)
+	mockNetLink.EXPECT().RouteDel(&staleRoutes[0]).Return(nil)
+	mockNetLink.EXPECT().RouteDel(&staleRoutes[1]).Return(syscall.ESRCH)
+	rules := []netlink.Rule{{Priority: 100, Src: vpcCIDR, Table: 3}}
+	assert.NoError(t, ln.DeleteMigratedRouteTables(rules))
+	assert.Equal(t, map[int]bool{3: true}, ln.migratedTables)
+
+	// Once no rule looks it up, table 3 is deleted too, and only once
+	mockNetLink.EXPECT().RouteListFiltered(unix.AF_INET, &netlink.Route{Table: 3}, uint64(netlink.RT_FILTER_TABLE)).
+		Return(nil, nil)
+	assert.NoError(t, ln.DeleteMigratedRouteTables(nil))
+	assert.NoError(t, ln.DeleteMigratedRouteTables(nil))
+	assert.Empty(t, ln.migratedTables)
+}
+
+func TestDeletePodRoute(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{netLink: mockNetLink, layout: DefaultRoutingLayout}
+
+	_, podIP, _ := net.ParseCIDR("10.10.10.20/32")
+	route := &netlink.Route{Scope: netlink.SCOPE_LINK, Dst: podIP, Table: unix.RT_TABLE_MAIN}
+	mockNetLink.EXPECT().RouteDel(route).Return(nil)
+	assert.NoError(t, ln.DeletePodRoute(*podIP))
+
+	// A route that is already gone is not an error
+	mockNetLink.EXPECT().RouteDel(route).Return(syscall.ESRCH)
+	assert.NoError(t, ln.DeletePodRoute(*podIP))
+
+	mockNetLink.EXPECT().RouteDel(route).Return(syscall.EPERM)
+	assert.Error(t, ln.DeletePodRoute(*podIP))
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package cron parses the five field schedules of crontab(5)
+package cron
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// field is the range of a schedule field
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	// 7 is Sunday as well as 0
+	{"day of week", 0, 7},
+}
+
+// Schedule is a parsed schedule. Each field is a bit set of the values it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are set when the day of month or day of week field is "*". When neither is, a day matches
+	// if either field matches it, like cron does.
+	domStar, dowStar bool
+}
+
+// Parse parses a schedule of five fields: minute, hour, day of month, month and day of week. Each field is "*", a
+// number, a range "a-b" or a list of them separated by commas, and "*" and ranges may have a step "/n".
+func Parse(spec string) (*Schedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, errors.Errorf("cron: schedule %q has %d fields instead of %d", spec, len(parts), len(fields))
+	}
+	var bits [5]uint64
+	for i, part := range parts {
+		var err error
+		if bits[i], err = parseField(part, fields[i]); err != nil {
+			return nil, errors.Wrapf(err, "cron: invalid schedule %q", spec)
+		}
+	}
+	dow := bits[4]
+	if dow&(1<<7) != 0 {
+		dow |= 1
+	}
+	return &Schedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     dow,
+		domStar: strings.HasPrefix(parts[2], "*"),
+		dowStar: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+func parseField(spec string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(spec, ",") {
+		rangeSpec, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			rangeSpec = item[:i]
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return 0, errors.Errorf("invalid step in %s %q", f.name, item)
+			}
+		}
+		low, high := f.min, f.max
+		if rangeSpec != "*" {
+			bounds := strings.SplitN(rangeSpec, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("invalid %s %q", f.name, item)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.Errorf("invalid %s %q", f.name, item)
+				}
+			} else if step > 1 {
+				// "a/n" means from a to the end of the range
+				high = f.max
+			}
+			if low < f.min || high > f.max || low > high {
+				return 0, errors.Errorf("%s %q is out of range %d-%d", f.name, item, f.min, f.max)
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Matches returns whether the schedule fires in the minute of t
+func (s *Schedule) Matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Prev returns the start of the last minute at or before t in which the schedule fires, looking back no further
+// than lookback. It returns false if the schedule didn't fire within lookback.
+func (s *Schedule) Prev(t time.Time, lookback time.Duration) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	for earliest := t.Add(-lookback); !t.Before(earliest); t = t.Add(-time.Minute) {
+		if s.Matches(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
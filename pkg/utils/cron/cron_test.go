@@ -0,0 +1,96 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"* * * * MON",
+	} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	// Monday 2019-12-02 07:30 UTC
+	monday := time.Date(2019, 12, 2, 7, 30, 0, 0, time.UTC)
+	sunday := time.Date(2019, 12, 1, 7, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		t    time.Time
+		want bool
+	}{
+		{"* * * * *", monday, true},
+		{"30 7 * * *", monday, true},
+		{"30 7 * * *", monday.Add(time.Minute), false},
+		{"*/15 * * * *", monday, true},
+		{"*/20 * * * *", monday, false},
+		{"10/20 * * * *", monday, true},
+		{"0-29,31 * * * *", monday, false},
+		{"30 6-8 * * 1-5", monday, true},
+		{"30 6-8 * * 1-5", sunday, false},
+		{"30 7 * * 0", sunday, true},
+		{"30 7 * * 7", sunday, true},
+		{"30 7 * 12 *", monday, true},
+		{"30 7 * 1-11 *", monday, false},
+		// With both days restricted either one matches
+		{"30 7 1 * 1", monday, true},
+		{"30 7 1 * 1", sunday, true},
+		{"30 7 15 * 3", monday, false},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		assert.NoError(t, err, tt.spec)
+		assert.Equal(t, tt.want, s.Matches(tt.t), "%s at %v", tt.spec, tt.t)
+	}
+}
+
+func TestPrev(t *testing.T) {
+	now := time.Date(2019, 12, 2, 7, 30, 45, 0, time.UTC)
+	s, err := Parse("0 22 * * *")
+	assert.NoError(t, err)
+
+	prev, ok := s.Prev(now, 24*time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2019, 12, 1, 22, 0, 0, 0, time.UTC), prev)
+
+	_, ok = s.Prev(now, 9*time.Hour)
+	assert.False(t, ok)
+
+	// The current minute counts
+	s, err = Parse("30 7 * * *")
+	assert.NoError(t, err)
+	prev, ok = s.Prev(now, time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2019, 12, 2, 7, 30, 0, 0, time.UTC), prev)
+}
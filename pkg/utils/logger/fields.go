@@ -0,0 +1,171 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// fieldsMarker delimits the JSON encoded fields an Entry puts in front of its messages, for the jsonFormatter
+	fieldsMarker = "\x1e"
+	// jsonTimeFormat is the format of the time of the JSON log lines, the same as the text ones
+	jsonTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// errJSONFormatter is the error of the registration of the jsonFormatter, which the JSON format fails with. It isn't
+// printed, since the stdout of the CNI plugin is its result.
+var errJSONFormatter = log.RegisterCustomFormatter("CNIJSON", jsonFormatter)
+
+// Fields are the fields that identify what a log line is about, so that the lines of the plugin and ipamd about the
+// same request or pod can be joined
+type Fields struct {
+	// RequestID is the ID the plugin generates for each CNI command, and sends to ipamd with the gRPC requests
+	RequestID string `json:"requestID,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// Sandbox is the ID of the pod's infra container
+	Sandbox string `json:"sandbox,omitempty"`
+	ENI     string `json:"eni,omitempty"`
+	IP      string `json:"ip,omitempty"`
+}
+
+// merge returns the fields with the non-empty ones of other replacing them
+func (f Fields) merge(other Fields) Fields {
+	for _, field := range []struct{ dst, src *string }{
+		{&f.RequestID, &other.RequestID},
+		{&f.Pod, &other.Pod},
+		{&f.Namespace, &other.Namespace},
+		{&f.Sandbox, &other.Sandbox},
+		{&f.ENI, &other.ENI},
+		{&f.IP, &other.IP},
+	} {
+		if *field.src != "" {
+			*field.dst = *field.src
+		}
+	}
+	return f
+}
+
+// String returns the non-empty fields as key=value pairs, the way the text format shows them
+func (f Fields) String() string {
+	var pairs []string
+	for _, field := range []struct{ key, value string }{
+		{"requestID", f.RequestID},
+		{"namespace", f.Namespace},
+		{"pod", f.Pod},
+		{"sandbox", f.Sandbox},
+		{"eni", f.ENI},
+		{"ip", f.IP},
+	} {
+		if field.value != "" {
+			pairs = append(pairs, field.key+"="+field.value)
+		}
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Entry logs messages with fields. In the JSON format the fields are attributes of the line, in the text format they
+// are in brackets in front of the message.
+type Entry struct {
+	fields Fields
+}
+
+// With returns an Entry that logs with the fields
+func With(fields Fields) Entry {
+	return Entry{fields: fields}
+}
+
+// With returns a copy of the entry with the non-empty fields added
+func (e Entry) With(fields Fields) Entry {
+	return Entry{fields: e.fields.merge(fields)}
+}
+
+// Debugf formats the message and logs it with the fields at debug level
+func (e Entry) Debugf(format string, params ...interface{}) {
+	log.Debug(e.message(format, params...))
+}
+
+// Infof formats the message and logs it with the fields at info level
+func (e Entry) Infof(format string, params ...interface{}) {
+	log.Info(e.message(format, params...))
+}
+
+// Warnf formats the message and logs it with the fields at warn level, and returns it as an error like seelog
+func (e Entry) Warnf(format string, params ...interface{}) error {
+	log.Warn(e.message(format, params...))
+	return fmt.Errorf(format, params...)
+}
+
+// Errorf formats the message and logs it with the fields at error level, and returns it as an error like seelog
+func (e Entry) Errorf(format string, params ...interface{}) error {
+	log.Error(e.message(format, params...))
+	return fmt.Errorf(format, params...)
+}
+
+// message returns the formatted message with the fields, for the current log format
+func (e Entry) message(format string, params ...interface{}) string {
+	msg := fmt.Sprintf(format, params...)
+	if e.fields == (Fields{}) {
+		return msg
+	}
+	logLock.RLock()
+	current := logFormat
+	logLock.RUnlock()
+	if current == FormatJSON {
+		encoded, err := json.Marshal(e.fields)
+		if err == nil {
+			return fieldsMarker + string(encoded) + fieldsMarker + msg
+		}
+	}
+	return "[" + e.fields.String() + "] " + msg
+}
+
+// jsonLine is a log line of the JSON format
+type jsonLine struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Component string `json:"component,omitempty"`
+	Fields
+	Msg string `json:"msg"`
+}
+
+// jsonFormatter is the seelog formatter of the JSON format. Its parameter is the component that logs, and it takes
+// the fields an Entry puts in front of the message out of it.
+func jsonFormatter(component string) log.FormatterFunc {
+	return func(message string, level log.LogLevel, context log.LogContextInterface) interface{} {
+		line := jsonLine{
+			Time:      context.CallTime().UTC().Format(jsonTimeFormat),
+			Level:     level.String(),
+			Component: component,
+			Msg:       message,
+		}
+		if strings.HasPrefix(message, fieldsMarker) {
+			if end := strings.Index(message[1:], fieldsMarker); end >= 0 {
+				if err := json.Unmarshal([]byte(message[1:end+1]), &line.Fields); err == nil {
+					line.Msg = message[end+2:]
+				}
+			}
+		}
+		encoded, err := json.Marshal(line)
+		if err != nil {
+			return fmt.Sprintf(`{"level":"error","msg":%q}`, "failed to encode the log line: "+err.Error())
+		}
+		return string(encoded)
+	}
+}
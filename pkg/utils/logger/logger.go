@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 
 	log "github.com/cihub/seelog"
 )
@@ -24,6 +25,13 @@ import (
 const (
 	envLogLevel    = "AWS_VPC_K8S_CNI_LOGLEVEL"
 	envLogFilePath = "AWS_VPC_K8S_CNI_LOG_FILE"
+	// envLogFormat is the format of the log lines, "text" or "json". Default is "text".
+	envLogFormat = "AWS_VPC_K8S_CNI_LOG_FORMAT"
+
+	// FormatText and FormatJSON are the log formats
+	FormatText = "text"
+	FormatJSON = "json"
+
 	// logConfigFormat defines the seelog format, with a rolling file
 	// writer. We cannot do this in code and have to resort to using
 	// LoggerFromConfigAsString as seelog doesn't have a usable public
@@ -34,10 +42,25 @@ const (
   %s
  </outputs>
  <formats>
-  <format id="main" format="%%UTCDate(2006-01-02T15:04:05.000Z07:00) [%%LEVEL] %%t%%Msg%%n" />
+  <format id="main" format="%s" />
  </formats>
 </seelog>
 `
+	textFormat = "%UTCDate(2006-01-02T15:04:05.000Z07:00) [%LEVEL] %t%Msg%n"
+	// jsonFormat writes one JSON object per line with the jsonFormatter, the component is its parameter
+	jsonFormat = "%CNIJSON"
+)
+
+var (
+	// logOutput, logLevel and logFormat are the output, level and format of the logger set up by SetupLogger, which
+	// SetLogLevel and SetLogFormat keep
+	logOutput string
+	logLevel  string
+	logFormat string
+	// component is the name of the process in the JSON log lines
+	component string
+	// logLock protects the settings of the logger
+	logLock sync.RWMutex
 )
 
 // GetLogFileLocation returns the log file path
@@ -49,18 +72,76 @@ func GetLogFileLocation(defaultLogFilePath string) string {
 	return logFilePath
 }
 
-// SetupLogger sets up a file logger
-func SetupLogger(logFilePath string) {
-	logger, err := log.LoggerFromConfigAsString(fmt.Sprintf(logConfigFormat, getLogLevel(), getLogOutput(logFilePath)))
-	if err != nil {
+// SetupLogger sets up a file logger of the component, e.g. "aws-cni" or "aws-k8s-agent"
+func SetupLogger(logFilePath string, name string) {
+	logLock.Lock()
+	defer logLock.Unlock()
+	logOutput = getLogOutput(logFilePath)
+	logLevel = getLogLevel()
+	logFormat = getLogFormat()
+	component = name
+	if err := replaceLogger(); err != nil {
 		fmt.Println("Error setting up logger: ", err)
-		return
 	}
-	err = log.ReplaceLogger(logger)
+}
+
+// replaceLogger replaces the logger with one of the current settings
+func replaceLogger() error {
+	format := textFormat
+	if logFormat == FormatJSON {
+		if errJSONFormatter != nil {
+			return fmt.Errorf("failed to register the JSON log formatter: %v", errJSONFormatter)
+		}
+		format = jsonFormat + "(" + component + ")%n"
+	}
+	logger, err := log.LoggerFromConfigAsString(fmt.Sprintf(logConfigFormat, logLevel, logOutput, format))
 	if err != nil {
-		fmt.Println("Error replacing logger: ", err)
-		return
+		return err
 	}
+	return log.ReplaceLogger(logger)
+}
+
+// SetLogLevel replaces the logger set up by SetupLogger with one of the given level, until the next restart
+func SetLogLevel(level string) error {
+	seelogLevel, ok := log.LogLevelFromString(strings.ToLower(level))
+	if !ok {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	logLock.Lock()
+	defer logLock.Unlock()
+	if logOutput == "" {
+		return fmt.Errorf("the logger is not set up")
+	}
+	previous := logLevel
+	logLevel = seelogLevel.String()
+	if err := replaceLogger(); err != nil {
+		logLevel = previous
+		return err
+	}
+	return nil
+}
+
+// SetLogFormat replaces the logger set up by SetupLogger with one of the given format, "text" or "json"
+func SetLogFormat(format string) error {
+	format = strings.ToLower(format)
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("invalid log format %q, must be %q or %q", format, FormatText, FormatJSON)
+	}
+	logLock.Lock()
+	defer logLock.Unlock()
+	if logOutput == "" {
+		return fmt.Errorf("the logger is not set up")
+	}
+	if format == logFormat {
+		return nil
+	}
+	previous := logFormat
+	logFormat = format
+	if err := replaceLogger(); err != nil {
+		logFormat = previous
+		return err
+	}
+	return nil
 }
 
 func getLogLevel() string {
@@ -71,6 +152,13 @@ func getLogLevel() string {
 	return seelogLevel.String()
 }
 
+func getLogFormat() string {
+	if strings.ToLower(os.Getenv(envLogFormat)) == FormatJSON {
+		return FormatJSON
+	}
+	return FormatText
+}
+
 func getLogOutput(logFilePath string) string {
 	switch logFilePath {
 	case "stdout":
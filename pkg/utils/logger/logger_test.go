@@ -14,11 +14,17 @@
 package logger
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"os"
 	"testing"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 func TestGetLogFileLocationReturnsOverriddenPath(t *testing.T) {
@@ -71,3 +77,105 @@ func TestLogOutputReturnsConsole(t *testing.T) {
 	var expectedOutput = `<console />`
 	assert.Equal(t, expectedOutput, getLogOutput(path))
 }
+
+func TestSetLogLevel(t *testing.T) {
+	defer func() { logOutput = "" }()
+	assert.Error(t, SetLogLevel("info"))
+
+	SetupLogger("stdout", "test")
+	assert.NoError(t, SetLogLevel("INFO"))
+	assert.Error(t, SetLogLevel("everything"))
+}
+
+func TestSetLogFormat(t *testing.T) {
+	defer func() { logOutput, logFormat = "", "" }()
+	assert.Error(t, SetLogFormat(FormatJSON))
+
+	_ = os.Setenv(envLogFormat, "JSON")
+	SetupLogger("stdout", "test")
+	_ = os.Unsetenv(envLogFormat)
+	assert.Equal(t, FormatJSON, logFormat)
+
+	assert.NoError(t, SetLogFormat("text"))
+	assert.Equal(t, FormatText, logFormat)
+	assert.NoError(t, SetLogLevel("info"))
+	assert.Equal(t, FormatText, logFormat)
+	assert.Error(t, SetLogFormat("xml"))
+	assert.Equal(t, FormatText, logFormat)
+
+	// The JSON format fails if its formatter couldn't be registered
+	errJSONFormatter = errors.New("formatter already registered")
+	defer func() { errJSONFormatter = nil }()
+	assert.Error(t, SetLogFormat(FormatJSON))
+	assert.Equal(t, FormatText, logFormat)
+}
+
+// testContext is the seelog context of the formatter tests
+type testContext struct {
+	log.LogContextInterface
+	callTime time.Time
+}
+
+func (c testContext) CallTime() time.Time {
+	return c.callTime
+}
+
+func TestJSONFormatter(t *testing.T) {
+	defer func() { logFormat = "" }()
+	logFormat = FormatJSON
+	entry := With(Fields{RequestID: "8a1f", Namespace: "default", Pod: "nginx"}).With(Fields{IP: "10.0.1.5"})
+	context := testContext{callTime: time.Date(2019, 10, 21, 17, 0, 0, 0, time.FixedZone("PDT", -7*3600))}
+
+	line := jsonFormatter("aws-cni")(entry.message("Assigned %s", "10.0.1.5"), log.InfoLvl, context)
+	assert.JSONEq(t, `{"time":"2019-10-22T00:00:00.000Z","level":"info","component":"aws-cni","requestID":"8a1f",
+		"pod":"nginx","namespace":"default","ip":"10.0.1.5","msg":"Assigned 10.0.1.5"}`, line.(string))
+
+	// The lines that aren't logged with an Entry only have the message
+	line = jsonFormatter("aws-k8s-agent")("Reconciling ENI/IP pool info...", log.DebugLvl, context)
+	var decoded map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(line.(string)), &decoded))
+	assert.Equal(t, map[string]string{"time": "2019-10-22T00:00:00.000Z", "level": "debug",
+		"component": "aws-k8s-agent", "msg": "Reconciling ENI/IP pool info..."}, decoded)
+}
+
+func TestTextFields(t *testing.T) {
+	defer func() { logFormat = "" }()
+	logFormat = FormatText
+	entry := With(Fields{RequestID: "8a1f", Namespace: "default", Pod: "nginx", ENI: "eni-1"})
+	assert.Equal(t, "[requestID=8a1f namespace=default pod=nginx eni=eni-1] Assigned 10.0.1.5",
+		entry.message("Assigned %s", "10.0.1.5"))
+	assert.Equal(t, "no fields", With(Fields{}).message("no fields"))
+	assert.EqualError(t, entry.Errorf("failed to assign %s", "10.0.1.5"), "failed to assign 10.0.1.5")
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	requestID := NewRequestID()
+	assert.Len(t, requestID, 16)
+	assert.NotEqual(t, requestID, NewRequestID())
+
+	// The client sends the request ID as metadata
+	var outgoing metadata.MD
+	err := UnaryClientInterceptor()(ContextWithRequestID(context.Background(), requestID), "/rpc.CNIBackend/AddNetwork",
+		nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			outgoing, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{requestID}, outgoing.Get(requestIDKey))
+
+	// The server puts it in the context of the handler, or a new one if the client didn't send it
+	info := &grpc.UnaryServerInfo{FullMethod: "/rpc.CNIBackend/AddNetwork"}
+	var received string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		received = RequestIDFromContext(ctx)
+		return nil, nil
+	}
+	_, err = UnaryServerInterceptor()(metadata.NewIncomingContext(context.Background(), outgoing), nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, requestID, received)
+
+	_, err = UnaryServerInterceptor()(context.Background(), nil, info, handler)
+	assert.NoError(t, err)
+	assert.Len(t, received, 16)
+	assert.NotEqual(t, requestID, received)
+}
@@ -0,0 +1,284 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ExporterNone turns tracing off
+	ExporterNone = "none"
+	// ExporterOTLP sends the spans to an OTLP/HTTP collector
+	ExporterOTLP = "otlp"
+	// ExporterFile appends the spans to a file, one OTLP JSON export request per line
+	ExporterFile = "file"
+
+	// maxTraceFileSize is the size after which the file exporter moves the file to <file>.1, replacing the previous
+	// one, so that the traces take at most twice this size
+	maxTraceFileSize = 10 * 1024 * 1024
+
+	// envExporter is where the spans are exported: "none", "otlp" or "file". Default is "none".
+	envExporter = "AWS_VPC_K8S_CNI_TRACING_EXPORTER"
+	// envOTLPEndpoint is the URL of the OTLP/HTTP traces endpoint. Default is http://localhost:4318/v1/traces.
+	envOTLPEndpoint     = "AWS_VPC_K8S_CNI_TRACING_OTLP_ENDPOINT"
+	defaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+	// envFile is the file of the "file" exporter
+	envFile = "AWS_VPC_K8S_CNI_TRACING_FILE"
+	// envSampleRatio is the ratio of the traces that are recorded, from 0 to 1. Default is 0.1.
+	envSampleRatio     = "AWS_VPC_K8S_CNI_TRACING_SAMPLE_RATIO"
+	defaultSampleRatio = "0.1"
+
+	// otlpTimeout is how long an export to the OTLP collector may take
+	otlpTimeout = 5 * time.Second
+	// scopeName is the instrumentation scope of the spans
+	scopeName = "github.com/aws/amazon-vpc-cni-k8s"
+)
+
+// Config is where a process exports its spans
+type Config struct {
+	// Exporter is "none", "otlp" or "file", empty is "none"
+	Exporter string `json:"exporter,omitempty"`
+	// OTLPEndpoint is the URL of the OTLP/HTTP traces endpoint of the "otlp" exporter
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty"`
+	// File is the file of the "file" exporter
+	File string `json:"file,omitempty"`
+	// SampleRatio is the ratio of the traces that start in the process that are recorded, from 0 to 1. The traces
+	// that start in another process follow its decision.
+	SampleRatio string `json:"sampleRatio,omitempty"`
+}
+
+// ConfigFromEnv returns the tracing config of the environment variables, with defaultFile as the file of the "file"
+// exporter if none is set
+func ConfigFromEnv(defaultFile string) Config {
+	return Config{
+		Exporter:     os.Getenv(envExporter),
+		OTLPEndpoint: os.Getenv(envOTLPEndpoint),
+		File:         os.Getenv(envFile),
+		SampleRatio:  os.Getenv(envSampleRatio),
+	}.WithDefaults(defaultFile)
+}
+
+// WithDefaults returns the config with the default OTLP endpoint and sample ratio, and defaultFile as the file, if they
+// aren't set
+func (c Config) WithDefaults(defaultFile string) Config {
+	if c.Exporter == "" {
+		c.Exporter = ExporterNone
+	}
+	if c.OTLPEndpoint == "" {
+		c.OTLPEndpoint = defaultOTLPEndpoint
+	}
+	if c.File == "" {
+		c.File = defaultFile
+	}
+	if c.SampleRatio == "" {
+		c.SampleRatio = defaultSampleRatio
+	}
+	return c
+}
+
+// NewSampler returns the parent based ratio sampler of the config
+func (c Config) NewSampler() (Sampler, error) {
+	value := c.SampleRatio
+	if value == "" {
+		value = defaultSampleRatio
+	}
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return nil, errors.Errorf("tracing: invalid sample ratio %q, must be between 0 and 1", c.SampleRatio)
+	}
+	return ParentBasedRatio(ratio), nil
+}
+
+// NewExporter returns the exporter of the config, nil for "none"
+func (c Config) NewExporter() (Exporter, error) {
+	switch c.Exporter {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterOTLP:
+		if c.OTLPEndpoint == "" {
+			return nil, errors.New("tracing: the otlp exporter needs an endpoint")
+		}
+		return &otlpExporter{endpoint: c.OTLPEndpoint, client: &http.Client{Timeout: otlpTimeout}}, nil
+	case ExporterFile:
+		if c.File == "" {
+			return nil, errors.New("tracing: the file exporter needs a file")
+		}
+		return &fileExporter{path: c.File, maxSize: maxTraceFileSize}, nil
+	default:
+		return nil, errors.Errorf("tracing: unknown exporter %q, must be %q, %q or %q",
+			c.Exporter, ExporterNone, ExporterOTLP, ExporterFile)
+	}
+}
+
+// otlpExporter posts the spans to an OTLP/HTTP collector
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+// Export implements Exporter
+func (e *otlpExporter) Export(service string, spans []SpanData) error {
+	body, err := encodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "tracing: failed to post the spans")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("tracing: %s answered %s: %s", e.endpoint, resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// fileExporter appends the spans to a file, one export request per line, which an OpenTelemetry collector can read
+// with its otlpjsonfile receiver. The file is moved to <file>.1 once it reaches maxSize.
+type fileExporter struct {
+	path    string
+	maxSize int64
+	lock    sync.Mutex
+}
+
+// rotate moves the file to <file>.1 if the line would make it larger than maxSize
+func (e *fileExporter) rotate(line []byte) error {
+	info, err := os.Stat(e.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "tracing: failed to stat the trace file")
+	}
+	if info.Size() == 0 || info.Size()+int64(len(line)) <= e.maxSize {
+		return nil
+	}
+	return errors.Wrap(os.Rename(e.path, e.path+".1"), "tracing: failed to rotate the trace file")
+}
+
+// Export implements Exporter
+func (e *fileExporter) Export(service string, spans []SpanData) error {
+	line, err := encodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err := e.rotate(line); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(e.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "tracing: failed to open the trace file")
+	}
+	_, err = file.Write(line)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrap(err, "tracing: failed to write the trace file")
+}
+
+// The OTLP JSON encoding of an ExportTraceServiceRequest
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              SpanKind        `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		// Code is 1 for ok and 2 for error
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// encodeOTLP returns the OTLP JSON export request of the spans of the service
+func encodeOTLP(service string, spans []SpanData) ([]byte, error) {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: scopeName}}
+	for _, span := range spans {
+		encoded := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+		if span.ParentSpanID != (SpanID{}) {
+			encoded.ParentSpanID = span.ParentSpanID.String()
+		}
+		keys := make([]string, 0, len(span.Attributes))
+		for key := range span.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			encoded.Attributes = append(encoded.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: span.Attributes[key]}})
+		}
+		if span.Error != "" {
+			encoded.Status = otlpStatus{Code: 2, Message: span.Error}
+		}
+		scope.Spans = append(scope.Spans, encoded)
+	}
+	request := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: service}}}},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+	body, err := json.Marshal(request)
+	return body, errors.Wrap(err, "tracing: failed to encode the spans")
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package tracing
+
+import (
+	"context"
+
+	log "github.com/cihub/seelog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// traceparentKey is the gRPC metadata key of the W3C trace context
+const traceparentKey = "traceparent"
+
+// UnaryClientInterceptor sends the span context in the context of the calls as the traceparent metadata
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if sc := FromContext(ctx); sc.IsValid() {
+			ctx = metadata.AppendToOutgoingContext(ctx, traceparentKey, sc.Traceparent())
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// UnaryServerInterceptor makes the span context of the traceparent metadata of the requests the parent of the spans
+// the handlers start
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(traceparentKey); len(values) > 0 {
+				sc, err := ParseTraceparent(values[0])
+				if err != nil {
+					log.Debugf("Tracing: ignoring the trace context of %s: %v", info.FullMethod, err)
+				} else {
+					ctx = ContextWithSpanContext(ctx, sc)
+				}
+			}
+		}
+		return handler(ctx, req)
+	}
+}
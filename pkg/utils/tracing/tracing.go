@@ -0,0 +1,392 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package tracing records OpenTelemetry spans of the CNI plugin and ipamd, and propagates their W3C trace context
+// over gRPC. The traces are sampled by the ratio of their root span, and the spans are exported in the OTLP JSON
+// encoding, to an OTLP/HTTP collector or to a file.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// SpanKind is the OpenTelemetry kind of a span
+type SpanKind int
+
+const (
+	// KindInternal is an operation inside the process
+	KindInternal SpanKind = 1
+	// KindServer is the handling of a request from another process
+	KindServer SpanKind = 2
+	// KindClient is a request to another process or service
+	KindClient SpanKind = 3
+)
+
+const (
+	// flushInterval is how often the spans are exported
+	flushInterval = 5 * time.Second
+	// maxQueuedSpans is the number of spans kept until the next export, the ones after are dropped
+	maxQueuedSpans = 2048
+)
+
+// TraceID is the ID of a trace
+type TraceID [16]byte
+
+// SpanID is the ID of a span in a trace
+type SpanID [8]byte
+
+// String returns the ID in hex
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// String returns the ID in hex
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is what identifies a span across processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid returns true if the trace and span IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent returns the W3C traceparent header of the span context
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, errors.Errorf("invalid traceparent %q", value)
+	}
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return sc, errors.Wrapf(err, "invalid trace ID in traceparent %q", value)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return sc, errors.Wrapf(err, "invalid span ID in traceparent %q", value)
+	}
+	var flags [1]byte
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return sc, errors.Wrapf(err, "invalid flags in traceparent %q", value)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, errors.Errorf("invalid traceparent %q, the IDs are zero", value)
+	}
+	return sc, nil
+}
+
+// decodeHex decodes the lower case hex value into dst, which it must fill exactly
+func decodeHex(dst []byte, value string) error {
+	if len(value) != 2*len(dst) || strings.ToLower(value) != value {
+		return errors.Errorf("%q is not %d lower case hex digits", value, 2*len(dst))
+	}
+	_, err := hex.Decode(dst, []byte(value))
+	return err
+}
+
+// SpanData is a finished span, as it is exported
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	// Error is the error the operation failed with, if it did
+	Error string
+}
+
+// Span is an operation being traced. A nil Span is one that isn't recorded, all of its methods do nothing.
+type Span struct {
+	tracer *Tracer
+	data   SpanData
+	lock   sync.Mutex
+	ended  bool
+}
+
+// SetAttribute adds an attribute to the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = fmt.Sprint(value)
+}
+
+// Context returns the span context of the span, to propagate it
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: true}
+}
+
+// End finishes the span, with the error the operation failed with or nil. Only the first End counts.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	data := s.data
+	s.lock.Unlock()
+	s.tracer.enqueue(data)
+}
+
+// Sampler decides which traces are recorded
+type Sampler interface {
+	// ShouldSample returns whether a new span of the trace is recorded. The parent is invalid for a root span.
+	ShouldSample(parent SpanContext, traceID TraceID) bool
+}
+
+// parentBasedRatio samples a ratio of the traces by their root span, and the other spans like their parent, as the
+// parentbased_traceidratio sampler of OpenTelemetry does
+type parentBasedRatio struct {
+	// bound is the ratio scaled to the 63 bits of the trace IDs that are compared with it
+	bound uint64
+}
+
+// ParentBasedRatio returns a sampler that records the ratio, between 0 and 1, of the traces that start in the process,
+// and the spans of the traces whose remote parent is sampled
+func ParentBasedRatio(ratio float64) Sampler {
+	switch {
+	case ratio >= 1:
+		return parentBasedRatio{bound: 1 << 63}
+	case ratio <= 0:
+		return parentBasedRatio{}
+	}
+	return parentBasedRatio{bound: uint64(ratio * (1 << 63))}
+}
+
+// ShouldSample implements Sampler
+func (s parentBasedRatio) ShouldSample(parent SpanContext, traceID TraceID) bool {
+	if parent.IsValid() {
+		return parent.Sampled
+	}
+	return binary.BigEndian.Uint64(traceID[8:])>>1 < s.bound
+}
+
+// Exporter sends the finished spans of a service somewhere
+type Exporter interface {
+	Export(service string, spans []SpanData) error
+}
+
+// Tracer records the spans of a service and exports them
+type Tracer struct {
+	service  string
+	exporter Exporter
+	sampler  Sampler
+	lock     sync.Mutex
+	queue    []SpanData
+	dropped  int
+}
+
+// NewTracer returns a tracer that records the spans of the service the sampler picks, and exports them with the
+// exporter when Flush is called
+func NewTracer(service string, exporter Exporter, sampler Sampler) *Tracer {
+	return &Tracer{service: service, exporter: exporter, sampler: sampler}
+}
+
+// enqueue keeps the span until the next Flush
+func (t *Tracer) enqueue(data SpanData) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.queue) >= maxQueuedSpans {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, data)
+}
+
+// Flush exports the finished spans
+func (t *Tracer) Flush() {
+	t.lock.Lock()
+	spans, dropped := t.queue, t.dropped
+	t.queue, t.dropped = nil, 0
+	t.lock.Unlock()
+
+	if dropped > 0 {
+		log.Warnf("Tracing: dropped %d spans, more than %d ended between two exports", dropped, maxQueuedSpans)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.exporter.Export(t.service, spans); err != nil {
+		log.Warnf("Tracing: failed to export %d spans: %v", len(spans), err)
+	}
+}
+
+// StartAt starts a span of the tracer at the given time, as a child of the span or remote span context in ctx. It
+// returns a nil span when the trace isn't sampled, with the unsampled span context in the returned context so that
+// the spans of the trace in ipamd aren't recorded either.
+func (t *Tracer) StartAt(ctx context.Context, name string, kind SpanKind, start time.Time) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent.IsValid() && !parent.Sampled {
+		return ctx, nil
+	}
+	data := SpanData{Name: name, Kind: kind, Start: start}
+	if parent.IsValid() {
+		data.TraceID = parent.TraceID
+		data.ParentSpanID = parent.SpanID
+	} else {
+		_, _ = rand.Read(data.TraceID[:])
+	}
+	_, _ = rand.Read(data.SpanID[:])
+	if !t.sampler.ShouldSample(parent, data.TraceID) {
+		return ContextWithSpanContext(ctx, SpanContext{TraceID: data.TraceID, SpanID: data.SpanID}), nil
+	}
+	span := &Span{tracer: t, data: data}
+	return ContextWithSpanContext(ctx, span.Context()), span
+}
+
+var (
+	// tracer records the spans of Start and StartAt, it is nil until Init sets it up with an exporter
+	tracer     *Tracer
+	tracerLock sync.RWMutex
+)
+
+// Start starts a span as a child of the span or remote span context in ctx, and returns the context of the new span
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return StartAt(ctx, name, kind, time.Now())
+}
+
+// StartAt starts a span at the given time, e.g. to record an operation that is already done. The span is nil if
+// tracing is off.
+func StartAt(ctx context.Context, name string, kind SpanKind, start time.Time) (context.Context, *Span) {
+	tracerLock.RLock()
+	t := tracer
+	tracerLock.RUnlock()
+	if t == nil {
+		return ctx, nil
+	}
+	return t.StartAt(ctx, name, kind, start)
+}
+
+// setTracer sets up the tracer of the service with the exporter and the sampler of the config. It returns nil if there
+// is no exporter.
+func setTracer(service string, config Config) (*Tracer, error) {
+	exporter, err := config.NewExporter()
+	if err != nil || exporter == nil {
+		return nil, err
+	}
+	sampler, err := config.NewSampler()
+	if err != nil {
+		return nil, err
+	}
+	t := NewTracer(service, exporter, sampler)
+	tracerLock.Lock()
+	tracer = t
+	tracerLock.Unlock()
+	return t, nil
+}
+
+// Init sets up the tracer of a long running service with the exporter and the sampler of the config, and exports its
+// spans every flushInterval. It returns a function that exports the remaining spans, to call before the process exits.
+// With no exporter, tracing stays off.
+func Init(service string, config Config) (func(), error) {
+	t, err := setTracer(service, config)
+	if err != nil || t == nil {
+		return func() {}, err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.Flush()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	log.Infof("Tracing: exporting the spans of %s with the %s exporter, sampling %s of the traces", service,
+		config.Exporter, config.SampleRatio)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+			t.Flush()
+		})
+	}, nil
+}
+
+// InitCommand sets up the tracer of a process that runs a single command, like the CNI plugin, with the exporter and
+// the sampler of the config. It doesn't export in the background: the returned function exports the spans when the
+// command is done, and does nothing if the trace wasn't sampled.
+func InitCommand(service string, config Config) (func(), error) {
+	t, err := setTracer(service, config)
+	if err != nil || t == nil {
+		return func() {}, err
+	}
+	return t.Flush, nil
+}
+
+// spanContextKey is the key of the current span context in a context.Context
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx with the span context as the parent of the next spans
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// FromContext returns the span context of the current span in ctx, or of the remote parent of the request
+func FromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
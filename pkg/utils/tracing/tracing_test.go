@@ -0,0 +1,291 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//      http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// recordingExporter keeps the exported spans
+type recordingExporter struct {
+	spans []SpanData
+}
+
+func (e *recordingExporter) Export(service string, spans []SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestTraceparent(t *testing.T) {
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(value)
+	assert.NoError(t, err)
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.Equal(t, value, sc.Traceparent())
+
+	sc, err = ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	assert.NoError(t, err)
+	assert.False(t, sc.Sampled)
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba9-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, err := ParseTraceparent(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestStartSpans(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer("test", exporter, ParentBasedRatio(1))
+
+	ctx, root := tracer.StartAt(context.Background(), "root", KindInternal, time.Now())
+	root.SetAttribute("k8s.pod.name", "a")
+	_, child := tracer.StartAt(ctx, "child", KindClient, time.Now())
+	child.End(errors.New("throttled"))
+	root.End(nil)
+	root.End(errors.New("only the first End counts"))
+	tracer.Flush()
+
+	assert.Len(t, exporter.spans, 2)
+	childData, rootData := exporter.spans[0], exporter.spans[1]
+	assert.Equal(t, rootData.TraceID, childData.TraceID)
+	assert.Equal(t, rootData.SpanID, childData.ParentSpanID)
+	assert.Equal(t, SpanID{}, rootData.ParentSpanID)
+	assert.Equal(t, "throttled", childData.Error)
+	assert.Equal(t, "", rootData.Error)
+	assert.Equal(t, map[string]string{"k8s.pod.name": "a"}, rootData.Attributes)
+
+	// A remote parent that isn't sampled isn't recorded
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := tracer.StartAt(ContextWithSpanContext(context.Background(), remote), "unsampled", KindServer, time.Now())
+	assert.Nil(t, span)
+	// and a nil span does nothing
+	span.SetAttribute("key", "value")
+	span.End(nil)
+	assert.False(t, span.Context().IsValid())
+}
+
+func TestSampler(t *testing.T) {
+	low, high := TraceID{}, TraceID{}
+	low[8], high[8] = 0x10, 0xf0
+	assert.True(t, ParentBasedRatio(0.5).ShouldSample(SpanContext{}, low))
+	assert.False(t, ParentBasedRatio(0.5).ShouldSample(SpanContext{}, high))
+	assert.True(t, ParentBasedRatio(1).ShouldSample(SpanContext{}, high))
+	assert.False(t, ParentBasedRatio(0).ShouldSample(SpanContext{}, low))
+
+	// The children follow the decision of their parent
+	sampled, _ := ParseTraceparent("00-4bf92f3577b34da6f000000000000000-00f067aa0ba902b7-01")
+	assert.True(t, ParentBasedRatio(0).ShouldSample(sampled, high))
+
+	// A root span that isn't sampled passes its unsampled span context to its children
+	exporter := &recordingExporter{}
+	tracer := NewTracer("test", exporter, ParentBasedRatio(0))
+	ctx, root := tracer.StartAt(context.Background(), "root", KindInternal, time.Now())
+	assert.Nil(t, root)
+	assert.True(t, FromContext(ctx).IsValid())
+	assert.False(t, FromContext(ctx).Sampled)
+	_, child := NewTracer("test", exporter, ParentBasedRatio(1)).StartAt(ctx, "child", KindServer, time.Now())
+	assert.Nil(t, child)
+	tracer.Flush()
+	assert.Empty(t, exporter.spans)
+}
+
+func TestInitCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.json")
+	defer func() {
+		tracerLock.Lock()
+		tracer = nil
+		tracerLock.Unlock()
+	}()
+
+	// An unsampled command doesn't write the file
+	flush, err := InitCommand("aws-cni", Config{Exporter: ExporterFile, File: path, SampleRatio: "0"})
+	assert.NoError(t, err)
+	_, span := Start(context.Background(), "cni.add", KindInternal)
+	span.End(nil)
+	flush()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	flush, err = InitCommand("aws-cni", Config{Exporter: ExporterFile, File: path, SampleRatio: "1"})
+	assert.NoError(t, err)
+	_, span = Start(context.Background(), "cni.add", KindInternal)
+	span.End(nil)
+	flush()
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "cni.add")
+
+	_, err = InitCommand("aws-cni", Config{Exporter: ExporterFile, File: path, SampleRatio: "all"})
+	assert.Error(t, err)
+}
+
+func TestStartWithoutTracer(t *testing.T) {
+	ctx := context.Background()
+	spanCtx, span := Start(ctx, "off", KindInternal)
+	assert.Nil(t, span)
+	assert.Equal(t, ctx, spanCtx)
+}
+
+func TestGRPCPropagation(t *testing.T) {
+	tracer := NewTracer("test", &recordingExporter{}, ParentBasedRatio(1))
+	ctx, span := tracer.StartAt(context.Background(), "cni.add", KindInternal, time.Now())
+
+	// The client sends the span context as metadata
+	var outgoing metadata.MD
+	err := UnaryClientInterceptor()(ctx, "/rpc.CNIBackend/AddNetwork", nil, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			outgoing, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{span.Context().Traceparent()}, outgoing.Get(traceparentKey))
+
+	// The server makes it the parent of the spans of the handler
+	var parent SpanContext
+	_, err = UnaryServerInterceptor()(metadata.NewIncomingContext(context.Background(), outgoing), nil,
+		&grpc.UnaryServerInfo{FullMethod: "/rpc.CNIBackend/AddNetwork"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			parent = FromContext(ctx)
+			return nil, nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, span.Context(), parent)
+}
+
+func TestEncodeOTLP(t *testing.T) {
+	start := time.Unix(1571680000, 5)
+	body, err := encodeOTLP("aws-cni", []SpanData{{
+		Name:         "driver.SetupNS",
+		Kind:         KindInternal,
+		TraceID:      TraceID{1},
+		SpanID:       SpanID{2},
+		ParentSpanID: SpanID{3},
+		Start:        start,
+		End:          start.Add(time.Millisecond),
+		Attributes:   map[string]string{"b": "2", "a": "1"},
+		Error:        "file exists",
+	}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"resourceSpans":[{
+		"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"aws-cni"}}]},
+		"scopeSpans":[{"scope":{"name":"github.com/aws/amazon-vpc-cni-k8s"},"spans":[{
+			"traceId":"01000000000000000000000000000000","spanId":"0200000000000000","parentSpanId":"0300000000000000",
+			"name":"driver.SetupNS","kind":1,"startTimeUnixNano":"1571680000000000005","endTimeUnixNano":"1571680000001000005",
+			"attributes":[{"key":"a","value":{"stringValue":"1"}},{"key":"b","value":{"stringValue":"2"}}],
+			"status":{"code":2,"message":"file exists"}}]}]}]}`, string(body))
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var received otlpRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	exporter, err := Config{Exporter: ExporterOTLP, OTLPEndpoint: server.URL}.NewExporter()
+	assert.NoError(t, err)
+	assert.NoError(t, exporter.Export("aws-k8s-agent", []SpanData{{Name: "ec2.AssignPrivateIpAddresses", Kind: KindClient}}))
+	assert.Equal(t, "ec2.AssignPrivateIpAddresses", received.ResourceSpans[0].ScopeSpans[0].Spans[0].Name)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+	}))
+	defer failing.Close()
+	exporter, _ = Config{Exporter: ExporterOTLP, OTLPEndpoint: failing.URL}.NewExporter()
+	err = exporter.Export("aws-k8s-agent", []SpanData{{Name: "ec2.AssignPrivateIpAddresses"}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported content type")
+}
+
+func TestFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.json")
+
+	exporter, err := Config{Exporter: ExporterFile, File: path}.NewExporter()
+	assert.NoError(t, err)
+	assert.NoError(t, exporter.Export("aws-cni", []SpanData{{Name: "cni.add"}}))
+	assert.NoError(t, exporter.Export("aws-cni", []SpanData{{Name: "cni.del"}}))
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 2)
+	var request otlpRequest
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &request))
+	assert.Equal(t, "cni.del", request.ResourceSpans[0].ScopeSpans[0].Spans[0].Name)
+
+	// The file is moved to <file>.1 when the next export would make it too large
+	exporter.(*fileExporter).maxSize = int64(len(content)) + 1
+	assert.NoError(t, exporter.Export("aws-cni", []SpanData{{Name: "cni.add"}}))
+	rotated, err := ioutil.ReadFile(path + ".1")
+	assert.NoError(t, err)
+	assert.Equal(t, content, rotated)
+	content, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "\n"))
+}
+
+func TestConfig(t *testing.T) {
+	_ = os.Unsetenv(envExporter)
+	_ = os.Setenv(envOTLPEndpoint, "http://collector:4318/v1/traces")
+	defer os.Unsetenv(envOTLPEndpoint)
+	config := ConfigFromEnv("/var/log/traces.json")
+	assert.Equal(t, Config{Exporter: ExporterNone, OTLPEndpoint: "http://collector:4318/v1/traces",
+		File: "/var/log/traces.json", SampleRatio: defaultSampleRatio}, config)
+	exporter, err := config.NewExporter()
+	assert.NoError(t, err)
+	assert.Nil(t, exporter)
+
+	_, err = Config{Exporter: "jaeger"}.NewExporter()
+	assert.Error(t, err)
+	_, err = Config{Exporter: ExporterFile}.NewExporter()
+	assert.Error(t, err)
+
+	_, err = Config{}.NewSampler()
+	assert.NoError(t, err)
+	for _, invalid := range []string{"-0.5", "2", "half"} {
+		_, err = Config{SampleRatio: invalid}.NewSampler()
+		assert.Error(t, err, invalid)
+	}
+}
@@ -84,3 +84,21 @@ func (mr *MockCNIBackendClientMockRecorder) DelNetwork(arg0, arg1 interface{}, a
 	varargs := append([]interface{}{arg0, arg1}, arg2...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DelNetwork", reflect.TypeOf((*MockCNIBackendClient)(nil).DelNetwork), varargs...)
 }
+
+// ReportNetworkSetup mocks base method
+func (m *MockCNIBackendClient) ReportNetworkSetup(arg0 context.Context, arg1 *rpc.ReportNetworkSetupRequest, arg2 ...grpc.CallOption) (*rpc.ReportNetworkSetupReply, error) {
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ReportNetworkSetup", varargs...)
+	ret0, _ := ret[0].(*rpc.ReportNetworkSetupReply)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReportNetworkSetup indicates an expected call of ReportNetworkSetup
+func (mr *MockCNIBackendClientMockRecorder) ReportNetworkSetup(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReportNetworkSetup", reflect.TypeOf((*MockCNIBackendClient)(nil).ReportNetworkSetup), varargs...)
+}
@@ -12,6 +12,8 @@ It has these top-level messages:
 	AddNetworkReply
 	DelNetworkRequest
 	DelNetworkReply
+	ReportNetworkSetupRequest
+	ReportNetworkSetupReply
 */
 package rpc
 
@@ -84,12 +86,17 @@ func (m *AddNetworkRequest) GetIfName() string {
 }
 
 type AddNetworkReply struct {
-	Success         bool     `protobuf:"varint,1,opt,name=Success" json:"Success,omitempty"`
-	IPv4Addr        string   `protobuf:"bytes,2,opt,name=IPv4Addr" json:"IPv4Addr,omitempty"`
-	IPv4Subnet      string   `protobuf:"bytes,3,opt,name=IPv4Subnet" json:"IPv4Subnet,omitempty"`
-	DeviceNumber    int32    `protobuf:"varint,4,opt,name=DeviceNumber" json:"DeviceNumber,omitempty"`
-	UseExternalSNAT bool     `protobuf:"varint,5,opt,name=UseExternalSNAT" json:"UseExternalSNAT,omitempty"`
-	VPCcidrs        []string `protobuf:"bytes,6,rep,name=VPCcidrs" json:"VPCcidrs,omitempty"`
+	Success          bool     `protobuf:"varint,1,opt,name=Success" json:"Success,omitempty"`
+	IPv4Addr         string   `protobuf:"bytes,2,opt,name=IPv4Addr" json:"IPv4Addr,omitempty"`
+	IPv4Subnet       string   `protobuf:"bytes,3,opt,name=IPv4Subnet" json:"IPv4Subnet,omitempty"`
+	DeviceNumber     int32    `protobuf:"varint,4,opt,name=DeviceNumber" json:"DeviceNumber,omitempty"`
+	UseExternalSNAT  bool     `protobuf:"varint,5,opt,name=UseExternalSNAT" json:"UseExternalSNAT,omitempty"`
+	VPCcidrs         []string `protobuf:"bytes,6,rep,name=VPCcidrs" json:"VPCcidrs,omitempty"`
+	UsePerENISNAT    bool     `protobuf:"varint,7,opt,name=UsePerENISNAT" json:"UsePerENISNAT,omitempty"`
+	RouteTableOffset int32    `protobuf:"varint,8,opt,name=RouteTableOffset" json:"RouteTableOffset,omitempty"`
+	RulePriorityBase int32    `protobuf:"varint,9,opt,name=RulePriorityBase" json:"RulePriorityBase,omitempty"`
+	ErrorCode        string   `protobuf:"bytes,10,opt,name=ErrorCode" json:"ErrorCode,omitempty"`
+	ErrorMessage     string   `protobuf:"bytes,11,opt,name=ErrorMessage" json:"ErrorMessage,omitempty"`
 }
 
 func (m *AddNetworkReply) Reset()                    { *m = AddNetworkReply{} }
@@ -139,6 +146,41 @@ func (m *AddNetworkReply) GetVPCcidrs() []string {
 	return nil
 }
 
+func (m *AddNetworkReply) GetUsePerENISNAT() bool {
+	if m != nil {
+		return m.UsePerENISNAT
+	}
+	return false
+}
+
+func (m *AddNetworkReply) GetRouteTableOffset() int32 {
+	if m != nil {
+		return m.RouteTableOffset
+	}
+	return 0
+}
+
+func (m *AddNetworkReply) GetRulePriorityBase() int32 {
+	if m != nil {
+		return m.RulePriorityBase
+	}
+	return 0
+}
+
+func (m *AddNetworkReply) GetErrorCode() string {
+	if m != nil {
+		return m.ErrorCode
+	}
+	return ""
+}
+
+func (m *AddNetworkReply) GetErrorMessage() string {
+	if m != nil {
+		return m.ErrorMessage
+	}
+	return ""
+}
+
 type DelNetworkRequest struct {
 	K8S_POD_NAME               string `protobuf:"bytes,1,opt,name=K8S_POD_NAME,json=K8SPODNAME" json:"K8S_POD_NAME,omitempty"`
 	K8S_POD_NAMESPACE          string `protobuf:"bytes,2,opt,name=K8S_POD_NAMESPACE,json=K8SPODNAMESPACE" json:"K8S_POD_NAMESPACE,omitempty"`
@@ -188,9 +230,11 @@ func (m *DelNetworkRequest) GetReason() string {
 }
 
 type DelNetworkReply struct {
-	Success      bool   `protobuf:"varint,1,opt,name=Success" json:"Success,omitempty"`
-	IPv4Addr     string `protobuf:"bytes,2,opt,name=IPv4Addr" json:"IPv4Addr,omitempty"`
-	DeviceNumber int32  `protobuf:"varint,3,opt,name=DeviceNumber" json:"DeviceNumber,omitempty"`
+	Success          bool   `protobuf:"varint,1,opt,name=Success" json:"Success,omitempty"`
+	IPv4Addr         string `protobuf:"bytes,2,opt,name=IPv4Addr" json:"IPv4Addr,omitempty"`
+	DeviceNumber     int32  `protobuf:"varint,3,opt,name=DeviceNumber" json:"DeviceNumber,omitempty"`
+	RouteTableOffset int32  `protobuf:"varint,4,opt,name=RouteTableOffset" json:"RouteTableOffset,omitempty"`
+	RulePriorityBase int32  `protobuf:"varint,5,opt,name=RulePriorityBase" json:"RulePriorityBase,omitempty"`
 }
 
 func (m *DelNetworkReply) Reset()                    { *m = DelNetworkReply{} }
@@ -219,11 +263,91 @@ func (m *DelNetworkReply) GetDeviceNumber() int32 {
 	return 0
 }
 
+func (m *DelNetworkReply) GetRouteTableOffset() int32 {
+	if m != nil {
+		return m.RouteTableOffset
+	}
+	return 0
+}
+
+func (m *DelNetworkReply) GetRulePriorityBase() int32 {
+	if m != nil {
+		return m.RulePriorityBase
+	}
+	return 0
+}
+
+type ReportNetworkSetupRequest struct {
+	K8S_POD_NAME               string  `protobuf:"bytes,1,opt,name=K8S_POD_NAME,json=K8SPODNAME" json:"K8S_POD_NAME,omitempty"`
+	K8S_POD_NAMESPACE          string  `protobuf:"bytes,2,opt,name=K8S_POD_NAMESPACE,json=K8SPODNAMESPACE" json:"K8S_POD_NAMESPACE,omitempty"`
+	K8S_POD_INFRA_CONTAINER_ID string  `protobuf:"bytes,3,opt,name=K8S_POD_INFRA_CONTAINER_ID,json=K8SPODINFRACONTAINERID" json:"K8S_POD_INFRA_CONTAINER_ID,omitempty"`
+	IPv4Addr                   string  `protobuf:"bytes,4,opt,name=IPv4Addr" json:"IPv4Addr,omitempty"`
+	Success                    bool    `protobuf:"varint,5,opt,name=Success" json:"Success,omitempty"`
+	SetupSeconds               float64 `protobuf:"fixed64,6,opt,name=SetupSeconds" json:"SetupSeconds,omitempty"`
+}
+
+func (m *ReportNetworkSetupRequest) Reset()                    { *m = ReportNetworkSetupRequest{} }
+func (m *ReportNetworkSetupRequest) String() string            { return proto.CompactTextString(m) }
+func (*ReportNetworkSetupRequest) ProtoMessage()               {}
+func (*ReportNetworkSetupRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *ReportNetworkSetupRequest) GetK8S_POD_NAME() string {
+	if m != nil {
+		return m.K8S_POD_NAME
+	}
+	return ""
+}
+
+func (m *ReportNetworkSetupRequest) GetK8S_POD_NAMESPACE() string {
+	if m != nil {
+		return m.K8S_POD_NAMESPACE
+	}
+	return ""
+}
+
+func (m *ReportNetworkSetupRequest) GetK8S_POD_INFRA_CONTAINER_ID() string {
+	if m != nil {
+		return m.K8S_POD_INFRA_CONTAINER_ID
+	}
+	return ""
+}
+
+func (m *ReportNetworkSetupRequest) GetIPv4Addr() string {
+	if m != nil {
+		return m.IPv4Addr
+	}
+	return ""
+}
+
+func (m *ReportNetworkSetupRequest) GetSuccess() bool {
+	if m != nil {
+		return m.Success
+	}
+	return false
+}
+
+func (m *ReportNetworkSetupRequest) GetSetupSeconds() float64 {
+	if m != nil {
+		return m.SetupSeconds
+	}
+	return 0
+}
+
+type ReportNetworkSetupReply struct {
+}
+
+func (m *ReportNetworkSetupReply) Reset()                    { *m = ReportNetworkSetupReply{} }
+func (m *ReportNetworkSetupReply) String() string            { return proto.CompactTextString(m) }
+func (*ReportNetworkSetupReply) ProtoMessage()               {}
+func (*ReportNetworkSetupReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
 func init() {
 	proto.RegisterType((*AddNetworkRequest)(nil), "rpc.AddNetworkRequest")
 	proto.RegisterType((*AddNetworkReply)(nil), "rpc.AddNetworkReply")
 	proto.RegisterType((*DelNetworkRequest)(nil), "rpc.DelNetworkRequest")
 	proto.RegisterType((*DelNetworkReply)(nil), "rpc.DelNetworkReply")
+	proto.RegisterType((*ReportNetworkSetupRequest)(nil), "rpc.ReportNetworkSetupRequest")
+	proto.RegisterType((*ReportNetworkSetupReply)(nil), "rpc.ReportNetworkSetupReply")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -239,6 +363,7 @@ const _ = grpc.SupportPackageIsVersion4
 type CNIBackendClient interface {
 	AddNetwork(ctx context.Context, in *AddNetworkRequest, opts ...grpc.CallOption) (*AddNetworkReply, error)
 	DelNetwork(ctx context.Context, in *DelNetworkRequest, opts ...grpc.CallOption) (*DelNetworkReply, error)
+	ReportNetworkSetup(ctx context.Context, in *ReportNetworkSetupRequest, opts ...grpc.CallOption) (*ReportNetworkSetupReply, error)
 }
 
 type cNIBackendClient struct {
@@ -267,11 +392,21 @@ func (c *cNIBackendClient) DelNetwork(ctx context.Context, in *DelNetworkRequest
 	return out, nil
 }
 
+func (c *cNIBackendClient) ReportNetworkSetup(ctx context.Context, in *ReportNetworkSetupRequest, opts ...grpc.CallOption) (*ReportNetworkSetupReply, error) {
+	out := new(ReportNetworkSetupReply)
+	err := grpc.Invoke(ctx, "/rpc.CNIBackend/ReportNetworkSetup", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for CNIBackend service
 
 type CNIBackendServer interface {
 	AddNetwork(context.Context, *AddNetworkRequest) (*AddNetworkReply, error)
 	DelNetwork(context.Context, *DelNetworkRequest) (*DelNetworkReply, error)
+	ReportNetworkSetup(context.Context, *ReportNetworkSetupRequest) (*ReportNetworkSetupReply, error)
 }
 
 func RegisterCNIBackendServer(s *grpc.Server, srv CNIBackendServer) {
@@ -314,6 +449,24 @@ func _CNIBackend_DelNetwork_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CNIBackend_ReportNetworkSetup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportNetworkSetupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CNIBackendServer).ReportNetworkSetup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.CNIBackend/ReportNetworkSetup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CNIBackendServer).ReportNetworkSetup(ctx, req.(*ReportNetworkSetupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _CNIBackend_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "rpc.CNIBackend",
 	HandlerType: (*CNIBackendServer)(nil),
@@ -326,6 +479,10 @@ var _CNIBackend_serviceDesc = grpc.ServiceDesc{
 			MethodName: "DelNetwork",
 			Handler:    _CNIBackend_DelNetwork_Handler,
 		},
+		{
+			MethodName: "ReportNetworkSetup",
+			Handler:    _CNIBackend_ReportNetworkSetup_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "rpc.proto",
@@ -334,30 +491,40 @@ var _CNIBackend_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("rpc.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 391 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x53, 0x41, 0x8f, 0x9a, 0x40,
-	0x18, 0x95, 0xa2, 0x54, 0xbf, 0x98, 0x10, 0x27, 0x86, 0x10, 0x0f, 0x8d, 0xe1, 0x64, 0x7a, 0xf0,
-	0xd0, 0xf6, 0x60, 0x9a, 0x5e, 0x28, 0xd0, 0x84, 0x98, 0x8e, 0x64, 0xb0, 0xbd, 0x12, 0x84, 0xd9,
-	0xc4, 0x88, 0xc0, 0x0e, 0xe0, 0xae, 0xff, 0x60, 0xff, 0xdb, 0x9e, 0xf6, 0x1f, 0x6d, 0x18, 0x41,
-	0x59, 0xb8, 0xed, 0x69, 0x6f, 0xbc, 0x37, 0xef, 0x25, 0xef, 0xcd, 0x63, 0x60, 0xc4, 0xd2, 0x60,
-	0x99, 0xb2, 0x24, 0x4f, 0x90, 0xc8, 0xd2, 0x40, 0x7b, 0x16, 0x60, 0xa2, 0x87, 0x21, 0xa6, 0xf9,
-	0x43, 0xc2, 0x0e, 0x84, 0xde, 0x17, 0x34, 0xcb, 0xd1, 0x1c, 0xc6, 0xeb, 0x95, 0xeb, 0x39, 0x1b,
-	0xd3, 0xc3, 0xfa, 0x5f, 0x4b, 0x15, 0xe6, 0xc2, 0x62, 0x44, 0x60, 0xbd, 0x72, 0x9d, 0x8d, 0x59,
-	0x32, 0xe8, 0x2b, 0x4c, 0x9a, 0x0a, 0xd7, 0xd1, 0x0d, 0x4b, 0xfd, 0xc4, 0x65, 0xf2, 0x4d, 0xc6,
-	0x69, 0xf4, 0x13, 0x66, 0xb5, 0xd6, 0xc6, 0x7f, 0x88, 0xee, 0x19, 0x1b, 0xbc, 0xd5, 0x6d, 0x6c,
-	0x11, 0xcf, 0x36, 0x55, 0x91, 0x9b, 0x94, 0x8b, 0x89, 0x9f, 0x5f, 0x8f, 0x6d, 0x13, 0x4d, 0x61,
-	0x80, 0x69, 0x1e, 0x67, 0x6a, 0x9f, 0xcb, 0x2e, 0x00, 0x29, 0x20, 0xd9, 0x77, 0xd8, 0x3f, 0x52,
-	0x75, 0xc0, 0xe9, 0x0a, 0x69, 0x2f, 0x02, 0xc8, 0xcd, 0x36, 0x69, 0x74, 0x46, 0x2a, 0x7c, 0x76,
-	0x8b, 0x20, 0xa0, 0x59, 0xc6, 0x6b, 0x0c, 0x49, 0x0d, 0xd1, 0x0c, 0x86, 0xb6, 0x73, 0xfa, 0xa1,
-	0x87, 0x21, 0xab, 0xa2, 0x5f, 0x31, 0xfa, 0x02, 0x50, 0x7e, 0xbb, 0xc5, 0x2e, 0xa6, 0x79, 0x95,
-	0xb1, 0xc1, 0x20, 0x0d, 0xc6, 0x26, 0x3d, 0xed, 0x03, 0x8a, 0x8b, 0xe3, 0x8e, 0x32, 0x1e, 0x6f,
-	0x40, 0xde, 0x70, 0x68, 0x01, 0xf2, 0xbf, 0x8c, 0x5a, 0x8f, 0x39, 0x65, 0xb1, 0x1f, 0xb9, 0x58,
-	0xdf, 0xf2, 0xb8, 0x43, 0xd2, 0xa6, 0xcb, 0x24, 0xff, 0x1d, 0x23, 0xd8, 0x87, 0x2c, 0x53, 0xa5,
-	0xb9, 0x58, 0x26, 0xa9, 0x71, 0xd9, 0x69, 0x62, 0xd2, 0xe8, 0xc3, 0x2e, 0xd4, 0xbc, 0xc5, 0x7e,
-	0xeb, 0x16, 0x15, 0x90, 0x08, 0xf5, 0xb3, 0x24, 0xae, 0x77, 0xba, 0x20, 0xed, 0x00, 0x72, 0xb3,
-	0xd2, 0xfb, 0x67, 0x6a, 0xcf, 0x20, 0x76, 0x67, 0xf8, 0xf6, 0x24, 0x00, 0x18, 0xd8, 0xfe, 0xed,
-	0x07, 0x07, 0x1a, 0x87, 0xe8, 0x17, 0xc0, 0xed, 0x17, 0x41, 0xca, 0xb2, 0x7c, 0x10, 0x9d, 0x17,
-	0x30, 0x9b, 0x76, 0xf8, 0x34, 0x3a, 0x6b, 0xbd, 0xd2, 0x7d, 0x4b, 0x5e, 0xb9, 0x3b, 0xeb, 0x54,
-	0xee, 0x56, 0x45, 0xad, 0xb7, 0x93, 0xf8, 0xcb, 0xfb, 0xfe, 0x1a, 0x00, 0x00, 0xff, 0xff, 0x7c,
-	0x38, 0x53, 0x0e, 0x86, 0x03, 0x00, 0x00,
+	// 559 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xd4, 0x55, 0x41, 0x6f, 0xda, 0x4c,
+	0x10, 0xfd, 0xfc, 0x11, 0x08, 0x4c, 0x53, 0x51, 0x56, 0x88, 0x3a, 0x28, 0x8a, 0x90, 0xd5, 0x03,
+	0xca, 0x21, 0x87, 0xb6, 0x87, 0xa8, 0xea, 0xc5, 0xc1, 0xae, 0x64, 0x45, 0x59, 0xac, 0x35, 0xe9,
+	0x15, 0x19, 0x7b, 0xa8, 0x50, 0x1c, 0xdb, 0xdd, 0x5d, 0xa7, 0xe5, 0x67, 0xf4, 0x37, 0xf5, 0xd8,
+	0x53, 0xff, 0x40, 0xff, 0x4a, 0x2b, 0x2f, 0x26, 0x18, 0x4c, 0x0e, 0xed, 0xa9, 0xbd, 0xf1, 0xde,
+	0xbe, 0x59, 0xbd, 0x99, 0x79, 0x5e, 0xa0, 0xc5, 0xd3, 0xe0, 0x3c, 0xe5, 0x89, 0x4c, 0x48, 0x8d,
+	0xa7, 0x81, 0xf1, 0x4d, 0x83, 0x8e, 0x19, 0x86, 0x14, 0xe5, 0xa7, 0x84, 0xdf, 0x32, 0xfc, 0x98,
+	0xa1, 0x90, 0x64, 0x00, 0x47, 0x57, 0x17, 0xde, 0xd4, 0x1d, 0x5b, 0x53, 0x6a, 0x5e, 0xdb, 0xba,
+	0x36, 0xd0, 0x86, 0x2d, 0x06, 0x57, 0x17, 0x9e, 0x3b, 0xb6, 0x72, 0x86, 0x9c, 0x41, 0xa7, 0xac,
+	0xf0, 0x5c, 0x73, 0x64, 0xeb, 0xff, 0x2b, 0x59, 0x7b, 0x23, 0x53, 0x34, 0x79, 0x03, 0xfd, 0xb5,
+	0xd6, 0xa1, 0xef, 0x98, 0x39, 0x1d, 0x8d, 0xe9, 0xc4, 0x74, 0xa8, 0xcd, 0xa6, 0x8e, 0xa5, 0xd7,
+	0x54, 0x51, 0x6f, 0x55, 0xa4, 0xce, 0x1f, 0x8e, 0x1d, 0x8b, 0x74, 0xa1, 0x4e, 0x51, 0xc6, 0x42,
+	0x3f, 0x50, 0xb2, 0x15, 0x20, 0x3d, 0x68, 0x38, 0x73, 0xea, 0xdf, 0xa1, 0x5e, 0x57, 0x74, 0x81,
+	0x8c, 0x2f, 0x35, 0x68, 0x97, 0xbb, 0x49, 0xa3, 0x25, 0xd1, 0xe1, 0xd0, 0xcb, 0x82, 0x00, 0x85,
+	0x50, 0x6d, 0x34, 0xd9, 0x1a, 0x92, 0x3e, 0x34, 0x1d, 0xf7, 0xfe, 0xb5, 0x19, 0x86, 0xbc, 0xb0,
+	0xfe, 0x80, 0xc9, 0x29, 0x40, 0xfe, 0xdb, 0xcb, 0x66, 0x31, 0xca, 0xc2, 0x63, 0x89, 0x21, 0x06,
+	0x1c, 0x59, 0x78, 0xbf, 0x08, 0x90, 0x66, 0x77, 0x33, 0xe4, 0xca, 0x5e, 0x9d, 0x6d, 0x71, 0x64,
+	0x08, 0xed, 0x1b, 0x81, 0xf6, 0x67, 0x89, 0x3c, 0xf6, 0x23, 0x8f, 0x9a, 0x13, 0x65, 0xb7, 0xc9,
+	0x76, 0xe9, 0xdc, 0xc9, 0x7b, 0x77, 0x14, 0x2c, 0x42, 0x2e, 0xf4, 0xc6, 0xa0, 0x96, 0x3b, 0x59,
+	0x63, 0xf2, 0x02, 0x9e, 0xde, 0x08, 0x74, 0x91, 0xdb, 0xd4, 0x51, 0x77, 0x1c, 0xaa, 0x3b, 0xb6,
+	0x49, 0x72, 0x06, 0xcf, 0x58, 0x92, 0x49, 0x9c, 0xf8, 0xb3, 0x08, 0xc7, 0xf3, 0xb9, 0x40, 0xa9,
+	0x37, 0x95, 0xa7, 0x0a, 0xaf, 0xb4, 0x59, 0x84, 0x2e, 0x5f, 0x24, 0x7c, 0x21, 0x97, 0x97, 0xbe,
+	0x40, 0xbd, 0x55, 0x68, 0x77, 0x78, 0x72, 0x02, 0x2d, 0x9b, 0xf3, 0x84, 0x8f, 0x92, 0x10, 0x75,
+	0x50, 0x63, 0xd8, 0x10, 0xf9, 0x14, 0x14, 0xb8, 0x46, 0x21, 0xfc, 0x0f, 0xa8, 0x3f, 0x51, 0x82,
+	0x2d, 0xce, 0xf8, 0xae, 0x41, 0xc7, 0xc2, 0xe8, 0xaf, 0x4d, 0x58, 0x39, 0x05, 0x07, 0x3b, 0x29,
+	0xe8, 0x41, 0x83, 0xa1, 0x2f, 0x92, 0x78, 0x9d, 0xb3, 0x15, 0x32, 0xbe, 0x6a, 0xd0, 0x2e, 0xf7,
+	0xf4, 0xe7, 0x39, 0xdb, 0xcd, 0x51, 0x6d, 0x4f, 0x8e, 0xf6, 0xed, 0xf6, 0xe0, 0x37, 0x76, 0x5b,
+	0xdf, 0xbf, 0x5b, 0xe3, 0xa7, 0x06, 0xc7, 0x0c, 0xd3, 0x84, 0xcb, 0xa2, 0x11, 0x0f, 0x65, 0x96,
+	0xfe, 0x5b, 0x1b, 0x2a, 0x4d, 0xbd, 0xbe, 0x3d, 0x75, 0x03, 0x8e, 0x54, 0x3f, 0x1e, 0x06, 0x49,
+	0x1c, 0xe6, 0xdf, 0x95, 0x36, 0xd4, 0xd8, 0x16, 0x67, 0x1c, 0xc3, 0xf3, 0x7d, 0x03, 0x48, 0xa3,
+	0xe5, 0xcb, 0x1f, 0x1a, 0xc0, 0x88, 0x3a, 0x97, 0x7e, 0x70, 0x8b, 0x71, 0x48, 0xde, 0x02, 0x6c,
+	0x1e, 0x16, 0xd2, 0x3b, 0xcf, 0x9f, 0xd1, 0xca, 0xbb, 0xd9, 0xef, 0x56, 0xf8, 0x34, 0x5a, 0x1a,
+	0xff, 0xe5, 0xd5, 0x9b, 0xb8, 0x14, 0xd5, 0x95, 0x6f, 0xa2, 0xdf, 0xad, 0xf0, 0xab, 0xea, 0x09,
+	0x90, 0xaa, 0x4b, 0x72, 0xaa, 0xd4, 0x8f, 0xee, 0xaf, 0x7f, 0xf2, 0xe8, 0xb9, 0xba, 0x75, 0xd6,
+	0x50, 0xff, 0x02, 0xaf, 0x7e, 0x0d, 0x00, 0x07, 0x6e, 0x5e, 0x23, 0x12, 0x06, 0x00, 0x00,
 }
@@ -21,6 +21,12 @@ set -uo pipefail
 LOG_DIR="/var/log/aws-routed-eni"
 mkdir -p ${LOG_DIR}
 
+# aws-cni-ctl collects the same data with a manifest, and can redact it. Its flags are passed on, like -redact ips,pods
+AWS_CNI_CTL="$(dirname "$0")/aws-cni-ctl"
+if [[ -x "${AWS_CNI_CTL}" ]]; then
+    exec "${AWS_CNI_CTL}" bundle -out ${LOG_DIR}/aws-cni-support.tar.gz "$@"
+fi
+
 # Collecting L-IPAMD introspection data
 curl http://localhost:61679/v1/enis         > ${LOG_DIR}/eni.out
 curl http://localhost:61679/v1/pods         > ${LOG_DIR}/pod.out
@@ -39,15 +39,18 @@ HOST_CNI_BIN_PATH=${HOST_CNI_BIN_PATH:-/host/opt/cni/bin}
 HOST_CNI_CONFDIR_PATH=${HOST_CNI_CONFDIR_PATH:-/host/etc/cni/net.d}
 
 # Checks for IPAM connectivity on localhost port 50051, retrying connectivity
-# check with a timeout of 36 seconds
+# check with a timeout of 36 seconds. ipamD only needs to answer: an unhealthy
+# status (exit code 4) is left to the liveness probe, so that the CNI plugin is
+# still installed.
 wait_for_ipam() {
     local __sleep_time=0
 
     until [ $__sleep_time -eq 8 ]; do
         sleep $(( __sleep_time++ ))
-        if $(./grpc-health-probe -addr 127.0.0.1:50051 >/dev/null 2>&1); then
-            return 0
-        fi
+        ./grpc-health-probe -addr 127.0.0.1:50051 >/dev/null 2>&1
+        case $? in
+            0|4) return 0 ;;
+        esac
     done
     return 1
 }
@@ -71,9 +74,15 @@ echo -n "copying CNI plugin binaries and config files ... "
 cp portmap "$HOST_CNI_BIN_PATH"
 cp aws-cni "$HOST_CNI_BIN_PATH"
 cp aws-cni-support.sh "$HOST_CNI_BIN_PATH"
+cp aws-cni-ctl "$HOST_CNI_BIN_PATH"
 
 sed -i s/__VETHPREFIX__/"${AWS_VPC_K8S_CNI_VETHPREFIX:-"eni"}"/g 10-aws.conflist
 sed -i s/__MTU__/"${AWS_VPC_ENI_MTU:-"9001"}"/g 10-aws.conflist
+sed -i s/__LOGFORMAT__/"${AWS_VPC_K8S_CNI_LOG_FORMAT:-"text"}"/g 10-aws.conflist
+sed -i s/__TRACING_EXPORTER__/"${AWS_VPC_K8S_CNI_TRACING_EXPORTER:-"none"}"/g 10-aws.conflist
+sed -i "s|__TRACING_OTLP_ENDPOINT__|${AWS_VPC_K8S_CNI_TRACING_OTLP_ENDPOINT}|g" 10-aws.conflist
+sed -i "s|__TRACING_PLUGIN_FILE__|${AWS_VPC_K8S_CNI_TRACING_PLUGIN_FILE}|g" 10-aws.conflist
+sed -i "s|__TRACING_SAMPLE_RATIO__|${AWS_VPC_K8S_CNI_TRACING_SAMPLE_RATIO}|g" 10-aws.conflist
 cp 10-aws.conflist "$HOST_CNI_CONFDIR_PATH"
 
 echo " ok."